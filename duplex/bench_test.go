@@ -0,0 +1,185 @@
+package duplex
+
+// The benchmarks cover the whole stack over each registered codec and each
+// transport, named codec=<name>/transport=<name> so results can be compared
+// across commits with benchstat:
+//
+//	go test -run '^$' -bench . -count 10 > old.txt
+//	git checkout <commit>
+//	go test -run '^$' -bench . -count 10 > new.txt
+//	benchstat old.txt new.txt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/mux"
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+// benchTransports are the transports benchmarked, where pipe is an
+// in-memory pipe giving a baseline without the cost of the network stack.
+var benchTransports = []string{"pipe", "tcp", "unix", "ws"}
+
+// benchStreamChunk is the size of each value sent by BenchmarkStream.
+const benchStreamChunk = 32 << 10
+
+// benchHandler echoes the argument of "echo" calls, and returns the number of
+// values streamed to "sink" calls.
+func benchHandler() rpc.Handler {
+	m := rpc.NewRespondMux()
+	m.Handle("echo", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		var v any
+		if err := c.Receive(&v); err != nil {
+			r.Return(err)
+			return
+		}
+		r.Return(v)
+	}))
+	m.Handle("sink", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		n := 0
+		var b []byte
+		for {
+			err := c.Receive(&b)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				r.Return(err)
+				return
+			}
+			n++
+		}
+		r.Return(n)
+	}))
+	return m
+}
+
+// benchClient returns a Client connected over transport to a Server using c.
+func benchClient(b *testing.B, transport string, c codec.Codec) *rpc.Client {
+	b.Helper()
+	srv := &rpc.Server{Handler: benchHandler(), Codec: c}
+
+	if transport == "pipe" {
+		sa, sb := mux.Pair()
+		b.Cleanup(func() {
+			sa.Close()
+			sb.Close()
+		})
+		go srv.Respond(sb, nil)
+		return rpc.NewClient(sa, c)
+	}
+
+	var (
+		l   mux.Listener
+		err error
+	)
+	switch transport {
+	case "tcp":
+		l, err = mux.ListenTCP("127.0.0.1:0")
+	case "unix":
+		l, err = mux.ListenUnix(filepath.Join(b.TempDir(), "bench.sock"))
+	case "ws":
+		l, err = mux.ListenWS("127.0.0.1:0")
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+	go srv.ServeMux(l)
+
+	var sess mux.Session
+	switch transport {
+	case "tcp":
+		sess, err = mux.DialTCP(l.Addr().String())
+	case "unix":
+		sess, err = mux.DialUnix(l.Addr().String())
+	case "ws":
+		sess, err = mux.DialWS(l.Addr().String())
+	}
+	if err != nil {
+		l.Close()
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		sess.Close()
+		l.Close()
+	})
+	return rpc.NewClient(sess, c)
+}
+
+// benchMatrix runs fn as a sub-benchmark for each registered codec and transport.
+func benchMatrix(b *testing.B, fn func(b *testing.B, client *rpc.Client)) {
+	for _, contentType := range codec.DefaultRegistry.ContentTypes() {
+		c, _ := codec.Lookup(contentType)
+		name := strings.TrimPrefix(contentType, "application/")
+		for _, transport := range benchTransports {
+			b.Run(fmt.Sprintf("codec=%s/transport=%s", name, transport), func(b *testing.B) {
+				fn(b, benchClient(b, transport, c))
+			})
+		}
+	}
+}
+
+// BenchmarkCall measures the round trip of a small call.
+func BenchmarkCall(b *testing.B) {
+	benchMatrix(b, func(b *testing.B, client *rpc.Client) {
+		ctx := context.Background()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			var ret string
+			if _, err := client.Call(ctx, "echo", "hello", &ret); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkCallParallel measures small calls made concurrently by many
+// goroutines over one session.
+func BenchmarkCallParallel(b *testing.B) {
+	benchMatrix(b, func(b *testing.B, client *rpc.Client) {
+		ctx := context.Background()
+		b.ReportAllocs()
+		b.SetParallelism(16)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				var ret string
+				if _, err := client.Call(ctx, "echo", "hello", &ret); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
+
+// BenchmarkStream measures the throughput of streaming large values in one call.
+func BenchmarkStream(b *testing.B) {
+	benchMatrix(b, func(b *testing.B, client *rpc.Client) {
+		chunk := make([]byte, benchStreamChunk)
+		b.SetBytes(benchStreamChunk)
+		b.ReportAllocs()
+		b.ResetTimer()
+		args := make(chan any)
+		go func() {
+			for i := 0; i < b.N; i++ {
+				args <- chunk
+			}
+			close(args)
+		}()
+		var n int
+		if _, err := client.Call(context.Background(), "sink", args, &n); err != nil {
+			b.Fatal(err)
+		}
+		if n != b.N {
+			b.Fatalf("sink received %d values, expected %d", n, b.N)
+		}
+	})
+}
@@ -0,0 +1,207 @@
+// Command duplex-dump prints the frames, channel lifecycles and rpc headers and
+// values of duplex sessions, for debugging integration problems with other
+// implementations of the protocol stack.
+//
+// The proxy command sits between two endpoints, forwarding each connection to
+// the target while printing its traffic, and can capture the raw traffic of
+// each side to files. The read command prints a captured file.
+//
+//	duplex-dump proxy -o capture tcp://localhost:4001 tcp://localhost:4000
+//	duplex-dump read -side server capture-1.server
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/dump"
+	"tractor.dev/toolkit-go/engine/cli"
+)
+
+func main() {
+	log.SetOutput(os.Stderr)
+
+	root := &cli.Command{
+		Usage: "duplex-dump",
+		Long:  `duplex-dump prints the traffic of duplex sessions by proxying them or from captured files`,
+	}
+	root.AddCommand(proxyCmd())
+	root.AddCommand(readCmd())
+
+	if err := cli.Execute(cli.ContextWithIO(context.Background(), os.Stdin, os.Stdout, os.Stderr), root, os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func proxyCmd() *cli.Command {
+	cmd := &cli.Command{
+		Usage: "proxy <listen-url> <target-url>",
+		Short: "forward tcp or unix connections to a target, printing their traffic",
+		Args:  cli.ExactArgs(2),
+	}
+	codecName := cmd.Flags().String("codec", "", "codec of rpc values: json or cbor (default from handshake, or json)")
+	capture := cmd.Flags().String("o", "", "write the raw traffic of each side to <prefix>-<n>.client and <prefix>-<n>.server")
+	cmd.Run = func(ctx *cli.Context, args []string) {
+		l, err := listen(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer l.Close()
+
+		// output of concurrent connections is interleaved by line
+		out := &lockedWriter{w: ctx}
+		for n := 1; ; n++ {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Fatal(err)
+			}
+			target, err := dial(args[1])
+			if err != nil {
+				log.Println(err)
+				conn.Close()
+				continue
+			}
+			fmt.Fprintf(out, "# connection %d from %s\n", n, conn.RemoteAddr())
+			d := dump.New(out)
+			d.Codec = lookupCodec(*codecName)
+			client, server := io.ReadWriteCloser(conn), io.ReadWriteCloser(target)
+			if *capture != "" {
+				client = captureConn(conn, fmt.Sprintf("%s-%d.client", *capture, n))
+				server = captureConn(target, fmt.Sprintf("%s-%d.server", *capture, n))
+			}
+			go d.Proxy(client, server)
+		}
+	}
+	return cmd
+}
+
+func readCmd() *cli.Command {
+	cmd := &cli.Command{
+		Usage: "read [file]",
+		Short: "print the frames captured from one side of a session, from a file or stdin",
+		Args:  cli.MaxArgs(1),
+	}
+	codecName := cmd.Flags().String("codec", "", "codec of rpc values: json or cbor (default from handshake, or json)")
+	sideName := cmd.Flags().String("side", "client", "side that sent the frames: client or server")
+	cmd.Run = func(ctx *cli.Context, args []string) {
+		var side dump.Side
+		switch *sideName {
+		case "client":
+			side = dump.Client
+		case "server":
+			side = dump.Server
+		default:
+			log.Fatalf("unknown side %q", *sideName)
+		}
+
+		var r io.Reader = ctx
+		if len(args) > 0 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			r = f
+		}
+
+		d := dump.New(ctx)
+		d.Codec = lookupCodec(*codecName)
+		if err := d.Dump(side, r); err != nil {
+			os.Exit(1)
+		}
+	}
+	return cmd
+}
+
+func lookupCodec(name string) codec.Codec {
+	if name == "" {
+		return nil
+	}
+	c, ok := codec.Lookup("application/" + name)
+	if !ok {
+		log.Fatalf("unknown codec %q", name)
+	}
+	return c
+}
+
+// listen returns a listener for a tcp or unix URL. Other transports carry
+// frames inside their own framing, so they can't be dumped as raw bytes.
+func listen(rawurl string) (net.Listener, error) {
+	network, addr, err := parseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return net.Listen(network, addr)
+}
+
+func dial(rawurl string) (net.Conn, error) {
+	network, addr, err := parseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return net.Dial(network, addr)
+}
+
+func parseURL(rawurl string) (network, addr string, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", "", err
+	}
+	switch u.Scheme {
+	case "tcp":
+		return "tcp", u.Host, nil
+	case "unix":
+		return "unix", u.Path, nil
+	default:
+		return "", "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+}
+
+// capturedConn writes the bytes read from a connection to a file.
+type capturedConn struct {
+	net.Conn
+	r io.Reader
+	f *os.File
+}
+
+func captureConn(conn net.Conn, name string) io.ReadWriteCloser {
+	f, err := os.Create(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &capturedConn{Conn: conn, r: io.TeeReader(conn, f), f: f}
+}
+
+func (c *capturedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *capturedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *capturedConn) Close() error {
+	c.f.Close()
+	return c.Conn.Close()
+}
+
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
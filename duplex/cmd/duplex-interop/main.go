@@ -0,0 +1,157 @@
+// Command duplex-interop runs the interop service or the interop conformance
+// suite, so other implementations of the duplex protocol stack can check
+// their compatibility in CI.
+//
+//	duplex-interop serve tcp://localhost:4000
+//	duplex-interop check -json -cases unary/,stream tcp://localhost:4000
+//
+// The check command exits with status 1 if any case fails.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/fn"
+	"tractor.dev/toolkit-go/duplex/interop"
+	"tractor.dev/toolkit-go/duplex/mux"
+	"tractor.dev/toolkit-go/duplex/rpc"
+	"tractor.dev/toolkit-go/engine/cli"
+)
+
+func main() {
+	log.SetOutput(os.Stderr)
+
+	root := &cli.Command{
+		Usage: "duplex-interop",
+		Long:  `duplex-interop runs the interop service or checks an endpoint serving it`,
+	}
+	root.AddCommand(serveCmd())
+	root.AddCommand(checkCmd())
+
+	if err := cli.Execute(cli.ContextWithIO(context.Background(), os.Stdin, os.Stdout, os.Stderr), root, os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serveCmd() *cli.Command {
+	cmd := &cli.Command{
+		Usage: "serve [url]",
+		Short: "serve the interop service on a tcp, unix or ws url, or stdio",
+		Args:  cli.MaxArgs(1),
+	}
+	codecName := cmd.Flags().String("codec", "cbor", "codec to use: json or cbor")
+	cmd.Run = func(ctx *cli.Context, args []string) {
+		srv := &rpc.Server{
+			Handler: fn.HandlerFrom(interop.InteropService{}),
+			Codec:   lookupCodec(*codecName),
+		}
+		if len(args) == 0 || args[0] == "stdio:" {
+			sess, err := mux.DialStdio()
+			if err != nil {
+				log.Fatal(err)
+			}
+			srv.Respond(sess, nil)
+			return
+		}
+		l, err := listen(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer l.Close()
+		log.Fatal(srv.ServeMux(l))
+	}
+	return cmd
+}
+
+func checkCmd() *cli.Command {
+	cmd := &cli.Command{
+		Usage: "check <url>",
+		Short: "run the conformance suite against an endpoint serving the interop service",
+		Args:  cli.ExactArgs(1),
+	}
+	codecName := cmd.Flags().String("codec", "cbor", "codec to use: json or cbor")
+	names := cmd.Flags().String("cases", "", "comma separated names or name prefixes of cases to run, such as unary/,stream")
+	asJSON := cmd.Flags().Bool("json", false, "print results as a JSON array")
+	timeout := cmd.Flags().Duration("timeout", interop.CaseTimeout, "timeout for each case")
+	cmd.Run = func(ctx *cli.Context, args []string) {
+		c := lookupCodec(*codecName)
+		cases := selectCases(*names)
+		if len(cases) == 0 {
+			log.Fatalf("no cases match %q", *names)
+		}
+		interop.CaseTimeout = *timeout
+
+		results, err := interop.Check(ctx, args[0], c, cases...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *asJSON {
+			enc := json.NewEncoder(ctx)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(results); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			for _, r := range results {
+				fmt.Fprintln(ctx, r)
+			}
+		}
+		if failed := interop.Failed(results); len(failed) > 0 {
+			fmt.Fprintf(ctx.Errout(), "%d of %d cases failed\n", len(failed), len(results))
+			os.Exit(1)
+		}
+	}
+	return cmd
+}
+
+// selectCases returns the cases whose names match one of the comma separated
+// names, or start with one ending in "/". All cases are returned if names is
+// empty.
+func selectCases(names string) []interop.Case {
+	if names == "" {
+		return interop.Cases()
+	}
+	var cases []interop.Case
+	for _, cs := range interop.Cases() {
+		for _, name := range strings.Split(names, ",") {
+			if cs.Name == name || (strings.HasSuffix(name, "/") && strings.HasPrefix(cs.Name, name)) {
+				cases = append(cases, cs)
+				break
+			}
+		}
+	}
+	return cases
+}
+
+func lookupCodec(name string) codec.Codec {
+	c, ok := codec.Lookup("application/" + name)
+	if !ok {
+		log.Fatalf("unknown codec %q", name)
+	}
+	return c
+}
+
+// listen returns a listener for a tcp, unix or ws URL.
+func listen(rawurl string) (mux.Listener, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp":
+		return mux.ListenTCP(u.Host)
+	case "unix":
+		return mux.ListenUnix(u.Path)
+	case "ws":
+		return mux.ListenWS(u.Host)
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+}
@@ -1,35 +1,21 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/rand"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/url"
 	"os"
 	"os/exec"
-	"strings"
 
 	"tractor.dev/toolkit-go/duplex/codec"
-	"tractor.dev/toolkit-go/duplex/fn"
 	"tractor.dev/toolkit-go/duplex/interop"
 	"tractor.dev/toolkit-go/duplex/mux"
-	"tractor.dev/toolkit-go/duplex/rpc"
 	"tractor.dev/toolkit-go/duplex/x/quic"
 	"tractor.dev/toolkit-go/engine/cli"
 )
 
-var vals = []any{
-	100,
-	true,
-	"hello",
-	map[string]any{"foo": "bar"},
-	[]any{1, 2, 3},
-}
-
 var checkCmd = &cli.Command{
 	Usage: "check",
 	Short: "check interop",
@@ -99,71 +85,12 @@ var checkCmd = &cli.Command{
 
 		defer sess.Close()
 
-		srv := rpc.Server{
-			Handler: fn.HandlerFrom(interop.CallbackService{}),
-			Codec:   c,
-		}
-		go srv.Respond(sess, nil)
-
-		caller := rpc.NewClient(sess, c)
-		var ret any
-
-		// Error check
-		_, err = caller.Call(ctx, "Error", "test", nil)
-		if err == nil {
-			log.Fatal("expected error")
-		}
-		fmt.Println("Error:", strings.TrimPrefix(err.Error(), "remote: "))
-		_, err = caller.Call(ctx, "BadSelector", "test", nil)
-		if err == nil {
-			log.Fatal("expected error")
-		}
-		fmt.Println("Error:", strings.TrimPrefix(err.Error(), "remote: "))
-
-		// Unary check
-		for _, v := range vals {
-			_, err = caller.Call(ctx, "Unary", v, &ret)
-			fatal(err)
-			fmt.Println("Unary:", v, ret)
-		}
-
-		// Stream check
-		resp, err := caller.Call(ctx, "Stream", nil, nil)
-		fatal(err)
-		go func() {
-			for _, v := range vals {
-				fatal(resp.Send(v))
-			}
-			fatal(resp.Channel.CloseWrite())
-		}()
-		for {
-			err = resp.Receive(&ret)
-			if err != nil {
-				if err != io.EOF {
-					log.Println(err)
-				}
-				break
-			}
-			fmt.Println("Stream:", ret)
+		results := interop.Run(ctx, sess, c)
+		for _, r := range results {
+			fmt.Println(r)
 		}
-
-		// Bytes check
-		// 1 byte, 1kb, 1mb
-		for _, v := range []int{1, 1024, 1 << 20} {
-			data := make([]byte, v)
-			rand.Read(data)
-			resp, err = caller.Call(ctx, "Bytes", nil, nil)
-			fatal(err)
-			var buf bytes.Buffer
-			go func() {
-				io.Copy(resp.Channel, bytes.NewBuffer(data))
-				resp.Channel.CloseWrite()
-			}()
-			io.Copy(&buf, resp.Channel)
-			if buf.Len() != len(data) {
-				log.Fatal("byte stream buffer does not match")
-			}
-			fmt.Println("Bytes:", buf.Len())
+		if failed := interop.Failed(results); len(failed) > 0 {
+			log.Fatalf("%d of %d checks failed", len(failed), len(results))
 		}
 	},
 }
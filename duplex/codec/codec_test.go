@@ -2,6 +2,11 @@ package codec
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -51,3 +56,347 @@ func TestCBORCodec(t *testing.T) {
 		t.Fatal("unexpected data:", data)
 	}
 }
+
+func TestMsgpackCodec(t *testing.T) {
+	c := &MsgpackCodec{}
+	var buf bytes.Buffer
+
+	if err := c.Encoder(&buf).Encode(testData{
+		Map: map[string]bool{"true": true, "false": false},
+		Arr: []int{1, 2, 3},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var data testData
+	if err := c.Decoder(&buf).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Map["true"] != true || data.Arr[2] != 3 {
+		t.Fatal("unexpected data:", data)
+	}
+}
+
+type testShape interface {
+	Area() float64
+}
+
+type testSquare struct {
+	Side float64
+}
+
+func (s testSquare) Area() float64 { return s.Side * s.Side }
+
+type testCircle struct {
+	R float64
+}
+
+func (c *testCircle) Area() float64 { return 3 * c.R * c.R }
+
+func init() {
+	RegisterType("square", testSquare{})
+	RegisterType("circle", &testCircle{})
+}
+
+func TestTyped(t *testing.T) {
+	for _, c := range []Codec{JSONCodec{}, CBORCodec{}} {
+		var buf bytes.Buffer
+		enc := c.Encoder(&buf)
+		for _, v := range []any{testSquare{Side: 2}, &testCircle{R: 1}, nil} {
+			if err := enc.Encode(Typed{Value: v}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		dec := c.Decoder(&buf)
+		var values []any
+		for i := 0; i < 3; i++ {
+			var v Typed
+			if err := dec.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, v.Value)
+		}
+		if s, ok := values[0].(testSquare); !ok || s.Area() != 4 {
+			t.Fatalf("unexpected value: %#v", values[0])
+		}
+		if s, ok := values[1].(*testCircle); !ok || s.Area() != 3 {
+			t.Fatalf("unexpected value: %#v", values[1])
+		}
+		if values[2] != nil {
+			t.Fatalf("unexpected value: %#v", values[2])
+		}
+
+		if err := enc.Encode(Typed{Value: 1}); err == nil {
+			t.Fatal("expected error encoding unregistered type")
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Register("application/json", JSONCodec{})
+	r.Register("Application/CBOR", CBORCodec{})
+
+	if c, ok := r.Lookup("application/json; charset=utf-8"); !ok || c != (JSONCodec{}) {
+		t.Fatal("unexpected lookup:", c, ok)
+	}
+	if c, ok := r.Lookup("application/cbor"); !ok || c != (CBORCodec{}) {
+		t.Fatal("unexpected lookup:", c, ok)
+	}
+	if _, ok := r.Lookup("text/plain"); ok {
+		t.Fatal("unexpected codec for unregistered content type")
+	}
+	if types := r.ContentTypes(); len(types) != 2 || types[0] != "application/cbor" {
+		t.Fatal("unexpected content types:", types)
+	}
+	if _, ok := Lookup("application/json"); !ok {
+		t.Fatal("expected builtin codec in default registry")
+	}
+}
+
+func TestCompressed(t *testing.T) {
+	for _, algo := range []string{"gzip", "deflate"} {
+		c := Compressed(JSONCodec{}, algo)
+		var buf bytes.Buffer
+		enc := c.Encoder(&buf)
+
+		large := testData{Arr: make([]int, 1000)}
+		if err := enc.Encode(large); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() >= 1000 {
+			t.Fatalf("%s: expected compressed value, got %d bytes", algo, buf.Len())
+		}
+		if err := enc.Encode(testData{Arr: []int{1, 2, 3}}); err != nil {
+			t.Fatal(err)
+		}
+
+		dec := c.Decoder(&buf)
+		var data testData
+		if err := dec.Decode(&data); err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Arr) != 1000 {
+			t.Fatalf("%s: unexpected data length: %d", algo, len(data.Arr))
+		}
+		if err := dec.Decode(&data); err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Arr) != 3 || data.Arr[2] != 3 {
+			t.Fatalf("%s: unexpected data: %v", algo, data)
+		}
+	}
+
+	if err := Compressed(JSONCodec{}, "bogus").Encoder(&bytes.Buffer{}).Encode(make([]int, 1000)); err == nil {
+		t.Fatal("expected unknown compression error")
+	}
+	RegisterCompressor("bogus", gzipCompressor{})
+	if err := Compressed(JSONCodec{}, "bogus").Encoder(&bytes.Buffer{}).Encode(make([]int, 1000)); err != nil {
+		t.Fatal("expected registered compression:", err)
+	}
+
+	// a corrupt length prefix
+	corrupt := binary.AppendUvarint([]byte{0}, 1<<62)
+	if err := Compressed(JSONCodec{}, "gzip").Decoder(bytes.NewReader(corrupt)).Decode(nil); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatal("expected value too large error:", err)
+	}
+
+	// a value decompressing to more than MaxValueSize
+	defer func(size int) { MaxValueSize = size }(MaxValueSize)
+	MaxValueSize = 4096
+	var buf bytes.Buffer
+	if err := Compressed(JSONCodec{}, "gzip").Encoder(&buf).Encode(strings.Repeat("a", 8192)); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := Compressed(JSONCodec{}, "gzip").Decoder(&buf).Decode(&s); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatal("expected value too large error:", err)
+	}
+}
+
+func TestJSONCodecCanonical(t *testing.T) {
+	c := JSONCodec{Canonical: true}
+	var buf bytes.Buffer
+	enc := c.Encoder(&buf)
+
+	type value struct {
+		Z string
+		A []any
+		M map[string]float64
+	}
+	if err := enc.Encode(value{
+		Z: "<tag>",
+		A: []any{1.0, 2.5, 1e21, uint64(math.MaxUint64), nil, true},
+		M: map[string]float64{"b": 2, "a": 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"A":[1,2.5,1e+21,18446744073709551615,null,true],"M":{"a":1,"b":2},"Z":"<tag>"}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected encoding: %s", buf.String())
+	}
+
+	var data value
+	if err := c.Decoder(&buf).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Z != "<tag>" || data.M["b"] != 2 {
+		t.Fatal("unexpected data:", data)
+	}
+}
+
+func TestTagged(t *testing.T) {
+	for _, c := range []Codec{Tagged(JSONCodec{}), Tagged(CBORCodec{})} {
+		var buf bytes.Buffer
+		enc := c.Encoder(&buf)
+		for _, v := range []any{testSquare{Side: 2}, &testCircle{R: 1}, "plain", nil, testSquare{Side: 3}} {
+			if err := enc.Encode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		dec := c.Decoder(&buf)
+		var values []any
+		for i := 0; i < 4; i++ {
+			var v any
+			if err := dec.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, v)
+		}
+		if s, ok := values[0].(testSquare); !ok || s.Area() != 4 {
+			t.Fatalf("unexpected value: %#v", values[0])
+		}
+		if s, ok := values[1].(*testCircle); !ok || s.Area() != 3 {
+			t.Fatalf("unexpected value: %#v", values[1])
+		}
+		if values[2] != "plain" || values[3] != nil {
+			t.Fatalf("unexpected values: %#v", values[2:])
+		}
+
+		var shape testShape
+		if err := dec.Decode(&shape); err != nil {
+			t.Fatal(err)
+		}
+		if shape.Area() != 9 {
+			t.Fatalf("unexpected value: %#v", shape)
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	c := Raw(JSONCodec{})
+	var buf bytes.Buffer
+	enc := c.Encoder(&buf)
+
+	payload := bytes.Repeat([]byte{0xff}, 100000)
+	for _, v := range []any{payload, testData{Arr: []int{1, 2, 3}}, bytes.NewReader(payload), bytes.NewReader(nil), []byte("last")} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.Len() > 2*len(payload)+100 {
+		t.Fatalf("unexpected encoded size: %d", buf.Len())
+	}
+
+	dec := c.Decoder(&buf)
+	var b []byte
+	if err := dec.Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, payload) {
+		t.Fatal("unexpected bytes")
+	}
+	var data testData
+	if err := dec.Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Arr[2] != 3 {
+		t.Fatal("unexpected data:", data)
+	}
+	var r io.Reader
+	if err := dec.Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	head := make([]byte, 10)
+	if _, err := io.ReadFull(r, head); err != nil {
+		t.Fatal(err)
+	}
+	// the rest of the stream is discarded by the next decode
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := v.([]byte); !ok || len(b) != 0 {
+		t.Fatalf("unexpected value: %#v", v)
+	}
+	if err := dec.Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "last" {
+		t.Fatal("unexpected bytes:", string(b))
+	}
+
+	corrupt := binary.AppendUvarint([]byte{rawBytes}, 1<<62)
+	if err := Raw(JSONCodec{}).Decoder(bytes.NewReader(corrupt)).Decode(nil); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatal("expected value too large error:", err)
+	}
+}
+
+func TestValidated(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{
+		"type": "object",
+		"required": ["name"],
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"tags": {"type": "array", "items": {"type": "string", "enum": ["a", "b"]}},
+			"age": {"type": "integer", "minimum": 0}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []Codec{JSONCodec{}, CBORCodec{}} {
+		var buf bytes.Buffer
+		vc := Validated(c, schema)
+		enc := vc.Encoder(&buf)
+		if err := enc.Encode(map[string]any{"name": "bob", "tags": []string{"a"}, "age": 3}); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(map[string]any{"name": "bob", "tags": []string{"a", "c"}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(map[string]any{"age": 1.5}); err != nil {
+			t.Fatal(err)
+		}
+
+		dec := vc.Decoder(&buf)
+		var v struct {
+			Name string
+			Age  int
+		}
+		if err := dec.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Name != "bob" || v.Age != 3 {
+			t.Fatal("unexpected value:", v)
+		}
+		err := dec.Decode(&v)
+		serr, ok := err.(*SchemaError)
+		if !ok || serr.Path != "$.tags[1]" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = dec.Decode(&v)
+		serr, ok = err.(*SchemaError)
+		if !ok || serr.Path != "$" || serr.Message != `missing required property "name"` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if _, err := ParseSchema([]byte(`{"items": {"pattern": "["}}`)); err == nil {
+		t.Fatal("expected invalid pattern error")
+	}
+}
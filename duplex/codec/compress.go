@@ -0,0 +1,233 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A Compressor compresses and decompresses encoded values.
+type Compressor interface {
+	Compress(w io.Writer) io.WriteCloser
+	Decompress(r io.Reader) (io.Reader, error)
+}
+
+// compressors maps compression names to the registered Compressors.
+var compressors = struct {
+	sync.RWMutex
+	m map[string]Compressor
+}{m: map[string]Compressor{
+	"gzip":    gzipCompressor{},
+	"deflate": flateCompressor{},
+}}
+
+// RegisterCompressor sets the Compressor for a compression name, replacing any
+// registered for it. The "gzip" and "deflate" compressions are built in, and
+// "zstd" and "snappy" are registered by importing the duplex/x/zstd and
+// duplex/x/snappy modules, which keeps this module free of third-party
+// compression implementations. Both sides must have a compression registered
+// to use it.
+func RegisterCompressor(name string, c Compressor) {
+	compressors.Lock()
+	defer compressors.Unlock()
+	compressors.m[name] = c
+}
+
+// LookupCompressor returns the Compressor registered for a compression name.
+func LookupCompressor(name string) (Compressor, bool) {
+	compressors.RLock()
+	defer compressors.RUnlock()
+	c, ok := compressors.m[name]
+	return c, ok
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+func (gzipCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+type flateCompressor struct{}
+
+func (flateCompressor) Compress(w io.Writer) io.WriteCloser {
+	fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+	return fw
+}
+
+func (flateCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return flate.NewReader(r), nil
+}
+
+// MaxValueSize is the largest length prefixed value that Compressed and Raw
+// codecs will read, and that a Compressed codec will decompress a value to,
+// so a corrupt length prefix or a highly compressed value can't exhaust memory.
+var MaxValueSize = 64 << 20
+
+// ErrValueTooLarge is returned when decoding a value larger than MaxValueSize.
+var ErrValueTooLarge = errors.New("codec: value too large")
+
+// preallocValueSize is the most allocated up front to read a value. Larger
+// values are allocated as they arrive.
+const preallocValueSize = 64 << 10
+
+// readValue reads a value of size bytes, which must not be more than MaxValueSize.
+func readValue(r io.Reader, size uint64) ([]byte, error) {
+	if size > uint64(MaxValueSize) {
+		return nil, fmt.Errorf("%w: %d bytes", ErrValueTooLarge, size)
+	}
+	var buf bytes.Buffer
+	buf.Grow(int(min(size, preallocValueSize)))
+	read, err := io.CopyN(&buf, r, int64(size))
+	if err == io.EOF && read > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CompressThreshold is the smallest encoded value size that Compressed codecs
+// will compress, since compressing small values makes them larger.
+const CompressThreshold = 512
+
+// Compressed returns a codec that compresses values encoded by the inner codec
+// using the named Compressor, if they are at least CompressThreshold bytes.
+//
+// Each value is written with a flag byte and a length prefix, so both sides
+// must use the same Compressed codec. To agree on one when setting up a session,
+// register it with a content type such as "application/cbor+gzip".
+func Compressed(inner Codec, algo string) Codec {
+	return compressedCodec{inner: inner, algo: algo}
+}
+
+type compressedCodec struct {
+	inner Codec
+	algo  string
+}
+
+func (c compressedCodec) Encoder(w io.Writer) Encoder {
+	return &compressedEncoder{codec: c, w: w}
+}
+
+func (c compressedCodec) Decoder(r io.Reader) Decoder {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &compressedDecoder{codec: c, r: br}
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+type compressedEncoder struct {
+	codec compressedCodec
+	w     io.Writer
+}
+
+func (e *compressedEncoder) Encode(v interface{}) error {
+	var buf bytes.Buffer
+	if err := e.codec.inner.Encoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	flag := byte(0)
+	if len(b) >= CompressThreshold {
+		var err error
+		b, err = Compress(e.codec.algo, b)
+		if err != nil {
+			return err
+		}
+		flag = 1
+	}
+	header := binary.AppendUvarint([]byte{flag}, uint64(len(b)))
+	_, err := e.w.Write(append(header, b...))
+	return err
+}
+
+type compressedDecoder struct {
+	codec compressedCodec
+	r     byteReader
+}
+
+func (d *compressedDecoder) Decode(v interface{}) error {
+	flag, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	size, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return err
+	}
+	b, err := readValue(d.r, size)
+	if err != nil {
+		return err
+	}
+	if flag == 1 {
+		b, err = decompress(d.codec.algo, b, int64(MaxValueSize))
+		if err != nil {
+			return err
+		}
+	}
+	return d.codec.inner.Decoder(bytes.NewReader(b)).Decode(v)
+}
+
+// Compress compresses b using the named Compressor.
+func Compress(name string, b []byte) ([]byte, error) {
+	c, ok := LookupCompressor(name)
+	if !ok {
+		return nil, fmt.Errorf("codec: unknown compression: %s", name)
+	}
+	var buf bytes.Buffer
+	w := c.Compress(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses b using the named Compressor.
+func Decompress(name string, b []byte) ([]byte, error) {
+	return decompress(name, b, -1)
+}
+
+// decompress decompresses b using the named Compressor, returning
+// ErrValueTooLarge if it decompresses to more than limit bytes. A negative
+// limit means there is no limit.
+func decompress(name string, b []byte, limit int64) ([]byte, error) {
+	c, ok := LookupCompressor(name)
+	if !ok {
+		return nil, fmt.Errorf("codec: unknown compression: %s", name)
+	}
+	r, err := c.Decompress(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if limit < 0 {
+		return io.ReadAll(r)
+	}
+	out, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, fmt.Errorf("%w: decompresses to more than %d bytes", ErrValueTooLarge, limit)
+	}
+	return out, nil
+}
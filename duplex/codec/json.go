@@ -1,15 +1,30 @@
 package codec
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // JSONCodec provides a codec API for the standard library JSON encoder and decoder.
-type JSONCodec struct{}
+//
+// If Canonical is set, values are encoded in a canonical form so the same value
+// always encodes to the same bytes, which is useful for hashing or signing encoded
+// values. Object keys are sorted, HTML characters are not escaped, and numbers are
+// written as integers when they are whole, otherwise in the shortest form.
+type JSONCodec struct {
+	Canonical bool
+}
 
 // Encoder returns a JSON encoder
 func (c JSONCodec) Encoder(w io.Writer) Encoder {
+	if c.Canonical {
+		return &canonicalEncoder{w: w}
+	}
 	return json.NewEncoder(w)
 }
 
@@ -17,3 +32,109 @@ func (c JSONCodec) Encoder(w io.Writer) Encoder {
 func (c JSONCodec) Decoder(r io.Reader) Decoder {
 	return json.NewDecoder(r)
 }
+
+type canonicalEncoder struct {
+	w io.Writer
+}
+
+func (e *canonicalEncoder) Encode(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, value); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err = e.w.Write(buf.Bytes())
+	return err
+}
+
+// isIntegerLiteral returns whether s is a JSON number without a fraction or exponent.
+func isIntegerLiteral(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func writeCanonical(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeString(buf, k)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []any:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			buf.WriteString(strconv.FormatInt(i, 10))
+			return nil
+		}
+		if isIntegerLiteral(string(v)) {
+			// out of the int64 range, where a float would lose precision
+			buf.WriteString(string(v))
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		if math.Abs(f) < 1<<53 && f == math.Trunc(f) {
+			buf.WriteString(strconv.FormatInt(int64(f), 10))
+			return nil
+		}
+		buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	case string:
+		writeString(buf, v)
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	// remove the newline written by Encode
+	buf.Truncate(buf.Len() - 1)
+}
@@ -0,0 +1,27 @@
+package codec
+
+import (
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackCodec provides a codec API for a MessagePack encoder and decoder.
+//
+// Struct fields are named by their json tags, like the other codecs, and
+// maps decoded into empty interface values have string keys.
+type MsgpackCodec struct{}
+
+// Encoder returns a MessagePack encoder
+func (c MsgpackCodec) Encoder(w io.Writer) Encoder {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc
+}
+
+// Decoder returns a MessagePack decoder
+func (c MsgpackCodec) Decoder(r io.Reader) Decoder {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	return dec
+}
@@ -0,0 +1,197 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	rawValue  = 0 // a value encoded by the inner codec
+	rawBytes  = 1 // a byte slice
+	rawStream = 2 // chunks read from an io.Reader, ending with an empty chunk
+)
+
+// rawChunkSize is the size of chunks read from an io.Reader when encoding.
+const rawChunkSize = 32 * 1024
+
+// Raw returns a codec that writes []byte values and the contents of io.Reader
+// values as raw bytes, avoiding the overhead of encodings like base64 in JSON.
+// Other values are encoded with the inner codec. Values are written with a type
+// byte and length prefix, so both sides must use a Raw codec.
+//
+// Byte slices and reader contents can be decoded into a *[]byte, an *io.Reader,
+// or an empty interface value, which is given a []byte. Decoding into an
+// *io.Reader gives a reader of the contents as they are read from the stream,
+// which must be read before the next value is decoded or the rest of the
+// contents are discarded. Byte slices and values of the inner codec are limited
+// to MaxValueSize when decoding, while reader contents are not.
+func Raw(inner Codec) Codec {
+	return rawCodec{inner: inner}
+}
+
+type rawCodec struct {
+	inner Codec
+}
+
+func (c rawCodec) Encoder(w io.Writer) Encoder {
+	return &rawEncoder{codec: c, w: w}
+}
+
+func (c rawCodec) Decoder(r io.Reader) Decoder {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &rawDecoder{codec: c, r: br}
+}
+
+type rawEncoder struct {
+	codec rawCodec
+	w     io.Writer
+}
+
+func (e *rawEncoder) Encode(v interface{}) error {
+	switch v := v.(type) {
+	case []byte:
+		return e.write(rawBytes, v)
+	case io.Reader:
+		if _, err := e.w.Write([]byte{rawStream}); err != nil {
+			return err
+		}
+		buf := make([]byte, rawChunkSize)
+		for {
+			n, err := v.Read(buf)
+			if n > 0 {
+				if err := e.chunk(buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				return e.chunk(nil)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		var buf bytes.Buffer
+		if err := e.codec.inner.Encoder(&buf).Encode(v); err != nil {
+			return err
+		}
+		return e.write(rawValue, buf.Bytes())
+	}
+}
+
+// write writes the type byte followed by a length prefixed chunk.
+func (e *rawEncoder) write(kind byte, b []byte) error {
+	header := binary.AppendUvarint([]byte{kind}, uint64(len(b)))
+	_, err := e.w.Write(append(header, b...))
+	return err
+}
+
+// chunk writes a length prefixed chunk.
+func (e *rawEncoder) chunk(b []byte) error {
+	header := binary.AppendUvarint(nil, uint64(len(b)))
+	_, err := e.w.Write(append(header, b...))
+	return err
+}
+
+type rawDecoder struct {
+	codec rawCodec
+	r     byteReader
+	// stream is the reader of the last decoded stream, if it hasn't ended
+	stream *rawStreamReader
+}
+
+func (d *rawDecoder) Decode(v interface{}) error {
+	if d.stream != nil {
+		if _, err := io.Copy(io.Discard, d.stream); err != nil {
+			return err
+		}
+	}
+	kind, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case rawValue:
+		b, err := d.chunk()
+		if err != nil {
+			return err
+		}
+		return d.codec.inner.Decoder(bytes.NewReader(b)).Decode(v)
+	case rawBytes, rawStream:
+		var r io.Reader
+		if kind == rawBytes {
+			b, err := d.chunk()
+			if err != nil {
+				return err
+			}
+			r = bytes.NewReader(b)
+		} else {
+			d.stream = &rawStreamReader{d: d}
+			r = d.stream
+		}
+		switch v := v.(type) {
+		case *io.Reader:
+			*v = r
+			return nil
+		case *[]byte:
+			*v, err = io.ReadAll(r)
+			return err
+		case *any:
+			*v, err = io.ReadAll(r)
+			return err
+		case nil:
+			_, err = io.Copy(io.Discard, r)
+			return err
+		default:
+			return fmt.Errorf("codec: cannot decode raw bytes into %T", v)
+		}
+	default:
+		return fmt.Errorf("codec: unknown raw value type: %d", kind)
+	}
+}
+
+// chunk reads a length prefixed chunk, which must not be more than MaxValueSize.
+func (d *rawDecoder) chunk() ([]byte, error) {
+	size, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, err
+	}
+	return readValue(d.r, size)
+}
+
+// rawStreamReader reads the chunks of a stream until the empty chunk.
+type rawStreamReader struct {
+	d         *rawDecoder
+	remaining uint64
+	done      bool
+}
+
+func (r *rawStreamReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	if r.remaining == 0 {
+		size, err := binary.ReadUvarint(r.d.r)
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			r.done = true
+			r.d.stream = nil
+			return 0, io.EOF
+		}
+		r.remaining = size
+	}
+	if uint64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.d.r.Read(p)
+	r.remaining -= uint64(n)
+	return n, err
+}
@@ -0,0 +1,76 @@
+package codec
+
+import (
+	"mime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry maps content types to codecs.
+type Registry struct {
+	mu     sync.RWMutex
+	codecs map[string]Codec
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{codecs: make(map[string]Codec)}
+}
+
+// Register sets the codec for contentType, replacing any codec
+// already registered for it.
+func (r *Registry) Register(contentType string, c Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codecs[mediaType(contentType)] = c
+}
+
+// Lookup returns the codec for contentType. Parameters of the content
+// type, such as charset, are ignored.
+func (r *Registry) Lookup(contentType string) (Codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.codecs[mediaType(contentType)]
+	return c, ok
+}
+
+// ContentTypes returns the registered content types in sorted order.
+func (r *Registry) ContentTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var types []string
+	for t := range r.codecs {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// mediaType returns the lowercase media type of a content type without parameters.
+func mediaType(contentType string) string {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mt
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// DefaultRegistry is the Registry used by Register and Lookup.
+// It includes the builtin codecs.
+var DefaultRegistry = NewRegistry()
+
+func init() {
+	Register("application/json", JSONCodec{})
+	Register("application/cbor", CBORCodec{})
+	Register("application/msgpack", MsgpackCodec{})
+}
+
+// Register sets the codec for contentType in the DefaultRegistry.
+func Register(contentType string, c Codec) {
+	DefaultRegistry.Register(contentType, c)
+}
+
+// Lookup returns the codec for contentType from the DefaultRegistry.
+func Lookup(contentType string) (Codec, bool) {
+	return DefaultRegistry.Lookup(contentType)
+}
@@ -0,0 +1,297 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Schema is a subset of JSON Schema used to validate decoded values. It supports
+// the type, enum, properties, required, additionalProperties, items, minimum,
+// maximum, minLength, maxLength, pattern, minItems and maxItems keywords.
+//
+// A Schema satisfies the rpc.Validator interface, so it can be used to validate
+// the params of calls to a particular selector with RespondMux.SetValidator.
+type Schema struct {
+	Type                 SchemaType         `json:"type,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+
+	// the compiled Pattern, compiled once when first needed
+	patternOnce sync.Once
+	pattern     *regexp.Regexp
+	patternErr  error
+}
+
+// compiledPattern returns Pattern compiled as a regular expression.
+func (s *Schema) compiledPattern() (*regexp.Regexp, error) {
+	s.patternOnce.Do(func() {
+		s.pattern, s.patternErr = regexp.Compile(s.Pattern)
+	})
+	return s.pattern, s.patternErr
+}
+
+// compile compiles the patterns of the schema and its subschemas.
+func (s *Schema) compile() error {
+	if s.Pattern != "" {
+		if _, err := s.compiledPattern(); err != nil {
+			return fmt.Errorf("codec: invalid pattern: %w", err)
+		}
+	}
+	for _, p := range s.Properties {
+		if err := p.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// SchemaType is one or more JSON Schema type names.
+type SchemaType []string
+
+func (t *SchemaType) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*t = SchemaType{name}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+// ParseSchema parses a JSON Schema document, returning an error if any of its
+// patterns are not valid regular expressions.
+func ParseSchema(b []byte) (*Schema, error) {
+	s := &Schema{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SchemaError describes where and why a value failed validation.
+type SchemaError struct {
+	Path    string
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Validate returns a SchemaError if v does not match the schema. Values are
+// checked as they would be encoded as JSON, so structs are checked as objects.
+func (s *Schema) Validate(v any) error {
+	b, err := json.Marshal(normalize(v))
+	if err != nil {
+		return err
+	}
+	var value any
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	return s.validate("$", value)
+}
+
+// normalize converts maps with non-string keys, as decoded by CBOR,
+// to maps with string keys so they can be encoded as JSON.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, e := range v {
+			s[i] = normalize(e)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+func (s *Schema) validate(path string, v any) error {
+	fail := func(format string, args ...any) error {
+		return &SchemaError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+	if len(s.Type) > 0 && !s.Type.matches(v) {
+		return fail("expected %s, got %s", strings.Join(s.Type, " or "), jsonType(v))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("value not in enum %v", s.Enum)
+		}
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fail("missing required property %q", name)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			prop, ok := s.Properties[k]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fail("unexpected property %q", k)
+				}
+				continue
+			}
+			if err := prop.validate(path+"."+k, v[k]); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return fail("expected at least %d items, got %d", *s.MinItems, len(v))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fail("expected at most %d items, got %d", *s.MaxItems, len(v))
+		}
+		if s.Items != nil {
+			for i, e := range v {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), e); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := len([]rune(v))
+		if s.MinLength != nil && n < *s.MinLength {
+			return fail("expected length at least %d, got %d", *s.MinLength, n)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fail("expected length at most %d, got %d", *s.MaxLength, n)
+		}
+		if s.Pattern != "" {
+			re, err := s.compiledPattern()
+			if err != nil {
+				return fail("invalid pattern: %s", err)
+			}
+			if !re.MatchString(v) {
+				return fail("does not match pattern %q", s.Pattern)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fail("expected at least %v, got %v", *s.Minimum, v)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fail("expected at most %v, got %v", *s.Maximum, v)
+		}
+	}
+	return nil
+}
+
+func (t SchemaType) matches(v any) bool {
+	typ := jsonType(v)
+	for _, name := range t {
+		if name == typ || (name == "number" && typ == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// Validated returns a codec that validates values decoded by the inner codec
+// against the schema, returning a SchemaError for invalid values. Values are
+// first decoded as empty interface values to be validated, then encoded again
+// to be decoded into the given value.
+func Validated(inner Codec, schema *Schema) Codec {
+	return validatedCodec{inner: inner, schema: schema}
+}
+
+type validatedCodec struct {
+	inner  Codec
+	schema *Schema
+}
+
+func (c validatedCodec) Encoder(w io.Writer) Encoder {
+	return c.inner.Encoder(w)
+}
+
+func (c validatedCodec) Decoder(r io.Reader) Decoder {
+	return &validatedDecoder{codec: c, dec: c.inner.Decoder(r)}
+}
+
+type validatedDecoder struct {
+	codec validatedCodec
+	dec   Decoder
+}
+
+func (d *validatedDecoder) Decode(v interface{}) error {
+	var value any
+	if err := d.dec.Decode(&value); err != nil {
+		return err
+	}
+	if err := d.codec.schema.Validate(value); err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := d.codec.inner.Encoder(&buf).Encode(value); err != nil {
+		return err
+	}
+	return d.codec.inner.Decoder(&buf).Decode(v)
+}
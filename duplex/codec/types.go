@@ -0,0 +1,233 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+var typeRegistry struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}
+
+// RegisterType registers the concrete type of v with name, allowing values of
+// the type to be tagged with the name when encoded as a Typed value and decoded
+// back into the same type. Registering a name or type again panics.
+func RegisterType(name string, v any) {
+	t := reflect.TypeOf(v)
+	if name == "" || t == nil {
+		panic("codec: invalid type registration")
+	}
+	typeRegistry.Lock()
+	defer typeRegistry.Unlock()
+	if typeRegistry.byName == nil {
+		typeRegistry.byName = make(map[string]reflect.Type)
+		typeRegistry.byType = make(map[reflect.Type]string)
+	}
+	if _, exists := typeRegistry.byName[name]; exists {
+		panic(fmt.Sprintf("codec: type name registered twice: %s", name))
+	}
+	if _, exists := typeRegistry.byType[t]; exists {
+		panic(fmt.Sprintf("codec: type registered twice: %s", t))
+	}
+	typeRegistry.byName[name] = t
+	typeRegistry.byType[t] = name
+}
+
+// TypeName returns the registered name for the concrete type of v.
+func TypeName(v any) (string, bool) {
+	typeRegistry.RLock()
+	defer typeRegistry.RUnlock()
+	name, ok := typeRegistry.byType[reflect.TypeOf(v)]
+	return name, ok
+}
+
+func registeredType(name string) (reflect.Type, bool) {
+	typeRegistry.RLock()
+	defer typeRegistry.RUnlock()
+	t, ok := typeRegistry.byName[name]
+	return t, ok
+}
+
+// Typed holds a value that is encoded along with the registered name of its
+// type, so it can be decoded back into a value of that type. Decoding a Typed
+// value with an unknown type name returns an error. A nil Value is encoded as
+// nil. Typed supports the JSON and CBOR codecs.
+type Typed struct {
+	Value any
+}
+
+type typedWire[T any] struct {
+	T string // Type name
+	V T      // Value
+}
+
+func (t Typed) wire() (typedWire[any], error) {
+	name, ok := TypeName(t.Value)
+	if !ok {
+		return typedWire[any]{}, fmt.Errorf("codec: type not registered: %T", t.Value)
+	}
+	return typedWire[any]{T: name, V: t.Value}, nil
+}
+
+// value decodes the value of a type name using the decode function.
+func (t *Typed) value(name string, decode func(v any) error) error {
+	if name == "" {
+		t.Value = nil
+		return nil
+	}
+	typ, ok := registeredType(name)
+	if !ok {
+		return fmt.Errorf("codec: unknown type name: %s", name)
+	}
+	v := reflect.New(typ)
+	if err := decode(v.Interface()); err != nil {
+		return err
+	}
+	t.Value = v.Elem().Interface()
+	return nil
+}
+
+func (t Typed) MarshalJSON() ([]byte, error) {
+	if t.Value == nil {
+		return []byte("null"), nil
+	}
+	w, err := t.wire()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(w)
+}
+
+func (t *Typed) UnmarshalJSON(b []byte) error {
+	var w typedWire[json.RawMessage]
+	if err := json.Unmarshal(b, &w); err != nil {
+		return err
+	}
+	return t.value(w.T, func(v any) error {
+		return json.Unmarshal(w.V, v)
+	})
+}
+
+func (t Typed) MarshalCBOR() ([]byte, error) {
+	if t.Value == nil {
+		return cbor.Marshal(nil)
+	}
+	w, err := t.wire()
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Marshal(w)
+}
+
+func (t *Typed) UnmarshalCBOR(b []byte) error {
+	var w typedWire[cbor.RawMessage]
+	if err := cbor.Unmarshal(b, &w); err != nil {
+		return err
+	}
+	return t.value(w.T, func(v any) error {
+		return cbor.Unmarshal(w.V, v)
+	})
+}
+
+// Tagged returns a codec that tags every value encoded by the inner codec with
+// the registered name of its type, or an empty name if the type isn't registered.
+// Decoding into an empty interface value gives a value of the registered type for
+// the name, so streams of values of different types can be decoded without
+// knowing the type of each value ahead of time. Decoding into any other value
+// decodes as the inner codec would. Both sides must use a Tagged codec.
+func Tagged(inner Codec) Codec {
+	return taggedCodec{inner: inner}
+}
+
+type taggedCodec struct {
+	inner Codec
+}
+
+func (c taggedCodec) Encoder(w io.Writer) Encoder {
+	return &taggedEncoder{codec: c, enc: c.inner.Encoder(w)}
+}
+
+func (c taggedCodec) Decoder(r io.Reader) Decoder {
+	return &taggedDecoder{codec: c, dec: c.inner.Decoder(r)}
+}
+
+type taggedEncoder struct {
+	codec taggedCodec
+	enc   Encoder
+}
+
+func (e *taggedEncoder) Encode(v interface{}) error {
+	name, _ := TypeName(v)
+	return e.enc.Encode(typedWire[any]{T: name, V: v})
+}
+
+type taggedDecoder struct {
+	codec taggedCodec
+	dec   Decoder
+}
+
+func (d *taggedDecoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		// let the inner codec decide how to handle the value
+		return d.dec.Decode(&typedWire[any]{V: v})
+	}
+	// decode into a wire struct with a V field of the target type,
+	// using an empty interface for interface types
+	target := rv.Elem()
+	vt := target.Type()
+	if vt.Kind() == reflect.Interface {
+		vt = reflect.TypeOf((*any)(nil)).Elem()
+	}
+	wt := reflect.StructOf([]reflect.StructField{
+		{Name: "T", Type: reflect.TypeOf("")},
+		{Name: "V", Type: vt},
+	})
+	w := reflect.New(wt)
+	if err := d.dec.Decode(w.Interface()); err != nil {
+		return err
+	}
+	name := w.Elem().Field(0).String()
+	value := w.Elem().Field(1)
+	if target.Kind() != reflect.Interface {
+		target.Set(value)
+		return nil
+	}
+	if value.IsNil() {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+	if name == "" {
+		if !value.Elem().Type().AssignableTo(target.Type()) {
+			return fmt.Errorf("codec: %s value not assignable to %s", value.Elem().Type(), target.Type())
+		}
+		target.Set(value.Elem())
+		return nil
+	}
+	typ, ok := registeredType(name)
+	if !ok {
+		return fmt.Errorf("codec: unknown type name: %s", name)
+	}
+	// re-encode the generically decoded value to decode it into the registered type
+	var buf bytes.Buffer
+	if err := d.codec.inner.Encoder(&buf).Encode(value.Interface()); err != nil {
+		return err
+	}
+	tv := reflect.New(typ)
+	if err := d.codec.inner.Decoder(&buf).Decode(tv.Interface()); err != nil {
+		return err
+	}
+	if !typ.AssignableTo(target.Type()) {
+		return fmt.Errorf("codec: %s value not assignable to %s", typ, target.Type())
+	}
+	target.Set(tv.Elem())
+	return nil
+}
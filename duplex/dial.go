@@ -0,0 +1,53 @@
+package duplex
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/talk"
+	"tractor.dev/toolkit-go/duplex/transport"
+)
+
+// Dial connects to the URL with the transport for its scheme, as supported by
+// transport.Dial, and returns a Peer using the codec named by the "codec" query
+// parameter, such as "json", or CBOR if there is none. Stdio URLs always use
+// CBOR. Call Respond on the Peer to also serve calls from the remote side. The
+// context only bounds making the connection.
+func Dial(ctx context.Context, rawurl string) (*talk.Peer, error) {
+	c, rawurl, err := codecFrom(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := transport.Dial(ctx, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return talk.NewPeer(sess, c), nil
+}
+
+// codecFrom returns the codec named by the "codec" query parameter of
+// rawurl, and rawurl without the parameter.
+func codecFrom(rawurl string) (codec.Codec, string, error) {
+	if strings.HasPrefix(rawurl, "stdio:") {
+		return codec.CBORCodec{}, rawurl, nil
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, "", err
+	}
+	q := u.Query()
+	name := q.Get("codec")
+	if name == "" {
+		return codec.CBORCodec{}, rawurl, nil
+	}
+	c, ok := codec.Lookup("application/" + name)
+	if !ok {
+		return nil, "", fmt.Errorf("duplex: unknown codec '%s'", name)
+	}
+	q.Del("codec")
+	u.RawQuery = q.Encode()
+	return c, u.String(), nil
+}
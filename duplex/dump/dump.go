@@ -0,0 +1,409 @@
+// Package dump decodes the raw traffic of duplex sessions and prints their
+// frames, the lifecycle of their channels, and the rpc headers and values sent
+// over the channels, for debugging integration problems with other
+// implementations of the protocol.
+//
+// A Dumper is given the bytes sent by each side of a session with Dump, such
+// as with Proxy sitting between the two sides, or from a file of frames
+// captured from one side:
+//
+//	d := dump.New(os.Stdout)
+//	d.Dump(dump.Client, f)
+package dump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/mux/frame"
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+// MaxValueLength is the longest a decoded value is printed before it is truncated.
+var MaxValueLength = 200
+
+// Side is a side of a session.
+type Side uint8
+
+const (
+	// Client is the side that dialed the session.
+	Client Side = iota
+	// Server is the side that accepted the session.
+	Server
+)
+
+func (s Side) String() string {
+	if s == Server {
+		return "server"
+	}
+	return "client"
+}
+
+func (s Side) other() Side {
+	return 1 - s
+}
+
+// Dumper prints the frames sent by both sides of a session. It is safe to
+// call Dump for each side from its own goroutine.
+type Dumper struct {
+	// Codec is used to decode rpc headers and values. If nil, the first codec
+	// named in a hello frame that is in the codec registry is used, or else the
+	// registered codec that decodes the first rpc header, falling back to JSON.
+	Codec codec.Codec
+
+	w  io.Writer
+	mu sync.Mutex
+	// channels are keyed by the side and its local ID for the channel, so
+	// frames from the other side can be matched by their channel ID.
+	channels map[chanKey]*channel
+	nextID   int
+}
+
+type chanKey struct {
+	side Side
+	id   uint32
+}
+
+type channel struct {
+	name   string
+	opener Side
+	closed [2]bool
+	rpc    [2]*rpcStream
+	// unknown is set for channels whose opening was not seen, such as in a
+	// capture of one side, so which side opened them is guessed from the
+	// headers they carry.
+	unknown bool
+}
+
+// New returns a Dumper that prints to w.
+func New(w io.Writer) *Dumper {
+	return &Dumper{
+		w:        w,
+		channels: make(map[chanKey]*channel),
+	}
+}
+
+// Dump decodes the frames sent by side from r and prints them until r returns
+// io.EOF, returning nil, or until another error, returning it. A frame that
+// can't be decoded is printed and returned as an error.
+func (d *Dumper) Dump(side Side, r io.Reader) error {
+	dec := frame.NewDecoder(r)
+	for {
+		msg, err := dec.Decode()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			d.printf(side, "error: %v\n", err)
+			return err
+		}
+		d.Message(side, msg)
+	}
+}
+
+// Message prints a frame sent by side.
+func (d *Dumper) Message(side Side, msg frame.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch m := msg.(type) {
+	case *frame.HelloMessage:
+		d.printf(side, "%s\n", m)
+		if d.Codec == nil {
+			d.Codec = codecFrom(m.Data)
+		}
+
+	case *frame.OpenMessage:
+		ch := &channel{name: fmt.Sprintf("c%d", d.nextID), opener: side}
+		d.nextID++
+		d.channels[chanKey{side, m.SenderID}] = ch
+		d.printf(side, "%s %s\n", ch.name, m)
+		d.printf(side, "  channel %s opened by %s\n", ch.name, side)
+
+	case *frame.OpenConfirmMessage:
+		ch := d.channel(side.other(), m.ChannelID)
+		d.channels[chanKey{side, m.SenderID}] = ch
+		d.printf(side, "%s %s\n", ch.name, m)
+		d.printf(side, "  channel %s accepted\n", ch.name)
+
+	case *frame.OpenFailureMessage:
+		ch := d.channel(side.other(), m.ChannelID)
+		delete(d.channels, chanKey{side.other(), m.ChannelID})
+		d.printf(side, "%s %s\n", ch.name, m)
+		d.printf(side, "  channel %s rejected\n", ch.name)
+
+	case *frame.DataMessage:
+		ch := d.channel(side.other(), m.ChannelID)
+		d.printf(side, "%s %s\n", ch.name, m)
+		d.data(side, ch, m.Data)
+
+	case *frame.EOFMessage:
+		ch := d.channel(side.other(), m.ChannelID)
+		d.printf(side, "%s %s\n", ch.name, m)
+		d.printf(side, "  channel %s closed for writing by %s\n", ch.name, side)
+
+	case *frame.CloseMessage:
+		ch := d.channel(side.other(), m.ChannelID)
+		d.printf(side, "%s %s\n", ch.name, m)
+		ch.closed[side] = true
+		if ch.closed[side.other()] {
+			d.printf(side, "  channel %s closed\n", ch.name)
+		}
+		// the close of the other side of an unknown channel may never be
+		// seen, so its IDs are forgotten on the first close to be reused
+		if ch.closed[side.other()] || ch.unknown {
+			d.forget(ch)
+		}
+
+	default:
+		if id, ok := msg.Channel(); ok {
+			d.printf(side, "%s %s\n", d.channel(side.other(), id).name, msg)
+			return
+		}
+		d.printf(side, "%s\n", msg)
+	}
+}
+
+// channel returns the channel with the local ID of side, or a placeholder for
+// a channel opened before the capture started.
+func (d *Dumper) channel(side Side, id uint32) *channel {
+	ch, ok := d.channels[chanKey{side, id}]
+	if !ok {
+		ch = &channel{name: fmt.Sprintf("%s:%d", side, id), opener: side, unknown: true}
+		d.channels[chanKey{side, id}] = ch
+	}
+	return ch
+}
+
+func (d *Dumper) forget(ch *channel) {
+	for k, c := range d.channels {
+		if c == ch {
+			delete(d.channels, k)
+		}
+	}
+}
+
+// data prints the rpc frames in data sent by side on a channel. Once data
+// can't be decoded as rpc frames, the rest of the data on the channel from
+// side is only printed as raw bytes.
+func (d *Dumper) data(side Side, ch *channel, data []byte) {
+	s := ch.rpc[side]
+	if s == nil {
+		s = &rpcStream{call: side == ch.opener}
+		ch.rpc[side] = s
+	}
+	if s.err != nil {
+		d.printf(side, "  raw %s\n", preview(fmt.Sprintf("%q", data)))
+		return
+	}
+	s.buf.Write(data)
+	if !s.header && !s.progress && (d.Codec == nil || ch.unknown) {
+		c, header := decodeHeader(d.Codec, s.buf.Bytes())
+		if d.Codec == nil {
+			d.Codec = c
+		}
+		if header != nil && ch.unknown {
+			_, s.call = header["S"]
+		}
+	}
+	for {
+		line, err := s.next(d.codec(), ch)
+		if err == errShortFrame {
+			return
+		}
+		if err != nil {
+			s.err = err
+			d.printf(side, "  not rpc (%v): raw %s\n", err, preview(fmt.Sprintf("%q", s.buf.Bytes())))
+			return
+		}
+		d.printf(side, "  %s\n", line)
+	}
+}
+
+func (d *Dumper) codec() codec.Codec {
+	if d.Codec == nil {
+		return codec.JSONCodec{}
+	}
+	return d.Codec
+}
+
+func (d *Dumper) printf(side Side, format string, args ...any) {
+	fmt.Fprintf(d.w, "%-6s "+format, append([]any{side}, args...)...)
+}
+
+// codecFrom returns the first registered codec named in the data of a hello
+// frame, or nil if there is none.
+func codecFrom(names []byte) codec.Codec {
+	for _, name := range strings.Split(string(names), ",") {
+		if c, ok := codec.Lookup("application/" + name); ok {
+			return c
+		}
+	}
+	return nil
+}
+
+// decodeHeader decodes the frame at the start of b as a header with c, or if
+// c is nil, the first registered codec that decodes it, and returns the codec
+// and header. It returns nils if b does not hold a whole frame or it can't
+// be decoded.
+func decodeHeader(c codec.Codec, b []byte) (codec.Codec, map[string]any) {
+	if len(b) < 4 {
+		return nil, nil
+	}
+	n := binary.BigEndian.Uint32(b)
+	if n == 0 || n > uint32(len(b)-4) {
+		return nil, nil
+	}
+	codecs := []codec.Codec{c}
+	if c == nil {
+		codecs = nil
+		for _, contentType := range codec.DefaultRegistry.ContentTypes() {
+			c, _ := codec.Lookup(contentType)
+			codecs = append(codecs, c)
+		}
+	}
+	for _, c := range codecs {
+		var header map[string]any
+		if err := c.Decoder(bytes.NewReader(b[4 : 4+n])).Decode(&header); err == nil {
+			return c, header
+		}
+	}
+	return nil, nil
+}
+
+var errShortFrame = errors.New("dump: short frame")
+
+// maxFrameSize is the largest rpc frame expected, so data starting with a
+// larger length prefix is taken as not being rpc frames instead of buffered.
+const maxFrameSize = 16 << 20
+
+// rpcStream decodes the rpc frames sent by one side of a channel. The side
+// that opened the channel sends a call header followed by values, and the
+// other side sends a response header, or a progress header and value before
+// another response header, followed by values.
+type rpcStream struct {
+	call        bool
+	buf         bytes.Buffer
+	header      bool
+	progress    bool
+	compression string // set from the call header on the opening side
+	err         error
+}
+
+// next decodes the next frame in the buffer and describes it, returning
+// errShortFrame if the buffer does not hold the whole frame yet.
+func (s *rpcStream) next(c codec.Codec, ch *channel) (string, error) {
+	b := s.buf.Bytes()
+	if len(b) < 4 {
+		return "", errShortFrame
+	}
+	size := binary.BigEndian.Uint32(b)
+	n := int(size &^ (1 << 31))
+	if n > maxFrameSize {
+		return "", fmt.Errorf("frame of %d bytes", n)
+	}
+	if len(b) < 4+n {
+		return "", errShortFrame
+	}
+	b = b[4 : 4+n]
+	s.buf.Next(4 + n)
+	if size == 0 {
+		return "end of stream", nil
+	}
+
+	compressed := size&(1<<31) != 0
+	if compressed {
+		var err error
+		if b, err = codec.Decompress(ch.compression(), b); err != nil {
+			return "", err
+		}
+	}
+	dec := c.Decoder(bytes.NewReader(b))
+
+	switch {
+	case s.call && !s.header:
+		var h rpc.CallHeader
+		if err := dec.Decode(&h); err != nil {
+			return "", err
+		}
+		s.header = true
+		s.compression = h.Z
+		return fmt.Sprintf("call %q id=%s%s", h.S, h.I, compressionOf(h.Z)), nil
+
+	case !s.call && (!s.header || s.progress):
+		if s.progress {
+			s.progress = false
+			return value("progress", dec, compressed)
+		}
+		var h rpc.ResponseHeader
+		if err := dec.Decode(&h); err != nil {
+			return "", err
+		}
+		if h.P {
+			s.progress = true
+			return "progress header", nil
+		}
+		s.header = true
+		return "response " + describeResponse(h), nil
+
+	default:
+		return value("value", dec, compressed)
+	}
+}
+
+// compression returns the compression named in the call header of the channel.
+func (ch *channel) compression() string {
+	if s := ch.rpc[ch.opener]; s != nil {
+		return s.compression
+	}
+	return ""
+}
+
+func describeResponse(h rpc.ResponseHeader) string {
+	if h.E == nil {
+		if h.C {
+			return "ok, continue"
+		}
+		return "ok"
+	}
+	desc := fmt.Sprintf("error %q", *h.E)
+	if h.K != nil {
+		desc += fmt.Sprintf(" code=%s", *h.K)
+	}
+	if h.D != nil {
+		desc += " details=" + preview(fmt.Sprintf("%v", h.D))
+	}
+	return desc
+}
+
+func value(kind string, dec codec.Decoder, compressed bool) (string, error) {
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	desc := kind + " " + preview(fmt.Sprintf("%v", v))
+	if compressed {
+		desc += " (compressed)"
+	}
+	return desc, nil
+}
+
+func compressionOf(z string) string {
+	if z == "" {
+		return ""
+	}
+	return " compression=" + z
+}
+
+func preview(s string) string {
+	if MaxValueLength > 0 && len(s) > MaxValueLength {
+		return s[:MaxValueLength] + "..."
+	}
+	return s
+}
@@ -0,0 +1,119 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/mux"
+	"tractor.dev/toolkit-go/duplex/mux/frame"
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestProxy(t *testing.T) {
+	clientConn, proxyClient := net.Pipe()
+	proxyServer, serverConn := net.Pipe()
+
+	var out syncBuffer
+	done := make(chan struct{})
+	go func() {
+		New(&out).Proxy(proxyClient, proxyServer)
+		close(done)
+	}()
+
+	srv := &rpc.Server{
+		Codec: codec.JSONCodec{},
+		Handler: rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+			if c.Selector() == "/fail" {
+				r.Return(errors.New("failed on purpose"))
+				return
+			}
+			var args []any
+			c.Receive(&args)
+			r.Return(args)
+		}),
+	}
+	go srv.Respond(mux.New(serverConn), nil)
+
+	sess := mux.New(clientConn)
+	client := rpc.NewClient(sess, codec.JSONCodec{})
+	var reply any
+	if _, err := client.Call(context.Background(), "echo", []any{"hello", 42}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Call(context.Background(), "fail", nil); err == nil {
+		t.Fatal("expected error")
+	}
+	// the channel closes after the call returns
+	for deadline := time.Now().Add(time.Second); !strings.Contains(out.String(), "channel c1 closed"); {
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	sess.Close()
+	<-done
+
+	for _, want := range []string{
+		"client c0 {OpenMessage",
+		"channel c0 opened by client",
+		"server c0 {OpenConfirmMessage",
+		`call "echo"`,
+		"value [hello 42]",
+		"response ok",
+		`call "fail"`,
+		`response error "failed on purpose"`,
+		"channel c1 closed",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("missing %q in output:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestDumpRaw(t *testing.T) {
+	var in bytes.Buffer
+	in.Write(frame.OpenMessage{SenderID: 3, WindowSize: 64, MaxPacketSize: 1024}.Bytes())
+	data := []byte("not an rpc frame")
+	in.Write(frame.DataMessage{ChannelID: 7, Length: uint32(len(data)), Data: data}.Bytes())
+	in.Write([]byte{255})
+
+	var out bytes.Buffer
+	err := New(&out).Dump(Client, &in)
+	if err == nil {
+		t.Fatal("expected error for unknown message")
+	}
+	for _, want := range []string{
+		"channel c0 opened by client",
+		"client server:7 {DataMessage",
+		"not rpc",
+		"error: qtalk: unexpected message type 255",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("missing %q in output:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package dump
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"tractor.dev/toolkit-go/duplex/mux/frame"
+)
+
+// Proxy copies the bytes sent by client to server and the bytes sent by server
+// to client, dumping the frames sent by each side, until both sides have
+// stopped sending. Each side is closed for writing, if it supports CloseWrite,
+// once the other stops sending, and both are closed before Proxy returns.
+//
+// The bytes are still copied if they can't be decoded as frames, so a Proxy
+// does not change the behavior of the session it sits in.
+func (d *Dumper) Proxy(client, server io.ReadWriteCloser) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		d.copy(Client, server, client)
+		wg.Done()
+	}()
+	go func() {
+		d.copy(Server, client, server)
+		wg.Done()
+	}()
+	wg.Wait()
+	client.Close()
+	server.Close()
+}
+
+// copy decodes each frame sent by side from src and dumps it before writing
+// its bytes to dst, so the frames of both sides are dumped in the order they
+// are delivered and a reply is never dumped before the frame it answers.
+func (d *Dumper) copy(side Side, dst io.ReadWriteCloser, src io.Reader) {
+	var buf bytes.Buffer
+	dec := frame.NewDecoder(io.TeeReader(src, &buf))
+	for {
+		msg, err := dec.Decode()
+		if err != nil {
+			// the other side closing dst also ends the copy
+			if err != io.EOF && !errors.Is(err, io.ErrClosedPipe) && !errors.Is(err, net.ErrClosed) {
+				d.printf(side, "error: %v\n", err)
+			}
+			// forward the rest undecoded so the session is not changed
+			if _, err := dst.Write(buf.Bytes()); err == nil {
+				io.Copy(dst, src)
+			}
+			break
+		}
+		d.Message(side, msg)
+		if _, err := dst.Write(buf.Bytes()); err != nil {
+			break
+		}
+		buf.Reset()
+	}
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
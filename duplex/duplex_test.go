@@ -105,3 +105,37 @@ func TestSimultaneousOpen(t *testing.T) {
 	client.Close()
 	server.Close()
 }
+
+func TestDial(t *testing.T) {
+	l, err := mux.ListenTCP("127.0.0.1:0")
+	fatal(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			sess, err := l.Accept()
+			if err != nil {
+				return
+			}
+			server := talk.NewPeer(sess, codec.JSONCodec{})
+			server.Server.Handler = fn.HandlerFrom(&TestService{T: t})
+			go server.Respond()
+		}
+	}()
+
+	peer, err := Dial(context.Background(), "tcp://"+l.Addr().String()+"?codec=json")
+	fatal(t, err)
+	defer peer.Close()
+	var ret string
+	_, err = peer.Call(context.Background(), "Hello", nil, &ret)
+	fatal(t, err)
+	equal(t, ret, "Hello", "unexpected return")
+
+	_, err = Dial(context.Background(), "tcp://"+l.Addr().String()+"?codec=nope")
+	if err == nil {
+		t.Fatal("expected unknown codec error")
+	}
+	_, err = Dial(context.Background(), "gopher://localhost")
+	if err == nil {
+		t.Fatal("expected unsupported scheme error")
+	}
+}
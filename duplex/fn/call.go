@@ -1,8 +1,10 @@
 package fn
 
 import (
+	"encoding/base64"
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 )
@@ -26,45 +28,162 @@ func Call(fn any, args []any) (_ []any, err error) {
 }
 
 // ArgsTo converts the arguments into `reflect.Value`s suitable to pass as
-// parameters to a function with the given type via reflection.
+// parameters to a function with the given type via reflection. Trailing
+// arguments for params that can be nil (pointers, interfaces, maps and slices)
+// can be omitted and are given zero values. For variadic functions, any
+// arguments past the fixed params are converted to the variadic element type.
+// Arguments are decoded using DefaultDecoderConfig where needed.
 func ArgsTo(fntyp reflect.Type, args []any) ([]reflect.Value, error) {
-	if len(args) != fntyp.NumIn() {
-		return nil, fmt.Errorf("fn: expected %d params, got %d", fntyp.NumIn(), len(args))
+	return argsTo(fntyp, args, DefaultDecoderConfig())
+}
+
+func argsTo(fntyp reflect.Type, args []any, cfg *mapstructure.DecoderConfig) ([]reflect.Value, error) {
+	in := make([]reflect.Type, fntyp.NumIn())
+	for i := range in {
+		in[i] = fntyp.In(i)
+	}
+	args, err := fitArgs(in, fntyp.IsVariadic(), args)
+	if err != nil {
+		return nil, err
 	}
 	fnParams := make([]reflect.Value, len(args))
 	for idx, param := range args {
-		switch fntyp.In(idx).Kind() {
-		case reflect.Struct:
-			// decode to struct type using mapstructure
-			arg := reflect.New(fntyp.In(idx))
-			if err := mapstructure.Decode(param, arg.Interface()); err != nil {
-				return nil, fmt.Errorf("fn: mapstructure: %s", err.Error())
+		t := fntyp.In(min(idx, fntyp.NumIn()-1))
+		if fntyp.IsVariadic() && idx >= fntyp.NumIn()-1 {
+			t = t.Elem()
+		}
+		v, err := argTo(t, param, cfg)
+		if err != nil {
+			return nil, err
+		}
+		fnParams[idx] = v
+	}
+	return fnParams, nil
+}
+
+// fitArgs checks the number of args against the param types, returning args
+// padded with nil for omitted optional params. A variadic final param is not
+// padded as it accepts zero or more args.
+func fitArgs(in []reflect.Type, variadic bool, args []any) ([]any, error) {
+	fixed := len(in)
+	if variadic {
+		fixed--
+	}
+	required := fixed
+	for required > 0 && nilable(in[required-1]) {
+		required--
+	}
+	switch {
+	case variadic && len(args) < required:
+		return nil, fmt.Errorf("fn: expected at least %d params, got %d", required, len(args))
+	case !variadic && (len(args) < required || len(args) > fixed):
+		if required == fixed {
+			return nil, fmt.Errorf("fn: expected %d params, got %d", fixed, len(args))
+		}
+		return nil, fmt.Errorf("fn: expected %d to %d params, got %d", required, fixed, len(args))
+	}
+	for len(args) < fixed {
+		args = append(args, nil)
+	}
+	return args, nil
+}
+
+func nilable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		return true
+	default:
+		return false
+	}
+}
+
+// argTo converts a single argument to a value of the param type.
+func argTo(t reflect.Type, param any, cfg *mapstructure.DecoderConfig) (reflect.Value, error) {
+	switch t.Kind() {
+	case reflect.Struct:
+		// decode to struct type using mapstructure
+		arg := reflect.New(t)
+		if err := decode(cfg, param, arg.Interface()); err != nil {
+			return reflect.Value{}, fmt.Errorf("fn: mapstructure: %s", err.Error())
+		}
+		return ensureType(arg.Elem(), t), nil
+	case reflect.Slice:
+		rv := reflect.ValueOf(param)
+		if !rv.IsValid() {
+			return reflect.Zero(t), nil
+		}
+		// decode strings to slices (such as base64 to []byte) using mapstructure
+		if rv.Kind() == reflect.String {
+			arg := reflect.New(t)
+			if err := decode(cfg, param, arg.Interface()); err != nil {
+				return reflect.Value{}, fmt.Errorf("fn: mapstructure: %s", err.Error())
 			}
-			fnParams[idx] = ensureType(arg.Elem(), fntyp.In(idx))
-		case reflect.Slice:
-			rv := reflect.ValueOf(param)
-			// decode slice of structs to struct type using mapstructure
-			if fntyp.In(idx).Elem().Kind() == reflect.Struct {
-				nv := reflect.MakeSlice(fntyp.In(idx), rv.Len(), rv.Len())
-				for i := 0; i < rv.Len(); i++ {
-					ref := reflect.New(nv.Index(i).Type())
-					if err := mapstructure.Decode(rv.Index(i).Interface(), ref.Interface()); err != nil {
-						return nil, fmt.Errorf("fn: mapstructure: %s", err.Error())
-					}
-					nv.Index(i).Set(reflect.Indirect(ref))
+			return arg.Elem(), nil
+		}
+		// decode slice of structs to struct type using mapstructure
+		if t.Elem().Kind() == reflect.Struct {
+			nv := reflect.MakeSlice(t, rv.Len(), rv.Len())
+			for i := 0; i < rv.Len(); i++ {
+				ref := reflect.New(nv.Index(i).Type())
+				if err := decode(cfg, rv.Index(i).Interface(), ref.Interface()); err != nil {
+					return reflect.Value{}, fmt.Errorf("fn: mapstructure: %s", err.Error())
 				}
-				rv = nv
+				nv.Index(i).Set(reflect.Indirect(ref))
 			}
-			fnParams[idx] = rv
-		default:
-			// if int is expected but got float64 assume json-like encoding and cast float to int
-			if fntyp.In(idx).Kind() == reflect.Int && reflect.TypeOf(param).Kind() == reflect.Float64 {
-				param = int(param.(float64))
+			rv = nv
+		}
+		return rv, nil
+	default:
+		// decode strings to non-string types (such as durations) using mapstructure
+		if s, ok := param.(string); ok && t.Kind() != reflect.String && t.Kind() != reflect.Interface {
+			arg := reflect.New(t)
+			if err := decode(cfg, s, arg.Interface()); err != nil {
+				return reflect.Value{}, fmt.Errorf("fn: mapstructure: %s", err.Error())
 			}
-			fnParams[idx] = ensureType(reflect.ValueOf(param), fntyp.In(idx))
+			return arg.Elem(), nil
+		}
+		// if int is expected but got float64 assume json-like encoding and cast float to int
+		if t.Kind() == reflect.Int && reflect.TypeOf(param) != nil && reflect.TypeOf(param).Kind() == reflect.Float64 {
+			param = int(param.(float64))
 		}
+		return ensureType(reflect.ValueOf(param), t), nil
 	}
-	return fnParams, nil
+}
+
+// DefaultDecoderConfig returns the mapstructure decoder config used to convert
+// arguments by default. It decodes RFC 3339 strings to time.Time, duration strings
+// to time.Duration and base64 strings to byte slices. The Result field is set for
+// each decode, so the config can be used as a template.
+func DefaultDecoderConfig() *mapstructure.DecoderConfig {
+	return &mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeHookFunc(time.RFC3339Nano),
+			mapstructure.StringToTimeDurationHookFunc(),
+			StringToBytesHookFunc(),
+		),
+	}
+}
+
+// StringToBytesHookFunc returns a mapstructure DecodeHookFunc that decodes base64
+// strings to byte slices, which is how JSON encodes byte slices.
+func StringToBytesHookFunc() mapstructure.DecodeHookFunc {
+	return func(f reflect.Type, t reflect.Type, data any) (any, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf([]byte(nil)) {
+			return data, nil
+		}
+		return base64.StdEncoding.DecodeString(data.(string))
+	}
+}
+
+// decode uses a copy of the config to decode input into output.
+func decode(cfg *mapstructure.DecoderConfig, input, output any) error {
+	c := *cfg
+	c.Result = output
+	d, err := mapstructure.NewDecoder(&c)
+	if err != nil {
+		return err
+	}
+	return d.Decode(input)
 }
 
 // ParseReturn splits the results of reflect.Call() into the values, and
@@ -0,0 +1,44 @@
+package fn
+
+import "errors"
+
+// Error is implemented by errors returned from functions that have an error code
+// and structured details. Handlers made with HandlerFrom send the code and details
+// to the caller, where the error will implement rpc.ErrorCoder and rpc.ErrorDetailer.
+type Error interface {
+	error
+	Code() string
+	Details() any
+}
+
+// typedError adapts an error wrapping an Error to the rpc error interfaces,
+// keeping the message of the outer error.
+type typedError struct {
+	err   error
+	typed Error
+}
+
+func (e typedError) Error() string {
+	return e.err.Error()
+}
+
+func (e typedError) ErrorCode() string {
+	return e.typed.Code()
+}
+
+func (e typedError) ErrorDetails() any {
+	return e.typed.Details()
+}
+
+func (e typedError) Unwrap() error {
+	return e.err
+}
+
+// returnError returns err ready to be returned by a handler.
+func returnError(err error) error {
+	var typed Error
+	if errors.As(err, &typed) {
+		return typedError{err: err, typed: typed}
+	}
+	return err
+}
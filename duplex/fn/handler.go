@@ -1,11 +1,16 @@
 package fn
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"runtime"
 	"strings"
+	"unicode"
 
+	"github.com/mitchellh/mapstructure"
+
+	"tractor.dev/toolkit-go/duplex/codec"
 	"tractor.dev/toolkit-go/duplex/mux"
 	"tractor.dev/toolkit-go/duplex/rpc"
 )
@@ -26,21 +31,47 @@ import (
 // directly with the handler arguments. Otherwise it will be wrapped as described below.
 //
 // Function handlers expect an array to use as arguments. If the incoming argument
-// array is too large or too small, the handler returns an error. Functions can opt-in
+// array is too large or too small, the handler returns an error. Trailing arguments
+// for params that can be nil, such as pointers, can be omitted and variadic functions
+// take any number of arguments past their fixed params. Functions can opt-in
 // to take a final Call pointer argument, allowing the handler to give it the Call value
-// being processed. Functions can return nothing which the handler returns as nil, or
+// being processed, or a final rpc.Caller argument to make calls back to the calling
+// peer. Functions can also take a context.Context as their first argument,
+// which is given the Call context so handlers can observe cancellation and deadlines.
+//
+// Functions can return nothing which the handler returns as nil, or
 // a single value which can be an error, or two values where one value is an error.
 // In the latter case, the value is returned if the error is nil, otherwise just the
-// error is returned. Handlers based on functions that return more than two values will
+// error is returned. Errors implementing Error are returned with their code and
+// details. Handlers based on functions that return more than two values will
 // simply ignore the remaining values.
 //
+// Values returned as an interface type (other than the empty interface) whose
+// concrete type is registered with codec.RegisterType are returned as codec.Typed
+// values, so callers can decode them back into the concrete type using codec.Typed.
+// Nil pointers and interfaces are returned as nil.
+//
+// Functions can stream values back by taking a final channel argument or by returning
+// a channel as their first return value. The response is continued and values sent on
+// the channel are streamed to the caller until the channel is closed. Functions can
+// instead take a final receive-only channel argument (<-chan T) to be fed values the
+// caller streams in after the arguments array, which is closed when the caller ends
+// the stream.
+//
 // Structs that implement the Handler interface will be added as a catch-all handler
 // along with their individual methods. This lets you implement dynamic methods.
-func HandlerFrom[T any](v T) rpc.Handler {
+//
+// Options can be given to limit which methods are registered and how their
+// selectors are named. Only exported methods are ever registered.
+func HandlerFrom[T any](v T, opts ...Option) rpc.Handler {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
 	rv := reflect.Indirect(reflect.ValueOf(v))
 	switch rv.Type().Kind() {
 	case reflect.Func:
-		return fromFunc(reflect.ValueOf(v))
+		return fromFunc(reflect.ValueOf(v), o)
 	case reflect.Struct:
 		// assume T is an interface
 		t := reflect.TypeOf((*T)(nil)).Elem()
@@ -49,12 +80,160 @@ func HandlerFrom[T any](v T) rpc.Handler {
 			// so then just use TypeOf v
 			t = reflect.TypeOf(v)
 		}
-		return fromMethods(v, t)
+		return fromMethods(v, t, o)
 	default:
 		panic("must be func or struct")
 	}
 }
 
+// Option configures a handler made with HandlerFrom.
+type Option func(*options)
+
+type options struct {
+	include       map[string]bool
+	exclude       map[string]bool
+	rename        func(string) string
+	skipEmbedded  bool
+	decoderConfig *mapstructure.DecoderConfig
+	validator     Validator
+	paramNames    map[string][]string
+}
+
+// Include limits the methods registered to those with the given names.
+func Include(names ...string) Option {
+	return func(o *options) {
+		if o.include == nil {
+			o.include = make(map[string]bool)
+		}
+		for _, name := range names {
+			o.include[name] = true
+		}
+	}
+}
+
+// Exclude prevents methods with the given names from being registered.
+func Exclude(names ...string) Option {
+	return func(o *options) {
+		if o.exclude == nil {
+			o.exclude = make(map[string]bool)
+		}
+		for _, name := range names {
+			o.exclude[name] = true
+		}
+	}
+}
+
+// Rename sets a function used to get the selector for each method name.
+func Rename(fn func(name string) string) Option {
+	return func(o *options) {
+		o.rename = fn
+	}
+}
+
+// SnakeCase registers methods using snake_case selectors, so a method
+// named GetUserByID is registered as get_user_by_id.
+func SnakeCase() Option {
+	return Rename(snakeCase)
+}
+
+// SkipEmbedded prevents methods promoted from embedded fields of a struct
+// from being registered. Methods defined on the struct that shadow a method
+// of an embedded field are also skipped.
+func SkipEmbedded() Option {
+	return func(o *options) {
+		o.skipEmbedded = true
+	}
+}
+
+// WithDecoderConfig sets the mapstructure decoder config used to convert arguments
+// to the param types of functions, such as to change the tag name, allow weakly
+// typed input or add DecodeHooks. The Result field is ignored. To extend the
+// defaults, start with DefaultDecoderConfig.
+func WithDecoderConfig(cfg *mapstructure.DecoderConfig) Option {
+	return func(o *options) {
+		o.decoderConfig = cfg
+	}
+}
+
+// A Validator checks the arguments and results of calls to function handlers.
+//
+// ValidateArgs is given the arguments from the caller after they are decoded to
+// the param types, not including any context, Call or channel params. Returning
+// an error rejects the call with an rpc.ValidationError. ValidateResults is given
+// the values to be returned, not including a streamed channel or nil error.
+// Returning an error returns it to the caller in place of the results.
+type Validator interface {
+	ValidateArgs(selector string, args []any) error
+	ValidateResults(selector string, results []any) error
+}
+
+// WithValidator sets a Validator to check the arguments and results of calls.
+func WithValidator(v Validator) Option {
+	return func(o *options) {
+		o.validator = v
+	}
+}
+
+// selector returns the selector to register a method with, or false if the
+// method should not be registered.
+func (o *options) selector(name string, embedded map[string]bool) (string, bool) {
+	if o.include != nil && !o.include[name] {
+		return "", false
+	}
+	if o.exclude[name] || (o.skipEmbedded && embedded[name]) {
+		return "", false
+	}
+	if o.rename != nil {
+		return o.rename(name), true
+	}
+	return name, true
+}
+
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// start a new word at a lower-to-upper change or at the last
+			// upper of an acronym followed by a lower (HTTPServer -> http_server)
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) && runes[i-1] != '_' {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// embeddedMethods returns the names of methods of the embedded fields of the
+// struct the value is or points to.
+func embeddedMethods(v any) map[string]bool {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	names := make(map[string]bool)
+	if t.Kind() != reflect.Struct {
+		return names
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.Anonymous {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() != reflect.Pointer && ft.Kind() != reflect.Interface {
+			ft = reflect.PointerTo(ft)
+		}
+		for j := 0; j < ft.NumMethod(); j++ {
+			names[ft.Method(j).Name] = true
+		}
+	}
+	return names
+}
+
 // Args is the expected argument value for calls made to HandlerFrom handlers.
 // Since it is just a slice of empty interface values, you can alternatively use
 // more specific slice types ([]int{}, etc) if all arguments are of the same type.
@@ -62,21 +241,29 @@ type Args []any
 
 var handlerFuncType = reflect.TypeOf((*rpc.HandlerFunc)(nil)).Elem()
 
-func fromMethods(rcvr interface{}, t reflect.Type) rpc.Handler {
+func fromMethods(rcvr interface{}, t reflect.Type, o *options) rpc.Handler {
 	// If `t` is an interface, `Convert()` wraps the value with that interface
 	// type. This makes sure that the Method(i) indexes match for getting both the
 	// name and implementation.
 	rcvrval := reflect.ValueOf(rcvr).Convert(t)
+	embedded := embeddedMethods(rcvr)
 	mux := rpc.NewRespondMux()
 	for i := 0; i < t.NumMethod(); i++ {
+		if !t.Method(i).IsExported() {
+			continue
+		}
+		selector, ok := o.selector(t.Method(i).Name, embedded)
+		if !ok {
+			continue
+		}
 		m := rcvrval.Method(i)
 		var h rpc.Handler
 		if m.CanConvert(handlerFuncType) {
 			h = m.Convert(handlerFuncType).Interface().(rpc.HandlerFunc)
 		} else {
-			h = fromFunc(m)
+			h = fromFunc(m, o)
 		}
-		mux.Handle(t.Method(i).Name, h)
+		mux.Handle(selector, h)
 	}
 	h, ok := rcvr.(rpc.Handler)
 	if ok {
@@ -85,32 +272,78 @@ func fromMethods(rcvr interface{}, t reflect.Type) rpc.Handler {
 	return mux
 }
 
-var callRef = reflect.TypeOf((*rpc.Call)(nil))
+var (
+	callRef     = reflect.TypeOf((*rpc.Call)(nil))
+	callerType  = reflect.TypeOf((*rpc.Caller)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
 
-func fromFunc(fn reflect.Value) rpc.Handler {
-	fntyp := fn.Type()
-	// if the last argument in fn is an rpc.Call, add our call to fnParams
-	expectsCallParam := fntyp.NumIn() > 0 && fntyp.In(fntyp.NumIn()-1) == callRef
+// signature describes how a function is called by a handler.
+type signature struct {
+	// the first argument is a context.Context, given the call context
+	contextParam bool
+	// the last argument is an rpc.Call, given the call
+	callParam bool
+	// the last argument is an rpc.Caller, given the caller for calling back
+	callerParam bool
+	// the last argument is a receive-only channel, fed values streamed in
+	inputParam bool
+	// the last argument is a channel to stream back
+	chanParam bool
+	// the last argument or first return is a channel to stream back
+	chanStream bool
+	chanType   reflect.Type
+	// the params expected from the caller, not counting ones given by the handler
+	argTypes []reflect.Type
+	// the results returned to the caller, not counting a streamed channel or error
+	resultTypes []reflect.Type
+}
 
-	// if the last arg or first return in fn is a channel, we'll make a channel to stream back
-	expectsChanParam := fntyp.NumIn() > 0 && fntyp.In(fntyp.NumIn()-1).Kind() == reflect.Chan
-	var chanType reflect.Type
-	var chanStream bool
-	if expectsChanParam {
-		chanType = fntyp.In(fntyp.NumIn() - 1)
-		chanStream = true
+func signatureOf(fntyp reflect.Type) (sig signature) {
+	n := fntyp.NumIn()
+	sig.contextParam = n > 0 && fntyp.In(0) == contextType
+	sig.callParam = n > 0 && fntyp.In(n-1) == callRef
+	sig.callerParam = n > 0 && fntyp.In(n-1) == callerType
+	sig.inputParam = n > 0 && fntyp.In(n-1).Kind() == reflect.Chan && fntyp.In(n-1).ChanDir() == reflect.RecvDir
+	sig.chanParam = n > 0 && fntyp.In(n-1).Kind() == reflect.Chan && !sig.inputParam
+	if sig.chanParam {
+		sig.chanType = fntyp.In(n - 1)
+		sig.chanStream = true
 	}
 	if fntyp.NumOut() > 0 && fntyp.Out(0).Kind() == reflect.Chan {
-		chanType = fntyp.Out(0)
-		chanStream = true
+		sig.chanType = fntyp.Out(0)
+		sig.chanStream = true
 	}
+	for i := 0; i < n; i++ {
+		if (i == 0 && sig.contextParam) || (i == n-1 && (sig.callParam || sig.callerParam || sig.chanParam || sig.inputParam)) {
+			continue
+		}
+		sig.argTypes = append(sig.argTypes, fntyp.In(i))
+	}
+	for i := 0; i < fntyp.NumOut(); i++ {
+		t := fntyp.Out(i)
+		if (i == 0 && t.Kind() == reflect.Chan) || (i == fntyp.NumOut()-1 && t.Implements(errorInterface)) {
+			continue
+		}
+		sig.resultTypes = append(sig.resultTypes, t)
+	}
+	return sig
+}
+
+func fromFunc(fn reflect.Value, o *options) rpc.Handler {
+	fntyp := fn.Type()
+	decoderConfig := o.decoderConfig
+	if decoderConfig == nil {
+		decoderConfig = DefaultDecoderConfig()
+	}
+	sig := signatureOf(fntyp)
 
 	return rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
 		var params []any
 
 		defer func() {
 			if p := recover(); p != nil {
-				r.Return(fmt.Errorf("panic: %s [%s] %s(%s)", p, identifyPanic(), c.Selector(), params))
+				r.Return(fmt.Errorf("panic: %s [%s] %s(%s) call %s", p, identifyPanic(), c.Selector(), params, c.ID()))
 			}
 		}()
 
@@ -119,23 +352,74 @@ func fromFunc(fn reflect.Value) rpc.Handler {
 			r.Return(fmt.Errorf("fn: args: %s", err.Error()))
 			return
 		}
-		if expectsCallParam {
+		if len(params) != len(sig.argTypes) {
+			var err error
+			params, err = fitArgs(sig.argTypes, fntyp.IsVariadic(), params)
+			if err != nil {
+				r.Return(err)
+				return
+			}
+		}
+		nargs, first := len(params), 0
+		if sig.contextParam {
+			first = 1
+			ctx := c.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			params = append([]any{ctx}, params...)
+		}
+		if sig.callParam {
 			params = append(params, c)
-		} else if expectsChanParam {
+		} else if sig.callerParam {
+			params = append(params, c.Caller)
+		} else if sig.chanParam {
 			// TODO: somehow pass buffer via CallHeader?
-			ch = reflect.MakeChan(chanType, 512).Interface() // not supported by tinygo 0.28.1
+			ch = reflect.MakeChan(sig.chanType, 512).Interface() // not supported by tinygo 0.28.1
 			params = append(params, ch)
+		} else if sig.inputParam {
+			in := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, fntyp.In(fntyp.NumIn()-1).Elem()), 0)
+			done := make(chan struct{})
+			defer close(done)
+			go receiveValues(c, in, done)
+			params = append(params, in.Interface())
 		}
-		ret, err := Call(fn.Interface(), params)
+		fnParams, err := argsTo(fntyp, params, decoderConfig)
 		if err != nil {
 			r.Return(err)
 			return
 		}
-		if chanStream && fntyp.NumOut() > 0 && fntyp.Out(0).Kind() == reflect.Chan {
+		if o.validator != nil {
+			if err := o.validator.ValidateArgs(c.Selector(), interfaces(fnParams[first:first+nargs])); err != nil {
+				r.Return(&rpc.ValidationError{Selector: c.Selector(), Err: err})
+				return
+			}
+		}
+		ret, err := ParseReturn(fn.Call(fnParams))
+		if err != nil {
+			r.Return(returnError(err))
+			return
+		}
+		if sig.chanStream && fntyp.NumOut() > 0 && fntyp.Out(0).Kind() == reflect.Chan {
 			ch = ret[0]
 			ret = ret[1:]
 		}
-		if chanStream {
+		for i, t := range sig.resultTypes {
+			// tag values of registered types returned as interfaces, leaving
+			// nil pointers untagged so they decode as nil
+			if i < len(ret) && t.Kind() == reflect.Interface && t.NumMethod() > 0 && !isNil(ret[i]) {
+				if _, ok := codec.TypeName(ret[i]); ok {
+					ret[i] = codec.Typed{Value: ret[i]}
+				}
+			}
+		}
+		if o.validator != nil {
+			if err := o.validator.ValidateResults(c.Selector(), ret); err != nil {
+				r.Return(fmt.Errorf("fn: invalid results for %s: %w", c.Selector(), err))
+				return
+			}
+		}
+		if sig.chanStream {
 			c, _ := r.Continue(ret...)
 			go streamValues(r, c, reflect.ValueOf(ch))
 			return
@@ -144,13 +428,32 @@ func fromFunc(fn reflect.Value) rpc.Handler {
 	})
 }
 
+// isNil reports whether v is nil or a nil pointer.
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Pointer && rv.IsNil()
+}
+
+func interfaces(values []reflect.Value) []any {
+	out := make([]any, len(values))
+	for i, v := range values {
+		out[i] = v.Interface()
+	}
+	return out
+}
+
 // streamValues will receive on the reflected Go channel and send over the
-// duplex channel until an error is returned or either are closed.
+// duplex channel until an error is returned or either are closed. If the Go
+// channel is closed, the stream is ended before closing the duplex channel.
 func streamValues(r rpc.Responder, ch mux.Channel, valueCh reflect.Value) {
 	defer ch.Close()
 	for {
 		v, ok := valueCh.Recv()
 		if !ok {
+			r.CloseSend()
 			return
 		}
 		if err := r.Send(v.Interface()); err != nil {
@@ -159,6 +462,26 @@ func streamValues(r rpc.Responder, ch mux.Channel, valueCh reflect.Value) {
 	}
 }
 
+// receiveValues will receive values streamed in over the call and send them
+// on the reflected Go channel until the stream ends or done is closed. The Go
+// channel is closed when the stream ends.
+func receiveValues(c *rpc.Call, valueCh reflect.Value, done chan struct{}) {
+	defer valueCh.Close()
+	for {
+		v := reflect.New(valueCh.Type().Elem())
+		if err := c.Receive(v.Interface()); err != nil {
+			return
+		}
+		chosen, _, _ := reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: valueCh, Send: v.Elem()},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+		})
+		if chosen == 1 {
+			return
+		}
+	}
+}
+
 func identifyPanic() string {
 	var name, file string
 	var line int
@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"tractor.dev/toolkit-go/duplex/codec"
 	"tractor.dev/toolkit-go/duplex/rpc"
@@ -117,6 +118,112 @@ func TestHandlerFromFunc(t *testing.T) {
 		}
 	})
 
+	t.Run("variadic args", func(t *testing.T) {
+		client, _ := rpctest.NewPair(HandlerFrom(func(a int, rest ...int) int {
+			for _, v := range rest {
+				a += v
+			}
+			return a
+		}), codec.JSONCodec{})
+		defer client.Close()
+
+		var sum int
+		if _, err := client.Call(context.Background(), "", []interface{}{2, 3, 5}, &sum); err != nil {
+			t.Fatal(err)
+		}
+		if sum != 10 {
+			t.Fatalf("unexpected sum: %v", sum)
+		}
+		if _, err := client.Call(context.Background(), "", []interface{}{2}, &sum); err != nil {
+			t.Fatal(err)
+		}
+		if sum != 2 {
+			t.Fatalf("unexpected sum: %v", sum)
+		}
+		_, err := client.Call(context.Background(), "", []interface{}{}, &sum)
+		if err == nil || !strings.Contains(err.Error(), "expected at least 1 params") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("omitted optional args", func(t *testing.T) {
+		client, _ := rpctest.NewPair(HandlerFrom(func(a int, b *int) int {
+			if b == nil {
+				return a
+			}
+			return a + *b
+		}), codec.JSONCodec{})
+		defer client.Close()
+
+		var sum int
+		if _, err := client.Call(context.Background(), "", []interface{}{2}, &sum); err != nil {
+			t.Fatal(err)
+		}
+		if sum != 2 {
+			t.Fatalf("unexpected sum: %v", sum)
+		}
+		_, err := client.Call(context.Background(), "", []interface{}{2, 3, 5}, &sum)
+		if err == nil || !strings.Contains(err.Error(), "expected 1 to 2 params") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("decode common types", func(t *testing.T) {
+		type event struct {
+			At time.Time
+		}
+		client, _ := rpctest.NewPair(HandlerFrom(func(at time.Time, d time.Duration, b []byte, e event) string {
+			return fmt.Sprintf("%s %s %s %s", at.Format(time.RFC3339), d, b, e.At.Format(time.RFC3339))
+		}), codec.JSONCodec{})
+		defer client.Close()
+
+		at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		var ret string
+		if _, err := client.Call(context.Background(), "", Args{at, "5s", []byte("hi"), event{At: at}}, &ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != "2024-01-02T03:04:05Z 5s hi 2024-01-02T03:04:05Z" {
+			t.Fatalf("unexpected ret: %v", ret)
+		}
+	})
+
+	t.Run("custom decoder config", func(t *testing.T) {
+		cfg := DefaultDecoderConfig()
+		cfg.WeaklyTypedInput = true
+		client, _ := rpctest.NewPair(HandlerFrom(func(a, b int) int {
+			return a + b
+		}, WithDecoderConfig(cfg)), codec.JSONCodec{})
+		defer client.Close()
+
+		var sum int
+		if _, err := client.Call(context.Background(), "", Args{"2", 3}, &sum); err != nil {
+			t.Fatal(err)
+		}
+		if sum != 5 {
+			t.Fatalf("unexpected sum: %v", sum)
+		}
+	})
+
+	t.Run("validator", func(t *testing.T) {
+		client, _ := rpctest.NewPair(HandlerFrom(func(ctx context.Context, a, b int) int {
+			return a - b
+		}, WithValidator(mockValidator{})), codec.JSONCodec{})
+		defer client.Close()
+
+		var diff int
+		if _, err := client.Call(context.Background(), "", Args{3, 2}, &diff); err != nil {
+			t.Fatal(err)
+		}
+		_, err := client.Call(context.Background(), "", Args{-1, 2}, &diff)
+		if !errors.Is(err, rpc.ErrInvalidParams) || !strings.Contains(err.Error(), "negative arg") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = client.Call(context.Background(), "", Args{2, 3}, &diff)
+		if err == nil || !strings.Contains(err.Error(), "negative result") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
 	t.Run("with call", func(t *testing.T) {
 		client, _ := rpctest.NewPair(HandlerFrom(func(a, b int, call *rpc.Call) int {
 			if call.Selector() != "/sum" {
@@ -135,6 +242,49 @@ func TestHandlerFromFunc(t *testing.T) {
 		}
 	})
 
+	t.Run("with context", func(t *testing.T) {
+		client, _ := rpctest.NewPair(HandlerFrom(func(ctx context.Context, a, b int) (int, error) {
+			if ctx == nil {
+				t.Fatal("expected call context")
+			}
+			return a + b, ctx.Err()
+		}), codec.JSONCodec{})
+		defer client.Close()
+
+		var sum int
+		if _, err := client.Call(context.Background(), "", []interface{}{2, 3}, &sum); err != nil {
+			t.Fatal(err)
+		}
+		if sum != 5 {
+			t.Fatalf("unexpected sum: %v", sum)
+		}
+	})
+
+	t.Run("with caller", func(t *testing.T) {
+		client, _ := rpctest.NewPair(HandlerFrom(func(name string, caller rpc.Caller) (string, error) {
+			var greeting string
+			_, err := caller.Call(context.Background(), "greeting", nil, &greeting)
+			return greeting + " " + name, err
+		}), codec.JSONCodec{})
+		defer client.Close()
+
+		callbacks := &rpc.Server{
+			Codec: codec.JSONCodec{},
+			Handler: HandlerFrom(func() string {
+				return "hello"
+			}),
+		}
+		go callbacks.Respond(client.Session, nil)
+
+		var ret string
+		if _, err := client.Call(context.Background(), "", Args{"world"}, &ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != "hello world" {
+			t.Fatalf("unexpected ret: %v", ret)
+		}
+	})
+
 	t.Run("return error", func(t *testing.T) {
 		client, _ := rpctest.NewPair(HandlerFrom(func(a, b int) error {
 			return errors.New("test")
@@ -161,6 +311,81 @@ func TestHandlerFromFunc(t *testing.T) {
 		}
 	})
 
+	t.Run("return typed error", func(t *testing.T) {
+		client, _ := rpctest.NewPair(HandlerFrom(func(name string) (string, error) {
+			return "", &mockError{name: name}
+		}), codec.JSONCodec{})
+		defer client.Close()
+
+		var ret string
+		_, err := client.Call(context.Background(), "", Args{"alice"}, &ret)
+		if rpc.ErrorCode(err) != "not_found" {
+			t.Fatalf("unexpected error code: %v", rpc.ErrorCode(err))
+		}
+		details, ok := rpc.ErrorDetails(err).(map[string]any)
+		if !ok || details["name"] != "alice" {
+			t.Fatalf("unexpected error details: %#v", rpc.ErrorDetails(err))
+		}
+	})
+
+	t.Run("return wrapped typed error", func(t *testing.T) {
+		client, _ := rpctest.NewPair(HandlerFrom(func(name string) (string, error) {
+			return "", fmt.Errorf("lookup: %w", &mockError{name: name})
+		}), codec.JSONCodec{})
+		defer client.Close()
+
+		var ret string
+		_, err := client.Call(context.Background(), "", Args{"alice"}, &ret)
+		if rpc.ErrorCode(err) != "not_found" {
+			t.Fatalf("unexpected error code: %v", rpc.ErrorCode(err))
+		}
+		if !strings.Contains(err.Error(), "lookup: alice not found") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("interface and pointer returns", func(t *testing.T) {
+		client, _ := rpctest.NewPair(HandlerFrom(func(kind string) (mockShape, *mockSquare) {
+			switch kind {
+			case "square":
+				return mockSquare{Side: 2}, &mockSquare{Side: 3}
+			case "nil circle":
+				return (*mockCircle)(nil), nil
+			default:
+				return nil, nil
+			}
+		}), codec.CBORCodec{})
+		defer client.Close()
+
+		var shape codec.Typed
+		var square *mockSquare
+		if _, err := client.Call(context.Background(), "", Args{"square"}, &shape, &square); err != nil {
+			t.Fatal(err)
+		}
+		if s, ok := shape.Value.(mockShape); !ok || s.Area() != 4 {
+			t.Fatalf("unexpected shape: %#v", shape.Value)
+		}
+		if square == nil || square.Side != 3 {
+			t.Fatalf("unexpected square: %#v", square)
+		}
+
+		if _, err := client.Call(context.Background(), "", Args{"none"}, &shape, &square); err != nil {
+			t.Fatal(err)
+		}
+		if shape.Value != nil || square != nil {
+			t.Fatalf("unexpected non-nil returns: %#v %#v", shape.Value, square)
+		}
+
+		// a nil pointer returned as an interface is not tagged with its type
+		shape = codec.Typed{}
+		if _, err := client.Call(context.Background(), "", Args{"nil circle"}, &shape, &square); err != nil {
+			t.Fatal(err)
+		}
+		if shape.Value != nil {
+			t.Fatalf("unexpected non-nil shape: %#v", shape.Value)
+		}
+	})
+
 	t.Run("no return", func(t *testing.T) {
 		client, _ := rpctest.NewPair(HandlerFrom(func(a, b int) {
 			return
@@ -239,6 +464,120 @@ func TestHandlerFromFunc(t *testing.T) {
 
 	})
 
+	t.Run("receive-only channel return value stream", func(t *testing.T) {
+		client, _ := rpctest.NewPair(HandlerFrom(func(n int) <-chan int {
+			ch := make(chan int)
+			go func() {
+				for i := 0; i < n; i++ {
+					ch <- i
+				}
+				close(ch)
+			}()
+			return ch
+		}), codec.JSONCodec{})
+		defer client.Close()
+
+		ch := make(chan int)
+		ctx := context.Background()
+		resp, err := client.Call(ctx, "", Args{3})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		go rpc.ReceiveNotify(ctx, resp, ch)
+		var vals []int
+		for v := range ch {
+			vals = append(vals, v)
+		}
+		if !reflect.DeepEqual(vals, []int{0, 1, 2}) {
+			t.Fatalf("unexpected streamed values: %v", vals)
+		}
+	})
+
+	t.Run("channel arg input stream", func(t *testing.T) {
+		client, _ := rpctest.NewPair(HandlerFrom(func(prefix string, in <-chan string) []string {
+			var out []string
+			for s := range in {
+				out = append(out, prefix+s)
+			}
+			return out
+		}), codec.JSONCodec{})
+		defer client.Close()
+
+		sender := make(chan interface{})
+		go func() {
+			sender <- Args{"> "}
+			sender <- "one"
+			sender <- "two"
+			close(sender)
+		}()
+		var ret []string
+		if _, err := client.Call(context.Background(), "", sender, &ret); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(ret, []string{"> one", "> two"}) {
+			t.Fatalf("unexpected ret: %v", ret)
+		}
+	})
+
+}
+
+type mockError struct {
+	name string
+}
+
+func (e *mockError) Error() string {
+	return e.name + " not found"
+}
+
+func (e *mockError) Code() string {
+	return "not_found"
+}
+
+func (e *mockError) Details() any {
+	return map[string]any{"name": e.name}
+}
+
+type mockShape interface {
+	Area() float64
+}
+
+type mockSquare struct {
+	Side float64
+}
+
+func (s mockSquare) Area() float64 {
+	return s.Side * s.Side
+}
+
+type mockCircle struct {
+	Radius float64
+}
+
+func (c *mockCircle) Area() float64 {
+	return 3 * c.Radius * c.Radius
+}
+
+func init() {
+	codec.RegisterType("fn.square", mockSquare{})
+	codec.RegisterType("fn.circle", &mockCircle{})
+}
+
+type mockValidator struct{}
+
+func (mockValidator) ValidateArgs(selector string, args []any) error {
+	for _, arg := range args {
+		if arg.(int) < 0 {
+			return errors.New("negative arg")
+		}
+	}
+	return nil
+}
+
+func (mockValidator) ValidateResults(selector string, results []any) error {
+	if results[0].(int) < 0 {
+		return errors.New("negative result")
+	}
+	return nil
 }
 
 type mockMethods struct{}
@@ -276,6 +615,55 @@ func TestHandlerFromMethods(t *testing.T) {
 	}
 }
 
+type mockEmbeddedMethods struct {
+	mockMethods
+}
+
+func (m *mockEmbeddedMethods) GetUserByID() string {
+	return "user"
+}
+
+func TestHandlerFromMethodsOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    []Option
+		present []string
+		absent  []string
+	}{
+		{"include", []Option{Include("Foo")}, []string{"Foo"}, []string{"Bar", "GetUserByID"}},
+		{"exclude", []Option{Exclude("Foo")}, []string{"Bar", "GetUserByID"}, []string{"Foo"}},
+		{"snake case", []Option{SnakeCase()}, []string{"foo", "get_user_by_id"}, []string{"Foo", "GetUserByID"}},
+		{"skip embedded", []Option{SkipEmbedded()}, []string{"GetUserByID"}, []string{"Foo", "Bar"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := HandlerFrom(&mockEmbeddedMethods{}, tc.opts...).(*rpc.RespondMux)
+			for _, name := range tc.present {
+				if h, _ := mux.Match(name); h == nil {
+					t.Fatalf("expected %s handler", name)
+				}
+			}
+			for _, name := range tc.absent {
+				if h, _ := mux.Match(name); h != nil {
+					t.Fatalf("unexpected %s handler", name)
+				}
+			}
+		})
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for in, want := range map[string]string{
+		"Foo":         "foo",
+		"GetUserByID": "get_user_by_id",
+		"HTTPServer":  "http_server",
+		"Read2":       "read2",
+	} {
+		if got := snakeCase(in); got != want {
+			t.Fatalf("snakeCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
 func TestHandlerFromMethodsInterface(t *testing.T) {
 	handler := HandlerFrom[interface {
 		Foo() string
@@ -362,3 +750,58 @@ func TestMethodHandlerFunc(t *testing.T) {
 		t.Fatalf("unexpected ret: %v", ret)
 	}
 }
+
+type mockSchemaService struct{}
+
+type mockQuery struct {
+	Term  string `mapstructure:"term" doc:"text to search for"`
+	Limit int    `mapstructure:"limit,omitempty"`
+	skip  bool
+}
+
+func (s *mockSchemaService) Search(ctx context.Context, q mockQuery, page *int) ([]string, error) {
+	return nil, nil
+}
+
+func (s *mockSchemaService) Watch(prefix string) <-chan string {
+	return nil
+}
+
+func (s *mockSchemaService) Dynamic(r rpc.Responder, c *rpc.Call) {}
+
+func TestDescribe(t *testing.T) {
+	schemas := Describe(&mockSchemaService{}, SnakeCase(), ParamNames("Search", "query", "page"))
+	want := []Schema{
+		{Selector: "dynamic", Dynamic: true},
+		{
+			Selector: "search",
+			Params: []Field{
+				{Name: "query", Type: "fn.mockQuery", Fields: []Field{
+					{Name: "term", Type: "string", Doc: "text to search for"},
+					{Name: "limit", Type: "int"},
+				}},
+				{Name: "page", Type: "*int", Optional: true},
+			},
+			Results: []Field{{Type: "[]string"}},
+		},
+		{
+			Selector: "watch",
+			Params:   []Field{{Type: "string"}},
+			Stream:   "string",
+		},
+	}
+	if !reflect.DeepEqual(schemas, want) {
+		t.Fatalf("unexpected schemas: %#v", schemas)
+	}
+
+	client, _ := rpctest.NewPair(SchemaHandler(schemas), codec.JSONCodec{})
+	defer client.Close()
+
+	var ret []Schema
+	if _, err := client.Call(context.Background(), "", "watch", &ret); err != nil {
+		t.Fatal(err)
+	}
+	if len(ret) != 1 || !reflect.DeepEqual(ret[0], want[2]) {
+		t.Fatalf("unexpected schemas: %#v", ret)
+	}
+}
@@ -0,0 +1,180 @@
+package fn
+
+import (
+	"reflect"
+	"strings"
+
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+// Schema describes a selector of a handler made with HandlerFrom.
+type Schema struct {
+	Selector string
+	Params   []Field // params expected from the caller
+	Variadic bool    // the last param takes any number of arguments
+	Results  []Field // values returned, not including an error
+	Stream   string  // type of values streamed back to the caller, if any
+	Input    string  // type of values streamed in by the caller, if any
+	Dynamic  bool    // handled by a HandlerFunc, so params are unknown
+}
+
+// Field describes a param, a result, or a field of a struct type. Fields of
+// struct types are named by their decode tag, and a `doc` struct tag can be
+// used to set Doc.
+type Field struct {
+	Name     string `json:",omitempty"`
+	Type     string
+	Doc      string  `json:",omitempty"`
+	Optional bool    `json:",omitempty"`
+	Fields   []Field `json:",omitempty"`
+}
+
+// Describe returns the Schemas for the selectors of the handler that HandlerFrom
+// returns for v and the same options. Since reflection cannot get the names of
+// params, they are left empty unless set with ParamNames.
+//
+// A handler serving the Schemas can be made with SchemaHandler, allowing callers
+// to discover the selectors a service provides.
+func Describe[T any](v T, opts ...Option) []Schema {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch rv.Type().Kind() {
+	case reflect.Func:
+		return []Schema{describeFunc("", "", reflect.TypeOf(v), o)}
+	case reflect.Struct:
+		t := reflect.TypeOf((*T)(nil)).Elem()
+		if t.NumMethod() == 0 {
+			t = reflect.TypeOf(v)
+		}
+		rcvrval := reflect.ValueOf(v).Convert(t)
+		embedded := embeddedMethods(v)
+		var schemas []Schema
+		for i := 0; i < t.NumMethod(); i++ {
+			if !t.Method(i).IsExported() {
+				continue
+			}
+			name := t.Method(i).Name
+			selector, ok := o.selector(name, embedded)
+			if !ok {
+				continue
+			}
+			m := rcvrval.Method(i)
+			if m.CanConvert(handlerFuncType) {
+				schemas = append(schemas, Schema{Selector: selector, Dynamic: true})
+				continue
+			}
+			schemas = append(schemas, describeFunc(selector, name, m.Type(), o))
+		}
+		return schemas
+	default:
+		panic("must be func or struct")
+	}
+}
+
+// ParamNames sets the names of the params expected from the caller for the
+// method with the given name, used by Describe. For function handlers the
+// method name is empty.
+func ParamNames(method string, names ...string) Option {
+	return func(o *options) {
+		if o.paramNames == nil {
+			o.paramNames = make(map[string][]string)
+		}
+		o.paramNames[method] = names
+	}
+}
+
+// SchemaHandler returns a handler that returns the Schemas. If the caller gives
+// a selector as the param, only the Schema for that selector is returned.
+func SchemaHandler(schemas []Schema) rpc.Handler {
+	return rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		var selector string
+		if err := c.Receive(&selector); err != nil {
+			r.Return(err)
+			return
+		}
+		if selector == "" {
+			r.Return(schemas)
+			return
+		}
+		for _, s := range schemas {
+			if s.Selector == selector {
+				r.Return([]Schema{s})
+				return
+			}
+		}
+		r.Return([]Schema{})
+	})
+}
+
+func describeFunc(selector, method string, fntyp reflect.Type, o *options) Schema {
+	sig := signatureOf(fntyp)
+	tagName := "mapstructure"
+	if o.decoderConfig != nil && o.decoderConfig.TagName != "" {
+		tagName = o.decoderConfig.TagName
+	}
+	schema := Schema{
+		Selector: selector,
+		Variadic: fntyp.IsVariadic(),
+	}
+	names := o.paramNames[method]
+	required := len(sig.argTypes)
+	for required > 0 && nilable(sig.argTypes[required-1]) {
+		required--
+	}
+	for i, t := range sig.argTypes {
+		field := describeType(t, tagName, nil)
+		if i < len(names) {
+			field.Name = names[i]
+		}
+		field.Optional = i >= required && !(schema.Variadic && i == len(sig.argTypes)-1)
+		schema.Params = append(schema.Params, field)
+	}
+	if sig.inputParam {
+		schema.Input = fntyp.In(fntyp.NumIn() - 1).Elem().String()
+	}
+	if sig.chanStream {
+		schema.Stream = sig.chanType.Elem().String()
+	}
+	for _, t := range sig.resultTypes {
+		schema.Results = append(schema.Results, describeType(t, tagName, nil))
+	}
+	return schema
+}
+
+// describeType returns a Field for the type, including the fields of struct types.
+// Seen is used to avoid describing recursive types more than once.
+func describeType(t reflect.Type, tagName string, seen map[reflect.Type]bool) Field {
+	field := Field{Type: t.String()}
+	st := t
+	for st.Kind() == reflect.Pointer || st.Kind() == reflect.Slice || st.Kind() == reflect.Array {
+		st = st.Elem()
+	}
+	if st.Kind() != reflect.Struct || seen[st] {
+		return field
+	}
+	if seen == nil {
+		seen = make(map[reflect.Type]bool)
+	}
+	seen[st] = true
+	defer delete(seen, st)
+	for i := 0; i < st.NumField(); i++ {
+		sf := st.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := sf.Name
+		if tag, _, _ := strings.Cut(sf.Tag.Get(tagName), ","); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		f := describeType(sf.Type, tagName, seen)
+		f.Name = name
+		f.Doc = sf.Tag.Get("doc")
+		field.Fields = append(field.Fields, f)
+	}
+	return field
+}
@@ -40,6 +40,10 @@ func (s CallbackService) StreamCallback(resp rpc.Responder, call *rpc.Call) {
 	}
 	if err != nil && err != io.EOF {
 		log.Println(err)
+		return
+	}
+	if err := resp.CloseSend(); err != nil {
+		log.Println(err)
 	}
 }
 
@@ -0,0 +1,53 @@
+package interop
+
+import (
+	"context"
+	"testing"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/fn"
+	"tractor.dev/toolkit-go/duplex/mux"
+	"tractor.dev/toolkit-go/duplex/rpc"
+	"tractor.dev/toolkit-go/duplex/rpc/rpctest"
+)
+
+func TestSuite(t *testing.T) {
+	for name, c := range map[string]codec.Codec{
+		"json": codec.JSONCodec{},
+		"cbor": codec.CBORCodec{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			a, b := mux.Pair()
+			defer a.Close()
+			defer b.Close()
+			srv := &rpc.Server{
+				Handler: fn.HandlerFrom(InteropService{}),
+				Codec:   c,
+			}
+			go srv.Respond(b, nil)
+
+			results := Run(context.Background(), a, c)
+			if len(results) != len(Cases()) {
+				t.Fatalf("expected %d results, got %d", len(Cases()), len(results))
+			}
+			for _, r := range Failed(results) {
+				t.Error(r)
+			}
+		})
+	}
+}
+
+func TestChaos(t *testing.T) {
+	for _, selector := range []string{"Stream", "Bytes"} {
+		t.Run(selector, func(t *testing.T) {
+			rpctest.Chaos{
+				Handler:       fn.HandlerFrom(InteropService{}),
+				CallerHandler: fn.HandlerFrom(CallbackService{}),
+				Codec:         codec.CBORCodec{},
+				Selector:      selector,
+				Args:          "chaos",
+				Runs:          50,
+			}.Run(t)
+		})
+	}
+}
@@ -1,10 +1,13 @@
 package interop
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
 	"log"
+	"sync"
+	"time"
 
 	"tractor.dev/toolkit-go/duplex/rpc"
 )
@@ -42,13 +45,13 @@ func (s InteropService) Stream(resp rpc.Responder, call *rpc.Call) {
 		log.Println(err)
 		return
 	}
+	defer stream.Close()
 	ch, err := resp.Continue(ret)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	defer ch.Close()
-	defer stream.Close()
 	go func() {
 		var v any
 		var err error
@@ -62,7 +65,7 @@ func (s InteropService) Stream(resp rpc.Responder, call *rpc.Call) {
 				break
 			}
 		}
-		stream.CloseWrite()
+		stream.CloseSend()
 	}()
 	var v any
 	for {
@@ -75,6 +78,9 @@ func (s InteropService) Stream(resp rpc.Responder, call *rpc.Call) {
 			break
 		}
 	}
+	if err == io.EOF {
+		resp.CloseSend()
+	}
 }
 
 func (s InteropService) Bytes(resp rpc.Responder, call *rpc.Call) {
@@ -90,13 +96,13 @@ func (s InteropService) Bytes(resp rpc.Responder, call *rpc.Call) {
 		log.Println(err)
 		return
 	}
+	defer stream.Close()
 	ch, err := resp.Continue(ret)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	defer ch.Close()
-	defer stream.Close()
 	go func() {
 		io.Copy(stream.Channel, call)
 		stream.Channel.CloseWrite()
@@ -114,3 +120,239 @@ func (s InteropService) Error(resp rpc.Responder, call *rpc.Call) {
 		log.Println(err)
 	}
 }
+
+// tickInterval is the time between numbers streamed by Ticks.
+const tickInterval = 10 * time.Millisecond
+
+// cancelWait is how long Canceled waits for a canceled Ticks call to be noticed.
+const cancelWait = 5 * time.Second
+
+// cancelKey is the key of the session value recording canceled Ticks calls.
+type cancelKey struct{}
+
+type cancelState struct {
+	once sync.Once
+	done chan struct{}
+}
+
+var cancelMu sync.Mutex
+
+// cancelStateOf returns the cancel state stored for the session of the call.
+func cancelStateOf(call *rpc.Call) *cancelState {
+	values := call.Session().Values()
+	cancelMu.Lock()
+	defer cancelMu.Unlock()
+	if v, ok := values.Get(cancelKey{}); ok {
+		return v.(*cancelState)
+	}
+	state := &cancelState{done: make(chan struct{})}
+	values.Set(cancelKey{}, state)
+	return state
+}
+
+// Ticks streams increasing numbers from 1 until it has sent the number given,
+// or forever if it is zero. If the caller cancels the call by closing it
+// before then, Ticks records it for Canceled.
+func (s InteropService) Ticks(resp rpc.Responder, call *rpc.Call) {
+	var n int
+	if err := call.Receive(&n); err != nil {
+		log.Println(err)
+		return
+	}
+	ch, err := resp.Continue(nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer ch.Close()
+	canceled := make(chan struct{})
+	go func() {
+		// the caller sends nothing, so a read returns once it cancels
+		io.Copy(io.Discard, call)
+		close(canceled)
+	}()
+	if sendTicks(resp, n, canceled) {
+		if err := resp.CloseSend(); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	<-canceled
+	state := cancelStateOf(call)
+	state.once.Do(func() {
+		close(state.done)
+	})
+}
+
+// sendTicks returns true if it sent n ticks before the caller canceled.
+func sendTicks(resp rpc.Responder, n int, canceled <-chan struct{}) bool {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for i := 1; n == 0 || i <= n; i++ {
+		if err := resp.Send(i); err != nil {
+			return false
+		}
+		select {
+		case <-canceled:
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// Canceled returns true once a Ticks call on the same session was canceled
+// by the caller, waiting a few seconds for it to be noticed.
+func (s InteropService) Canceled(resp rpc.Responder, call *rpc.Call) {
+	call.Receive(nil)
+	select {
+	case <-cancelStateOf(call).done:
+		resp.Return(true)
+	case <-time.After(cancelWait):
+		resp.Return(false)
+	}
+}
+
+// Deadline takes an array of a timeout and a duration of work in milliseconds,
+// and does the work with a deadline of the timeout from when it was called. It
+// returns "done" if the work finished, or an error if the deadline expired.
+func (s InteropService) Deadline(resp rpc.Responder, call *rpc.Call) {
+	var params []int
+	if err := call.Receive(&params); err != nil {
+		log.Println(err)
+		return
+	}
+	if len(params) != 2 {
+		resp.Return(errors.New("expected timeout and work durations"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(call.Context, time.Duration(params[0])*time.Millisecond)
+	defer cancel()
+	select {
+	case <-time.After(time.Duration(params[1]) * time.Millisecond):
+		resp.Return("done")
+	case <-ctx.Done():
+		resp.Return(ctx.Err())
+	}
+}
+
+// HalfClose continues the call and receives values until the caller ends the
+// stream, then sends back the number of values received and ends its stream,
+// checking that ending the stream one way leaves the other way open.
+func (s InteropService) HalfClose(resp rpc.Responder, call *rpc.Call) {
+	call.Receive(nil)
+	ch, err := resp.Continue(nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer ch.Close()
+	var n int
+	for {
+		var v any
+		err := call.Receive(&v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		n++
+	}
+	if err := resp.Send(n); err != nil {
+		log.Println(err)
+		return
+	}
+	if err := resp.CloseSend(); err != nil {
+		log.Println(err)
+	}
+}
+
+// Pattern returns n bytes where byte i is i%251, used for values exchanged
+// by Payload, Flood and Sink so they can be checked for corruption.
+func Pattern(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+// Payload returns a byte value of the size given, filled with Pattern.
+func (s InteropService) Payload(resp rpc.Responder, call *rpc.Call) {
+	var size int
+	if err := call.Receive(&size); err != nil {
+		log.Println(err)
+		return
+	}
+	if err := resp.Return(Pattern(size)); err != nil {
+		log.Println(err)
+	}
+}
+
+// Flood takes an array of a count and a size, and streams the count of byte
+// values of the size filled with Pattern, then ends the stream.
+func (s InteropService) Flood(resp rpc.Responder, call *rpc.Call) {
+	var params []int
+	if err := call.Receive(&params); err != nil {
+		log.Println(err)
+		return
+	}
+	if len(params) != 2 {
+		resp.Return(errors.New("expected count and size"))
+		return
+	}
+	ch, err := resp.Continue(nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer ch.Close()
+	v := Pattern(params[1])
+	for i := 0; i < params[0]; i++ {
+		if err := resp.Send(v); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	if err := resp.CloseSend(); err != nil {
+		log.Println(err)
+	}
+}
+
+// Sink continues the call and receives byte values until the caller ends the
+// stream, then sends back an array of the number of values received and the
+// number of them that did not match Pattern, and ends its stream.
+func (s InteropService) Sink(resp rpc.Responder, call *rpc.Call) {
+	call.Receive(nil)
+	ch, err := resp.Continue(nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer ch.Close()
+	var count, corrupt int
+	for {
+		var b []byte
+		err := call.Receive(&b)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		count++
+		if !bytes.Equal(b, Pattern(len(b))) {
+			corrupt++
+		}
+	}
+	if err := resp.Send([]int{count, corrupt}); err != nil {
+		log.Println(err)
+		return
+	}
+	if err := resp.CloseSend(); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,490 @@
+// Package interop provides services and a conformance suite for checking that
+// implementations of the duplex protocol stack work together.
+//
+// An endpoint under test serves InteropService, which calls back to the
+// CallbackService served by the suite. Run and Check run a matrix of Cases
+// against an endpoint and report a Result for each:
+//
+//   - error: a returned error is received as a remote error
+//   - not-found: calling an unknown selector returns an error
+//   - unary/<kind>: values of each kind round trip through a call and callback
+//   - stream: values streamed in are streamed back in order, then the stream ends
+//   - bytes/<size>: a raw byte stream of the size round trips after the response
+//   - cancel/session: closing a stream mid-way leaves the session usable
+//   - cancel/server: the endpoint notices a caller canceling a stream mid-way
+//   - deadline/expired: work past a server-side deadline returns an error
+//   - deadline/met: work within a server-side deadline returns normally
+//   - half-close: ending the stream of a call leaves the response stream open
+//   - large: a 1MB value round trips through a call and callback
+//   - payload/<size>: a byte value of the size is returned, then round trips
+//     through a call and callback
+//   - flood/<count>x<size>: a stream of byte values is received intact
+//   - sink/<count>x<size>: a stream of byte values is sent intact
+//
+// The payload, flood and sink cases exercise frame fragmentation and flow
+// control. Cases of other sizes and counts can be made with PayloadCase,
+// FloodCase and SinkCase.
+//
+// Other implementations can run the suite against themselves with the check
+// command of the duplex tool, or import this package to run it from Go.
+package interop
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/fn"
+	"tractor.dev/toolkit-go/duplex/mux"
+	"tractor.dev/toolkit-go/duplex/rpc"
+	"tractor.dev/toolkit-go/duplex/transport"
+)
+
+// CaseTimeout bounds each case run by Run, so a case that hangs fails
+// instead of holding up the rest.
+var CaseTimeout = 30 * time.Second
+
+// Case is a conformance check run against an endpoint serving InteropService.
+type Case struct {
+	Name string
+	Run  func(ctx context.Context, caller rpc.Caller) error
+}
+
+// Result is the outcome of running a Case.
+type Result struct {
+	Case     string
+	Err      error
+	Duration time.Duration
+}
+
+// Passed returns true if the case did not fail.
+func (r Result) Passed() bool {
+	return r.Err == nil
+}
+
+func (r Result) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("FAIL %s: %v", r.Case, r.Err)
+	}
+	return fmt.Sprintf("PASS %s (%s)", r.Case, r.Duration.Round(time.Microsecond))
+}
+
+// MarshalJSON encodes the result as an object with the case name, whether it
+// passed, the error if it failed, and the duration in nanoseconds, such as
+// {"case":"stream","passed":true,"duration":1200000}.
+func (r Result) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Case     string        `json:"case"`
+		Passed   bool          `json:"passed"`
+		Error    string        `json:"error,omitempty"`
+		Duration time.Duration `json:"duration"`
+	}{Case: r.Case, Passed: r.Passed(), Duration: r.Duration}
+	if r.Err != nil {
+		v.Error = r.Err.Error()
+	}
+	return json.Marshal(v)
+}
+
+// Failed returns the results of cases that failed.
+func Failed(results []Result) []Result {
+	var failed []Result
+	for _, r := range results {
+		if !r.Passed() {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// values are the values of each kind used by the unary cases.
+var values = []struct {
+	kind  string
+	value any
+}{
+	{"int", 100},
+	{"bool", true},
+	{"string", "hello"},
+	{"map", map[string]any{"foo": "bar"}},
+	{"array", []any{1, 2, 3}},
+}
+
+// Cases returns the cases of the conformance suite, in the order Run runs them.
+func Cases() []Case {
+	cases := []Case{
+		{Name: "error", Run: checkError},
+		{Name: "not-found", Run: checkNotFound},
+	}
+	for _, v := range values {
+		cases = append(cases, Case{
+			Name: "unary/" + v.kind,
+			Run: func(ctx context.Context, caller rpc.Caller) error {
+				return checkUnary(ctx, caller, v.value)
+			},
+		})
+	}
+	cases = append(cases, Case{Name: "stream", Run: checkStream})
+	for _, size := range []int{1, 1 << 10, 1 << 20} {
+		cases = append(cases, Case{
+			Name: "bytes/" + sizeName(size),
+			Run: func(ctx context.Context, caller rpc.Caller) error {
+				return checkBytes(ctx, caller, size)
+			},
+		})
+	}
+	return append(cases,
+		Case{Name: "cancel/session", Run: checkCancel},
+		Case{Name: "cancel/server", Run: checkServerCancel},
+		Case{Name: "deadline/expired", Run: func(ctx context.Context, caller rpc.Caller) error {
+			return checkDeadline(ctx, caller, 50, 5000, false)
+		}},
+		Case{Name: "deadline/met", Run: func(ctx context.Context, caller rpc.Caller) error {
+			return checkDeadline(ctx, caller, 5000, 10, true)
+		}},
+		Case{Name: "half-close", Run: checkHalfClose},
+		Case{Name: "large", Run: checkLarge},
+		PayloadCase(4<<20),
+		FloodCase(10000, 64),
+		FloodCase(64, 64<<10),
+		SinkCase(10000, 64),
+		SinkCase(64, 64<<10),
+	)
+}
+
+// PayloadCase returns a case that receives a byte value of size from Payload
+// and sends it back through Unary.
+func PayloadCase(size int) Case {
+	return Case{
+		Name: "payload/" + sizeName(size),
+		Run: func(ctx context.Context, caller rpc.Caller) error {
+			var b []byte
+			if _, err := caller.Call(ctx, "Payload", size, &b); err != nil {
+				return err
+			}
+			if !bytes.Equal(b, Pattern(size)) {
+				return fmt.Errorf("received %d bytes not matching the %d sent", len(b), size)
+			}
+			var ret []byte
+			if _, err := caller.Call(ctx, "Unary", b, &ret); err != nil {
+				return err
+			}
+			if !bytes.Equal(ret, b) {
+				return fmt.Errorf("sent %d bytes, got %d different bytes", size, len(ret))
+			}
+			return nil
+		},
+	}
+}
+
+// FloodCase returns a case that receives count byte values of size streamed
+// by Flood.
+func FloodCase(count, size int) Case {
+	return Case{
+		Name: fmt.Sprintf("flood/%dx%s", count, sizeName(size)),
+		Run: func(ctx context.Context, caller rpc.Caller) error {
+			resp, err := caller.Call(ctx, "Flood", []int{count, size}, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Close()
+			want := Pattern(size)
+			for i := 0; i < count; i++ {
+				var b []byte
+				if err := resp.Receive(&b); err != nil {
+					return fmt.Errorf("value %d: %w", i, err)
+				}
+				if !bytes.Equal(b, want) {
+					return fmt.Errorf("value %d does not match", i)
+				}
+			}
+			var b []byte
+			if err := resp.Receive(&b); err != io.EOF {
+				return fmt.Errorf("expected end of stream, got %v", err)
+			}
+			return nil
+		},
+	}
+}
+
+// SinkCase returns a case that streams count byte values of size to Sink.
+func SinkCase(count, size int) Case {
+	return Case{
+		Name: fmt.Sprintf("sink/%dx%s", count, sizeName(size)),
+		Run: func(ctx context.Context, caller rpc.Caller) error {
+			resp, err := caller.Call(ctx, "Sink", nil, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Close()
+			v := Pattern(size)
+			for i := 0; i < count; i++ {
+				if err := resp.Send(v); err != nil {
+					return fmt.Errorf("value %d: %w", i, err)
+				}
+			}
+			if err := resp.CloseSend(); err != nil {
+				return err
+			}
+			var got []int
+			if err := resp.Receive(&got); err != nil {
+				return err
+			}
+			if len(got) != 2 || got[0] != count || got[1] != 0 {
+				return fmt.Errorf("sent %d values, endpoint received %v (count, corrupt)", count, got)
+			}
+			return nil
+		},
+	}
+}
+
+// Run serves CallbackService on sess, which is connected to an endpoint
+// serving InteropService, and runs the cases against it using codec c. If
+// no cases are given, the cases returned by Cases are run. The session is
+// left open.
+func Run(ctx context.Context, sess mux.Session, c codec.Codec, cases ...Case) []Result {
+	if len(cases) == 0 {
+		cases = Cases()
+	}
+	srv := &rpc.Server{
+		Handler: fn.HandlerFrom(CallbackService{}),
+		Codec:   c,
+	}
+	go srv.ServeSession(ctx, sess)
+
+	caller := rpc.NewClient(sess, c)
+	results := make([]Result, 0, len(cases))
+	for _, cs := range cases {
+		results = append(results, runCase(ctx, caller, cs))
+	}
+	return results
+}
+
+// Check dials the endpoint at rawurl with transport.Dial and runs the cases
+// against it like Run, closing the session once done.
+func Check(ctx context.Context, rawurl string, c codec.Codec, cases ...Case) ([]Result, error) {
+	sess, err := transport.Dial(ctx, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+	return Run(ctx, sess, c, cases...), nil
+}
+
+func runCase(ctx context.Context, caller rpc.Caller, cs Case) Result {
+	ctx, cancel := context.WithTimeout(ctx, CaseTimeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- cs.Run(ctx, caller)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return Result{Case: cs.Name, Err: err, Duration: time.Since(start)}
+}
+
+func sizeName(n int) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", n>>10)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+// same compares values by how they print, since codecs can decode
+// numbers to different types.
+func same(a, b any) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func checkError(ctx context.Context, caller rpc.Caller) error {
+	_, err := caller.Call(ctx, "Error", "test", nil)
+	var remote rpc.RemoteError
+	if !errors.As(err, &remote) {
+		return fmt.Errorf("expected remote error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "test") {
+		return fmt.Errorf("unexpected error: %v", err)
+	}
+	return nil
+}
+
+func checkNotFound(ctx context.Context, caller rpc.Caller) error {
+	_, err := caller.Call(ctx, "BadSelector", "test", nil)
+	if err == nil {
+		return errors.New("expected error calling unknown selector")
+	}
+	return nil
+}
+
+func checkUnary(ctx context.Context, caller rpc.Caller, v any) error {
+	var ret any
+	if _, err := caller.Call(ctx, "Unary", v, &ret); err != nil {
+		return err
+	}
+	if !same(v, ret) {
+		return fmt.Errorf("sent %v, got %v", v, ret)
+	}
+	return nil
+}
+
+func checkStream(ctx context.Context, caller rpc.Caller) error {
+	resp, err := caller.Call(ctx, "Stream", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	sent := make(chan error, 1)
+	go func() {
+		for _, v := range values {
+			if err := resp.Send(v.value); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- resp.CloseSend()
+	}()
+	for _, v := range values {
+		var ret any
+		if err := resp.Receive(&ret); err != nil {
+			return err
+		}
+		if !same(v.value, ret) {
+			return fmt.Errorf("sent %v, got %v", v.value, ret)
+		}
+	}
+	if err := <-sent; err != nil {
+		return err
+	}
+	var ret any
+	if err := resp.Receive(&ret); err != io.EOF {
+		return fmt.Errorf("expected end of stream, got %v", err)
+	}
+	return nil
+}
+
+func checkBytes(ctx context.Context, caller rpc.Caller, size int) error {
+	data := make([]byte, size)
+	rand.Read(data)
+	resp, err := caller.Call(ctx, "Bytes", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Channel.Close()
+	go func() {
+		io.Copy(resp.Channel, bytes.NewReader(data))
+		resp.Channel.CloseWrite()
+	}()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, resp.Channel); err != nil {
+		return err
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		return fmt.Errorf("sent %d bytes, got %d different bytes", size, buf.Len())
+	}
+	return nil
+}
+
+func checkCancel(ctx context.Context, caller rpc.Caller) error {
+	resp, err := caller.Call(ctx, "Stream", nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := resp.Send("first"); err != nil {
+		return err
+	}
+	var ret any
+	if err := resp.Receive(&ret); err != nil {
+		return err
+	}
+	// give up on the stream before ending it
+	resp.Close()
+	return checkUnary(ctx, caller, "after cancel")
+}
+
+func checkServerCancel(ctx context.Context, caller rpc.Caller) error {
+	resp, err := caller.Call(ctx, "Ticks", 0, nil)
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= 3; i++ {
+		var tick int
+		if err := resp.Receive(&tick); err != nil {
+			return err
+		}
+		if tick != i {
+			return fmt.Errorf("expected tick %d, got %d", i, tick)
+		}
+	}
+	resp.Close()
+	var canceled bool
+	if _, err := caller.Call(ctx, "Canceled", nil, &canceled); err != nil {
+		return err
+	}
+	if !canceled {
+		return errors.New("endpoint did not notice the canceled stream")
+	}
+	return nil
+}
+
+// checkDeadline calls Deadline with a timeout and work in milliseconds.
+func checkDeadline(ctx context.Context, caller rpc.Caller, timeout, work int, met bool) error {
+	start := time.Now()
+	var ret string
+	_, err := caller.Call(ctx, "Deadline", []int{timeout, work}, &ret)
+	switch {
+	case met && err != nil:
+		return err
+	case met && ret != "done":
+		return fmt.Errorf("unexpected return: %q", ret)
+	case !met && err == nil:
+		return errors.New("expected deadline error")
+	case !met && time.Since(start) >= time.Duration(work)*time.Millisecond:
+		return errors.New("deadline error returned after the work was done")
+	}
+	return nil
+}
+
+func checkHalfClose(ctx context.Context, caller rpc.Caller) error {
+	resp, err := caller.Call(ctx, "HalfClose", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	for _, v := range values {
+		if err := resp.Send(v.value); err != nil {
+			return err
+		}
+	}
+	if err := resp.CloseSend(); err != nil {
+		return err
+	}
+	var n int
+	if err := resp.Receive(&n); err != nil {
+		return err
+	}
+	if n != len(values) {
+		return fmt.Errorf("sent %d values, endpoint received %d", len(values), n)
+	}
+	if err := resp.Receive(&n); err != io.EOF {
+		return fmt.Errorf("expected end of stream, got %v", err)
+	}
+	return nil
+}
+
+func checkLarge(ctx context.Context, caller rpc.Caller) error {
+	return checkUnary(ctx, caller, strings.Repeat("x", 1<<20))
+}
@@ -1,10 +1,12 @@
 package mux
 
 import (
-	"errors"
 	"fmt"
 	"io"
+	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"tractor.dev/toolkit-go/duplex/mux/frame"
 )
@@ -23,12 +25,27 @@ func min(a uint32, b int) uint32 {
 	return uint32(b)
 }
 
+// Channel is a bi-directional byte stream within a session. Channels
+// satisfy net.Conn, where both addresses identify the channel.
 type Channel interface {
-	io.ReadWriteCloser
+	net.Conn
 	ID() uint32
 	CloseWrite() error
 }
 
+// Addr is the net.Addr of a channel.
+type Addr struct {
+	ID uint32
+}
+
+func (a Addr) Network() string {
+	return "qmux"
+}
+
+func (a Addr) String() string {
+	return fmt.Sprintf("channel:%d", a.ID)
+}
+
 // channel is an implementation of the Channel interface that works
 // with the session class.
 type channel struct {
@@ -58,9 +75,13 @@ type channel struct {
 	remoteWin window
 	pending   *buffer
 
-	// windowMu protects myWindow, the flow-control window.
+	// windowMu protects myWindow, the flow-control window, and unread,
+	// the bytes received but not yet read which count towards the
+	// session's buffered bytes until the channel is released.
 	windowMu sync.Mutex
 	myWindow uint32
+	unread   int64
+	released bool
 
 	// writeMu serializes calls to session.conn.Write() and
 	// protects sentClose and packetPool. This mutex must be
@@ -71,6 +92,9 @@ type channel struct {
 
 	// packet buffer for writing
 	packetBuf []byte
+
+	stats    channelStats
+	priority atomic.Uint32
 }
 
 // ID returns the unique identifier of this channel
@@ -79,6 +103,38 @@ func (ch *channel) ID() uint32 {
 	return ch.localId
 }
 
+// LocalAddr returns the address of the channel.
+func (ch *channel) LocalAddr() net.Addr {
+	return Addr{ID: ch.localId}
+}
+
+// RemoteAddr returns the address of the channel.
+func (ch *channel) RemoteAddr() net.Addr {
+	return Addr{ID: ch.localId}
+}
+
+// SetDeadline sets both the read and write deadlines.
+func (ch *channel) SetDeadline(t time.Time) error {
+	ch.SetReadDeadline(t)
+	return ch.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the time after which blocked and future reads
+// fail with os.ErrDeadlineExceeded. A zero time clears the deadline.
+func (ch *channel) SetReadDeadline(t time.Time) error {
+	ch.pending.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline sets the time after which writes waiting for the
+// remote side to read fail with os.ErrDeadlineExceeded. A write may
+// have sent part of its data when it fails. A zero time clears the
+// deadline.
+func (ch *channel) SetWriteDeadline(t time.Time) error {
+	ch.remoteWin.setDeadline(t)
+	return nil
+}
+
 // CloseWrite signals the end of sending data.
 // The other side may still send data
 func (ch *channel) CloseWrite() error {
@@ -101,22 +157,29 @@ func (ch *channel) Write(data []byte) (n int, err error) {
 	}
 
 	for len(data) > 0 {
+		// writes larger than the maximum frame size of either side are
+		// fragmented into several frames, which the remote side reads
+		// back as one stream of bytes.
 		space := min(ch.maxRemotePayload, len(data))
+		if space > ch.session.config.maxFrameSize {
+			space = ch.session.config.maxFrameSize
+		}
 		if space, err = ch.remoteWin.reserve(space); err != nil {
 			return n, err
 		}
 
 		toSend := data[:space]
 
-		if err = ch.session.enc.Encode(frame.DataMessage{
+		if err = ch.session.writer.write(frame.DataMessage{
 			ChannelID: ch.remoteId,
 			Length:    uint32(len(toSend)),
 			Data:      toSend,
-		}); err != nil {
+		}, Priority(ch.priority.Load())); err != nil {
 			return n, err
 		}
 
 		n += len(toSend)
+		ch.stats.bytesSent.Add(uint64(len(toSend)))
 		data = data[len(toSend):]
 	}
 
@@ -128,6 +191,12 @@ func (c *channel) Read(data []byte) (n int, err error) {
 	n, err = c.pending.Read(data)
 
 	if n > 0 {
+		c.windowMu.Lock()
+		c.unread -= int64(n)
+		if !c.released {
+			c.session.buffered.Add(-int64(n))
+		}
+		c.windowMu.Unlock()
 		err = c.adjustWindow(uint32(n))
 		// sendWindowAdjust can return io.EOF if the remote
 		// peer has closed the connection, however we want to
@@ -141,20 +210,41 @@ func (c *channel) Read(data []byte) (n int, err error) {
 }
 
 // sends writes a message frame. If the message is a channel close, it updates
-// sentClose. This method takes the lock c.writeMu.
+// sentClose. This method takes the lock c.writeMu to queue the frame, but
+// waits for it to be written without it, so the session loop replying on the
+// channel is not blocked by a transport waiting for the session loop.
 func (ch *channel) send(msg frame.Message) error {
+	ch.writeMu.Lock()
+	if ch.sentClose {
+		ch.writeMu.Unlock()
+		return io.EOF
+	}
+
+	if _, ok := msg.(frame.CloseMessage); ok {
+		ch.sentClose = true
+	}
+
+	done := make(chan error, 1)
+	ch.session.writer.queue(writeRequest{msg: msg, done: done}, Priority(ch.priority.Load()))
+	ch.writeMu.Unlock()
+	return <-done
+}
+
+// reply is like send but queues the message frame without waiting for
+// it to be written, for use by the session loop.
+func (ch *channel) reply(msg frame.Message) {
 	ch.writeMu.Lock()
 	defer ch.writeMu.Unlock()
 
 	if ch.sentClose {
-		return io.EOF
+		return
 	}
 
 	if _, ok := msg.(frame.CloseMessage); ok {
 		ch.sentClose = true
 	}
 
-	return ch.session.enc.Encode(msg)
+	ch.session.writer.post(msg, Priority(ch.priority.Load()))
 }
 
 func (c *channel) adjustWindow(n uint32) error {
@@ -170,6 +260,14 @@ func (c *channel) adjustWindow(n uint32) error {
 }
 
 func (c *channel) close() {
+	c.session.stats.channelClosed(c)
+	// data left unread no longer counts towards the session's limit
+	c.windowMu.Lock()
+	if !c.released {
+		c.session.buffered.Add(-c.unread)
+		c.released = true
+	}
+	c.windowMu.Unlock()
 	c.pending.eof()
 	close(c.msg)
 	c.writeMu.Lock()
@@ -186,7 +284,7 @@ func (c *channel) close() {
 // given channel.
 func (ch *channel) responseMessageReceived() error {
 	if ch.direction == channelInbound {
-		return errors.New("qmux: channel response message received on inbound channel")
+		return fmt.Errorf("%w: channel response message received on inbound channel", ErrProtocol)
 	}
 	return nil
 }
@@ -197,7 +295,7 @@ func (ch *channel) handle(msg frame.Message) error {
 		return ch.handleData(m)
 
 	case *frame.CloseMessage:
-		ch.send(frame.CloseMessage{
+		ch.reply(frame.CloseMessage{
 			ChannelID: ch.remoteId,
 		})
 		ch.session.chans.remove(ch.localId)
@@ -210,7 +308,7 @@ func (ch *channel) handle(msg frame.Message) error {
 
 	case *frame.WindowAdjustMessage:
 		if !ch.remoteWin.add(m.AdditionalBytes) {
-			return fmt.Errorf("qmux: invalid window update for %d bytes", m.AdditionalBytes)
+			return fmt.Errorf("%w: invalid window update for %d bytes", ErrProtocol, m.AdditionalBytes)
 		}
 		return nil
 
@@ -219,7 +317,7 @@ func (ch *channel) handle(msg frame.Message) error {
 			return err
 		}
 		if m.MaxPacketSize < minPacketLength || m.MaxPacketSize > maxPacketLength {
-			return fmt.Errorf("qmux: invalid MaxPacketSize %d from peer", m.MaxPacketSize)
+			return fmt.Errorf("%w: invalid MaxPacketSize %d from peer", ErrProtocol, m.MaxPacketSize)
 		}
 		ch.remoteId = m.SenderID
 		ch.maxRemotePayload = m.MaxPacketSize
@@ -236,29 +334,36 @@ func (ch *channel) handle(msg frame.Message) error {
 		return nil
 
 	default:
-		return fmt.Errorf("qmux: invalid channel message %v", msg)
+		return fmt.Errorf("%w: invalid channel message %v", ErrProtocol, msg)
 	}
 }
 
 func (ch *channel) handleData(msg *frame.DataMessage) error {
 	if msg.Length > ch.maxIncomingPayload {
 		// TODO(hanwen): should send Disconnect?
-		return errors.New("qmux: incoming packet exceeds maximum payload size")
+		return fmt.Errorf("%w: incoming packet exceeds maximum payload size", ErrProtocol)
 	}
 
 	if msg.Length != uint32(len(msg.Data)) {
-		return errors.New("qmux: wrong packet length")
+		return fmt.Errorf("%w: wrong packet length", ErrProtocol)
 	}
 
 	ch.windowMu.Lock()
 	if ch.myWindow < msg.Length {
 		ch.windowMu.Unlock()
 		// TODO(hanwen): should send Disconnect with reason?
-		return errors.New("qmux: remote side wrote too much")
+		return fmt.Errorf("%w: remote side wrote too much", ErrProtocol)
 	}
 	ch.myWindow -= msg.Length
+	ch.unread += int64(msg.Length)
+	buffered := ch.session.buffered.Add(int64(msg.Length))
 	ch.windowMu.Unlock()
 
+	if max := ch.session.config.maxBufferedBytes; max > 0 && buffered > max {
+		return fmt.Errorf("%w: more than %d bytes buffered", ErrLimitExceeded, max)
+	}
+
+	ch.stats.bytesReceived.Add(uint64(msg.Length))
 	ch.pending.write(msg.Data)
 	return nil
 }
@@ -0,0 +1,91 @@
+//go:build !js && !tinygo
+
+package mux
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// commandExitTimeout is how long closing a session from DialCommand waits for
+// the subprocess to exit after closing its stdin before it is killed.
+var commandExitTimeout = 5 * time.Second
+
+// DialCommand starts cmd and establishes a mux session over its stdin and
+// stdout, such as with a plugin process using ListenStdio. Stdin and Stdout of
+// cmd must not be set. If Stderr is not set, it is forwarded to os.Stderr.
+// Closing the session closes the stdin of the subprocess and waits for it to
+// exit, killing it if it does not exit in time. The session also ends when the
+// subprocess exits.
+func DialCommand(cmd *exec.Cmd, opts ...Option) (Session, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	err = cmd.Start()
+	// the subprocess has its own copies of these
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, err
+	}
+	t := &cmdTransport{
+		cmd:    cmd,
+		stdin:  stdinW,
+		stdout: stdoutR,
+		exited: make(chan struct{}),
+	}
+	go func() {
+		t.cmd.Wait()
+		close(t.exited)
+	}()
+	return New(t, opts...), nil
+}
+
+// cmdTransport reads from the stdout and writes to the stdin of a subprocess.
+type cmdTransport struct {
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *os.File
+	exited chan struct{}
+
+	closeOnce sync.Once
+}
+
+func (t *cmdTransport) Read(p []byte) (int, error) {
+	return t.stdout.Read(p)
+}
+
+func (t *cmdTransport) Write(p []byte) (int, error) {
+	return t.stdin.Write(p)
+}
+
+// Close closes stdin and waits for the subprocess to exit.
+func (t *cmdTransport) Close() error {
+	t.closeOnce.Do(func() {
+		t.stdin.Close()
+		select {
+		case <-t.exited:
+		case <-time.After(commandExitTimeout):
+			t.cmd.Process.Kill()
+			<-t.exited
+		}
+		t.stdout.Close()
+	})
+	return nil
+}
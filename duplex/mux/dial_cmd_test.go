@@ -0,0 +1,62 @@
+//go:build !js && !tinygo
+
+package mux
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), "QMUX_HELPER_PROCESS=1")
+	cmd.Stderr = &stderr
+
+	sess, err := DialCommand(cmd)
+	fatal(err, t)
+	ch, err := sess.Open(context.Background())
+	fatal(err, t)
+	_, err = ch.Write([]byte("Hello world"))
+	fatal(err, t)
+	fatal(ch.CloseWrite(), t)
+	b, err := ioutil.ReadAll(ch)
+	fatal(err, t)
+	if !bytes.Equal(b, []byte("Hello world")) {
+		t.Fatalf("unexpected bytes: %s", b)
+	}
+
+	fatal(sess.Close(), t)
+	if !cmd.ProcessState.Exited() {
+		t.Fatal("expected subprocess to exit")
+	}
+	if !strings.Contains(stderr.String(), "helper ready") {
+		t.Fatalf("expected stderr to be forwarded: %q", stderr.String())
+	}
+}
+
+// TestHelperProcess is run as a subprocess by TestCommand
+// and echoes a channel over stdio.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("QMUX_HELPER_PROCESS") != "1" {
+		return
+	}
+	l, _ := ListenStdio()
+	sess, _ := l.Accept()
+	fmt.Fprintln(os.Stderr, "helper ready")
+	ch, err := sess.Accept()
+	if err != nil {
+		os.Exit(1)
+	}
+	io.Copy(ch, ch)
+	ch.CloseWrite()
+	sess.Wait()
+	os.Exit(0)
+}
@@ -0,0 +1,266 @@
+//go:build !tinygo
+
+package mux
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// h2SessionHeader identifies the session of a channel opened as an
+// HTTP/2 stream, so streams can be grouped into sessions even when a
+// proxy or load balancer multiplexes them onto other connections.
+const h2SessionHeader = "Duplex-Session"
+
+// errH2Open is returned by Open on the server side of an HTTP/2 session,
+// since HTTP/2 streams can only be opened by the client.
+var errH2Open = errors.New("qmux: channels of an http/2 session can only be opened by the client")
+
+// DialH2 returns a session whose channels are each an HTTP/2 stream to the handler
+// of an H2Listener at url, so sessions can pass through proxies and load balancers
+// that support HTTP/2. Channels can only be opened by this side of the session. No
+// connection is made until a channel is opened. If client is nil, a client is used
+// that speaks HTTP/2 over TLS for https URLs and cleartext HTTP/2 for http URLs.
+func DialH2(u string, client *http.Client) (Session, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if pu.Scheme != "http" && pu.Scheme != "https" {
+		return nil, fmt.Errorf("qmux: unsupported url scheme %q", pu.Scheme)
+	}
+	if client == nil {
+		client = h2Client(pu)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &h2ClientSession{
+		url:    u,
+		client: client,
+		id:     hex.EncodeToString(id),
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+func h2Client(u *url.URL) *http.Client {
+	t := &http2.Transport{}
+	if u.Scheme == "http" {
+		t.AllowHTTP = true
+		t.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return &http.Client{Transport: t}
+}
+
+type h2ClientSession struct {
+	url    string
+	client *http.Client
+	id     string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	nextID   atomic.Uint32
+	open     atomic.Int64
+	draining atomic.Bool
+}
+
+// Close aborts open channels and tells the server to close its side of
+// the session.
+func (s *h2ClientSession) Close() error {
+	if s.ctx.Err() != nil {
+		return nil
+	}
+	s.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(h2SessionHeader, s.id)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Accept blocks until the session is closed since the server side
+// cannot open channels.
+func (s *h2ClientSession) Accept() (Channel, error) {
+	return s.AcceptContext(context.Background())
+}
+
+func (s *h2ClientSession) AcceptContext(ctx context.Context) (Channel, error) {
+	select {
+	case <-s.ctx.Done():
+		return nil, io.EOF
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Open makes a request to the server, which becomes a channel once the
+// server responds.
+func (s *h2ClientSession) Open(ctx context.Context) (Channel, error) {
+	if s.ctx.Err() != nil {
+		return nil, net.ErrClosed
+	}
+	if s.draining.Load() {
+		return nil, ErrGoAway
+	}
+	pr, pw := io.Pipe()
+	reqCtx, cancel := context.WithCancel(s.ctx)
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, s.url, pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set(h2SessionHeader, s.id)
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := s.client.Do(req)
+		done <- result{resp, err}
+	}()
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		cancel()
+		pw.Close()
+		return nil, ctx.Err()
+	}
+	if r.err != nil {
+		cancel()
+		pw.Close()
+		return nil, r.err
+	}
+	if r.resp.StatusCode != http.StatusOK {
+		r.resp.Body.Close()
+		cancel()
+		pw.Close()
+		if r.resp.StatusCode == http.StatusServiceUnavailable {
+			return nil, ErrGoAway
+		}
+		return nil, fmt.Errorf("qmux: http/2 channel refused: %s", r.resp.Status)
+	}
+	s.open.Add(1)
+	return &h2ClientChannel{
+		id:     s.nextID.Add(1),
+		sess:   s,
+		body:   r.resp.Body,
+		pw:     pw,
+		cancel: cancel,
+	}, nil
+}
+
+// Drain stops opening channels and waits for open channels to be closed
+// before closing the session.
+func (s *h2ClientSession) Drain(ctx context.Context) error {
+	s.draining.Store(true)
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for s.open.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return nil
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		}
+	}
+	return s.Close()
+}
+
+func (s *h2ClientSession) Wait() error {
+	<-s.ctx.Done()
+	return io.EOF
+}
+
+// h2ClientChannel writes to the request body and reads from the
+// response body of a stream.
+type h2ClientChannel struct {
+	id     uint32
+	sess   *h2ClientSession
+	body   io.ReadCloser
+	pw     *io.PipeWriter
+	cancel context.CancelFunc
+	closed sync.Once
+}
+
+func (c *h2ClientChannel) ID() uint32 {
+	return c.id
+}
+
+func (c *h2ClientChannel) Read(p []byte) (int, error) {
+	return c.body.Read(p)
+}
+
+func (c *h2ClientChannel) Write(p []byte) (int, error) {
+	return c.pw.Write(p)
+}
+
+// CloseWrite ends the request body.
+func (c *h2ClientChannel) CloseWrite() error {
+	return c.pw.Close()
+}
+
+// Close resets the stream.
+func (c *h2ClientChannel) Close() error {
+	c.closed.Do(func() {
+		c.pw.Close()
+		c.body.Close()
+		c.cancel()
+		c.sess.open.Add(-1)
+	})
+	return nil
+}
+
+func (c *h2ClientChannel) LocalAddr() net.Addr {
+	return Addr{ID: c.id}
+}
+
+func (c *h2ClientChannel) RemoteAddr() net.Addr {
+	return Addr{ID: c.id}
+}
+
+// Deadlines are not supported on the client side of a stream.
+
+func (c *h2ClientChannel) SetDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
+
+func (c *h2ClientChannel) SetReadDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
+
+func (c *h2ClientChannel) SetWriteDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
@@ -0,0 +1,64 @@
+package mux
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// ALPNProtocol is the protocol negotiated with ALPN by DialTLS and ListenTLS
+// when the tls.Config does not set NextProtos.
+const ALPNProtocol = "qmux"
+
+// DialTLS establishes a mux session via TLS connection. If config is nil, the
+// server is verified with the system roots. Client certificates for mutual TLS
+// can be set with ClientTLSConfig or on a custom config.
+func DialTLS(addr string, config *tls.Config) (Session, error) {
+	conn, err := tls.Dial("tcp", addr, withALPN(config))
+	if err != nil {
+		return nil, err
+	}
+	return New(conn), nil
+}
+
+// ClientTLSConfig returns a tls.Config for DialTLS that verifies the server
+// with roots, or the system roots if nil, and presents cert to the server
+// for mutual TLS if it is not nil.
+func ClientTLSConfig(roots *x509.CertPool, cert *tls.Certificate) *tls.Config {
+	config := &tls.Config{
+		RootCAs:    roots,
+		NextProtos: []string{ALPNProtocol},
+	}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return config
+}
+
+// TLSConnectionState returns the state of the TLS connection a session created
+// by New runs over, including the verified certificates of the remote side when
+// using mutual TLS. It returns false if the transport is not a TLS connection.
+func TLSConnectionState(sess Session) (tls.ConnectionState, bool) {
+	s, ok := sess.(*session)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	conn, ok := s.t.(interface{ ConnectionState() tls.ConnectionState })
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return conn.ConnectionState(), true
+}
+
+// withALPN returns a copy of config that negotiates ALPNProtocol
+// if it has no protocols set.
+func withALPN(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if len(config.NextProtos) > 0 {
+		return config
+	}
+	config = config.Clone()
+	config.NextProtos = []string{ALPNProtocol}
+	return config
+}
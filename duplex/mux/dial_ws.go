@@ -1,18 +1,32 @@
-//go:build !tinygo
+//go:build !tinygo && !js
 
 package mux
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 
 	"golang.org/x/net/websocket"
 )
 
-// DialWS establishes a mux session via WebSocket connection.
-// The address must be a host and port. Opening a WebSocket
-// connection at a particular path is not supported.
+// DialWS establishes a mux session via WebSocket connection. The address can
+// be a host and port, which is dialed with ws://, or a full ws:// or wss://
+// URL to open the connection at a particular path.
 func DialWS(addr string) (Session, error) {
-	ws, err := websocket.Dial(fmt.Sprintf("ws://%s/", addr), "", fmt.Sprintf("http://%s/", addr))
+	u := addr
+	if !strings.Contains(addr, "://") {
+		u = fmt.Sprintf("ws://%s/", addr)
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	origin := "http://" + pu.Host + "/"
+	if pu.Scheme == "wss" {
+		origin = "https://" + pu.Host + "/"
+	}
+	ws, err := websocket.Dial(u, "", origin)
 	if err != nil {
 		return nil, err
 	}
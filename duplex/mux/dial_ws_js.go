@@ -0,0 +1,126 @@
+//go:build js && wasm
+
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall/js"
+)
+
+// DialWS establishes a mux session via WebSocket connection using the
+// WebSocket API of the browser. The address can be a host and port, which
+// is dialed with ws://, or a full ws:// or wss:// URL.
+func DialWS(addr string) (Session, error) {
+	u := addr
+	if !strings.Contains(addr, "://") {
+		u = fmt.Sprintf("ws://%s/", addr)
+	}
+	conn, err := dialBrowserWS(u)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn), nil
+}
+
+// browserWS is a browser WebSocket used as an io.ReadWriteCloser.
+// Messages received are queued in a buffer until they are read.
+type browserWS struct {
+	ws      js.Value
+	pending *buffer
+	funcs   []js.Func
+
+	closeOnce sync.Once
+}
+
+func dialBrowserWS(u string) (*browserWS, error) {
+	ctor := js.Global().Get("WebSocket")
+	if ctor.IsUndefined() {
+		return nil, errors.New("qmux: WebSocket API not available")
+	}
+	c := &browserWS{
+		ws:      ctor.New(u),
+		pending: newBuffer(),
+	}
+	c.ws.Set("binaryType", "arraybuffer")
+
+	opened := make(chan error, 1)
+	c.on("open", func(js.Value) {
+		select {
+		case opened <- nil:
+		default:
+		}
+	})
+	c.on("error", func(js.Value) {
+		select {
+		case opened <- fmt.Errorf("qmux: websocket error connecting to %s", u):
+		default:
+		}
+	})
+	c.on("close", func(js.Value) {
+		c.pending.eof()
+		select {
+		case opened <- fmt.Errorf("qmux: websocket to %s closed", u):
+		default:
+		}
+		c.release()
+	})
+	c.on("message", func(event js.Value) {
+		arr := js.Global().Get("Uint8Array").New(event.Get("data"))
+		b := make([]byte, arr.Get("length").Int())
+		js.CopyBytesToGo(b, arr)
+		c.pending.write(b)
+	})
+
+	if err := <-opened; err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// on sets an event handler of the WebSocket, which is released
+// once the WebSocket is closed.
+func (c *browserWS) on(event string, fn func(js.Value)) {
+	f := js.FuncOf(func(this js.Value, args []js.Value) any {
+		fn(args[0])
+		return nil
+	})
+	c.funcs = append(c.funcs, f)
+	c.ws.Set("on"+event, f)
+}
+
+func (c *browserWS) release() {
+	for _, event := range []string{"open", "error", "close", "message"} {
+		c.ws.Set("on"+event, js.Null())
+	}
+	for _, f := range c.funcs {
+		f.Release()
+	}
+	c.funcs = nil
+}
+
+func (c *browserWS) Read(p []byte) (int, error) {
+	return c.pending.Read(p)
+}
+
+func (c *browserWS) Write(p []byte) (int, error) {
+	// readyState 1 is OPEN
+	if c.ws.Get("readyState").Int() != 1 {
+		return 0, errors.New("qmux: websocket is not open")
+	}
+	arr := js.Global().Get("Uint8Array").New(len(p))
+	js.CopyBytesToJS(arr, p)
+	c.ws.Call("send", arr)
+	return len(p), nil
+}
+
+func (c *browserWS) Close() error {
+	c.closeOnce.Do(func() {
+		c.ws.Call("close")
+		c.pending.eof()
+	})
+	return nil
+}
@@ -1,7 +1,6 @@
 package frame
 
 import (
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -10,9 +9,22 @@ import (
 	"syscall"
 )
 
+// ErrUnknownMessage is returned by a Decoder for a message of unknown type.
+var ErrUnknownMessage = errors.New("qtalk: unexpected message type")
+
+// ErrTooLarge is returned by a Decoder for a data message longer than its
+// MaxDataLength, or a hello message longer than the protocol allows.
+var ErrTooLarge = errors.New("qtalk: message data too long")
+
 // Decoder decodes messages given an io.Reader
 type Decoder struct {
-	r io.Reader
+	// MaxDataLength, if set, is the longest data of a data message that
+	// is decoded. Longer messages are rejected from their header without
+	// reading their data.
+	MaxDataLength uint32
+
+	r   io.Reader
+	buf [maxHeaderLength]byte
 	sync.Mutex
 }
 
@@ -24,8 +36,8 @@ func (dec *Decoder) Decode() (Message, error) {
 	dec.Lock()
 	defer dec.Unlock()
 
-	var msgNum [1]byte
-	_, err := io.ReadFull(dec.r, msgNum[:])
+	msgNum := dec.buf[:1]
+	_, err := io.ReadFull(dec.r, msgNum)
 	if err != nil {
 		var syscallErr *os.SyscallError
 		if errors.As(err, &syscallErr) && syscallErr.Err == syscall.ECONNRESET { // syscall.ECONNRESET not supported by tinygo 0.28.1
@@ -35,42 +47,61 @@ func (dec *Decoder) Decode() (Message, error) {
 	}
 
 	var msg Message
-	msg, err = messageFrom(msgNum)
+	msg, err = messageFrom(msgNum[0])
 	if err != nil {
 		return nil, err
 	}
 
-	if msgNum[0] == msgChannelData {
-		var data struct {
-			ChannelID uint32
-			Length    uint32
+	hdr := dec.buf[:headerLength(msgNum[0])]
+	if _, err := io.ReadFull(dec.r, hdr); err != nil {
+		return nil, err
+	}
+	decodeHeader(msg, hdr)
+
+	switch m := msg.(type) {
+	case *DataMessage:
+		if dec.MaxDataLength > 0 && m.Length > dec.MaxDataLength {
+			return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, m.Length)
 		}
-		if err := binary.Read(dec.r, binary.BigEndian, &data); err != nil {
+		// the data is handed off to the caller, so it is not pooled
+		if m.Data, err = readData(dec.r, m.Length); err != nil {
 			return nil, err
 		}
-		dataMsg := msg.(*DataMessage)
-		dataMsg.ChannelID = data.ChannelID
-		dataMsg.Length = data.Length
-		dataMsg.Data = make([]byte, data.Length)
-		_, err := io.ReadFull(dec.r, dataMsg.Data)
-		if err != nil {
-			return nil, err
+	case *HelloMessage:
+		if m.Length > maxHelloLength {
+			return nil, fmt.Errorf("%w: hello of %d bytes", ErrTooLarge, m.Length)
 		}
-	} else {
-		if err := binary.Read(dec.r, binary.BigEndian, msg); err != nil {
+		if m.Data, err = readData(dec.r, m.Length); err != nil {
 			return nil, err
 		}
 	}
 
-	if Debug != nil {
-		fmt.Fprintln(Debug, ">>DEC", msg)
-	}
-
 	return msg, nil
 }
 
-func messageFrom(num [1]byte) (Message, error) {
-	switch num[0] {
+// preallocDataLength is the most data allocated up front when reading the
+// data of a message. Larger data is allocated as it arrives, so a corrupt
+// length can't allocate much more memory than the data actually sent.
+const preallocDataLength = 64 << 10
+
+func readData(r io.Reader, n uint32) ([]byte, error) {
+	b := make([]byte, 0, min(n, preallocDataLength))
+	for len(b) < int(n) {
+		if len(b) == cap(b) {
+			b = append(b, 0)[:len(b)]
+		}
+		end := min(cap(b), int(n))
+		k, err := io.ReadFull(r, b[len(b):end])
+		b = b[:len(b)+k]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func messageFrom(num byte) (Message, error) {
+	switch num {
 	case msgChannelOpen:
 		return new(OpenMessage), nil
 	case msgChannelData:
@@ -85,7 +116,15 @@ func messageFrom(num [1]byte) (Message, error) {
 		return new(EOFMessage), nil
 	case msgChannelClose:
 		return new(CloseMessage), nil
+	case msgPing:
+		return new(PingMessage), nil
+	case msgPong:
+		return new(PongMessage), nil
+	case msgGoAway:
+		return new(GoAwayMessage), nil
+	case msgHello:
+		return new(HelloMessage), nil
 	default:
-		return nil, fmt.Errorf("qtalk: unexpected message type %d", num[0])
+		return nil, fmt.Errorf("%w %d", ErrUnknownMessage, num)
 	}
 }
@@ -1,8 +1,8 @@
 package frame
 
 import (
-	"fmt"
 	"io"
+	"net"
 	"sync"
 )
 
@@ -16,14 +16,44 @@ func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{w: w}
 }
 
+// Encode writes msg using a pooled buffer. Small messages are written with a
+// single Write, while the data of a large DataMessage is written from the
+// message without copying, using a vectored write if the writer supports it.
 func (enc *Encoder) Encode(msg Message) error {
 	enc.Lock()
 	defer enc.Unlock()
 
-	_, err := enc.w.Write(msg.Bytes())
+	bp := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(bp)
 
-	if Debug != nil {
-		fmt.Fprintln(Debug, "<<ENC", msg)
+	b, ok := appendHeader((*bp)[:0], msg)
+	if !ok {
+		b = append(b, msg.Bytes()...)
 	}
+	var err error
+	data := dataOf(msg)
+	if len(data) > inlineDataLength {
+		bufs := net.Buffers{b, data}
+		_, err = bufs.WriteTo(enc.w)
+	} else {
+		b = append(b, data...)
+		_, err = enc.w.Write(b)
+	}
+	*bp = b[:0]
 	return err
 }
+
+func dataOf(msg Message) []byte {
+	switch m := msg.(type) {
+	case DataMessage:
+		return m.Data
+	case *DataMessage:
+		return m.Data
+	case HelloMessage:
+		return m.Data
+	case *HelloMessage:
+		return m.Data
+	default:
+		return nil
+	}
+}
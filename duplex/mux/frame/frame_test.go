@@ -2,6 +2,8 @@ package frame
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"testing"
 )
 
@@ -68,6 +70,20 @@ func TestEncodeDecode(t *testing.T) {
 			id: 20,
 			ok: true,
 		},
+		{
+			in: PingMessage{
+				Data: 42,
+			},
+			id: 0,
+			ok: false,
+		},
+		{
+			in: PongMessage{
+				Data: 42,
+			},
+			id: 0,
+			ok: false,
+		},
 	}
 	for _, test := range tests {
 		var buf bytes.Buffer
@@ -93,3 +109,105 @@ func TestEncodeDecode(t *testing.T) {
 	}
 
 }
+
+func TestEncodeMatchesBytes(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), inlineDataLength+1)
+	for _, msg := range []Message{
+		OpenMessage{SenderID: 1, WindowSize: 2, MaxPacketSize: 3},
+		OpenConfirmMessage{ChannelID: 1, SenderID: 2, WindowSize: 3, MaxPacketSize: 4},
+		OpenFailureMessage{ChannelID: 1},
+		WindowAdjustMessage{ChannelID: 1, AdditionalBytes: 2},
+		DataMessage{ChannelID: 1, Length: 5, Data: []byte("Hello")},
+		DataMessage{ChannelID: 1, Length: uint32(len(data)), Data: data},
+		EOFMessage{ChannelID: 1},
+		CloseMessage{ChannelID: 1},
+		PingMessage{Data: 1},
+		PongMessage{Data: 1},
+		GoAwayMessage{Code: 1},
+		HelloMessage{Version: 1, Features: 2, MaxFrameSize: 3, Length: 4, Data: []byte("json")},
+	} {
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Encode(msg); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), msg.Bytes()) {
+			t.Fatalf("encoding of %s does not match Bytes", msg)
+		}
+		m, err := NewDecoder(&buf).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(m.Bytes(), msg.Bytes()) {
+			t.Fatalf("decoded %s does not match %s", m, msg)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, msg := range []Message{
+		OpenMessage{SenderID: 1, WindowSize: 2, MaxPacketSize: 3},
+		DataMessage{ChannelID: 1, Length: 5, Data: []byte("Hello")},
+		HelloMessage{Version: 1, Length: 4, Data: []byte("json")},
+		CloseMessage{ChannelID: 1},
+	} {
+		f.Add(msg.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		dec := NewDecoder(bytes.NewReader(b))
+		for {
+			msg, err := dec.Decode()
+			if err != nil {
+				return
+			}
+			if !bytes.HasPrefix(b, msg.Bytes()) {
+				t.Fatalf("decoded %s does not match input", msg)
+			}
+			b = b[len(msg.Bytes()):]
+		}
+	})
+}
+
+func BenchmarkEncodeData(b *testing.B) {
+	for _, size := range []int{64, 32 * 1024} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			enc := NewEncoder(io.Discard)
+			msg := DataMessage{ChannelID: 1, Length: uint32(size), Data: make([]byte, size)}
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := enc.Encode(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEncodeControl(b *testing.B) {
+	enc := NewEncoder(io.Discard)
+	msg := WindowAdjustMessage{ChannelID: 1, AdditionalBytes: 1024}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := enc.Encode(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Encode(WindowAdjustMessage{ChannelID: 1, AdditionalBytes: 1024})
+	enc.Encode(DataMessage{ChannelID: 1, Length: 64, Data: make([]byte, 64)})
+	r := bytes.NewReader(buf.Bytes())
+	dec := NewDecoder(r)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(buf.Bytes())
+		for j := 0; j < 2; j++ {
+			if _, err := dec.Decode(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -8,6 +8,10 @@ const (
 	msgChannelData
 	msgChannelEOF
 	msgChannelClose
+	msgPing
+	msgPong
+	msgGoAway
+	msgHello
 )
 
 type Message interface {
@@ -0,0 +1,28 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// GoAwayMessage tells the remote side to stop opening channels
+// because the session is shutting down.
+type GoAwayMessage struct {
+	Code uint32
+}
+
+func (msg GoAwayMessage) String() string {
+	return fmt.Sprintf("{GoAwayMessage Code:%d}", msg.Code)
+}
+
+func (msg GoAwayMessage) Channel() (uint32, bool) {
+	return 0, false
+}
+
+func (msg GoAwayMessage) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(msgGoAway)
+	binary.Write(buf, binary.BigEndian, msg)
+	return buf.Bytes()
+}
@@ -0,0 +1,36 @@
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// HelloMessage is the first message sent by each side of a session that
+// negotiates the protocol version and features. Data holds the names of
+// the supported codecs separated by commas.
+type HelloMessage struct {
+	Version      uint32
+	Features     uint32
+	MaxFrameSize uint32
+	Length       uint32
+	Data         []byte
+}
+
+func (msg HelloMessage) String() string {
+	return fmt.Sprintf("{HelloMessage Version:%d Features:%#x MaxFrameSize:%d Data:%q}",
+		msg.Version, msg.Features, msg.MaxFrameSize, msg.Data)
+}
+
+func (msg HelloMessage) Channel() (uint32, bool) {
+	return 0, false
+}
+
+func (msg HelloMessage) Bytes() []byte {
+	packet := make([]byte, 17)
+	packet[0] = msgHello
+	binary.BigEndian.PutUint32(packet[1:5], msg.Version)
+	binary.BigEndian.PutUint32(packet[5:9], msg.Features)
+	binary.BigEndian.PutUint32(packet[9:13], msg.MaxFrameSize)
+	binary.BigEndian.PutUint32(packet[13:17], msg.Length)
+	return append(packet, msg.Data...)
+}
@@ -0,0 +1,26 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type PingMessage struct {
+	Data uint32
+}
+
+func (msg PingMessage) String() string {
+	return fmt.Sprintf("{PingMessage Data:%d}", msg.Data)
+}
+
+func (msg PingMessage) Channel() (uint32, bool) {
+	return 0, false
+}
+
+func (msg PingMessage) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(msgPing)
+	binary.Write(buf, binary.BigEndian, msg)
+	return buf.Bytes()
+}
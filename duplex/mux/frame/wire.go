@@ -0,0 +1,129 @@
+package frame
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// maxHeaderLength is the length of the largest message without data,
+// an OpenConfirmMessage or HelloMessage, including the message number.
+const maxHeaderLength = 17
+
+// maxHelloLength is the largest data of a HelloMessage that is decoded.
+const maxHelloLength = 4096
+
+// inlineDataLength is the largest data that is copied into the header
+// buffer to write a DataMessage in a single Write. Larger data is written
+// from the message without copying.
+const inlineDataLength = 4096
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, maxHeaderLength+inlineDataLength)
+		return &b
+	},
+}
+
+// appendHeader appends the encoding of msg to b, except for the data of a
+// DataMessage. It returns false for messages it does not know.
+func appendHeader(b []byte, msg Message) ([]byte, bool) {
+	be := binary.BigEndian
+	switch m := msg.(type) {
+	case DataMessage:
+		return be.AppendUint32(be.AppendUint32(append(b, msgChannelData), m.ChannelID), m.Length), true
+	case *DataMessage:
+		return appendHeader(b, *m)
+	case OpenMessage:
+		b = be.AppendUint32(append(b, msgChannelOpen), m.SenderID)
+		return be.AppendUint32(be.AppendUint32(b, m.WindowSize), m.MaxPacketSize), true
+	case *OpenMessage:
+		return appendHeader(b, *m)
+	case OpenConfirmMessage:
+		b = be.AppendUint32(be.AppendUint32(append(b, msgChannelOpenConfirm), m.ChannelID), m.SenderID)
+		return be.AppendUint32(be.AppendUint32(b, m.WindowSize), m.MaxPacketSize), true
+	case *OpenConfirmMessage:
+		return appendHeader(b, *m)
+	case OpenFailureMessage:
+		return be.AppendUint32(append(b, msgChannelOpenFailure), m.ChannelID), true
+	case *OpenFailureMessage:
+		return appendHeader(b, *m)
+	case WindowAdjustMessage:
+		return be.AppendUint32(be.AppendUint32(append(b, msgChannelWindowAdjust), m.ChannelID), m.AdditionalBytes), true
+	case *WindowAdjustMessage:
+		return appendHeader(b, *m)
+	case EOFMessage:
+		return be.AppendUint32(append(b, msgChannelEOF), m.ChannelID), true
+	case *EOFMessage:
+		return appendHeader(b, *m)
+	case CloseMessage:
+		return be.AppendUint32(append(b, msgChannelClose), m.ChannelID), true
+	case *CloseMessage:
+		return appendHeader(b, *m)
+	case PingMessage:
+		return be.AppendUint32(append(b, msgPing), m.Data), true
+	case *PingMessage:
+		return appendHeader(b, *m)
+	case PongMessage:
+		return be.AppendUint32(append(b, msgPong), m.Data), true
+	case *PongMessage:
+		return appendHeader(b, *m)
+	case GoAwayMessage:
+		return be.AppendUint32(append(b, msgGoAway), m.Code), true
+	case *GoAwayMessage:
+		return appendHeader(b, *m)
+	case HelloMessage:
+		b = be.AppendUint32(be.AppendUint32(append(b, msgHello), m.Version), m.Features)
+		return be.AppendUint32(be.AppendUint32(b, m.MaxFrameSize), m.Length), true
+	case *HelloMessage:
+		return appendHeader(b, *m)
+	default:
+		return b, false
+	}
+}
+
+// headerLength returns the length of the message following its number,
+// excluding the data of a DataMessage or HelloMessage.
+func headerLength(num byte) int {
+	switch num {
+	case msgChannelOpenConfirm, msgHello:
+		return 16
+	case msgChannelOpen:
+		return 12
+	case msgChannelData, msgChannelWindowAdjust:
+		return 8
+	default:
+		return 4
+	}
+}
+
+// decodeHeader sets the fields of msg from b, excluding the data of a DataMessage
+// or HelloMessage.
+func decodeHeader(msg Message, b []byte) {
+	be := binary.BigEndian
+	switch m := msg.(type) {
+	case *DataMessage:
+		m.ChannelID, m.Length = be.Uint32(b), be.Uint32(b[4:])
+	case *OpenMessage:
+		m.SenderID, m.WindowSize, m.MaxPacketSize = be.Uint32(b), be.Uint32(b[4:]), be.Uint32(b[8:])
+	case *OpenConfirmMessage:
+		m.ChannelID, m.SenderID = be.Uint32(b), be.Uint32(b[4:])
+		m.WindowSize, m.MaxPacketSize = be.Uint32(b[8:]), be.Uint32(b[12:])
+	case *OpenFailureMessage:
+		m.ChannelID = be.Uint32(b)
+	case *WindowAdjustMessage:
+		m.ChannelID, m.AdditionalBytes = be.Uint32(b), be.Uint32(b[4:])
+	case *EOFMessage:
+		m.ChannelID = be.Uint32(b)
+	case *CloseMessage:
+		m.ChannelID = be.Uint32(b)
+	case *PingMessage:
+		m.Data = be.Uint32(b)
+	case *PongMessage:
+		m.Data = be.Uint32(b)
+	case *GoAwayMessage:
+		m.Code = be.Uint32(b)
+	case *HelloMessage:
+		m.Version, m.Features = be.Uint32(b), be.Uint32(b[4:])
+		m.MaxFrameSize, m.Length = be.Uint32(b[8:]), be.Uint32(b[12:])
+	}
+}
@@ -0,0 +1,143 @@
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"tractor.dev/toolkit-go/duplex/mux/frame"
+)
+
+// ProtocolVersion is the version of the protocol sent in the handshake.
+// The version used by a session is the lower of both sides' versions.
+const ProtocolVersion = 1
+
+// minProtocolVersion is the lowest version a session can be used with.
+const minProtocolVersion = 1
+
+// ErrHandshake is wrapped by the error returned by Wait if the handshake
+// of a session with the Negotiate option fails.
+var ErrHandshake = errors.New("qmux: handshake failed")
+
+// Feature is a set of optional protocol features sent in the handshake.
+// Flow control is not optional, so it is not a feature.
+type Feature uint32
+
+const (
+	// FeatureKeepAlive means ping frames are answered.
+	FeatureKeepAlive Feature = 1 << iota
+	// FeatureGoAway means go away frames are understood.
+	FeatureGoAway
+)
+
+// supportedFeatures are the features of this implementation.
+const supportedFeatures = FeatureKeepAlive | FeatureGoAway
+
+// Has returns true if all the features in f2 are in f.
+func (f Feature) Has(f2 Feature) bool {
+	return f&f2 == f2
+}
+
+// Negotiated is the result of the handshake of a session.
+type Negotiated struct {
+	// Version is the protocol version used by both sides.
+	Version uint32
+	// Features are the features supported by both sides.
+	Features Feature
+	// MaxFrameSize is the smaller of both sides' maximum frame size set
+	// with the MaxFrameSize option, which channel data frames never exceed.
+	MaxFrameSize uint32
+	// Codecs are the codecs supported by both sides, in the order
+	// they were given to Negotiate on this side.
+	Codecs []string
+}
+
+// Negotiate returns an Option that starts the session with a handshake, where
+// each side sends its protocol version, features, maximum frame size and the
+// names of the codecs it supports in order of preference. The result can be
+// read with NegotiatedOf. Both sides must use this option: a session with it
+// fails with ErrHandshake if the first frame from the remote side is not a
+// handshake, and a session without it fails on receiving one.
+func Negotiate(codecs ...string) Option {
+	return func(c *config) {
+		c.handshake = true
+		c.codecs = codecs
+	}
+}
+
+// NegotiatedOf waits for the handshake of a session created by New with the
+// Negotiate option and returns its result. It returns false for other sessions,
+// or if the session is closed before the handshake completes.
+func NegotiatedOf(sess Session) (Negotiated, bool) {
+	s, ok := sess.(*session)
+	if !ok || !s.config.handshake {
+		return Negotiated{}, false
+	}
+	select {
+	case <-s.negotiatedCh:
+		return s.negotiated, true
+	case <-s.done:
+		return Negotiated{}, false
+	}
+}
+
+// hello returns the handshake frame sent by the session.
+func (s *session) hello() frame.HelloMessage {
+	codecs := strings.Join(s.config.codecs, ",")
+	return frame.HelloMessage{
+		Version:      ProtocolVersion,
+		Features:     uint32(supportedFeatures),
+		MaxFrameSize: s.config.maxFrameSize,
+		Length:       uint32(len(codecs)),
+		Data:         []byte(codecs),
+	}
+}
+
+// handleHello negotiates the session from the handshake frame of the
+// remote side. It is called by the session loop.
+func (s *session) handleHello(msg frame.Message) error {
+	m, ok := msg.(*frame.HelloMessage)
+	if !ok {
+		return fmt.Errorf("%w: expected hello but got %s", ErrHandshake, msg)
+	}
+	if m.Version < minProtocolVersion {
+		return fmt.Errorf("%w: unsupported protocol version %d", ErrHandshake, m.Version)
+	}
+
+	if m.MaxFrameSize < minPacketLength || m.MaxFrameSize > maxPacketLength {
+		return fmt.Errorf("%w: invalid max frame size %d", ErrHandshake, m.MaxFrameSize)
+	}
+
+	n := Negotiated{
+		Version:      min(ProtocolVersion, int(m.Version)),
+		Features:     supportedFeatures & Feature(m.Features),
+		MaxFrameSize: min(m.MaxFrameSize, int(s.config.maxFrameSize)),
+	}
+	remote := strings.Split(string(m.Data), ",")
+	for _, codec := range s.config.codecs {
+		for _, r := range remote {
+			if codec == r {
+				n.Codecs = append(n.Codecs, codec)
+				break
+			}
+		}
+	}
+	s.negotiated = n
+	close(s.negotiatedCh)
+	return nil
+}
+
+// remoteSupports returns true if the remote side supports the features,
+// waiting for the handshake if there is one. Without a handshake, the
+// remote side is assumed to support all features.
+func (s *session) remoteSupports(f Feature) bool {
+	if !s.config.handshake {
+		return true
+	}
+	select {
+	case <-s.negotiatedCh:
+		return s.negotiated.Features.Has(f)
+	case <-s.done:
+		return false
+	}
+}
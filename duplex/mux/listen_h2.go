@@ -0,0 +1,408 @@
+//go:build !tinygo
+
+package mux
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// h2IdleTimeout is how long the server side of an HTTP/2 session waits
+// without open channels before it is closed, in case the client went away
+// without closing the session. It is a var so it can be changed in tests.
+var h2IdleTimeout = 30 * time.Second
+
+// H2Listener is an http.Handler that serves sessions dialed with DialH2, with
+// each channel being an HTTP/2 stream. It can be mounted on any server that
+// supports HTTP/2, and its Accept method returns a session for each client.
+type H2Listener struct {
+	mu       sync.Mutex
+	sessions map[string]*h2ServerSession
+
+	accepted  chan Session
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// set by ListenH2
+	ln net.Listener
+}
+
+// NewH2Listener returns an H2Listener to be mounted on an HTTP server.
+func NewH2Listener() *H2Listener {
+	return &H2Listener{
+		sessions: make(map[string]*h2ServerSession),
+		accepted: make(chan Session),
+		closed:   make(chan struct{}),
+	}
+}
+
+// ListenH2 takes a TCP address and returns a Listener for a cleartext HTTP/2
+// server listening on the given address. Use an H2Listener with an HTTPS
+// server to serve sessions over TLS.
+func ListenH2(addr string) (Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	hl := NewH2Listener()
+	hl.ln = l
+	srv := &http.Server{
+		Handler: h2c.NewHandler(hl, &http2.Server{}),
+	}
+	go srv.Serve(l)
+	return hl, nil
+}
+
+// Accept waits for and returns the next session dialed by a client.
+func (l *H2Listener) Accept() (Session, error) {
+	select {
+	case sess := <-l.accepted:
+		return sess, nil
+	case <-l.closed:
+		return nil, io.EOF
+	}
+}
+
+// Close stops accepting sessions and closes the sessions of the listener.
+func (l *H2Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	l.mu.Lock()
+	sessions := make([]*h2ServerSession, 0, len(l.sessions))
+	for _, sess := range l.sessions {
+		sessions = append(sessions, sess)
+	}
+	l.mu.Unlock()
+	for _, sess := range sessions {
+		sess.Close()
+	}
+	if l.ln != nil {
+		return l.ln.Close()
+	}
+	return nil
+}
+
+// Addr returns the address of the listener created by ListenH2,
+// or nil if the listener is mounted on another server.
+func (l *H2Listener) Addr() net.Addr {
+	if l.ln == nil {
+		return nil
+	}
+	return l.ln.Addr()
+}
+
+// ServeHTTP serves a channel of a session, or closes a session when
+// its client closes it.
+func (l *H2Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(h2SessionHeader)
+	if r.ProtoMajor != 2 || id == "" {
+		http.Error(w, "qmux: http/2 session required", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		l.mu.Lock()
+		sess := l.sessions[id]
+		l.mu.Unlock()
+		if sess != nil {
+			sess.Close()
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, "qmux: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sess, ok := l.session(r.Context(), id)
+	if !ok {
+		http.Error(w, "qmux: listener closed", http.StatusServiceUnavailable)
+		return
+	}
+	if sess.draining.Load() {
+		http.Error(w, "qmux: session is going away", http.StatusServiceUnavailable)
+		return
+	}
+
+	ch := sess.newChannel(w, r)
+	defer ch.finish()
+	w.WriteHeader(http.StatusOK)
+	if err := ch.rc.Flush(); err != nil {
+		return
+	}
+	select {
+	case sess.inbox <- ch:
+	case <-sess.done:
+		return
+	case <-r.Context().Done():
+		return
+	}
+	select {
+	case <-ch.done:
+	case <-sess.done:
+	case <-r.Context().Done():
+	}
+	// wait for a write in progress, since the response
+	// can't be written to once the handler returns
+	ch.finish()
+	ch.wmu.Lock()
+	ch.wmu.Unlock()
+}
+
+// session returns the session with id, creating it and waiting for it to be
+// accepted if it is new. It returns false if the listener is closed first.
+func (l *H2Listener) session(ctx context.Context, id string) (*h2ServerSession, bool) {
+	l.mu.Lock()
+	sess, ok := l.sessions[id]
+	if ok {
+		l.mu.Unlock()
+		return sess, true
+	}
+	sess = &h2ServerSession{
+		l:     l,
+		id:    id,
+		inbox: make(chan Channel),
+		done:  make(chan struct{}),
+	}
+	l.sessions[id] = sess
+	l.mu.Unlock()
+
+	select {
+	case l.accepted <- sess:
+		return sess, true
+	case <-l.closed:
+	case <-ctx.Done():
+	}
+	sess.Close()
+	return nil, false
+}
+
+type h2ServerSession struct {
+	l  *H2Listener
+	id string
+
+	inbox     chan Channel
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	open     int
+	idle     *time.Timer
+	nextID   atomic.Uint32
+	draining atomic.Bool
+}
+
+func (s *h2ServerSession) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.l.mu.Lock()
+		if s.l.sessions[s.id] == s {
+			delete(s.l.sessions, s.id)
+		}
+		s.l.mu.Unlock()
+	})
+	return nil
+}
+
+func (s *h2ServerSession) Accept() (Channel, error) {
+	return s.AcceptContext(context.Background())
+}
+
+func (s *h2ServerSession) AcceptContext(ctx context.Context) (Channel, error) {
+	select {
+	case ch := <-s.inbox:
+		return ch, nil
+	case <-s.done:
+		return nil, io.EOF
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Open returns an error since only the client can open streams.
+func (s *h2ServerSession) Open(ctx context.Context) (Channel, error) {
+	return nil, errH2Open
+}
+
+// Drain refuses new channels and waits for open channels to be closed
+// before closing the session.
+func (s *h2ServerSession) Drain(ctx context.Context) error {
+	s.draining.Store(true)
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		open := s.open
+		s.mu.Unlock()
+		if open == 0 {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return nil
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		}
+	}
+	return s.Close()
+}
+
+func (s *h2ServerSession) Wait() error {
+	<-s.done
+	return io.EOF
+}
+
+func (s *h2ServerSession) newChannel(w http.ResponseWriter, r *http.Request) *h2ServerChannel {
+	s.mu.Lock()
+	s.open++
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	s.mu.Unlock()
+	return &h2ServerChannel{
+		id:   s.nextID.Add(1),
+		sess: s,
+		w:    w,
+		rc:   http.NewResponseController(w),
+		body: r.Body,
+		done: make(chan struct{}),
+	}
+}
+
+// channelDone closes the session once it has had no open channels
+// for h2IdleTimeout.
+func (s *h2ServerSession) channelDone() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open--
+	if s.open == 0 {
+		s.idle = time.AfterFunc(h2IdleTimeout, func() {
+			s.Close()
+		})
+	}
+}
+
+// h2ServerChannel reads from the request body and writes to the response
+// of a stream. The stream ends when the channel is closed, or once both
+// CloseWrite is called and the request body is read to the end, since the
+// response can't be ended while the request body is still being read.
+type h2ServerChannel struct {
+	id   uint32
+	sess *h2ServerSession
+	w    http.ResponseWriter
+	rc   *http.ResponseController
+	body io.ReadCloser
+
+	// wmu serializes writes with the end of the handler
+	wmu sync.Mutex
+
+	mu       sync.Mutex
+	readEOF  bool
+	wroteEOF bool
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func (c *h2ServerChannel) ID() uint32 {
+	return c.id
+}
+
+func (c *h2ServerChannel) Read(p []byte) (int, error) {
+	n, err := c.body.Read(p)
+	if err == io.EOF {
+		c.mu.Lock()
+		c.readEOF = true
+		if c.wroteEOF {
+			c.finish()
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *h2ServerChannel) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.mu.Lock()
+	wroteEOF := c.wroteEOF
+	c.mu.Unlock()
+	if wroteEOF || isDone(c.done) {
+		return 0, io.EOF
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.rc.Flush()
+}
+
+// CloseWrite signals the end of sending data. The stream ends once
+// the request body has also been read to the end.
+func (c *h2ServerChannel) CloseWrite() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wroteEOF = true
+	if c.readEOF {
+		c.finish()
+	}
+	return nil
+}
+
+// Close ends the stream.
+func (c *h2ServerChannel) Close() error {
+	c.finish()
+	return nil
+}
+
+func (c *h2ServerChannel) finish() {
+	c.doneOnce.Do(func() {
+		close(c.done)
+		c.sess.channelDone()
+	})
+}
+
+func (c *h2ServerChannel) LocalAddr() net.Addr {
+	return Addr{ID: c.id}
+}
+
+func (c *h2ServerChannel) RemoteAddr() net.Addr {
+	return Addr{ID: c.id}
+}
+
+func (c *h2ServerChannel) SetDeadline(t time.Time) error {
+	if err := c.rc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.rc.SetWriteDeadline(t)
+}
+
+func (c *h2ServerChannel) SetReadDeadline(t time.Time) error {
+	return c.rc.SetReadDeadline(t)
+}
+
+func (c *h2ServerChannel) SetWriteDeadline(t time.Time) error {
+	return c.rc.SetWriteDeadline(t)
+}
+
+func isDone(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
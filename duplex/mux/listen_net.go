@@ -1,7 +1,12 @@
 package mux
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"net"
+	"os"
+	"time"
 )
 
 // netListener wraps a net.Listener to return connected mux sessions.
@@ -41,11 +46,51 @@ func ListenTCP(addr string) (Listener, error) {
 	return ListenerFrom(l), nil
 }
 
-// ListenTCP creates a Unix domain socket listener at the given path.
+// ListenUnix creates a Unix domain socket listener at the given path. A socket
+// file left at the path by a process that exited without closing its listener
+// is removed first, but an error is returned if another listener is using it.
+// The socket file is removed when the listener is closed.
 func ListenUnix(path string) (Listener, error) {
+	return ListenUnixMode(path, 0)
+}
+
+// ListenUnixMode is like ListenUnix but sets the permissions of the socket file
+// to mode, such as 0600 to only accept connections from processes of the same
+// user. A zero mode keeps the permissions given by the umask.
+func ListenUnixMode(path string, mode os.FileMode) (Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
 	l, err := net.Listen("unix", path)
 	if err != nil {
 		return nil, err
 	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
 	return ListenerFrom(l), nil
 }
+
+// removeStaleSocket removes the socket file at path if nothing is
+// listening on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("qmux: %s exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("qmux: socket %s is already in use", path)
+	}
+	return os.Remove(path)
+}
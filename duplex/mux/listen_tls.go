@@ -0,0 +1,74 @@
+package mux
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+)
+
+// tlsHandshakeTimeout limits how long Accept of a TLS listener waits
+// for a client to complete the handshake.
+var tlsHandshakeTimeout = 10 * time.Second
+
+// tlsListener wraps a TLS net.Listener to return connected mux sessions
+// once the handshake completes.
+type tlsListener struct {
+	net.Listener
+}
+
+// Accept waits for and returns the next connected session to the listener.
+// Connections that fail the handshake are closed and skipped.
+func (l *tlsListener) Accept() (Session, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		tc := conn.(*tls.Conn)
+		tc.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tc.Handshake(); err != nil {
+			tc.Close()
+			continue
+		}
+		tc.SetDeadline(time.Time{})
+		return New(tc), nil
+	}
+}
+
+// Close closes the listener.
+// Any blocked Accept operations will be unblocked and return errors.
+func (l *tlsListener) Close() error {
+	return l.Listener.Close()
+}
+
+func (l *tlsListener) Addr() net.Addr {
+	return l.Listener.Addr()
+}
+
+// ListenTLS creates a TLS listener at the given address. The config must have
+// a certificate. For mutual TLS, set ClientAuth and ClientCAs or use
+// ServerTLSConfig, and read the verified client certificate of a session
+// with TLSConnectionState.
+func ListenTLS(addr string, config *tls.Config) (Listener, error) {
+	l, err := tls.Listen("tcp", addr, withALPN(config))
+	if err != nil {
+		return nil, err
+	}
+	return &tlsListener{Listener: l}, nil
+}
+
+// ServerTLSConfig returns a tls.Config for ListenTLS using cert. If clientCAs
+// is not nil, clients must present a certificate signed by one of them, which
+// is mutual TLS.
+func ServerTLSConfig(cert tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{ALPNProtocol},
+	}
+	if clientCAs != nil {
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config
+}
@@ -3,13 +3,106 @@
 package mux
 
 import (
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"golang.org/x/net/websocket"
 )
 
+// WSHandler is an http.Handler that upgrades requests to WebSocket connections
+// and calls Handler with a mux session over each, so sessions can be served from
+// an existing HTTP server. The session is closed when Handler returns.
+type WSHandler struct {
+	// Handler is called with the session of each connection.
+	Handler func(sess Session)
+
+	// Origins are the allowed values of the Origin header sent by browsers,
+	// such as "https://example.com". If empty, only origins with the same host
+	// as the request are allowed. "*" allows any origin. Requests without an
+	// Origin header, which are not made by browsers, are always allowed.
+	Origins []string
+
+	// Subprotocols are the WebSocket subprotocols supported by the handler in
+	// order of preference. If set, clients must request one of them, and the
+	// one chosen can be read with WSSubprotocol.
+	Subprotocols []string
+
+	// Options are used to create the sessions.
+	Options []Option
+}
+
+func (h *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	websocket.Server{
+		Handshake: h.handshake,
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			sess := New(ws, h.Options...)
+			defer sess.Close()
+			h.Handler(sess)
+		},
+	}.ServeHTTP(w, r)
+}
+
+func (h *WSHandler) handshake(config *websocket.Config, r *http.Request) error {
+	if err := h.checkOrigin(r); err != nil {
+		return err
+	}
+	if len(h.Subprotocols) == 0 {
+		config.Protocol = nil
+		return nil
+	}
+	for _, p := range h.Subprotocols {
+		for _, offered := range config.Protocol {
+			if p == offered {
+				config.Protocol = []string{p}
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("qmux: no supported subprotocol in %v", config.Protocol)
+}
+
+func (h *WSHandler) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if len(h.Origins) == 0 {
+		u, err := url.Parse(origin)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(u.Host, r.Host) {
+			return nil
+		}
+		return fmt.Errorf("qmux: origin %q not allowed", origin)
+	}
+	for _, o := range h.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("qmux: origin %q not allowed", origin)
+}
+
+// WSSubprotocol returns the WebSocket subprotocol chosen for a session
+// served by a WSHandler or dialed with a subprotocol, or an empty string.
+func WSSubprotocol(sess Session) string {
+	s, ok := sess.(*session)
+	if !ok {
+		return ""
+	}
+	ws, ok := s.t.(*websocket.Conn)
+	if !ok || len(ws.Config().Protocol) == 0 {
+		return ""
+	}
+	return ws.Config().Protocol[0]
+}
+
 // wsListener wraps a net.Listener and WebSocket server to return connected mux sessions.
 type wsListener struct {
 	net.Listener
@@ -48,13 +141,13 @@ func ListenWS(addr string) (Listener, error) {
 	}
 	srv := &http.Server{
 		Addr: addr,
-		Handler: websocket.Handler(func(ws *websocket.Conn) {
-			ws.PayloadType = websocket.BinaryFrame
-			sess := New(ws)
-			defer sess.Close()
-			wsl.accepted <- sess
-			sess.Wait()
-		}),
+		Handler: &WSHandler{
+			Handler: func(sess Session) {
+				wsl.accepted <- sess
+				sess.Wait()
+			},
+			Origins: []string{"*"},
+		},
 	}
 	go srv.Serve(l)
 	return wsl, nil
@@ -0,0 +1,185 @@
+package muxtest
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+// Fault is a fault injected into a write of a FaultConn.
+type Fault int
+
+const (
+	// FaultNone writes the data unchanged.
+	FaultNone Fault = iota
+	// FaultCorrupt flips the bits of a random byte of the data.
+	FaultCorrupt
+	// FaultDelay waits a random time up to MaxDelay before writing.
+	FaultDelay
+	// FaultTruncate writes a random prefix of the data.
+	FaultTruncate
+	// FaultDrop discards the data.
+	FaultDrop
+)
+
+func (f Fault) String() string {
+	switch f {
+	case FaultNone:
+		return "none"
+	case FaultCorrupt:
+		return "corrupt"
+	case FaultDelay:
+		return "delay"
+	case FaultTruncate:
+		return "truncate"
+	case FaultDrop:
+		return "drop"
+	default:
+		return fmt.Sprintf("Fault(%d)", int(f))
+	}
+}
+
+// Faults are the chances of injecting each fault into a write of a
+// FaultConn. Since sessions write each frame with a single write, faults
+// apply to whole frames. The zero value injects no faults.
+type Faults struct {
+	// Corrupt, Delay, Truncate and Drop are the chances between 0 and 1
+	// of each fault. At most one fault is injected into each write.
+	Corrupt  float64
+	Delay    float64
+	Truncate float64
+	Drop     float64
+
+	// MaxDelay is the longest delay of FaultDelay.
+	MaxDelay time.Duration
+
+	// Seed seeds the choice of faults so runs can be repeated.
+	Seed int64
+}
+
+// InjectedFault is a fault injected into a write of a FaultConn.
+type InjectedFault struct {
+	// Write is the index of the write, starting at zero.
+	Write int
+	Fault Fault
+}
+
+// FaultConn wraps a connection, injecting faults into its writes.
+// Reads and Close are passed through unchanged.
+type FaultConn struct {
+	io.ReadWriteCloser
+
+	faults Faults
+
+	mu     sync.Mutex
+	rand   *rand.Rand
+	writes int
+	next   []Fault
+	log    []InjectedFault
+}
+
+// InjectFaults returns a FaultConn injecting faults into the writes of rwc.
+func InjectFaults(rwc io.ReadWriteCloser, faults Faults) *FaultConn {
+	return &FaultConn{
+		ReadWriteCloser: rwc,
+		faults:          faults,
+		rand:            rand.New(rand.NewSource(faults.Seed)),
+	}
+}
+
+// FaultPair returns two sessions connected over a simulated link, with
+// faults injected into the writes of the first.
+func FaultPair(link Link, faults Faults, opts ...mux.Option) (a, b mux.Session, fc *FaultConn) {
+	ca, cb := NewConn(link)
+	fc = InjectFaults(ca, faults)
+	return mux.New(fc, opts...), mux.New(cb, opts...), fc
+}
+
+// Next injects f into the next write instead of a random fault. Calls are
+// queued, so faults are injected into consecutive writes in order.
+func (c *FaultConn) Next(f Fault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next = append(c.next, f)
+}
+
+// Injected returns the faults injected so far, in order of the writes.
+func (c *FaultConn) Injected() []InjectedFault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]InjectedFault(nil), c.log...)
+}
+
+func (c *FaultConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	f := c.fault()
+	var (
+		delay time.Duration
+		pos   int
+	)
+	switch f {
+	case FaultDelay:
+		if c.faults.MaxDelay > 0 {
+			delay = time.Duration(c.rand.Int63n(int64(c.faults.MaxDelay)))
+		}
+	case FaultCorrupt, FaultTruncate:
+		if len(b) > 0 {
+			pos = c.rand.Intn(len(b))
+		}
+	}
+	if f != FaultNone {
+		c.log = append(c.log, InjectedFault{Write: c.writes, Fault: f})
+	}
+	c.writes++
+	c.mu.Unlock()
+
+	switch f {
+	case FaultDelay:
+		time.Sleep(delay)
+	case FaultCorrupt:
+		if len(b) > 0 {
+			data := make([]byte, len(b))
+			copy(data, b)
+			data[pos] ^= 0xff
+			return c.ReadWriteCloser.Write(data)
+		}
+	case FaultTruncate:
+		if _, err := c.ReadWriteCloser.Write(b[:pos]); err != nil {
+			return 0, err
+		}
+		// the caller is told the whole write succeeded
+		return len(b), nil
+	case FaultDrop:
+		return len(b), nil
+	}
+	return c.ReadWriteCloser.Write(b)
+}
+
+// fault chooses the fault of the next write. It is called with mu held.
+func (c *FaultConn) fault() Fault {
+	if len(c.next) > 0 {
+		f := c.next[0]
+		c.next = c.next[1:]
+		return f
+	}
+	r := c.rand.Float64()
+	for _, p := range []struct {
+		chance float64
+		fault  Fault
+	}{
+		{c.faults.Corrupt, FaultCorrupt},
+		{c.faults.Delay, FaultDelay},
+		{c.faults.Truncate, FaultTruncate},
+		{c.faults.Drop, FaultDrop},
+	} {
+		if r < p.chance {
+			return p.fault
+		}
+		r -= p.chance
+	}
+	return FaultNone
+}
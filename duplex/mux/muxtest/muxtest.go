@@ -0,0 +1,369 @@
+// Package muxtest provides an in-memory transport for testing mux sessions
+// under simulated network conditions.
+package muxtest
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+// ErrDisconnected is returned by reads and writes of a Conn after its link
+// is disconnected.
+var ErrDisconnected = errors.New("muxtest: link disconnected")
+
+// Link describes the simulated network between the two ends of a Conn.
+// The zero value is a link without delay that never disconnects.
+type Link struct {
+	// Latency is the time it takes written data to reach the other end.
+	Latency time.Duration
+
+	// Jitter is the most random delay added to Latency. Data is still
+	// delivered in the order it was written.
+	Jitter time.Duration
+
+	// Bandwidth is the number of bytes per second that can be sent in
+	// each direction, or unlimited if zero. Writes block while the link
+	// is busy sending earlier writes.
+	Bandwidth int
+
+	// MeanUptime is the average time after which the link disconnects at
+	// random, or never if zero.
+	MeanUptime time.Duration
+
+	// Seed seeds the random jitter and disconnects so runs can be repeated.
+	Seed int64
+}
+
+// Pair returns two sessions connected over a simulated link.
+func Pair(link Link, opts ...mux.Option) (a, b mux.Session) {
+	ca, cb := NewConn(link)
+	return mux.New(ca, opts...), mux.New(cb, opts...)
+}
+
+// NewConn returns both ends of a simulated link.
+func NewConn(link Link) (a, b *Conn) {
+	l := &linkState{
+		Link: link,
+		rand: rand.New(rand.NewSource(link.Seed)),
+	}
+	ab, ba := newPipe(l), newPipe(l)
+	a = &Conn{link: l, r: ba, w: ab, addr: addr("a")}
+	b = &Conn{link: l, r: ab, w: ba, addr: addr("b")}
+	l.pipes = []*pipe{ab, ba}
+	if link.MeanUptime > 0 {
+		uptime := l.uptime()
+		l.mu.Lock()
+		l.timer = time.AfterFunc(uptime, l.disconnect)
+		l.mu.Unlock()
+	}
+	return a, b
+}
+
+// linkState is shared by both ends of a link.
+type linkState struct {
+	Link
+
+	mu    sync.Mutex
+	rand  *rand.Rand
+	pipes []*pipe
+	timer *time.Timer
+}
+
+func (l *linkState) uptime() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Duration(l.rand.ExpFloat64() * float64(l.MeanUptime))
+}
+
+func (l *linkState) jitter() time.Duration {
+	if l.Jitter <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Duration(l.rand.Int63n(int64(l.Jitter)))
+}
+
+func (l *linkState) disconnect() {
+	l.mu.Lock()
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.mu.Unlock()
+	for _, p := range l.pipes {
+		p.close(ErrDisconnected)
+	}
+}
+
+// Conn is one end of a simulated link. It implements net.Conn.
+type Conn struct {
+	link *linkState
+	r, w *pipe
+	addr addr
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.r.read(b)
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	return c.w.write(b)
+}
+
+// Close closes both directions of the link. The other end reads
+// io.EOF once it has read the data already sent.
+func (c *Conn) Close() error {
+	c.r.close(io.EOF)
+	c.w.close(io.EOF)
+	return nil
+}
+
+// Disconnect drops the link, failing reads and writes on both ends with
+// ErrDisconnected and discarding data not yet delivered.
+func (c *Conn) Disconnect() {
+	c.link.disconnect()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.addr == "a" {
+		return addr("b")
+	}
+	return addr("a")
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.r.setDeadline(t)
+	c.w.setDeadline(t)
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.r.setDeadline(t)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.w.setDeadline(t)
+	return nil
+}
+
+type addr string
+
+func (a addr) Network() string {
+	return "muxtest"
+}
+
+func (a addr) String() string {
+	return string(a)
+}
+
+type packet struct {
+	data []byte
+	at   time.Time
+}
+
+// pipe is one direction of a link, delivering written data to the
+// reader after the delay of the link.
+type pipe struct {
+	link *linkState
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []packet
+	buf      []byte
+	err      error
+	free     time.Time // when the link is done sending earlier writes
+	last     time.Time // when the last packet is delivered
+	deadline time.Time
+}
+
+func newPipe(l *linkState) *pipe {
+	p := &pipe{link: l}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// waitUntil waits on the cond until t, or until woken earlier.
+func (p *pipe) waitUntil(t time.Time) {
+	if !p.deadline.IsZero() && p.deadline.Before(t) {
+		t = p.deadline
+	}
+	timer := time.AfterFunc(time.Until(t), func() {
+		// taking the lock makes sure the waiter is waiting
+		p.mu.Lock()
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	})
+	p.cond.Wait()
+	timer.Stop()
+}
+
+func (p *pipe) expired() bool {
+	return !p.deadline.IsZero() && !time.Now().Before(p.deadline)
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if p.err == ErrDisconnected {
+			return 0, p.err
+		}
+		if len(p.buf) > 0 {
+			n := copy(b, p.buf)
+			p.buf = p.buf[n:]
+			return n, nil
+		}
+		if p.expired() {
+			return 0, os.ErrDeadlineExceeded
+		}
+		if len(p.queue) > 0 {
+			if !time.Now().Before(p.queue[0].at) {
+				p.buf = p.queue[0].data
+				p.queue = p.queue[1:]
+				continue
+			}
+			p.waitUntil(p.queue[0].at)
+			continue
+		}
+		if p.err != nil {
+			return 0, p.err
+		}
+		if p.deadline.IsZero() {
+			p.cond.Wait()
+		} else {
+			p.waitUntil(p.deadline)
+		}
+	}
+}
+
+func (p *pipe) write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		if p.err == ErrDisconnected {
+			return 0, p.err
+		}
+		return 0, io.ErrClosedPipe
+	}
+	if p.expired() {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	now := time.Now()
+	if p.free.Before(now) {
+		p.free = now
+	}
+	if bw := p.link.Bandwidth; bw > 0 {
+		p.free = p.free.Add(time.Duration(len(b)) * time.Second / time.Duration(bw))
+	}
+	at := p.free.Add(p.link.Latency + p.link.jitter())
+	if at.Before(p.last) {
+		at = p.last
+	}
+	p.last = at
+	data := make([]byte, len(b))
+	copy(data, b)
+	p.queue = append(p.queue, packet{data: data, at: at})
+	p.cond.Broadcast()
+
+	// block while the link is busy sending this write
+	for time.Now().Before(p.free) && p.err == nil && !p.expired() {
+		p.waitUntil(p.free)
+	}
+	if p.err == ErrDisconnected {
+		return 0, p.err
+	}
+	return len(b), nil
+}
+
+func (p *pipe) close(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == ErrDisconnected {
+		return
+	}
+	p.err = err
+	if err == ErrDisconnected {
+		p.queue = nil
+		p.buf = nil
+	}
+	p.cond.Broadcast()
+}
+
+func (p *pipe) setDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deadline = t
+	p.cond.Broadcast()
+}
+
+// Listener is a net.Listener whose connections are simulated links dialed
+// with Dial, such as for testing mux.ListenResumable and mux.DialResumable.
+type Listener struct {
+	link   Link
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+
+	mu    sync.Mutex
+	dials int64
+}
+
+// Listen returns a Listener whose connections use link. Each connection
+// uses a different seed derived from the seed of link.
+func Listen(link Link) *Listener {
+	return &Listener{
+		link:   link,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// Dial connects to the listener, waiting for it to accept the connection.
+func (l *Listener) Dial() (*Conn, error) {
+	l.mu.Lock()
+	link := l.link
+	link.Seed += l.dials
+	l.dials++
+	l.mu.Unlock()
+
+	a, b := NewConn(link)
+	select {
+	case l.conns <- b:
+		return a, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return addr("listener")
+}
@@ -0,0 +1,167 @@
+package muxtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+func fatal(err error, t *testing.T) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLink(t *testing.T) {
+	a, b := NewConn(Link{Latency: 20 * time.Millisecond, Jitter: 5 * time.Millisecond, Bandwidth: 100 << 10})
+	defer a.Close()
+
+	start := time.Now()
+	go a.Write(make([]byte, 10<<10))
+	_, err := io.ReadFull(b, make([]byte, 10<<10))
+	fatal(err, t)
+	// 10KB at 100KB/s takes 100ms, plus the latency
+	if d := time.Since(start); d < 120*time.Millisecond {
+		t.Fatalf("data arrived too soon: %v", d)
+	}
+
+	a.Disconnect()
+	if _, err := b.Read(make([]byte, 1)); !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("expected disconnect: %v", err)
+	}
+}
+
+func TestPair(t *testing.T) {
+	a, b := Pair(Link{Latency: time.Millisecond}, mux.MaxPendingAccepts(1))
+	defer a.Close()
+	defer b.Close()
+
+	ch, err := a.Open(context.Background())
+	fatal(err, t)
+	_, err = ch.Write([]byte("Hello world"))
+	fatal(err, t)
+	chB, err := b.Accept()
+	fatal(err, t)
+	buf := make([]byte, 11)
+	_, err = io.ReadFull(chB, buf)
+	fatal(err, t)
+	if string(buf) != "Hello world" {
+		t.Fatalf("unexpected data: %q", buf)
+	}
+}
+
+func TestResumableDisconnects(t *testing.T) {
+	l := Listen(Link{Latency: time.Millisecond, MeanUptime: 50 * time.Millisecond, Seed: 1})
+	defer l.Close()
+	ml := mux.ListenResumable(l)
+
+	sessA, err := mux.DialResumable(func() (io.ReadWriteCloser, error) {
+		return l.Dial()
+	})
+	fatal(err, t)
+	defer sessA.Close()
+	sessB, err := ml.Accept()
+	fatal(err, t)
+	defer sessB.Close()
+
+	go func() {
+		ch, err := sessB.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(ch, ch)
+		ch.CloseWrite()
+	}()
+
+	ch, err := sessA.Open(context.Background())
+	fatal(err, t)
+	data := make([]byte, 64<<10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	go func() {
+		for i := 0; i < len(data); i += 1024 {
+			ch.Write(data[i : i+1024])
+			time.Sleep(time.Millisecond)
+		}
+		ch.CloseWrite()
+	}()
+	b, err := io.ReadAll(ch)
+	fatal(err, t)
+	if len(b) != len(data) {
+		t.Fatalf("unexpected length: %d", len(b))
+	}
+	for i := range b {
+		if b[i] != data[i] {
+			t.Fatalf("data differs at %d", i)
+		}
+	}
+}
+
+func TestFaults(t *testing.T) {
+	faults := Faults{Corrupt: 0.2, Truncate: 0.2, Drop: 0.2, Delay: 0.2, MaxDelay: time.Millisecond, Seed: 7}
+	run := func() ([]InjectedFault, []byte) {
+		a, b := NewConn(Link{})
+		defer a.Close()
+		fc := InjectFaults(a, faults)
+		fc.Next(FaultDrop)
+		go func() {
+			for i := 0; i < 20; i++ {
+				fc.Write([]byte("0123456789"))
+			}
+			fc.Close()
+		}()
+		data, err := io.ReadAll(b)
+		fatal(err, t)
+		return fc.Injected(), data
+	}
+
+	log1, data1 := run()
+	log2, data2 := run()
+	if len(log1) == 0 || log1[0] != (InjectedFault{Write: 0, Fault: FaultDrop}) {
+		t.Fatalf("expected forced drop first: %v", log1)
+	}
+	if fmt.Sprint(log1) != fmt.Sprint(log2) || string(data1) != string(data2) {
+		t.Fatal("expected the same faults for the same seed")
+	}
+	if len(data1) >= 200 {
+		t.Fatalf("expected data to be lost: %d bytes", len(data1))
+	}
+}
+
+func TestFaultPair(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		a, b, _ := FaultPair(Link{}, Faults{Corrupt: 0.1, Truncate: 0.1, Drop: 0.1, Seed: seed})
+
+		// the sessions must fail or time out rather than panic
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		go func() {
+			for {
+				ch, err := b.AcceptContext(ctx)
+				if err != nil {
+					return
+				}
+				go io.Copy(ch, ch)
+			}
+		}()
+		for i := 0; i < 10; i++ {
+			ch, err := a.Open(ctx)
+			if err != nil {
+				break
+			}
+			ch.SetDeadline(time.Now().Add(10 * time.Millisecond))
+			ch.Write([]byte("Hello world"))
+			ch.Read(make([]byte, 11))
+			ch.Close()
+		}
+		cancel()
+		a.Close()
+		b.Close()
+	}
+}
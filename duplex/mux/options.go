@@ -0,0 +1,134 @@
+package mux
+
+import "time"
+
+// Option configures a session created with New.
+type Option func(*config)
+
+type config struct {
+	windowSize        uint32
+	maxFrameSize      uint32
+	keepAliveInterval time.Duration
+	keepAliveTimeout  time.Duration
+	maxChannels       int
+	maxPendingAccepts int
+	maxBufferedBytes  int64
+	trace             func(TraceEvent)
+	resumeTimeout     time.Duration
+	handshake         bool
+	codecs            []string
+	hardened          bool
+}
+
+func newConfig(opts []Option) config {
+	c := config{
+		windowSize:    channelWindowSize,
+		maxFrameSize:  channelMaxPacket,
+		resumeTimeout: 30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.hardened && c.maxPendingAccepts == 0 {
+		c.maxPendingAccepts = hardenedPendingAccepts
+	}
+	return c
+}
+
+// hardenedPendingAccepts is the number of channels queued for Accept by
+// a hardened session unless set with MaxPendingAccepts.
+const hardenedPendingAccepts = 64
+
+// WindowSize returns an Option that sets the flow control window of channels in
+// the session, which is the number of bytes the remote side can send on a channel
+// before it has to wait for them to be read. Smaller windows bound the memory used
+// by a channel with a slow reader at the cost of throughput. Sizes smaller than
+// the minimum packet length are ignored.
+func WindowSize(n uint32) Option {
+	return func(c *config) {
+		if n >= minPacketLength {
+			c.windowSize = n
+		}
+	}
+}
+
+// MaxFrameSize returns an Option that sets the maximum size of channel data in a
+// frame. The size is advertised to the remote side when a channel is opened, and
+// each side fragments writes into frames no larger than the smaller of its own and
+// the remote side's maximum. Smaller frames keep a large write from holding up the
+// frames of other channels. Sizes outside the range allowed by the protocol are
+// ignored.
+func MaxFrameSize(n uint32) Option {
+	return func(c *config) {
+		if n >= minPacketLength && n <= maxPacketLength {
+			c.maxFrameSize = n
+		}
+	}
+}
+
+// KeepAlive returns an Option that pings the remote side every interval and
+// closes the session if a ping is not answered within timeout, so a dead peer
+// is detected promptly. Wait then returns ErrKeepAliveTimeout and pending
+// Accept, Open and Read calls fail. If timeout is zero, interval is used.
+// Keepalive is disabled by default and requires the remote side to support
+// ping frames, which is checked when the session uses Negotiate.
+func KeepAlive(interval, timeout time.Duration) Option {
+	return func(c *config) {
+		if timeout <= 0 {
+			timeout = interval
+		}
+		c.keepAliveInterval = interval
+		c.keepAliveTimeout = timeout
+	}
+}
+
+// MaxChannels returns an Option that limits the number of channels open at once
+// in the session, counting channels opened by either side. Open returns an error
+// wrapping ErrLimitExceeded when the limit is reached, and channels opened by the
+// remote side are refused.
+func MaxChannels(n int) Option {
+	return func(c *config) {
+		c.maxChannels = n
+	}
+}
+
+// MaxPendingAccepts returns an Option that queues up to n channels opened by
+// the remote side until they are accepted. Further channels are refused right
+// away. Without this option, a channel opened by the remote side waits for
+// Accept, blocking the session until it is accepted or times out.
+func MaxPendingAccepts(n int) Option {
+	return func(c *config) {
+		c.maxPendingAccepts = n
+	}
+}
+
+// MaxBufferedBytes returns an Option that limits the number of bytes received
+// but not yet read across all channels in the session. The session is closed
+// with an error wrapping ErrLimitExceeded if the remote side exceeds it.
+func MaxBufferedBytes(n int64) Option {
+	return func(c *config) {
+		c.maxBufferedBytes = n
+	}
+}
+
+// ResumeTimeout returns an Option that sets how long a session from
+// DialResumable or ListenResumable waits to be resumed after its connection
+// drops before it is closed. The default is 30 seconds.
+func ResumeTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.resumeTimeout = d
+	}
+}
+
+// Hardened returns an Option for sessions with untrusted remote sides. Data
+// frames longer than the maximum frame size are rejected from their header
+// without reading their data, and channels opened by the remote side are
+// refused once 64 are waiting for Accept, unless set with MaxPendingAccepts,
+// instead of holding up the session. A session closed for a malformed frame
+// or other protocol violation returns an error wrapping ErrProtocol from Wait,
+// whether or not it is hardened.
+func Hardened() Option {
+	return func(c *config) {
+		c.hardened = true
+	}
+}
@@ -2,6 +2,7 @@ package mux
 
 import (
 	"io"
+	"sync"
 )
 
 func Pair() (a, b Session) {
@@ -15,9 +16,9 @@ func Pair() (a, b Session) {
 }
 
 type bufferedPipeWriter struct {
-	dataCh  chan []byte
-	closeCh chan struct{}
-	closed  bool
+	dataCh    chan []byte
+	closeCh   chan struct{}
+	closeOnce sync.Once
 }
 
 func newBufferedPipeWriter(pw *io.PipeWriter, bufferSize int) *bufferedPipeWriter {
@@ -39,26 +40,25 @@ func newBufferedPipeWriter(pw *io.PipeWriter, bufferSize int) *bufferedPipeWrite
 	return &bufferedPipeWriter{
 		dataCh:  dataCh,
 		closeCh: closeCh,
-		closed:  false,
 	}
 }
 
 func (w *bufferedPipeWriter) Write(p []byte) (n int, err error) {
-	if w.closed {
-		return 0, io.ErrClosedPipe
-	}
-
 	data := make([]byte, len(p))
 	copy(data, p)
-	w.dataCh <- data
-	return len(p), nil
+	select {
+	case w.dataCh <- data:
+		return len(p), nil
+	case <-w.closeCh:
+		return 0, io.ErrClosedPipe
+	}
 }
 
 func (w *bufferedPipeWriter) Close() error {
-	if w.closed {
-		return io.ErrClosedPipe
-	}
-	w.closed = true
-	close(w.closeCh)
-	return nil
+	err := io.ErrClosedPipe
+	w.closeOnce.Do(func() {
+		close(w.closeCh)
+		err = nil
+	})
+	return err
 }
@@ -0,0 +1,67 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/mux/frame"
+)
+
+// ErrPingUnsupported is returned by Ping for sessions that can't be pinged,
+// either because they were not created by New or the remote side did not
+// negotiate FeatureKeepAlive.
+var ErrPingUnsupported = errors.New("qmux: ping not supported")
+
+// Ping sends a ping over a session created by New and returns the time until
+// the remote side answered. It uses the same messages as keepalives, so it
+// can be called alongside them.
+func Ping(ctx context.Context, sess Session) (time.Duration, error) {
+	s, ok := sess.(*session)
+	if !ok || !s.remoteSupports(FeatureKeepAlive) {
+		return 0, ErrPingUnsupported
+	}
+	start := time.Now()
+	pong, cancel := s.ping()
+	defer cancel()
+	select {
+	case <-pong:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-s.done:
+		return 0, net.ErrClosed
+	}
+}
+
+// ping sends a ping and returns a channel closed when its pong is received.
+// The returned func stops waiting for the pong.
+func (s *session) ping() (<-chan struct{}, func()) {
+	pong := make(chan struct{})
+	s.pingMu.Lock()
+	s.pingSeq++
+	seq := s.pingSeq
+	if s.pings == nil {
+		s.pings = make(map[uint32]chan struct{})
+	}
+	s.pings[seq] = pong
+	s.pingMu.Unlock()
+
+	s.writer.post(frame.PingMessage{Data: seq}, priorityControl)
+	return pong, func() {
+		s.pingMu.Lock()
+		delete(s.pings, seq)
+		s.pingMu.Unlock()
+	}
+}
+
+// handlePong wakes the ping waiting for the pong, if any.
+func (s *session) handlePong(seq uint32) {
+	s.pingMu.Lock()
+	defer s.pingMu.Unlock()
+	if pong, ok := s.pings[seq]; ok {
+		close(pong)
+		delete(s.pings, seq)
+	}
+}
@@ -0,0 +1,518 @@
+package mux
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrResumeFailed is returned by Wait on a resumable session that could not
+// be resumed before the resume timeout.
+var ErrResumeFailed = errors.New("qmux: session could not be resumed")
+
+const (
+	// resumeMaxUnacked is the number of bytes written but not acknowledged
+	// by the remote side after which writes to a resumable transport block.
+	resumeMaxUnacked = 4 << 20
+	// resumeMaxRecord is the largest data record, so a peer can't make the
+	// other side allocate more for a record.
+	resumeMaxRecord = resumeMaxUnacked
+	// resumeAckThreshold is the number of bytes received after which
+	// they are acknowledged to the remote side.
+	resumeAckThreshold = 32 << 10
+	// resumeRedialInterval is the time between attempts to reconnect.
+	resumeRedialInterval = 100 * time.Millisecond
+	// resumeHelloTimeout is how long to wait for the remote side's
+	// hello after connecting.
+	resumeHelloTimeout = 10 * time.Second
+
+	recordData  = 0
+	recordAck   = 1
+	recordClose = 2
+)
+
+type resumeToken [16]byte
+
+// resumeHello is exchanged at the start of each connection of a resumable
+// transport. A zero token from the dialing side asks for a new transport,
+// and a zero token from the listening side rejects the resumption.
+type resumeHello struct {
+	Token    resumeToken
+	Received uint64
+}
+
+// resumable is a transport that keeps its byte stream across reconnects of
+// the underlying connection, so a session over it keeps its channels when the
+// connection drops. Written bytes are kept until the remote side acknowledges
+// them, and are written again after a reconnect starting from the number of
+// bytes the remote side reports it received.
+type resumable struct {
+	token   resumeToken
+	redial  func() (io.ReadWriteCloser, error)
+	timeout time.Duration
+	onClose func()
+
+	// wmu serializes writes to the connection
+	wmu sync.Mutex
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	conn     io.ReadWriteCloser
+	gen      int
+	acked    uint64
+	unacked  []byte
+	received uint64
+	closed   bool
+	err      error
+
+	in    *buffer
+	ackCh chan struct{}
+	done  chan struct{}
+}
+
+func newResumable(token resumeToken, timeout time.Duration) *resumable {
+	r := &resumable{
+		token:   token,
+		timeout: timeout,
+		in:      newBuffer(),
+		ackCh:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	r.cond = sync.NewCond(&r.mu)
+	go r.ackLoop()
+	return r
+}
+
+// DialResumable returns a session over a connection made with dial that is
+// resumed over a new connection from dial if the connection drops, keeping
+// open channels and unacknowledged frames. The remote side must accept the
+// connections with ListenResumable. If the session cannot be resumed within
+// the ResumeTimeout, it is closed and Wait returns ErrResumeFailed.
+func DialResumable(dial func() (io.ReadWriteCloser, error), opts ...Option) (Session, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	hello, err := exchangeHello(conn, resumeHello{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if hello.Token == (resumeToken{}) {
+		conn.Close()
+		return nil, ErrResumeFailed
+	}
+	r := newResumable(hello.Token, newConfig(opts).resumeTimeout)
+	r.redial = dial
+	if err := r.attach(conn, hello.Received); err != nil {
+		return nil, err
+	}
+	return New(r, opts...), nil
+}
+
+// ListenResumable returns a Listener for sessions dialed with DialResumable
+// over connections accepted from l. Connections resuming a session are
+// attached to it instead of being returned by Accept.
+func ListenResumable(l net.Listener, opts ...Option) Listener {
+	rl := &resumeListener{
+		Listener: l,
+		opts:     opts,
+		timeout:  newConfig(opts).resumeTimeout,
+		sessions: make(map[resumeToken]*resumable),
+		accepted: make(chan Session),
+		done:     make(chan struct{}),
+	}
+	go rl.loop()
+	return rl
+}
+
+type resumeListener struct {
+	net.Listener
+	opts     []Option
+	timeout  time.Duration
+	mu       sync.Mutex
+	sessions map[resumeToken]*resumable
+	accepted chan Session
+	done     chan struct{}
+	err      error
+}
+
+func (l *resumeListener) loop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *resumeListener) handshake(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(l.timeout))
+	var hello resumeHello
+	if err := binary.Read(conn, binary.BigEndian, &hello); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	if hello.Token == (resumeToken{}) {
+		var token resumeToken
+		if _, err := rand.Read(token[:]); err != nil {
+			conn.Close()
+			return
+		}
+		r := newResumable(token, l.timeout)
+		r.onClose = func() {
+			l.mu.Lock()
+			delete(l.sessions, token)
+			l.mu.Unlock()
+		}
+		if err := writeHello(conn, resumeHello{Token: token}); err != nil {
+			conn.Close()
+			return
+		}
+		if err := r.attach(conn, 0); err != nil {
+			return
+		}
+		l.mu.Lock()
+		l.sessions[token] = r
+		l.mu.Unlock()
+		select {
+		case l.accepted <- New(r, l.opts...):
+		case <-l.done:
+			r.Close()
+		}
+		return
+	}
+
+	l.mu.Lock()
+	r := l.sessions[hello.Token]
+	l.mu.Unlock()
+	if r == nil {
+		writeHello(conn, resumeHello{})
+		conn.Close()
+		return
+	}
+	if err := writeHello(conn, resumeHello{Token: r.token, Received: r.receivedCount()}); err != nil {
+		conn.Close()
+		return
+	}
+	r.attach(conn, hello.Received)
+}
+
+// Accept waits for and returns the next new session.
+func (l *resumeListener) Accept() (Session, error) {
+	select {
+	case sess := <-l.accepted:
+		return sess, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+func writeHello(w io.Writer, hello resumeHello) error {
+	return binary.Write(w, binary.BigEndian, hello)
+}
+
+func exchangeHello(conn io.ReadWriter, hello resumeHello) (resumeHello, error) {
+	if c, ok := conn.(net.Conn); ok {
+		c.SetDeadline(time.Now().Add(resumeHelloTimeout))
+		defer c.SetDeadline(time.Time{})
+	}
+	var remote resumeHello
+	if err := writeHello(conn, hello); err != nil {
+		return remote, err
+	}
+	err := binary.Read(conn, binary.BigEndian, &remote)
+	return remote, err
+}
+
+func (r *resumable) receivedCount() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.received
+}
+
+// attach makes conn the current connection, writing again the bytes that the
+// remote side has not received.
+func (r *resumable) attach(conn io.ReadWriteCloser, remoteReceived uint64) error {
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		conn.Close()
+		return net.ErrClosed
+	}
+	if remoteReceived < r.acked || remoteReceived > r.acked+uint64(len(r.unacked)) {
+		r.mu.Unlock()
+		conn.Close()
+		r.fail(fmt.Errorf("%w: remote received %d bytes", ErrResumeFailed, remoteReceived))
+		return ErrResumeFailed
+	}
+	r.trim(remoteReceived)
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.conn = conn
+	r.gen++
+	gen := r.gen
+	pending := append([]byte(nil), r.unacked...)
+	r.cond.Broadcast()
+	r.mu.Unlock()
+
+	go r.readLoop(conn, gen)
+	if len(pending) > 0 {
+		if err := writeRecord(conn, recordData, pending); err != nil {
+			r.detach(gen)
+		}
+	}
+	return nil
+}
+
+// detach drops the connection of the given generation after an error and
+// starts resuming the transport.
+func (r *resumable) detach(gen int) {
+	r.mu.Lock()
+	if r.gen != gen || r.conn == nil || r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.conn.Close()
+	r.conn = nil
+	r.mu.Unlock()
+	go r.resume(gen)
+}
+
+// resume redials if this side dialed, then fails the transport if it was
+// not resumed by either side before the timeout.
+func (r *resumable) resume(gen int) {
+	deadline := time.Now().Add(r.timeout)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		resumed := r.gen != gen || r.closed
+		r.mu.Unlock()
+		if resumed {
+			return
+		}
+		if r.redial != nil {
+			if conn, err := r.redial(); err == nil {
+				hello, err := exchangeHello(conn, resumeHello{Token: r.token, Received: r.receivedCount()})
+				if err == nil && hello.Token == (resumeToken{}) {
+					conn.Close()
+					r.fail(ErrResumeFailed)
+					return
+				}
+				if err == nil {
+					r.attach(conn, hello.Received)
+					return
+				}
+				conn.Close()
+			}
+		}
+		time.Sleep(resumeRedialInterval)
+	}
+	r.mu.Lock()
+	resumed := r.gen != gen
+	r.mu.Unlock()
+	if !resumed {
+		r.fail(ErrResumeFailed)
+	}
+}
+
+// trim drops acknowledged bytes. It must be called with r.mu held.
+func (r *resumable) trim(ack uint64) {
+	if ack <= r.acked {
+		return
+	}
+	n := ack - r.acked
+	if n > uint64(len(r.unacked)) {
+		n = uint64(len(r.unacked))
+	}
+	r.unacked = r.unacked[n:]
+	r.acked += n
+	r.cond.Broadcast()
+}
+
+func (r *resumable) readLoop(conn io.Reader, gen int) {
+	var hdr [9]byte
+	for {
+		if _, err := io.ReadFull(conn, hdr[:1]); err != nil {
+			r.detach(gen)
+			return
+		}
+		switch hdr[0] {
+		case recordData:
+			if _, err := io.ReadFull(conn, hdr[1:5]); err != nil {
+				r.detach(gen)
+				return
+			}
+			n := binary.BigEndian.Uint32(hdr[1:5])
+			if n > resumeMaxRecord {
+				r.fail(fmt.Errorf("%w: resume record of %d bytes", ErrProtocol, n))
+				return
+			}
+			data := make([]byte, n)
+			if _, err := io.ReadFull(conn, data); err != nil {
+				r.detach(gen)
+				return
+			}
+			r.mu.Lock()
+			r.received += uint64(len(data))
+			r.mu.Unlock()
+			r.in.write(data)
+			select {
+			case r.ackCh <- struct{}{}:
+			default:
+			}
+		case recordAck:
+			if _, err := io.ReadFull(conn, hdr[1:9]); err != nil {
+				r.detach(gen)
+				return
+			}
+			r.mu.Lock()
+			r.trim(binary.BigEndian.Uint64(hdr[1:9]))
+			r.mu.Unlock()
+		case recordClose:
+			r.fail(io.EOF)
+			return
+		default:
+			r.detach(gen)
+			return
+		}
+	}
+}
+
+// ackLoop acknowledges received bytes once enough have been received,
+// so the remote side can drop them.
+func (r *resumable) ackLoop() {
+	var lastAck uint64
+	for {
+		select {
+		case <-r.ackCh:
+		case <-r.done:
+			return
+		}
+		r.mu.Lock()
+		received, conn := r.received, r.conn
+		r.mu.Unlock()
+		if conn == nil || received-lastAck < resumeAckThreshold {
+			continue
+		}
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], received)
+		r.wmu.Lock()
+		err := writeRecord(conn, recordAck, b[:])
+		r.wmu.Unlock()
+		if err == nil {
+			lastAck = received
+		}
+	}
+}
+
+// writeRecord writes a record of the type, splitting data into records of at
+// most resumeMaxRecord bytes.
+func writeRecord(w io.Writer, typ byte, p []byte) error {
+	for typ == recordData && len(p) > resumeMaxRecord {
+		if err := writeRecord(w, typ, p[:resumeMaxRecord]); err != nil {
+			return err
+		}
+		p = p[resumeMaxRecord:]
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(typ)
+	if typ == recordData {
+		binary.Write(&buf, binary.BigEndian, uint32(len(p)))
+	}
+	buf.Write(p)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (r *resumable) Read(p []byte) (int, error) {
+	n, err := r.in.Read(p)
+	if err == io.EOF {
+		r.mu.Lock()
+		if r.err != nil && r.err != io.EOF {
+			err = r.err
+		}
+		r.mu.Unlock()
+	}
+	return n, err
+}
+
+func (r *resumable) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	for !r.closed && len(r.unacked) >= resumeMaxUnacked {
+		r.cond.Wait()
+	}
+	r.mu.Unlock()
+
+	// holding wmu while adding to unacked keeps attach from writing p
+	// again if it resumes the transport before p is written here
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	r.unacked = append(r.unacked, p...)
+	conn, gen := r.conn, r.gen
+	r.mu.Unlock()
+	if conn != nil {
+		// bytes not written are written again once resumed
+		if err := writeRecord(conn, recordData, p); err != nil {
+			go r.detach(gen)
+		}
+	}
+	return len(p), nil
+}
+
+// Close closes the transport and tells the remote side it will not be resumed.
+func (r *resumable) Close() error {
+	r.wmu.Lock()
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+	if conn != nil {
+		writeRecord(conn, recordClose, nil)
+	}
+	r.wmu.Unlock()
+	r.fail(io.EOF)
+	return nil
+}
+
+// fail closes the transport with err.
+func (r *resumable) fail(err error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	r.err = err
+	conn := r.conn
+	r.conn = nil
+	r.cond.Broadcast()
+	r.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+	close(r.done)
+	r.in.eof()
+	if r.onClose != nil {
+		r.onClose()
+	}
+}
@@ -0,0 +1,133 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testDialer struct {
+	addr string
+	mu   sync.Mutex
+	conn net.Conn
+	fail bool
+}
+
+func (d *testDialer) dial() (io.ReadWriteCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.fail {
+		return nil, errors.New("dial failed")
+	}
+	conn, err := net.Dial("tcp", d.addr)
+	d.conn = conn
+	return conn, err
+}
+
+// drop closes the current connection, optionally failing later dials.
+func (d *testDialer) drop(fail bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fail = fail
+	d.conn.Close()
+}
+
+func TestResumable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	fatal(err, t)
+	rl := ListenResumable(l, ResumeTimeout(2*time.Second))
+	defer rl.Close()
+
+	go func() {
+		sess, err := rl.Accept()
+		if err != nil {
+			return
+		}
+		for {
+			ch, err := sess.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ch, ch)
+		}
+	}()
+
+	d := &testDialer{addr: l.Addr().String()}
+	sess, err := DialResumable(d.dial, ResumeTimeout(2*time.Second))
+	fatal(err, t)
+	defer sess.Close()
+
+	ch, err := sess.Open(context.Background())
+	fatal(err, t)
+	echo := func(s string) {
+		t.Helper()
+		_, err := ch.Write([]byte(s))
+		fatal(err, t)
+		b := make([]byte, len(s))
+		_, err = io.ReadFull(ch, b)
+		fatal(err, t)
+		if string(b) != s {
+			t.Fatalf("unexpected echo: %q", b)
+		}
+	}
+
+	echo("Hello")
+	d.drop(false)
+	// the channel keeps working over the new connection
+	echo("world")
+	d.drop(false)
+	echo("again")
+}
+
+func TestResumableTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	fatal(err, t)
+	rl := ListenResumable(l, ResumeTimeout(200*time.Millisecond))
+	defer rl.Close()
+
+	accepted := make(chan Session, 1)
+	go func() {
+		sess, err := rl.Accept()
+		if err == nil {
+			accepted <- sess
+		}
+	}()
+
+	d := &testDialer{addr: l.Addr().String()}
+	sess, err := DialResumable(d.dial, ResumeTimeout(200*time.Millisecond))
+	fatal(err, t)
+	remote := <-accepted
+
+	d.drop(true)
+	if err := sess.Wait(); !errors.Is(err, ErrResumeFailed) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := remote.Wait(); !errors.Is(err, ErrResumeFailed) {
+		t.Fatalf("unexpected remote error: %v", err)
+	}
+}
+
+func TestResumableRecordTooLarge(t *testing.T) {
+	r := newResumable(resumeToken{}, time.Second)
+	// a record header claiming 4 GiB of data
+	conn := bytes.NewReader([]byte{recordData, 0xff, 0xff, 0xff, 0xff})
+	r.readLoop(conn, 0)
+
+	_, err := r.Read(make([]byte, 1))
+	if !errors.Is(err, ErrProtocol) {
+		t.Fatalf("expected protocol error, got %v", err)
+	}
+}
+
+func TestWriteRecordSplits(t *testing.T) {
+	var buf bytes.Buffer
+	fatal(writeRecord(&buf, recordData, make([]byte, resumeMaxRecord+1)), t)
+	if want := 2*5 + resumeMaxRecord + 1; buf.Len() != want {
+		t.Fatalf("expected %d bytes written, got %d", want, buf.Len())
+	}
+}
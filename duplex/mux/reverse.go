@@ -0,0 +1,132 @@
+package mux
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// reverseRedialInterval is the time between attempts to dial out again
+// after the session of a reverse listener ends. It is a var so it can be
+// changed in tests.
+var reverseRedialInterval = time.Second
+
+// ListenReverse returns a Listener that serves sessions over a session it dials
+// out with dial, such as to a hub with DialTCP, so a server behind NAT can accept
+// sessions without forwarding a port. Each channel the remote side opens on the
+// dialed session with DialReverse is accepted as a session over that channel,
+// created with opts. If the dialed session ends or dial fails, it is dialed
+// again until the listener is closed.
+func ListenReverse(dial func() (Session, error), opts ...Option) Listener {
+	l := &reverseListener{
+		dial:     dial,
+		opts:     opts,
+		interval: reverseRedialInterval,
+		accepted: make(chan Session),
+		done:     make(chan struct{}),
+	}
+	go l.loop()
+	return l
+}
+
+// DialReverse opens a channel on sess, a session dialed by a listener created
+// with ListenReverse, and returns a session over it that the listener accepts.
+func DialReverse(ctx context.Context, sess Session, opts ...Option) (Session, error) {
+	ch, err := sess.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return New(ch, opts...), nil
+}
+
+type reverseListener struct {
+	dial     func() (Session, error)
+	opts     []Option
+	interval time.Duration
+
+	accepted  chan Session
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu   sync.Mutex
+	sess Session
+}
+
+func (l *reverseListener) loop() {
+	for {
+		sess, err := l.dial()
+		if err == nil {
+			l.serve(sess)
+		}
+		select {
+		case <-time.After(l.interval):
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// serve accepts channels on the dialed session until it ends.
+func (l *reverseListener) serve(sess Session) {
+	l.mu.Lock()
+	select {
+	case <-l.done:
+		l.mu.Unlock()
+		sess.Close()
+		return
+	default:
+	}
+	l.sess = sess
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		l.sess = nil
+		l.mu.Unlock()
+		sess.Close()
+	}()
+	for {
+		ch, err := sess.Accept()
+		if err != nil {
+			return
+		}
+		select {
+		case l.accepted <- New(ch, l.opts...):
+		case <-l.done:
+			ch.Close()
+			return
+		}
+	}
+}
+
+// Accept waits for and returns the next session opened over the
+// dialed session.
+func (l *reverseListener) Accept() (Session, error) {
+	select {
+	case sess := <-l.accepted:
+		return sess, nil
+	case <-l.done:
+		return nil, io.EOF
+	}
+}
+
+// Close stops accepting sessions and closes the dialed session.
+func (l *reverseListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.mu.Lock()
+		close(l.done)
+		sess := l.sess
+		l.mu.Unlock()
+		if sess != nil {
+			sess.Close()
+		}
+	})
+	return nil
+}
+
+// Addr returns nil since the listener has no address of its own.
+func (l *reverseListener) Addr() net.Addr {
+	return nil
+}
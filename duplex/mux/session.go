@@ -2,10 +2,12 @@ package mux
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"tractor.dev/toolkit-go/duplex/mux/frame"
@@ -21,12 +23,31 @@ const (
 	// We follow OpenSSH here.
 	channelWindowSize = 64 * channelMaxPacket
 
+	// drainInterval is how often Drain checks for open channels.
+	drainInterval = 10 * time.Millisecond
+
 	// chanSize sets the amount of buffering qmux connections. This is
 	// primarily for testing: setting chanSize=0 uncovers deadlocks more
 	// quickly.
 	chanSize = 16
 )
 
+// ErrKeepAliveTimeout is returned by Wait when the session was closed because
+// the remote side did not answer a keepalive ping in time.
+var ErrKeepAliveTimeout = errors.New("qmux: keepalive timeout")
+
+// ErrLimitExceeded is wrapped by errors returned when a session limit
+// set with an Option is exceeded.
+var ErrLimitExceeded = errors.New("qmux: limit exceeded")
+
+// ErrProtocol is wrapped by the error returned by Wait when the session was
+// closed because the remote side sent a malformed frame or broke the protocol.
+var ErrProtocol = errors.New("qmux: protocol violation")
+
+// ErrGoAway is returned by Open when the session is being drained by
+// either side, so no new channels can be opened.
+var ErrGoAway = errors.New("qmux: session is going away")
+
 var (
 	// timeout for queuing a new channel to be `Accept`ed
 	// use a `var` so that this can be overridden in tests
@@ -39,36 +60,79 @@ type Session interface {
 	Accept() (Channel, error)
 	Open(ctx context.Context) (Channel, error)
 	Wait() error
+
+	// AcceptContext is like Accept but returns the context error if ctx is
+	// done before a channel is accepted, so accept loops can be stopped
+	// without closing the session.
+	AcceptContext(ctx context.Context) (Channel, error)
+
+	// Drain shuts down the session gracefully. It tells the remote side to
+	// stop opening channels, waits for open channels to be closed or for ctx
+	// to be done, then closes the session.
+	Drain(ctx context.Context) error
 }
 
 type session struct {
 	t     io.ReadWriteCloser
 	chans chanList
 
-	enc *frame.Encoder
-	dec *frame.Decoder
+	writer *writer
+	dec    *frame.Decoder
+
+	config   config
+	stats    sessionStats
+	buffered atomic.Int64
 
 	inbox chan Channel
 
 	errCond *sync.Cond
 	err     error
-	closeCh chan bool
+	done    chan struct{}
+
+	// pings maps the sequence of each ping sent to a channel
+	// closed when its pong is received.
+	pingMu   sync.Mutex
+	pingSeq  uint32
+	pings    map[uint32]chan struct{}
+	timedOut atomic.Bool
+	goAway   atomic.Bool
+
+	// negotiatedCh is closed once negotiated is set by the handshake.
+	// helloReceived is only used by the session loop.
+	negotiatedCh  chan struct{}
+	negotiated    Negotiated
+	helloReceived bool
 }
 
-// NewSession returns a session that runs over the given transport.
-func New(t io.ReadWriteCloser) Session {
+// New returns a session that runs over the given transport.
+// Options can be given to configure the session.
+func New(t io.ReadWriteCloser, opts ...Option) Session {
 	if t == nil {
 		return nil
 	}
 	s := &session{
 		t:       t,
-		enc:     frame.NewEncoder(t),
-		dec:     frame.NewDecoder(t),
-		inbox:   make(chan Channel),
 		errCond: sync.NewCond(new(sync.Mutex)),
-		closeCh: make(chan bool, 1),
+		done:    make(chan struct{}),
+		config:  newConfig(opts),
+
+		negotiatedCh: make(chan struct{}),
+	}
+	s.inbox = make(chan Channel, s.config.maxPendingAccepts)
+	s.stats.started = time.Now()
+	st := &statsTransport{ReadWriteCloser: t, stats: &s.stats}
+	s.writer = newWriter(frame.NewEncoder(st), &s.stats, s.trace)
+	s.dec = frame.NewDecoder(st)
+	if s.config.hardened {
+		s.dec.MaxDataLength = s.config.maxFrameSize
+	}
+	if s.config.handshake {
+		s.writer.post(s.hello(), priorityControl)
 	}
 	go s.loop()
+	if s.config.keepAliveInterval > 0 {
+		go s.keepAlive()
+	}
 	return s
 }
 
@@ -89,26 +153,66 @@ func (s *session) Wait() error {
 	return s.err
 }
 
+// Drain sends a go away frame so the remote side stops opening channels, then
+// waits for open channels to be closed before closing the session. If ctx is
+// done first, the session is closed anyway and the context error is returned.
+func (s *session) Drain(ctx context.Context) error {
+	s.goAway.Store(true)
+	if s.remoteSupports(FeatureGoAway) {
+		if err := s.writer.write(frame.GoAwayMessage{}, priorityControl); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for s.chans.count() > 0 {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return nil
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		}
+	}
+	return s.Close()
+}
+
 // Accept waits for and returns the next incoming channel.
 func (s *session) Accept() (Channel, error) {
+	return s.AcceptContext(context.Background())
+}
+
+// AcceptContext waits for and returns the next incoming channel, or the
+// context error if ctx is done first.
+func (s *session) AcceptContext(ctx context.Context) (Channel, error) {
 	select {
 	case ch := <-s.inbox:
 		return ch, nil
-	case <-s.closeCh:
+	case <-s.done:
 		return nil, io.EOF
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
 // Open establishes a new channel with the other end.
 func (s *session) Open(ctx context.Context) (Channel, error) {
+	if s.goAway.Load() {
+		return nil, ErrGoAway
+	}
+	if s.channelLimitReached() {
+		return nil, fmt.Errorf("%w: too many open channels", ErrLimitExceeded)
+	}
 	ch := s.newChannel(channelOutbound)
-	ch.maxIncomingPayload = channelMaxPacket
+	ch.maxIncomingPayload = s.config.maxFrameSize
 
-	if err := s.enc.Encode(frame.OpenMessage{
+	if err := s.writer.write(frame.OpenMessage{
 		WindowSize:    ch.myWindow,
 		MaxPacketSize: ch.maxIncomingPayload,
 		SenderID:      ch.localId,
-	}); err != nil {
+	}, priorityControl); err != nil {
 		return nil, err
 	}
 
@@ -116,6 +220,7 @@ func (s *session) Open(ctx context.Context) (Channel, error) {
 
 	select {
 	case <-ctx.Done():
+		go s.abandonOpen(ch)
 		return nil, ctx.Err()
 	case m = <-ch.msg:
 		if m == nil {
@@ -127,6 +232,7 @@ func (s *session) Open(ctx context.Context) (Channel, error) {
 
 	switch msg := m.(type) {
 	case *frame.OpenConfirmMessage:
+		s.stats.channelOpened(ch)
 		return ch, nil
 	case *frame.OpenFailureMessage:
 		return nil, fmt.Errorf("qmux: channel open failed on remote side")
@@ -135,17 +241,27 @@ func (s *session) Open(ctx context.Context) (Channel, error) {
 	}
 }
 
+// abandonOpen closes a channel whose Open was canceled once the remote side
+// confirms it, so it isn't left open on the remote side.
+func (s *session) abandonOpen(ch *channel) {
+	if _, ok := (<-ch.msg).(*frame.OpenConfirmMessage); ok {
+		s.stats.channelOpened(ch)
+		ch.Close()
+	}
+}
+
 func (s *session) newChannel(direction channelDirection) *channel {
 	ch := &channel{
 		remoteWin: window{Cond: sync.NewCond(new(sync.Mutex))},
-		myWindow:  channelWindowSize,
+		myWindow:  s.config.windowSize,
 		pending:   newBuffer(),
 		direction: direction,
 		msg:       make(chan frame.Message, chanSize),
 		session:   s,
 		packetBuf: make([]byte, 0),
 	}
-	ch.localId = s.chans.add(ch)
+	ch.priority.Store(uint32(PriorityNormal))
+	s.chans.add(ch)
 	return ch
 }
 
@@ -162,7 +278,12 @@ func (s *session) loop() {
 	}
 
 	s.t.Close()
-	s.closeCh <- true
+	s.writer.close()
+	close(s.done)
+
+	if s.timedOut.Load() {
+		err = ErrKeepAliveTimeout
+	}
 
 	s.errCond.L.Lock()
 	s.err = err
@@ -176,18 +297,42 @@ func (s *session) onePacket() error {
 	var msg frame.Message
 
 	msg, err = s.dec.Decode()
+	if errors.Is(err, frame.ErrTooLarge) || errors.Is(err, frame.ErrUnknownMessage) {
+		return fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
 	if err != nil {
 		return err
 	}
+	s.stats.framesReceived.Add(1)
+	s.trace(Received, msg)
+
+	if s.config.handshake && !s.helloReceived {
+		s.helloReceived = true
+		return s.handleHello(msg)
+	}
 
 	id, isChan := msg.Channel()
 	if !isChan {
-		return s.handleOpen(msg.(*frame.OpenMessage))
+		switch m := msg.(type) {
+		case *frame.PingMessage:
+			s.writer.post(frame.PongMessage{Data: m.Data}, priorityControl)
+			return nil
+		case *frame.PongMessage:
+			s.handlePong(m.Data)
+			return nil
+		case *frame.GoAwayMessage:
+			s.goAway.Store(true)
+			return nil
+		case *frame.HelloMessage:
+			return fmt.Errorf("%w: unexpected hello", ErrHandshake)
+		default:
+			return s.handleOpen(msg.(*frame.OpenMessage))
+		}
 	}
 
 	ch := s.chans.getChan(id)
 	if ch == nil {
-		return fmt.Errorf("qmux: invalid channel %d", id)
+		return fmt.Errorf("%w: invalid channel %d", ErrProtocol, id)
 	}
 
 	return ch.handle(msg)
@@ -195,30 +340,92 @@ func (s *session) onePacket() error {
 
 // handleChannelOpen schedules a channel to be Accept()ed.
 func (s *session) handleOpen(msg *frame.OpenMessage) error {
-	if msg.MaxPacketSize < minPacketLength || msg.MaxPacketSize > maxPacketLength {
-		return s.enc.Encode(frame.OpenFailureMessage{
+	if msg.MaxPacketSize < minPacketLength || msg.MaxPacketSize > maxPacketLength || s.channelLimitReached() || s.goAway.Load() {
+		s.writer.post(frame.OpenFailureMessage{
 			ChannelID: msg.SenderID,
-		})
+		}, priorityControl)
+		return nil
 	}
 
 	c := s.newChannel(channelInbound)
 	c.remoteId = msg.SenderID
 	c.maxRemotePayload = msg.MaxPacketSize
 	c.remoteWin.add(msg.WindowSize)
-	c.maxIncomingPayload = channelMaxPacket
+	c.maxIncomingPayload = s.config.maxFrameSize
+	if s.config.maxPendingAccepts > 0 {
+		select {
+		case s.inbox <- c:
+			return s.confirmOpen(c)
+		default:
+			s.chans.remove(c.localId)
+			s.writer.post(frame.OpenFailureMessage{
+				ChannelID: msg.SenderID,
+			}, priorityControl)
+			return nil
+		}
+	}
 	t := time.NewTimer(openTimeout)
 	defer t.Stop()
 	select {
 	case s.inbox <- c:
-		return s.enc.Encode(frame.OpenConfirmMessage{
-			ChannelID:     c.remoteId,
-			SenderID:      c.localId,
-			WindowSize:    c.myWindow,
-			MaxPacketSize: c.maxIncomingPayload,
-		})
+		return s.confirmOpen(c)
 	case <-t.C:
-		return s.enc.Encode(frame.OpenFailureMessage{
+		s.chans.remove(c.localId)
+		s.writer.post(frame.OpenFailureMessage{
 			ChannelID: msg.SenderID,
-		})
+		}, priorityControl)
+		return nil
+	}
+}
+
+// keepAlive pings the remote side every interval and closes the transport
+// if a pong is not received before the timeout, which unblocks pending
+// calls on the session and its channels.
+func (s *session) keepAlive() {
+	if !s.remoteSupports(FeatureKeepAlive) {
+		return
+	}
+	ticker := time.NewTicker(s.config.keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		timeout := time.NewTimer(s.config.keepAliveTimeout)
+		// the write itself can block on a dead connection, so it
+		// is covered by the timeout as well
+		pong, cancel := s.ping()
+		select {
+		case <-pong:
+		case <-timeout.C:
+			cancel()
+			s.timedOut.Store(true)
+			s.t.Close()
+			return
+		case <-s.done:
+			cancel()
+			timeout.Stop()
+			return
+		}
+		timeout.Stop()
 	}
 }
+
+// confirmOpen confirms a channel opened by the remote side.
+func (s *session) confirmOpen(c *channel) error {
+	s.stats.channelOpened(c)
+	s.writer.post(frame.OpenConfirmMessage{
+		ChannelID:     c.remoteId,
+		SenderID:      c.localId,
+		WindowSize:    c.myWindow,
+		MaxPacketSize: c.maxIncomingPayload,
+	}, priorityControl)
+	return nil
+}
+
+// channelLimitReached returns true if no more channels can be opened.
+func (s *session) channelLimitReached() bool {
+	return s.config.maxChannels > 0 && s.chans.count() >= s.config.maxChannels
+}
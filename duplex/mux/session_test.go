@@ -4,10 +4,17 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
 	"io/ioutil"
+	"log/slog"
 	"net"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"tractor.dev/toolkit-go/duplex/mux/frame"
 )
 
 func init() {
@@ -116,6 +123,34 @@ func TestSessionOpenClientTimeout(t *testing.T) {
 	}
 }
 
+func TestSessionOpenCanceled(t *testing.T) {
+	sessA, sessB := newTestPair(nil, []Option{MaxPendingAccepts(16)})
+	defer sessA.Close()
+	defer sessB.Close()
+
+	// opens canceled before they are confirmed must not leave channels
+	// open on the remote side
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 10; i++ {
+		if ch, err := sessA.Open(ctx); err == nil {
+			ch.Close()
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		stats, _ := StatsOf(sessB)
+		if len(stats.Channels) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no open channels, got %d", len(stats.Channels))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func TestSessionOpenServerTimeout(t *testing.T) {
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	fatal(err, t)
@@ -165,3 +200,595 @@ func TestSessionWait(t *testing.T) {
 		t.Fatalf("expected a network error, but got: %v", err)
 	}
 }
+
+func TestSessionAcceptContext(t *testing.T) {
+	sessA, sessB := newTestPair(nil, []Option{MaxPendingAccepts(1)})
+	defer sessA.Close()
+	defer sessB.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := sessB.AcceptContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error: %v", err)
+	}
+
+	// the session is still usable after a cancelled accept
+	_, err := sessA.Open(context.Background())
+	fatal(err, t)
+	_, err = sessB.AcceptContext(context.Background())
+	fatal(err, t)
+}
+
+func TestChannelDeadlines(t *testing.T) {
+	sessA, sessB := newTestPair([]Option{WindowSize(minPacketLength)}, []Option{MaxPendingAccepts(1), WindowSize(minPacketLength)})
+	defer sessA.Close()
+	defer sessB.Close()
+
+	ch, err := sessA.Open(context.Background())
+	fatal(err, t)
+	chB, err := sessB.Accept()
+	fatal(err, t)
+
+	fatal(ch.SetReadDeadline(time.Now().Add(20*time.Millisecond)), t)
+	_, err = ch.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected read deadline error: %v", err)
+	}
+	fatal(ch.SetReadDeadline(time.Time{}), t)
+
+	// nothing reads on the remote side, so the write blocks on the window
+	fatal(ch.SetWriteDeadline(time.Now().Add(20*time.Millisecond)), t)
+	n, err := ch.Write(make([]byte, 2*minPacketLength))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected write deadline error: %v", err)
+	}
+	fatal(ch.SetWriteDeadline(time.Time{}), t)
+
+	// the channel still works once deadlines are cleared
+	go io.CopyN(io.Discard, chB, int64(n+1))
+	_, err = ch.Write([]byte{1})
+	fatal(err, t)
+}
+
+func TestSessionWindowSize(t *testing.T) {
+	ar, bw := io.Pipe()
+	br, aw := io.Pipe()
+	sessA := New(&ioduplex{aw, ar}, WindowSize(1024))
+	sessB := New(&ioduplex{bw, br}, WindowSize(1024))
+	defer sessA.Close()
+	defer sessB.Close()
+
+	opened := make(chan Channel)
+	go func() {
+		ch, err := sessA.Open(context.Background())
+		fatal(err, t)
+		opened <- ch
+	}()
+	ch, err := sessB.Accept()
+	fatal(err, t)
+	go func() {
+		ch.Write(make([]byte, 8192))
+		ch.Close()
+	}()
+
+	// the writer blocks once it fills the window since nothing has been read
+	ch.(*channel).remoteWin.waitWriterBlocked()
+
+	b, err := ioutil.ReadAll(<-opened)
+	fatal(err, t)
+	if len(b) != 8192 {
+		t.Fatalf("unexpected length: %d", len(b))
+	}
+}
+
+func TestSessionKeepAlive(t *testing.T) {
+	t.Run("alive", func(t *testing.T) {
+		ar, bw := io.Pipe()
+		br, aw := io.Pipe()
+		sessA := New(&ioduplex{aw, ar}, KeepAlive(10*time.Millisecond, 50*time.Millisecond))
+		sessB := New(&ioduplex{bw, br})
+		defer sessA.Close()
+		defer sessB.Close()
+
+		time.Sleep(100 * time.Millisecond)
+		go sessB.Accept()
+		_, err := sessA.Open(context.Background())
+		fatal(err, t)
+	})
+
+	t.Run("dead peer", func(t *testing.T) {
+		ar, _ := io.Pipe()
+		br, aw := io.Pipe()
+		// the peer reads frames but never answers
+		go io.Copy(io.Discard, br)
+		sess := New(&ioduplex{aw, ar}, KeepAlive(10*time.Millisecond, 50*time.Millisecond))
+
+		accepted := make(chan error)
+		go func() {
+			_, err := sess.Accept()
+			accepted <- err
+		}()
+		if err := sess.Wait(); err != ErrKeepAliveTimeout {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := <-accepted; err != io.EOF {
+			t.Fatalf("unexpected accept error: %v", err)
+		}
+	})
+}
+
+func TestPing(t *testing.T) {
+	ar, bw := io.Pipe()
+	br, aw := io.Pipe()
+	sessA := New(&ioduplex{aw, ar}, KeepAlive(5*time.Millisecond, time.Second))
+	sessB := New(&ioduplex{bw, br})
+	defer sessA.Close()
+	defer sessB.Close()
+
+	// pings alongside keepalives each get their own pong
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtt, err := Ping(context.Background(), sessA)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if rtt <= 0 {
+				t.Errorf("unexpected round trip time: %v", rtt)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sessB.Close()
+	sessA.Wait()
+	if _, err := Ping(context.Background(), sessA); err == nil {
+		t.Fatal("expected error pinging closed session")
+	}
+	if _, err := Ping(context.Background(), nil); err != ErrPingUnsupported {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSessionStats(t *testing.T) {
+	sessA, sessB := Pair()
+	defer sessA.Close()
+	defer sessB.Close()
+
+	go func() {
+		ch, err := sessB.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(ch, ch)
+		ch.Close()
+	}()
+
+	ch, err := sessA.Open(context.Background())
+	fatal(err, t)
+	if !SetPriority(ch, PriorityHigh) {
+		t.Fatal("expected priority to be set")
+	}
+	_, err = ch.Write([]byte("Hello world"))
+	fatal(err, t)
+	fatal(ch.CloseWrite(), t)
+	b, err := ioutil.ReadAll(ch)
+	fatal(err, t)
+	if string(b) != "Hello world" {
+		t.Fatalf("unexpected echo: %q", b)
+	}
+
+	chStats, ok := ChannelStatsOf(ch)
+	if !ok {
+		t.Fatal("expected channel stats")
+	}
+	if chStats.BytesSent != 11 || chStats.BytesReceived != 11 || !chStats.Outbound {
+		t.Fatalf("unexpected channel stats: %+v", chStats)
+	}
+
+	stats, ok := StatsOf(sessA)
+	if !ok {
+		t.Fatal("expected session stats")
+	}
+	if stats.ChannelsOpened != 1 || len(stats.Channels) > 1 {
+		t.Fatalf("unexpected session stats: %+v", stats)
+	}
+	if stats.FramesSent < 3 || stats.BytesSent < 11 || stats.BytesReceived < 11 {
+		t.Fatalf("unexpected session stats: %+v", stats)
+	}
+
+	// the channel is closed once the remote close is received
+	ch.Close()
+	deadline := time.Now().Add(time.Second)
+	for stats.ChannelsClosed != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("channel not closed: %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+		stats, _ = StatsOf(sessA)
+	}
+	if len(stats.Channels) != 0 || stats.ChannelLifetime <= 0 {
+		t.Fatalf("unexpected session stats: %+v", stats)
+	}
+}
+
+func newTestPair(optsA, optsB []Option) (a, b Session) {
+	ar, bw := io.Pipe()
+	br, aw := io.Pipe()
+	a = New(&ioduplex{aw, ar}, optsA...)
+	b = New(&ioduplex{bw, br}, optsB...)
+	return
+}
+
+func TestSessionLimits(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("max channels", func(t *testing.T) {
+		sessA, sessB := newTestPair([]Option{MaxChannels(1)}, []Option{MaxPendingAccepts(4), MaxChannels(1)})
+		defer sessA.Close()
+		defer sessB.Close()
+
+		_, err := sessA.Open(ctx)
+		fatal(err, t)
+		_, err = sessA.Open(ctx)
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("expected limit error: %v", err)
+		}
+		_, err = sessB.Open(ctx)
+		if err == nil {
+			t.Fatal("expected open to be refused by remote")
+		}
+	})
+
+	t.Run("max pending accepts", func(t *testing.T) {
+		sessA, sessB := newTestPair(nil, []Option{MaxPendingAccepts(1)})
+		defer sessA.Close()
+		defer sessB.Close()
+
+		_, err := sessA.Open(ctx)
+		fatal(err, t)
+		_, err = sessA.Open(ctx)
+		if err == nil {
+			t.Fatal("expected open to be refused by remote")
+		}
+		_, err = sessB.Accept()
+		fatal(err, t)
+		_, err = sessA.Open(ctx)
+		fatal(err, t)
+	})
+
+	t.Run("max buffered bytes", func(t *testing.T) {
+		sessA, sessB := newTestPair(nil, []Option{MaxPendingAccepts(1), MaxBufferedBytes(100)})
+		defer sessA.Close()
+		defer sessB.Close()
+
+		ch, err := sessA.Open(ctx)
+		fatal(err, t)
+		_, err = ch.Write(make([]byte, 50))
+		fatal(err, t)
+		// the write may or may not fail depending on when the session closes
+		ch.Write(make([]byte, 100))
+		if err := sessB.Wait(); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("expected limit error: %v", err)
+		}
+	})
+}
+
+func TestWriterPriority(t *testing.T) {
+	r, pw := io.Pipe()
+	w := newWriter(frame.NewEncoder(pw), &sessionStats{}, nil)
+	defer w.close()
+
+	// the first frame is taken by the writer, which blocks until it is read
+	w.post(frame.PingMessage{Data: 1}, PriorityLow)
+	for {
+		w.mu.Lock()
+		taken := len(w.queues[PriorityLow]) == 0
+		w.mu.Unlock()
+		if taken {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	w.post(frame.PingMessage{Data: 2}, PriorityLow)
+	w.post(frame.PingMessage{Data: 3}, PriorityHigh)
+	w.post(frame.PingMessage{Data: 4}, PriorityNormal)
+	w.post(frame.PingMessage{Data: 5}, priorityControl)
+
+	dec := frame.NewDecoder(r)
+	for _, expected := range []uint32{1, 5, 3, 4, 2} {
+		msg, err := dec.Decode()
+		fatal(err, t)
+		if ping := msg.(*frame.PingMessage); ping.Data != expected {
+			t.Fatalf("unexpected frame order: got %d, expected %d", ping.Data, expected)
+		}
+	}
+}
+
+func TestSessionMaxFrameSize(t *testing.T) {
+	sessA, sessB := newTestPair([]Option{MaxFrameSize(16)}, []Option{MaxPendingAccepts(1)})
+	defer sessA.Close()
+	defer sessB.Close()
+
+	chA, err := sessA.Open(context.Background())
+	fatal(err, t)
+	chB, err := sessB.Accept()
+	fatal(err, t)
+
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	for _, c := range [][2]Channel{{chA, chB}, {chB, chA}} {
+		before, _ := StatsOf(sessB)
+		go func(w Channel) {
+			w.Write(data)
+		}(c[0])
+		b := make([]byte, len(data))
+		_, err := io.ReadFull(c[1], b)
+		fatal(err, t)
+		if !bytes.Equal(b, data) {
+			t.Fatalf("unexpected data: %q", b)
+		}
+		after, _ := StatsOf(sessB)
+		// writes in both directions are fragmented into frames of 16 bytes
+		frames := after.FramesSent + after.FramesReceived - before.FramesSent - before.FramesReceived
+		if frames < 7 {
+			t.Fatalf("expected fragmented frames, got %d", frames)
+		}
+	}
+}
+
+func TestSessionTrace(t *testing.T) {
+	var mu sync.Mutex
+	var events []TraceEvent
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	sessA, sessB := newTestPair([]Option{Trace(func(e TraceEvent) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	})}, []Option{MaxPendingAccepts(1), TraceLogger(logger)})
+	defer sessA.Close()
+	defer sessB.Close()
+
+	ch, err := sessA.Open(context.Background())
+	fatal(err, t)
+	_, err = ch.Write([]byte("Hello world"))
+	fatal(err, t)
+	chB, err := sessB.Accept()
+	fatal(err, t)
+	_, err = io.ReadFull(chB, make([]byte, 11))
+	fatal(err, t)
+
+	mu.Lock()
+	defer mu.Unlock()
+	var sentOpen, receivedConfirm bool
+	for _, e := range events {
+		switch e.Message.(type) {
+		case frame.OpenMessage:
+			sentOpen = e.Direction == Sent && !e.Channel
+		case *frame.OpenConfirmMessage:
+			receivedConfirm = e.Direction == Received && e.Channel
+		}
+	}
+	if !sentOpen || !receivedConfirm {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	if !strings.Contains(logs.String(), "direction=received") {
+		t.Fatalf("unexpected logs: %s", logs.String())
+	}
+}
+
+func TestSessionDrain(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("waits for channels", func(t *testing.T) {
+		sessA, sessB := newTestPair(nil, []Option{MaxPendingAccepts(1)})
+		defer sessB.Close()
+
+		ch, err := sessA.Open(ctx)
+		fatal(err, t)
+		chB, err := sessB.Accept()
+		fatal(err, t)
+
+		drained := make(chan error, 1)
+		go func() {
+			drained <- sessA.Drain(ctx)
+		}()
+
+		// the remote side is told to stop opening channels
+		for {
+			_, err = sessB.Open(ctx)
+			if errors.Is(err, ErrGoAway) {
+				break
+			}
+			if err == nil {
+				t.Fatal("expected open to be refused")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if _, err := sessA.Open(ctx); !errors.Is(err, ErrGoAway) {
+			t.Fatalf("expected go away error: %v", err)
+		}
+
+		select {
+		case err := <-drained:
+			t.Fatalf("drain returned with open channel: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		ch.Close()
+		chB.Close()
+		select {
+		case err := <-drained:
+			fatal(err, t)
+		case <-time.After(time.Second):
+			t.Fatal("drain did not return after channels closed")
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		sessA, sessB := newTestPair(nil, []Option{MaxPendingAccepts(1)})
+		defer sessB.Close()
+
+		_, err := sessA.Open(ctx)
+		fatal(err, t)
+
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		if err := sessA.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline error: %v", err)
+		}
+		if _, err := sessA.Open(context.Background()); err == nil {
+			t.Fatal("expected session to be closed")
+		}
+	})
+}
+
+func TestSessionHandshake(t *testing.T) {
+	t.Run("negotiated", func(t *testing.T) {
+		sessA, sessB := newTestPair(
+			[]Option{Negotiate("cbor", "json"), MaxFrameSize(1024)},
+			[]Option{Negotiate("json", "msgpack", "cbor"), MaxPendingAccepts(1)},
+		)
+		defer sessA.Close()
+		defer sessB.Close()
+
+		n, ok := NegotiatedOf(sessA)
+		if !ok {
+			t.Fatal("expected handshake to complete")
+		}
+		if n.Version != ProtocolVersion || n.Features != supportedFeatures || n.MaxFrameSize != 1024 {
+			t.Fatalf("unexpected negotiation: %+v", n)
+		}
+		if strings.Join(n.Codecs, ",") != "cbor,json" {
+			t.Fatalf("unexpected codecs: %v", n.Codecs)
+		}
+		n, ok = NegotiatedOf(sessB)
+		if !ok || strings.Join(n.Codecs, ",") != "json,cbor" {
+			t.Fatalf("unexpected negotiation: %+v", n)
+		}
+
+		ch, err := sessA.Open(context.Background())
+		fatal(err, t)
+		_, err = sessB.Accept()
+		fatal(err, t)
+		ch.Close()
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		sessA, sessB := newTestPair([]Option{Negotiate()}, nil)
+		defer sessA.Close()
+
+		if err := sessB.Wait(); !errors.Is(err, ErrHandshake) {
+			t.Fatalf("expected handshake error: %v", err)
+		}
+		if _, ok := NegotiatedOf(sessA); ok {
+			t.Fatal("expected handshake to fail")
+		}
+		if _, ok := NegotiatedOf(sessB); ok {
+			t.Fatal("expected no handshake")
+		}
+	})
+}
+
+func TestSessionHardened(t *testing.T) {
+	frames := func(msgs ...frame.Message) []byte {
+		var b []byte
+		for _, msg := range msgs {
+			b = append(b, msg.Bytes()...)
+		}
+		return b
+	}
+	open := frame.OpenMessage{SenderID: 1, WindowSize: 1 << 20, MaxPacketSize: 1024}
+
+	t.Run("too large", func(t *testing.T) {
+		// only the header of the data is sent, which is enough to reject it
+		data := frame.DataMessage{ChannelID: 0, Length: 1 << 30}
+		b := frames(open, data)[:len(open.Bytes())+9]
+		sess := New(fuzzTransport{bytes.NewReader(b)}, Hardened(), MaxFrameSize(1024))
+		go sess.Accept()
+		err := sess.Wait()
+		if !errors.Is(err, ErrProtocol) || !errors.Is(err, frame.ErrTooLarge) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("unknown message", func(t *testing.T) {
+		sess := New(fuzzTransport{bytes.NewReader([]byte{255})})
+		if err := sess.Wait(); !errors.Is(err, ErrProtocol) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("invalid channel", func(t *testing.T) {
+		b := frames(frame.CloseMessage{ChannelID: 7})
+		sess := New(fuzzTransport{bytes.NewReader(b)})
+		if err := sess.Wait(); !errors.Is(err, ErrProtocol) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// fuzzTransport reads input from the remote side and discards
+// what the session writes.
+type fuzzTransport struct {
+	io.Reader
+}
+
+func (fuzzTransport) Write(p []byte) (int, error) { return len(p), nil }
+func (fuzzTransport) Close() error                { return nil }
+
+func FuzzSession(f *testing.F) {
+	frames := func(msgs ...frame.Message) []byte {
+		var b []byte
+		for _, msg := range msgs {
+			b = append(b, msg.Bytes()...)
+		}
+		return b
+	}
+	f.Add(frames(
+		frame.OpenMessage{SenderID: 1, WindowSize: 64, MaxPacketSize: 1024},
+		frame.DataMessage{ChannelID: 0, Length: 5, Data: []byte("hello")},
+		frame.WindowAdjustMessage{ChannelID: 0, AdditionalBytes: 64},
+		frame.EOFMessage{ChannelID: 0},
+		frame.CloseMessage{ChannelID: 0},
+	))
+	f.Add(frames(
+		frame.PingMessage{Data: 1},
+		frame.PongMessage{Data: 1},
+		frame.GoAwayMessage{},
+		frame.OpenConfirmMessage{ChannelID: 0, SenderID: 1, WindowSize: 64, MaxPacketSize: 1024},
+	))
+	f.Add(frames(
+		frame.OpenMessage{SenderID: 1, WindowSize: 1, MaxPacketSize: 1024},
+		frame.DataMessage{ChannelID: 0, Length: 5, Data: []byte("hello")},
+	))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		sess := New(fuzzTransport{bytes.NewReader(b)})
+		go func() {
+			for {
+				ch, err := sess.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(io.Discard, ch)
+					ch.Close()
+				}()
+			}
+		}()
+		done := make(chan struct{})
+		go func() {
+			sess.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("session did not end once its input ended")
+		}
+		sess.Close()
+	})
+}
@@ -0,0 +1,158 @@
+package mux
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// SessionStats is a snapshot of the counters of a session.
+type SessionStats struct {
+	// Started is when the session was created.
+	Started time.Time
+	// BytesSent and BytesReceived count bytes written to and
+	// read from the transport, including framing.
+	BytesSent     uint64
+	BytesReceived uint64
+	// FramesSent and FramesReceived count message frames.
+	FramesSent     uint64
+	FramesReceived uint64
+	// ChannelsOpened counts channels opened by either side and
+	// ChannelsClosed counts those that have since been closed.
+	ChannelsOpened uint64
+	ChannelsClosed uint64
+	// ChannelLifetime is the sum of the lifetimes of closed channels.
+	ChannelLifetime time.Duration
+	// Channels holds the stats of currently open channels.
+	Channels []ChannelStats
+}
+
+// ChannelStats is a snapshot of the counters of a channel.
+type ChannelStats struct {
+	ID uint32
+	// Outbound is true if the channel was opened by this side.
+	Outbound bool
+	// BytesSent and BytesReceived count channel data, excluding framing.
+	BytesSent     uint64
+	BytesReceived uint64
+	// Opened is when the channel was created and Closed is when it
+	// was closed, or the zero time if it is still open.
+	Opened time.Time
+	Closed time.Time
+}
+
+// Lifetime returns how long the channel was open, or has been open so far.
+func (s ChannelStats) Lifetime() time.Duration {
+	if s.Closed.IsZero() {
+		return time.Since(s.Opened)
+	}
+	return s.Closed.Sub(s.Opened)
+}
+
+// StatsOf returns a snapshot of the stats of a session created by New.
+// It returns false for other Session implementations.
+func StatsOf(sess Session) (SessionStats, bool) {
+	s, ok := sess.(*session)
+	if !ok {
+		return SessionStats{}, false
+	}
+	return s.stats.snapshot(s), true
+}
+
+// ChannelStatsOf returns a snapshot of the stats of a channel from a
+// session created by New. It returns false for other Channel implementations.
+func ChannelStatsOf(ch Channel) (ChannelStats, bool) {
+	c, ok := ch.(*channel)
+	if !ok {
+		return ChannelStats{}, false
+	}
+	return c.stats.snapshot(c), true
+}
+
+type sessionStats struct {
+	started         time.Time
+	bytesSent       atomic.Uint64
+	bytesReceived   atomic.Uint64
+	framesSent      atomic.Uint64
+	framesReceived  atomic.Uint64
+	channelsOpened  atomic.Uint64
+	channelsClosed  atomic.Uint64
+	channelLifetime atomic.Int64
+}
+
+func (s *sessionStats) snapshot(sess *session) SessionStats {
+	stats := SessionStats{
+		Started:         s.started,
+		BytesSent:       s.bytesSent.Load(),
+		BytesReceived:   s.bytesReceived.Load(),
+		FramesSent:      s.framesSent.Load(),
+		FramesReceived:  s.framesReceived.Load(),
+		ChannelsOpened:  s.channelsOpened.Load(),
+		ChannelsClosed:  s.channelsClosed.Load(),
+		ChannelLifetime: time.Duration(s.channelLifetime.Load()),
+	}
+	for _, ch := range sess.chans.list() {
+		stats.Channels = append(stats.Channels, ch.stats.snapshot(ch))
+	}
+	return stats
+}
+
+type channelStats struct {
+	opened        atomic.Int64
+	closed        atomic.Int64
+	bytesSent     atomic.Uint64
+	bytesReceived atomic.Uint64
+}
+
+func (s *channelStats) snapshot(ch *channel) ChannelStats {
+	stats := ChannelStats{
+		ID:            ch.localId,
+		Outbound:      ch.direction == channelOutbound,
+		BytesSent:     s.bytesSent.Load(),
+		BytesReceived: s.bytesReceived.Load(),
+	}
+	if opened := s.opened.Load(); opened != 0 {
+		stats.Opened = time.Unix(0, opened)
+	}
+	if closed := s.closed.Load(); closed != 0 {
+		stats.Closed = time.Unix(0, closed)
+	}
+	return stats
+}
+
+// channelOpened records the channel as opened once both sides have
+// agreed to open it.
+func (s *sessionStats) channelOpened(ch *channel) {
+	ch.stats.opened.Store(time.Now().UnixNano())
+	s.channelsOpened.Add(1)
+}
+
+// channelClosed records the channel as closed the first time it is
+// called for a channel that was opened.
+func (s *sessionStats) channelClosed(ch *channel) {
+	opened := ch.stats.opened.Load()
+	now := time.Now().UnixNano()
+	if opened == 0 || !ch.stats.closed.CompareAndSwap(0, now) {
+		return
+	}
+	s.channelsClosed.Add(1)
+	s.channelLifetime.Add(now - opened)
+}
+
+// statsTransport counts bytes written to and read from a transport.
+type statsTransport struct {
+	io.ReadWriteCloser
+	stats *sessionStats
+}
+
+func (t *statsTransport) Read(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Read(p)
+	t.stats.bytesReceived.Add(uint64(n))
+	return n, err
+}
+
+func (t *statsTransport) Write(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Write(p)
+	t.stats.bytesSent.Add(uint64(n))
+	return n, err
+}
@@ -0,0 +1,70 @@
+package mux
+
+import (
+	"context"
+	"log/slog"
+
+	"tractor.dev/toolkit-go/duplex/mux/frame"
+)
+
+// Direction tells whether a traced frame was sent or received.
+type Direction uint8
+
+const (
+	Sent Direction = iota
+	Received
+)
+
+func (d Direction) String() string {
+	if d == Received {
+		return "received"
+	}
+	return "sent"
+}
+
+// TraceEvent describes a frame sent or received by a session.
+type TraceEvent struct {
+	Direction Direction
+	// ChannelID is the ID of the channel the frame is for on the side
+	// receiving it, and is only set if Channel is true.
+	ChannelID uint32
+	Channel   bool
+	Message   frame.Message
+}
+
+// Trace returns an Option that calls fn with every frame sent or received by
+// the session. It is called from the goroutines reading and writing frames, so
+// it should return quickly.
+func Trace(fn func(TraceEvent)) Option {
+	return func(c *config) {
+		c.trace = fn
+	}
+}
+
+// TraceLogger returns an Option that logs every frame sent or received by the
+// session to logger at debug level.
+func TraceLogger(logger *slog.Logger) Option {
+	return Trace(func(e TraceEvent) {
+		if !logger.Enabled(context.Background(), slog.LevelDebug) {
+			return
+		}
+		attrs := []any{slog.String("direction", e.Direction.String())}
+		if e.Channel {
+			attrs = append(attrs, slog.Uint64("channel", uint64(e.ChannelID)))
+		}
+		logger.Debug(e.Message.String(), attrs...)
+	})
+}
+
+func (s *session) trace(dir Direction, msg frame.Message) {
+	if s.config.trace == nil {
+		return
+	}
+	id, isChan := msg.Channel()
+	s.config.trace(TraceEvent{
+		Direction: dir,
+		ChannelID: id,
+		Channel:   isChan,
+		Message:   msg,
+	})
+}
@@ -3,11 +3,24 @@ package mux
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
 	"path"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
 )
 
 func testExchange(t *testing.T, sess Session) {
@@ -98,6 +111,30 @@ func TestTCP(t *testing.T) {
 	testExchange(t, sess)
 }
 
+func TestReverse(t *testing.T) {
+	hub, err := ListenTCP("127.0.0.1:0")
+	fatal(err, t)
+	defer hub.Close()
+
+	reverseRedialInterval = 10 * time.Millisecond
+	l := ListenReverse(func() (Session, error) {
+		return DialTCP(hub.Addr().String())
+	})
+	startListener(t, l)
+
+	// the hub sees the listener dial out again after its session ends
+	out, err := hub.Accept()
+	fatal(err, t)
+	out.Close()
+	out, err = hub.Accept()
+	fatal(err, t)
+	defer out.Close()
+
+	sess, err := DialReverse(context.Background(), out)
+	fatal(err, t)
+	testExchange(t, sess)
+}
+
 func TestUnix(t *testing.T) {
 	tmp := t.TempDir()
 	sockPath := path.Join(tmp, "qmux.sock")
@@ -110,6 +147,37 @@ func TestUnix(t *testing.T) {
 	testExchange(t, sess)
 }
 
+func TestUnixSocketFile(t *testing.T) {
+	sockPath := path.Join(t.TempDir(), "qmux.sock")
+
+	// leave a stale socket file behind
+	l, err := net.Listen("unix", sockPath)
+	fatal(err, t)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	ul, err := ListenUnixMode(sockPath, 0600)
+	fatal(err, t)
+	fi, err := os.Stat(sockPath)
+	fatal(err, t)
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected permissions: %v", fi.Mode().Perm())
+	}
+
+	if _, err := ListenUnix(sockPath); err == nil {
+		t.Fatal("expected socket in use error")
+	}
+	fatal(ul.Close(), t)
+	if _, err := os.Stat(sockPath); !os.IsNotExist(err) {
+		t.Fatalf("expected socket file to be removed: %v", err)
+	}
+
+	fatal(os.WriteFile(sockPath, nil, 0644), t)
+	if _, err := ListenUnix(sockPath); err == nil {
+		t.Fatal("expected error for a file that is not a socket")
+	}
+}
+
 func TestIO(t *testing.T) {
 	pr1, pw1 := io.Pipe()
 	pr2, pw2 := io.Pipe()
@@ -123,6 +191,36 @@ func TestIO(t *testing.T) {
 	testExchange(t, sess)
 }
 
+func TestTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	fatal(err, t)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	fatal(err, t)
+	cert, err := x509.ParseCertificate(der)
+	fatal(err, t)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	l, err := ListenTLS("127.0.0.1:0", ServerTLSConfig(tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil))
+	fatal(err, t)
+	startListener(t, l)
+
+	sess, err := DialTLS(l.Addr().String(), ClientTLSConfig(roots, nil))
+	fatal(err, t)
+	state, ok := TLSConnectionState(sess)
+	if !ok || state.NegotiatedProtocol != ALPNProtocol {
+		t.Fatalf("unexpected connection state: %v %q", ok, state.NegotiatedProtocol)
+	}
+	testExchange(t, sess)
+}
+
 func TestWS(t *testing.T) {
 	l, err := ListenWS("127.0.0.1:0")
 	fatal(err, t)
@@ -132,3 +230,107 @@ func TestWS(t *testing.T) {
 	fatal(err, t)
 	testExchange(t, sess)
 }
+
+func TestH2(t *testing.T) {
+	l, err := ListenH2("127.0.0.1:0")
+	fatal(err, t)
+	defer l.Close()
+
+	closed := make(chan error, 1)
+	go func() {
+		sess, err := l.Accept()
+		if err != nil {
+			closed <- err
+			return
+		}
+		for {
+			ch, err := sess.Accept()
+			if err != nil {
+				closed <- err
+				return
+			}
+			go func() {
+				io.Copy(ch, ch)
+				ch.CloseWrite()
+			}()
+		}
+	}()
+
+	sess, err := DialH2("http://"+l.Addr().String(), nil)
+	fatal(err, t)
+	for i := 0; i < 2; i++ {
+		ch, err := sess.Open(context.Background())
+		fatal(err, t)
+		_, err = ch.Write([]byte("Hello world"))
+		fatal(err, t)
+		fatal(ch.CloseWrite(), t)
+		b, err := ioutil.ReadAll(ch)
+		fatal(err, t)
+		if !bytes.Equal(b, []byte("Hello world")) {
+			t.Fatalf("unexpected bytes: %s", b)
+		}
+		fatal(ch.Close(), t)
+	}
+
+	fatal(sess.Close(), t)
+	if err := <-closed; err != io.EOF {
+		t.Fatalf("expected server session to be closed: %v", err)
+	}
+}
+
+func TestWSHandler(t *testing.T) {
+	accepted := make(chan string, 1)
+	srv := httptest.NewServer(&WSHandler{
+		Handler: func(sess Session) {
+			accepted <- WSSubprotocol(sess)
+			ch, err := sess.Accept()
+			if err != nil {
+				return
+			}
+			io.Copy(ch, ch)
+			ch.CloseWrite()
+			sess.Wait()
+		},
+		Origins:      []string{"https://example.com"},
+		Subprotocols: []string{"qtalk.v2", "qtalk.v1"},
+	})
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	dial := func(origin string, protocols ...string) (*websocket.Conn, error) {
+		config, err := websocket.NewConfig(wsURL, origin)
+		fatal(err, t)
+		config.Protocol = protocols
+		return websocket.DialConfig(config)
+	}
+
+	if _, err := dial("https://evil.example", "qtalk.v1"); err == nil {
+		t.Fatal("expected origin to be refused")
+	}
+	if _, err := dial("https://example.com", "other"); err == nil {
+		t.Fatal("expected subprotocol to be refused")
+	}
+
+	ws, err := dial("https://example.com", "qtalk.v1", "qtalk.v2")
+	fatal(err, t)
+	ws.PayloadType = websocket.BinaryFrame
+	sess := New(ws)
+	defer sess.Close()
+	if p := <-accepted; p != "qtalk.v2" {
+		t.Fatalf("unexpected subprotocol: %q", p)
+	}
+	if p := WSSubprotocol(sess); p != "qtalk.v2" {
+		t.Fatalf("unexpected client subprotocol: %q", p)
+	}
+
+	ch, err := sess.Open(context.Background())
+	fatal(err, t)
+	_, err = ch.Write([]byte("Hello world"))
+	fatal(err, t)
+	fatal(ch.CloseWrite(), t)
+	b, err := ioutil.ReadAll(ch)
+	fatal(err, t)
+	if !bytes.Equal(b, []byte("Hello world")) {
+		t.Fatalf("unexpected bytes: %s", b)
+	}
+}
@@ -2,7 +2,9 @@ package mux
 
 import (
 	"io"
+	"os"
 	"sync"
+	"time"
 )
 
 // buffer provides a linked list buffer for data exchange
@@ -15,7 +17,8 @@ type buffer struct {
 	head *element // the buffer that will be read first
 	tail *element // the buffer that will be read last
 
-	closed bool
+	closed   bool
+	deadline condDeadline
 }
 
 // An element represents a single link in a linked list.
@@ -55,12 +58,25 @@ func (b *buffer) eof() {
 	b.Cond.L.Unlock()
 }
 
+// setDeadline sets the time after which Read fails with
+// os.ErrDeadlineExceeded. A zero time clears the deadline.
+func (b *buffer) setDeadline(t time.Time) {
+	b.Cond.L.Lock()
+	b.deadline.set(b.Cond, t)
+	b.Cond.L.Unlock()
+}
+
 // Read reads data from the internal buffer in buf.  Reads will block
-// if no data is available, or until the buffer is closed.
+// if no data is available, or until the buffer is closed or the
+// deadline passes.
 func (b *buffer) Read(buf []byte) (n int, err error) {
 	b.Cond.L.Lock()
 	defer b.Cond.L.Unlock()
 
+	if b.deadline.expired() {
+		return 0, os.ErrDeadlineExceeded
+	}
+
 	for len(buf) > 0 {
 		// if there is data in b.head, copy it
 		if len(b.head.buf) > 0 {
@@ -86,6 +102,10 @@ func (b *buffer) Read(buf []byte) (n int, err error) {
 			err = io.EOF
 			break
 		}
+		if b.deadline.expired() {
+			err = os.ErrDeadlineExceeded
+			break
+		}
 		// out of buffers, wait for producer
 		b.Cond.Wait()
 	}
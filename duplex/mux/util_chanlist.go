@@ -12,18 +12,20 @@ type chanList struct {
 	chans []*channel
 }
 
-// Assigns a channel ID to the given channel.
-func (c *chanList) add(ch *channel) uint32 {
+// Assigns a channel ID to the given channel. The ID is set as its localId
+// before it is added, so it can be read by others listing the channels.
+func (c *chanList) add(ch *channel) {
 	c.Lock()
 	defer c.Unlock()
 	for i := range c.chans {
 		if c.chans[i] == nil {
+			ch.localId = uint32(i)
 			c.chans[i] = ch
-			return uint32(i)
+			return
 		}
 	}
+	ch.localId = uint32(len(c.chans))
 	c.chans = append(c.chans, ch)
-	return uint32(len(c.chans) - 1)
 }
 
 // getChan returns the channel for the given ID.
@@ -44,6 +46,33 @@ func (c *chanList) remove(id uint32) {
 	c.Unlock()
 }
 
+// count returns the number of channels it knows.
+func (c *chanList) count() int {
+	c.Lock()
+	defer c.Unlock()
+	n := 0
+	for _, ch := range c.chans {
+		if ch != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// list returns the channels it knows in a slice.
+func (c *chanList) list() []*channel {
+	c.Lock()
+	defer c.Unlock()
+	var r []*channel
+
+	for _, ch := range c.chans {
+		if ch != nil {
+			r = append(r, ch)
+		}
+	}
+	return r
+}
+
 // dropAll forgets all channels it knows, returning them in a slice.
 func (c *chanList) dropAll() []*channel {
 	c.Lock()
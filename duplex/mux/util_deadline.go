@@ -0,0 +1,38 @@
+package mux
+
+import (
+	"sync"
+	"time"
+)
+
+// condDeadline wakes the waiters of a sync.Cond once a deadline passes,
+// so they can check expired after each wait. Its methods must be called
+// with the lock of the cond held.
+type condDeadline struct {
+	t     time.Time
+	timer *time.Timer
+}
+
+// set sets the deadline and wakes any waiters so they see the change.
+// A zero time clears the deadline.
+func (d *condDeadline) set(c *sync.Cond, t time.Time) {
+	if d.timer != nil {
+		// a timer that already fired only causes a spurious wakeup
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.t = t
+	if dur := time.Until(t); !t.IsZero() && dur > 0 {
+		d.timer = time.AfterFunc(dur, func() {
+			c.L.Lock()
+			c.Broadcast()
+			c.L.Unlock()
+		})
+	}
+	c.Broadcast()
+}
+
+// expired returns true if the deadline is set and has passed.
+func (d *condDeadline) expired() bool {
+	return !d.t.IsZero() && !time.Now().Before(d.t)
+}
@@ -2,7 +2,9 @@ package mux
 
 import (
 	"io"
+	"os"
 	"sync"
+	"time"
 )
 
 // window represents the buffer available to clients
@@ -12,6 +14,7 @@ type window struct {
 	win          uint32 // RFC 4254 5.2 says the window size can grow to 2^32-1
 	writeWaiters int
 	closed       bool
+	deadline     condDeadline
 }
 
 // add adds win to the amount of window available
@@ -44,18 +47,30 @@ func (w *window) close() {
 	w.L.Unlock()
 }
 
+// setDeadline sets the time after which reserve fails with
+// os.ErrDeadlineExceeded. A zero time clears the deadline.
+func (w *window) setDeadline(t time.Time) {
+	w.L.Lock()
+	w.deadline.set(w.Cond, t)
+	w.L.Unlock()
+}
+
 // reserve reserves win from the available window capacity.
-// If no capacity remains, reserve will block. reserve may
-// return less than requested.
+// If no capacity remains, reserve will block until the deadline
+// passes. reserve may return less than requested.
 func (w *window) reserve(win uint32) (uint32, error) {
 	var err error
 	w.L.Lock()
 	w.writeWaiters++
 	w.Broadcast()
-	for w.win == 0 && !w.closed {
+	for w.win == 0 && !w.closed && !w.deadline.expired() {
 		w.Wait()
 	}
 	w.writeWaiters--
+	if w.deadline.expired() && !w.closed {
+		w.L.Unlock()
+		return 0, os.ErrDeadlineExceeded
+	}
 	if w.win < win {
 		win = w.win
 	}
@@ -0,0 +1,153 @@
+package mux
+
+import (
+	"net"
+	"sync"
+
+	"tractor.dev/toolkit-go/duplex/mux/frame"
+)
+
+// Priority determines the order frames of channels are written in when
+// several channels are sending at once. Frames of a higher priority channel
+// are always written before queued frames of lower priority channels, and
+// frames of the same priority are written in the order they were sent.
+type Priority uint8
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+
+	// priorityControl is used for session frames such as channel
+	// opens and pings, which are written before any channel frames.
+	priorityControl
+)
+
+// SetPriority sets the priority of frames sent on a channel from a session
+// created by New. Channels have PriorityNormal by default. It returns false
+// for other Channel implementations.
+func SetPriority(ch Channel, p Priority) bool {
+	c, ok := ch.(*channel)
+	if !ok {
+		return false
+	}
+	if p > PriorityHigh {
+		p = PriorityHigh
+	}
+	c.priority.Store(uint32(p))
+	return true
+}
+
+type writeRequest struct {
+	msg  frame.Message
+	done chan error
+}
+
+// writer writes frames queued by priority from a single goroutine,
+// so the session loop can queue frames without blocking on the
+// transport while the remote side is writing to it.
+type writer struct {
+	enc   *frame.Encoder
+	stats *sessionStats
+	trace func(Direction, frame.Message)
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues [priorityControl + 1][]writeRequest
+	err    error
+}
+
+func newWriter(enc *frame.Encoder, stats *sessionStats, trace func(Direction, frame.Message)) *writer {
+	w := &writer{enc: enc, stats: stats, trace: trace}
+	w.cond = sync.NewCond(&w.mu)
+	go w.loop()
+	return w
+}
+
+// write queues msg and waits for it to be written.
+func (w *writer) write(msg frame.Message, p Priority) error {
+	done := make(chan error, 1)
+	w.queue(writeRequest{msg: msg, done: done}, p)
+	return <-done
+}
+
+// post queues msg without waiting for it to be written.
+func (w *writer) post(msg frame.Message, p Priority) {
+	w.queue(writeRequest{msg: msg}, p)
+}
+
+func (w *writer) queue(req writeRequest, p Priority) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		if req.done != nil {
+			req.done <- w.err
+		}
+		return
+	}
+	w.queues[p] = append(w.queues[p], req)
+	w.cond.Signal()
+}
+
+// close fails queued and future writes with net.ErrClosed.
+func (w *writer) close() {
+	w.fail(net.ErrClosed)
+}
+
+func (w *writer) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	w.err = err
+	for p, queue := range w.queues {
+		for _, req := range queue {
+			if req.done != nil {
+				req.done <- err
+			}
+		}
+		w.queues[p] = nil
+	}
+	w.cond.Broadcast()
+}
+
+// next blocks until a request is queued and returns the one with the
+// highest priority, or false once the writer has failed.
+func (w *writer) next() (writeRequest, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.err == nil {
+		for p := len(w.queues) - 1; p >= 0; p-- {
+			if len(w.queues[p]) > 0 {
+				req := w.queues[p][0]
+				w.queues[p][0] = writeRequest{}
+				w.queues[p] = w.queues[p][1:]
+				return req, true
+			}
+		}
+		w.cond.Wait()
+	}
+	return writeRequest{}, false
+}
+
+func (w *writer) loop() {
+	for {
+		req, ok := w.next()
+		if !ok {
+			return
+		}
+		err := w.enc.Encode(req.msg)
+		w.stats.framesSent.Add(1)
+		if w.trace != nil {
+			w.trace(Sent, req.msg)
+		}
+		if req.done != nil {
+			req.done <- err
+		}
+		if err != nil {
+			w.fail(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Package pubsub provides publish/subscribe messaging over rpc. A Hub is
+// mounted on a peer at the "pubsub/" selector, and peers use Subscribe and
+// Publish to call it. Values published to a topic are streamed to each
+// subscriber of a matching pattern over the continued response of its call.
+//
+// Topics are segments separated by dots, such as "news.tech". A pattern can
+// use "*" to match any one segment and end with ">" to match one or more
+// remaining segments, so "news.*" and "news.>" both match "news.tech".
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+const (
+	// SubscribeSelector and PublishSelector are the selectors of a Hub
+	// mounted at "pubsub/".
+	SubscribeSelector = "pubsub/subscribe"
+	PublishSelector   = "pubsub/publish"
+
+	defaultBuffer = 64
+)
+
+// ErrSlowSubscriber ends the subscription of a subscriber that falls
+// behind when the Hub uses the Disconnect policy.
+var ErrSlowSubscriber = errors.New("pubsub: subscriber too slow")
+
+// Overflow is what a Hub does when a value is published to a subscriber
+// whose queue is full.
+type Overflow int
+
+const (
+	// DropOldest drops the oldest queued value to make room.
+	DropOldest Overflow = iota
+	// DropNewest drops the published value.
+	DropNewest
+	// Disconnect ends the subscription with ErrSlowSubscriber.
+	Disconnect
+)
+
+type message struct {
+	topic string
+	value any
+}
+
+type publishArgs struct {
+	Topic string
+	Value any
+}
+
+// Hub fans out published values to subscribers. It is an rpc.Handler to be
+// mounted at "pubsub/". The zero value is ready to use.
+type Hub struct {
+	// Buffer is the number of values queued for each subscriber that
+	// has not received them yet. It defaults to 64.
+	Buffer int
+
+	// Overflow is what to do when the queue of a subscriber is full.
+	Overflow Overflow
+
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	pattern string
+	queue   chan message
+	done    chan struct{}
+	once    sync.Once
+	err     error
+}
+
+func (s *subscriber) close(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// Publish sends value to the subscribers of topic and returns the
+// number of subscribers it was sent to.
+func (h *Hub) Publish(topic string, value any) int {
+	h.mu.Lock()
+	var subs []*subscriber
+	for s := range h.subs {
+		if Match(s.pattern, topic) {
+			subs = append(subs, s)
+		}
+	}
+	h.mu.Unlock()
+	for _, s := range subs {
+		h.deliver(s, message{topic: topic, value: value})
+	}
+	return len(subs)
+}
+
+func (h *Hub) deliver(s *subscriber, m message) {
+	select {
+	case s.queue <- m:
+		return
+	default:
+	}
+	switch h.Overflow {
+	case DropNewest:
+	case Disconnect:
+		s.close(ErrSlowSubscriber)
+	default:
+		for {
+			select {
+			case <-s.queue:
+			default:
+			}
+			select {
+			case s.queue <- m:
+				return
+			default:
+			}
+		}
+	}
+}
+
+func (h *Hub) add(pattern string) *subscriber {
+	size := h.Buffer
+	if size <= 0 {
+		size = defaultBuffer
+	}
+	s := &subscriber{
+		pattern: pattern,
+		queue:   make(chan message, size),
+		done:    make(chan struct{}),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[*subscriber]struct{})
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+func (h *Hub) remove(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, s)
+}
+
+// RespondRPC handles subscribe and publish calls.
+func (h *Hub) RespondRPC(r rpc.Responder, c *rpc.Call) {
+	switch {
+	case strings.HasSuffix(c.Selector(), "subscribe"):
+		h.subscribe(r, c)
+	case strings.HasSuffix(c.Selector(), "publish"):
+		var args publishArgs
+		if err := c.Receive(&args); err != nil {
+			r.Return(err)
+			return
+		}
+		if err := validate(args.Topic, false); err != nil {
+			r.Return(err)
+			return
+		}
+		r.Return(h.Publish(args.Topic, args.Value))
+	default:
+		r.Return(fmt.Errorf("pubsub: unknown selector %s", c.Selector()))
+	}
+}
+
+// subscribe streams the values published to a pattern until the caller
+// closes the subscription or it falls behind.
+func (h *Hub) subscribe(r rpc.Responder, c *rpc.Call) {
+	var pattern string
+	if err := c.Receive(&pattern); err != nil {
+		r.Return(err)
+		return
+	}
+	if err := validate(pattern, true); err != nil {
+		r.Return(err)
+		return
+	}
+	s := h.add(pattern)
+	defer h.remove(s)
+
+	ch, err := r.Continue(nil)
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go func() {
+		// the caller sends nothing, so a read returns once it closes
+		io.Copy(io.Discard, ch)
+		s.close(nil)
+	}()
+	for {
+		select {
+		case m := <-s.queue:
+			if err := r.Send(m.topic); err != nil {
+				return
+			}
+			if err := r.Send(m.value); err != nil {
+				return
+			}
+		case <-s.done:
+			if s.err != nil {
+				// an empty topic, which can't be published to, tells
+				// the subscriber it fell behind
+				r.Send("")
+			}
+			return
+		}
+	}
+}
+
+// Subscription is a stream of values published to the topics matching
+// a pattern.
+type Subscription struct {
+	resp *rpc.Response
+}
+
+// Subscribe calls the hub with caller to subscribe to the topics matching
+// pattern.
+func Subscribe(ctx context.Context, caller rpc.Caller, pattern string) (*Subscription, error) {
+	if err := validate(pattern, true); err != nil {
+		return nil, err
+	}
+	resp, err := caller.Call(ctx, SubscribeSelector, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Continue() {
+		return nil, errors.New("pubsub: subscription not continued")
+	}
+	return &Subscription{resp: resp}, nil
+}
+
+// Receive decodes the next published value into v and returns its topic.
+// It returns ErrSlowSubscriber if the hub ended the subscription for
+// falling behind.
+func (s *Subscription) Receive(v any) (topic string, err error) {
+	if err := s.resp.Receive(&topic); err != nil {
+		return "", err
+	}
+	if topic == "" {
+		return "", ErrSlowSubscriber
+	}
+	return topic, s.resp.Receive(v)
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() error {
+	return s.resp.Close()
+}
+
+// Publish calls the hub with caller to publish value to topic, returning
+// the number of subscribers it was sent to.
+func Publish(ctx context.Context, caller rpc.Caller, topic string, value any) (int, error) {
+	if err := validate(topic, false); err != nil {
+		return 0, err
+	}
+	var n int
+	_, err := caller.Call(ctx, PublishSelector, publishArgs{Topic: topic, Value: value}, &n)
+	return n, err
+}
+
+// Match returns true if topic matches pattern.
+func Match(pattern, topic string) bool {
+	ps := strings.Split(pattern, ".")
+	ts := strings.Split(topic, ".")
+	for i, p := range ps {
+		if p == ">" {
+			return len(ts) > i
+		}
+		if i >= len(ts) || (p != "*" && p != ts[i]) {
+			return false
+		}
+	}
+	return len(ps) == len(ts)
+}
+
+// validate checks a topic, or a pattern if wildcards are allowed.
+func validate(topic string, wildcards bool) error {
+	segments := strings.Split(topic, ".")
+	for i, s := range segments {
+		switch {
+		case s == "":
+			return fmt.Errorf("pubsub: empty segment in %q", topic)
+		case (s == "*" || s == ">") && !wildcards:
+			return fmt.Errorf("pubsub: wildcard in topic %q", topic)
+		case s == ">" && i != len(segments)-1:
+			return fmt.Errorf("pubsub: > not at end of pattern %q", topic)
+		}
+	}
+	return nil
+}
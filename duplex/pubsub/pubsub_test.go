@@ -0,0 +1,116 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/mux"
+	"tractor.dev/toolkit-go/duplex/talk"
+)
+
+func fatal(err error, t *testing.T) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, topic string
+		match          bool
+	}{
+		{"news.tech", "news.tech", true},
+		{"news.tech", "news.sports", false},
+		{"news.*", "news.tech", true},
+		{"news.*", "news.tech.ai", false},
+		{"*.tech", "news.tech", true},
+		{"news.>", "news.tech.ai", true},
+		{"news.>", "news", false},
+		{">", "news", true},
+	} {
+		if got := Match(tt.pattern, tt.topic); got != tt.match {
+			t.Errorf("Match(%q, %q) = %v", tt.pattern, tt.topic, got)
+		}
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	a, b := mux.Pair()
+	client := talk.NewPeer(a, codec.JSONCodec{})
+	hub := talk.NewPeer(b, codec.JSONCodec{})
+	defer client.Close()
+	defer hub.Close()
+	var h Hub
+	hub.Handle("pubsub/", &h)
+	go hub.Respond()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sub, err := Subscribe(ctx, client, "news.*")
+	fatal(err, t)
+	defer sub.Close()
+
+	n, err := Publish(ctx, client, "sports.football", "goal")
+	fatal(err, t)
+	if n != 0 {
+		t.Fatal("unexpected subscribers:", n)
+	}
+	n, err = Publish(ctx, client, "news.tech", "hello")
+	fatal(err, t)
+	if n != 1 {
+		t.Fatal("unexpected subscribers:", n)
+	}
+	h.Publish("news.local", "world")
+
+	for _, want := range []string{"news.tech hello", "news.local world"} {
+		var v string
+		topic, err := sub.Receive(&v)
+		fatal(err, t)
+		if got := topic + " " + v; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	if _, err := Publish(ctx, client, "news.*", "x"); err == nil {
+		t.Fatal("expected error publishing to a pattern")
+	}
+
+	// the hub forgets the subscriber once it closes
+	sub.Close()
+	for h.Publish("news.tech", "gone") != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	h := &Hub{Buffer: 2}
+	s := h.add("t")
+	for i := 0; i < 3; i++ {
+		h.Publish("t", i)
+	}
+	if v := (<-s.queue).value; v != 1 {
+		t.Fatal("expected oldest dropped:", v)
+	}
+
+	h = &Hub{Buffer: 2, Overflow: DropNewest}
+	s = h.add("t")
+	for i := 0; i < 3; i++ {
+		h.Publish("t", i)
+	}
+	if v := (<-s.queue).value; v != 0 {
+		t.Fatal("expected newest dropped:", v)
+	}
+
+	h = &Hub{Buffer: 2, Overflow: Disconnect}
+	s = h.add("t")
+	for i := 0; i < 3; i++ {
+		h.Publish("t", i)
+	}
+	<-s.done
+	if s.err != ErrSlowSubscriber {
+		t.Fatal("expected slow subscriber:", s.err)
+	}
+}
@@ -0,0 +1,243 @@
+// Package relay brokers mux sessions between peers that can't reach each
+// other directly, such as when both are behind NAT. Both peers dial out to a
+// relay Server, one registering a name with Listen and the other connecting
+// to that name with Dial, and the relay forwards the frames of the session
+// between them.
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+const (
+	opRegister = "register"
+	opConnect  = "connect"
+)
+
+// request is sent by a peer on a channel it opens to the relay,
+// always encoded as JSON.
+type request struct {
+	Op   string `json:"op"`
+	Peer string `json:"peer"`
+}
+
+// answer is sent back by the relay, always encoded as JSON.
+type answer struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Server is a relay that peers connect to. The zero value is ready to use.
+type Server struct {
+	mu    sync.Mutex
+	peers map[string]mux.Session
+}
+
+// Serve serves the sessions accepted from l until l is closed.
+func (s *Server) Serve(l mux.Listener) error {
+	for {
+		sess, err := l.Accept()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		go s.ServeSession(sess)
+	}
+}
+
+// ServeSession serves the requests of a peer connected to the relay
+// until its session ends.
+func (s *Server) ServeSession(sess mux.Session) {
+	for {
+		ch, err := sess.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(sess, ch)
+	}
+}
+
+func (s *Server) handle(sess mux.Session, ch mux.Channel) {
+	dec := json.NewDecoder(ch)
+	var req request
+	if err := dec.Decode(&req); err != nil {
+		ch.Close()
+		return
+	}
+	switch req.Op {
+	case opRegister:
+		if err := s.register(req.Peer, sess); err != nil {
+			reply(ch, err)
+			ch.Close()
+			return
+		}
+		// the channel stays open for the life of the session
+		reply(ch, nil)
+	case opConnect:
+		peer, ok := s.lookup(req.Peer)
+		if !ok {
+			reply(ch, fmt.Errorf("unknown peer %q", req.Peer))
+			ch.Close()
+			return
+		}
+		dst, err := peer.Open(context.Background())
+		if err != nil {
+			reply(ch, err)
+			ch.Close()
+			return
+		}
+		if err := reply(ch, nil); err != nil {
+			ch.Close()
+			dst.Close()
+			return
+		}
+		join(ch, io.MultiReader(dec.Buffered(), ch), dst)
+	default:
+		reply(ch, fmt.Errorf("unknown op %q", req.Op))
+		ch.Close()
+	}
+}
+
+// register makes sess reachable by name until it ends.
+func (s *Server) register(name string, sess mux.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.peers[name]; ok {
+		return fmt.Errorf("peer %q already registered", name)
+	}
+	if s.peers == nil {
+		s.peers = make(map[string]mux.Session)
+	}
+	s.peers[name] = sess
+	go func() {
+		sess.Wait()
+		s.mu.Lock()
+		if s.peers[name] == sess {
+			delete(s.peers, name)
+		}
+		s.mu.Unlock()
+	}()
+	return nil
+}
+
+func (s *Server) lookup(name string) (mux.Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.peers[name]
+	return sess, ok
+}
+
+func reply(ch mux.Channel, err error) error {
+	var a answer
+	if err != nil {
+		a.Error = err.Error()
+	}
+	return writeJSON(ch, a)
+}
+
+// writeJSON writes v without the newline written by a json.Encoder,
+// since the decoder on the other side stops reading at the end of the
+// value and anything after it is part of the forwarded session.
+func writeJSON(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// join copies between two channels in both directions until both
+// are done, then closes them. Data from a is read from r.
+func join(a mux.Channel, r io.Reader, b mux.Channel) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		io.Copy(a, b)
+		a.CloseWrite()
+		wg.Done()
+	}()
+	go func() {
+		io.Copy(b, r)
+		b.CloseWrite()
+		wg.Done()
+	}()
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
+
+// Listen returns a Listener for sessions dialed through a relay with Dial to
+// the peer name. It connects to the relay with dial and registers name, using
+// mux.ListenReverse to dial the relay again if the connection ends. The
+// accepted sessions are created with opts.
+func Listen(dial func() (mux.Session, error), name string, opts ...mux.Option) mux.Listener {
+	return mux.ListenReverse(func() (mux.Session, error) {
+		sess, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := roundTrip(context.Background(), sess, request{Op: opRegister, Peer: name}); err != nil {
+			sess.Close()
+			return nil, err
+		}
+		return sess, nil
+	}, opts...)
+}
+
+// Dial returns a session to the peer registered as name with Listen on the
+// relay connected to with sess. The session is created with opts.
+func Dial(ctx context.Context, sess mux.Session, name string, opts ...mux.Option) (mux.Session, error) {
+	ch, r, err := roundTrip(ctx, sess, request{Op: opConnect, Peer: name})
+	if err != nil {
+		return nil, err
+	}
+	return mux.New(&relayedChannel{Channel: ch, r: r}, opts...), nil
+}
+
+// roundTrip opens a channel on sess to send req to the relay and returns it
+// once the relay answers, along with a reader for the data that follows.
+func roundTrip(ctx context.Context, sess mux.Session, req request) (mux.Channel, io.Reader, error) {
+	ch, err := sess.Open(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d, ok := ctx.Deadline(); ok {
+		ch.SetDeadline(d)
+		defer ch.SetDeadline(time.Time{})
+	}
+	if err := writeJSON(ch, req); err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+	dec := json.NewDecoder(ch)
+	var a answer
+	if err := dec.Decode(&a); err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+	if a.Error != "" {
+		ch.Close()
+		return nil, nil, fmt.Errorf("relay: %s", a.Error)
+	}
+	return ch, io.MultiReader(dec.Buffered(), ch), nil
+}
+
+// relayedChannel reads from the data left over from the answer of
+// the relay before reading from the channel.
+type relayedChannel struct {
+	mux.Channel
+	r io.Reader
+}
+
+func (c *relayedChannel) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,78 @@
+package relay
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+func fatal(err error, t *testing.T) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	l, err := mux.ListenTCP("127.0.0.1:0")
+	fatal(err, t)
+	defer l.Close()
+	var srv Server
+	go srv.Serve(l)
+
+	dial := func() (mux.Session, error) {
+		return mux.DialTCP(l.Addr().String())
+	}
+	peer := Listen(dial, "peer")
+	defer peer.Close()
+	go func() {
+		sess, err := peer.Accept()
+		if err != nil {
+			return
+		}
+		ch, err := sess.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(ch, ch)
+		ch.Close()
+	}()
+
+	client, err := dial()
+	fatal(err, t)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = Dial(ctx, client, "nobody")
+	if err == nil || !strings.Contains(err.Error(), "unknown peer") {
+		t.Fatalf("expected unknown peer: %v", err)
+	}
+
+	// the peer registers in the background
+	var sess mux.Session
+	for {
+		sess, err = Dial(ctx, client, "peer")
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fatal(err, t)
+	defer sess.Close()
+
+	ch, err := sess.Open(ctx)
+	fatal(err, t)
+	_, err = ch.Write([]byte("Hello world"))
+	fatal(err, t)
+	fatal(ch.CloseWrite(), t)
+	b, err := io.ReadAll(ch)
+	fatal(err, t)
+	if string(b) != "Hello world" {
+		t.Fatalf("unexpected data: %q", b)
+	}
+}
@@ -0,0 +1,208 @@
+package rpc
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+)
+
+// CachingCaller is a Caller that caches the reply values of calls made with
+// another Caller, keyed on the selector and params. It is intended for read-heavy,
+// idempotent selectors.
+//
+// Only calls that return without error and are not continued are cached. Calls
+// with channel params are never cached. Reply values are stored encoded with Codec
+// and decoded into the reply values of later calls with the same selector and params.
+// A later call asking for a reply value that was not stored is made with Caller.
+type CachingCaller struct {
+	Caller Caller
+	Codec  codec.Codec
+
+	// TTL is how long an entry is used before it expires. Zero means entries do not expire.
+	TTL time.Duration
+
+	// MaxEntries is the number of entries kept before the least recently used entry is
+	// evicted. Zero means there is no limit.
+	MaxEntries int
+
+	// Cacheable determines which selectors are cached. If nil, all selectors are cached.
+	Cacheable func(selector string) bool
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type cacheEntry struct {
+	key      string
+	selector string
+	values   [][]byte
+	expires  time.Time
+}
+
+// NewCachingCaller returns a CachingCaller for caller using codec to store reply values.
+func NewCachingCaller(caller Caller, codec codec.Codec, ttl time.Duration, maxEntries int) *CachingCaller {
+	return &CachingCaller{
+		Caller:     caller,
+		Codec:      codec,
+		TTL:        ttl,
+		MaxEntries: maxEntries,
+	}
+}
+
+// Call makes a call using the cached reply values if available, otherwise the
+// call is made with Caller and the reply values are cached.
+func (c *CachingCaller) Call(ctx context.Context, selector string, params any, reply ...any) (*Response, error) {
+	selector = CleanSelector(selector)
+	if c.Cacheable != nil && !c.Cacheable(selector) {
+		return c.Caller.Call(ctx, selector, params, reply...)
+	}
+	if _, isChan := params.(chan interface{}); isChan {
+		return c.Caller.Call(ctx, selector, params, reply...)
+	}
+	key, err := c.key(selector, params)
+	if err != nil {
+		return nil, err
+	}
+	_, values := splitCallOptions(reply)
+
+	if resp, ok, err := c.get(key, values); ok || err != nil {
+		return resp, err
+	}
+
+	resp, err := c.Caller.Call(ctx, selector, params, reply...)
+	if err != nil || resp.Continue() {
+		return resp, err
+	}
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		if v == nil || v == Discard {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := c.Codec.Encoder(&buf).Encode(v); err != nil {
+			return resp, nil
+		}
+		encoded[i] = buf.Bytes()
+	}
+	c.put(&cacheEntry{
+		key:      key,
+		selector: selector,
+		values:   encoded,
+	})
+	return resp, nil
+}
+
+// Invalidate removes all entries for the selector.
+func (c *CachingCaller) Invalidate(selector string) {
+	selector = CleanSelector(selector)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.entries {
+		if el.Value.(*cacheEntry).selector == selector {
+			c.remove(el)
+		}
+	}
+}
+
+// Purge removes all entries.
+func (c *CachingCaller) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+	c.lru = nil
+}
+
+// Len returns the number of entries.
+func (c *CachingCaller) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *CachingCaller) key(selector string, params any) (string, error) {
+	var buf bytes.Buffer
+	if err := c.Codec.Encoder(&buf).Encode(params); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(selector))
+	h.Write([]byte{0})
+	h.Write(buf.Bytes())
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *CachingCaller) get(key string, reply []any) (*Response, bool, error) {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false, nil
+	}
+	e := el.Value.(*cacheEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.remove(el)
+		c.mu.Unlock()
+		return nil, false, nil
+	}
+	for i, v := range reply {
+		if v == nil || v == Discard {
+			continue
+		}
+		if i >= len(e.values) || e.values[i] == nil {
+			// the value wasn't asked for when the entry was stored
+			c.mu.Unlock()
+			return nil, false, nil
+		}
+	}
+	c.lru.MoveToFront(el)
+	c.mu.Unlock()
+
+	for i, v := range reply {
+		if v == nil || v == Discard {
+			continue
+		}
+		if err := c.Codec.Decoder(bytes.NewBuffer(e.values[i])).Decode(v); err != nil {
+			return nil, false, err
+		}
+	}
+	resp := &Response{}
+	if len(reply) == 1 {
+		resp.Value = reply[0]
+	} else if len(reply) > 1 {
+		resp.Value = reply
+	}
+	return resp, true, nil
+}
+
+func (c *CachingCaller) put(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+		c.lru = list.New()
+	}
+	if c.TTL > 0 {
+		e.expires = time.Now().Add(c.TTL)
+	}
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	if c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *CachingCaller) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
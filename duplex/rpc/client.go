@@ -2,14 +2,20 @@ package rpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 
 	"tractor.dev/toolkit-go/duplex/codec"
 	"tractor.dev/toolkit-go/duplex/mux"
 )
 
 // RemoteError is an error that has been returned from
-// the remote side of the RPC connection.
+// the remote side of the RPC connection. If the error was
+// sent with an error code, the returned error wraps a
+// RemoteError and will match the error registered for
+// the code using errors.Is. The ID of the call, to find it
+// in the logs of the remote side, is given by Response.ID.
 type RemoteError string
 
 func (e RemoteError) Error() string {
@@ -20,6 +26,10 @@ func (e RemoteError) Error() string {
 type Client struct {
 	mux.Session
 	codec codec.Codec
+
+	// Trace, if set, is called with each call made and its response, such as
+	// to log the IDs of calls to correlate them with the remote side.
+	Trace func(TraceEvent)
 }
 
 // NewClient takes a session and codec to make a client for making RPC calls.
@@ -33,9 +43,17 @@ func NewClient(session mux.Session, codec codec.Codec) *Client {
 // Call makes synchronous calls to the remote selector passing args and putting the reply
 // value in reply. Both args and reply can be nil. Args can be a channel of interface{}
 // values for asynchronously streaming multiple values from another goroutine, however
-// the call will still block until a response is sent. If there is an error making the call
-// an error is returned, and if an error is returned by the remote handler a RemoteError
-// is returned.
+// the call will still block until a response is sent. Once the channel is closed, the
+// end of the stream is marked so the handler receives io.EOF. If there is an error
+// making the call an error is returned, and if an error is returned by the remote
+// handler a RemoteError is returned.
+//
+// If no reply values are given, returned values are drained and discarded, but remote
+// errors are still returned. Discard can be used as a reply value to skip a value in
+// a particular position of a multi-value return.
+//
+// CallOption values can be given along with reply values to configure the call,
+// such as OnProgress to receive progress values sent before the response.
 //
 // A Response value is also returned for advanced operations. For example, you can check
 // if the call is continued, meaning the underlying channel will be kept open for either
@@ -56,38 +74,66 @@ func (c *Client) Call(ctx context.Context, selector string, args any, reply ...a
 		case <-done:
 		}
 	}()
-	resp, err := call(ctx, ch, c.codec, selector, args, reply...)
+	opts, reply := splitCallOptions(reply)
+	opts.trace = c.Trace
+	resp, err := call(ctx, ch, c.codec, selector, args, opts, reply...)
 	if ctxErr := ctx.Err(); ctxErr != nil {
 		return resp, ctxErr
 	}
 	return resp, err
 }
 
-func call(ctx context.Context, ch mux.Channel, cd codec.Codec, selector string, args any, reply ...any) (*Response, error) {
+func call(ctx context.Context, ch mux.Channel, cd codec.Codec, selector string, args any, opts callOptions, reply ...any) (*Response, error) {
 	framer := &FrameCodec{Codec: cd}
 	enc := framer.Encoder(ch)
 	dec := framer.Decoder(ch)
 
+	id := opts.id
+	if id == "" {
+		id = newCallID()
+	}
+	if opts.trace != nil {
+		opts.trace(TraceEvent{ID: id, Selector: selector})
+	}
+
 	// request
 	err := enc.Encode(CallHeader{
 		S: selector,
+		I: id,
+		Z: opts.compression,
 	})
 	if err != nil {
 		ch.Close()
 		return nil, err
 	}
+	framer.Compression = opts.compression
 
+	// io.EOF from sending args means the handler responded and closed the
+	// channel without reading all of them, so the response can still be read.
 	argCh, isChan := args.(chan interface{})
 	switch {
 	case isChan:
+		err = nil
 		for arg := range argCh {
-			if err := enc.Encode(arg); err != nil {
-				ch.Close()
-				return nil, err
+			if err = enc.Encode(arg); err != nil {
+				break
 			}
 		}
+		if err == nil {
+			err = writeEndOfStream(ch)
+		} else {
+			// unblock the sender of the remaining args
+			go func() {
+				for range argCh {
+				}
+			}()
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			ch.Close()
+			return nil, err
+		}
 	default:
-		if err := enc.Encode(args); err != nil {
+		if err := enc.Encode(args); err != nil && !errors.Is(err, io.EOF) {
 			ch.Close()
 			return nil, err
 		}
@@ -100,14 +146,34 @@ func call(ctx context.Context, ch mux.Channel, cd codec.Codec, selector string,
 		ch.Close()
 		return nil, err
 	}
+	for header.P {
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			ch.Close()
+			return nil, err
+		}
+		if opts.progress != nil {
+			opts.progress(v)
+		}
+		header = ResponseHeader{}
+		if err := dec.Decode(&header); err != nil {
+			ch.Close()
+			return nil, err
+		}
+	}
 
 	if !header.C {
 		defer ch.Close()
 	}
 
+	if opts.trace != nil {
+		opts.trace(TraceEvent{ID: id, Selector: selector, Response: &header})
+	}
+
 	resp := &Response{
 		ResponseHeader: header,
 		Channel:        ch,
+		id:             id,
 		codec:          framer,
 	}
 	if len(reply) == 1 {
@@ -115,16 +181,29 @@ func call(ctx context.Context, ch mux.Channel, cd codec.Codec, selector string,
 	} else if len(reply) > 1 {
 		resp.Value = reply
 	}
-	if resp.Err() != nil {
-		return resp, RemoteError(resp.Err().Error())
+	if err := remoteError(header); err != nil {
+		return resp, err
 	}
 
 	if resp.Value == nil {
-		// read into throwaway buffer
-		var buf []byte
-		dec.Decode(&buf)
+		// no reply values were given, so drain and discard. if the response
+		// was not continued, all values up to the channel close are drained
+		// since the handler may have returned more than one value.
+		if err := discardValue(dec); err != nil {
+			return resp, err
+		}
+		if !header.C {
+			for discardValue(dec) == nil {
+			}
+		}
 	} else {
 		for _, r := range reply {
+			if r == nil || r == Discard {
+				if err := discardValue(dec); err != nil {
+					return resp, err
+				}
+				continue
+			}
 			if err := dec.Decode(r); err != nil {
 				return resp, err
 			}
@@ -133,3 +212,62 @@ func call(ctx context.Context, ch mux.Channel, cd codec.Codec, selector string,
 
 	return resp, nil
 }
+
+// Discard can be passed as a reply value to Call to skip decoding the
+// return value in that position. Passing nil has the same effect.
+var Discard any = discardReply{}
+
+type discardReply struct{}
+
+// discardValue decodes the next value and throws it away.
+func discardValue(dec codec.Decoder) error {
+	var v any
+	return dec.Decode(&v)
+}
+
+// CallOption configures a call when given to Call along with reply values.
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	progress    func(v any)
+	id          string
+	compression string
+	trace       func(TraceEvent)
+}
+
+// WithCompression returns a CallOption that compresses values sent in both directions
+// after the call is made, including streamed values of a continued call, using the
+// named codec.Compressor. The Compressor must be registered on both sides.
+func WithCompression(name string) CallOption {
+	return func(o *callOptions) {
+		o.compression = name
+	}
+}
+
+// WithCallID returns a CallOption that uses id as the call ID instead of
+// generating a new one, which is useful to correlate calls across hops.
+func WithCallID(id string) CallOption {
+	return func(o *callOptions) {
+		o.id = id
+	}
+}
+
+// OnProgress returns a CallOption that calls fn with each progress value
+// sent by the handler before it responds.
+func OnProgress(fn func(v any)) CallOption {
+	return func(o *callOptions) {
+		o.progress = fn
+	}
+}
+
+// splitCallOptions separates CallOption values from reply values.
+func splitCallOptions(values []any) (opts callOptions, reply []any) {
+	for _, v := range values {
+		if opt, ok := v.(CallOption); ok {
+			opt(&opts)
+			continue
+		}
+		reply = append(reply, v)
+	}
+	return
+}
@@ -0,0 +1,27 @@
+package rpc
+
+import (
+	"net"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+// Conn returns the underlying channel of a continued response as a net.Conn.
+func (r *Response) Conn() net.Conn {
+	return NewConn(r.Channel)
+}
+
+// Conn returns the underlying channel of a call as a net.Conn, which
+// should only be used after the response is continued.
+func (c *Call) Conn() net.Conn {
+	return NewConn(c.Channel)
+}
+
+// NewConn returns a channel used as a byte stream, such as with a continued call, as a
+// net.Conn. Channels already satisfy net.Conn, including deadlines and a CloseWrite method.
+func NewConn(ch mux.Channel) net.Conn {
+	return ch
+}
+
+// Addr is the address of a channel used as a net.Conn.
+type Addr = mux.Addr
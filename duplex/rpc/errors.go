@@ -0,0 +1,150 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// ErrorCoder is implemented by errors that provide their own error code
+// to send to the caller.
+type ErrorCoder interface {
+	ErrorCode() string
+}
+
+// ErrorDetailer is implemented by errors that provide structured details
+// to send to the caller.
+type ErrorDetailer interface {
+	ErrorDetails() any
+}
+
+type errorEntry struct {
+	code string
+	err  error
+}
+
+var errorRegistry struct {
+	sync.RWMutex
+	entries []errorEntry
+}
+
+func init() {
+	RegisterError("not_exist", os.ErrNotExist)
+	RegisterError("exist", os.ErrExist)
+	RegisterError("permission", os.ErrPermission)
+	RegisterError("deadline_exceeded", context.DeadlineExceeded)
+	RegisterError("canceled", context.Canceled)
+	RegisterError("closed", net.ErrClosed)
+	RegisterError("unexpected_eof", io.ErrUnexpectedEOF)
+	RegisterError("eof", io.EOF)
+	RegisterError("invalid_params", ErrInvalidParams)
+}
+
+// RegisterError registers err as the error for code. Errors returned by handlers
+// that match err with errors.Is are sent to the caller with code, and remote errors
+// received with code will match err with errors.Is. Registering a code again
+// replaces the error for that code.
+func RegisterError(code string, err error) {
+	if code == "" || err == nil {
+		panic("rpc: invalid error registration")
+	}
+	errorRegistry.Lock()
+	defer errorRegistry.Unlock()
+	for i, e := range errorRegistry.entries {
+		if e.code == code {
+			errorRegistry.entries[i].err = err
+			return
+		}
+	}
+	errorRegistry.entries = append(errorRegistry.entries, errorEntry{code: code, err: err})
+}
+
+// ErrorCode returns the error code for err. If err or an error it wraps implements
+// ErrorCoder, that code is used, otherwise the code of the first registered
+// error matching err is used. An empty string is returned if there is no code.
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var coder ErrorCoder
+	if errors.As(err, &coder) {
+		return coder.ErrorCode()
+	}
+	errorRegistry.RLock()
+	defer errorRegistry.RUnlock()
+	for _, e := range errorRegistry.entries {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return ""
+}
+
+// ErrorDetails returns the details of err if it or an error it wraps implements
+// ErrorDetailer, otherwise nil. Remote errors received with details implement
+// ErrorDetailer, though the details are decoded as empty interface values.
+func ErrorDetails(err error) any {
+	var detailer ErrorDetailer
+	if errors.As(err, &detailer) {
+		return detailer.ErrorDetails()
+	}
+	return nil
+}
+
+func registeredError(code string) error {
+	errorRegistry.RLock()
+	defer errorRegistry.RUnlock()
+	for _, e := range errorRegistry.entries {
+		if e.code == code {
+			return e.err
+		}
+	}
+	return nil
+}
+
+// codedError is a RemoteError received with an error code or details. It will
+// match the error registered for the code.
+type codedError struct {
+	RemoteError
+	code    string
+	details any
+	err     error
+}
+
+func (e *codedError) ErrorCode() string {
+	return e.code
+}
+
+func (e *codedError) ErrorDetails() any {
+	return e.details
+}
+
+func (e *codedError) Unwrap() []error {
+	if e.err == nil {
+		return []error{e.RemoteError}
+	}
+	return []error{e.RemoteError, e.err}
+}
+
+// remoteError returns the error for a response header with an error.
+func remoteError(header ResponseHeader) error {
+	if header.E == nil {
+		return nil
+	}
+	err := RemoteError(*header.E)
+	if header.K == nil && header.D == nil {
+		return err
+	}
+	coded := &codedError{
+		RemoteError: err,
+		details:     header.D,
+	}
+	if header.K != nil {
+		coded.code = *header.K
+		coded.err = registeredError(*header.K)
+	}
+	return coded
+}
@@ -3,15 +3,50 @@ package rpc
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 
 	"tractor.dev/toolkit-go/duplex/codec"
 )
 
+// frameCompressed is set on the length prefix of compressed frames.
+const frameCompressed = 1 << 31
+
+// ErrFrameTooLarge is returned by frame decoders for a frame larger than
+// the MaxSize of the FrameCodec.
+var ErrFrameTooLarge = errors.New("rpc: frame too large")
+
+// preallocFrameSize is the most allocated up front to read a frame. Larger
+// frames are allocated as they arrive, so a corrupt length prefix can't
+// allocate much more memory than the data actually sent.
+const preallocFrameSize = 64 << 10
+
+// errEndOfStream is returned by frame decoders when an end-of-stream
+// marker is received, which is encoded as a zero length frame.
+var errEndOfStream = errors.New("rpc: end of stream")
+
+// writeEndOfStream writes an end-of-stream marker, which is a zero
+// length frame since codecs never encode a value as zero bytes.
+func writeEndOfStream(w io.Writer) error {
+	_, err := w.Write(make([]byte, 4))
+	return err
+}
+
 // FrameCodec is a special codec used to actually read/write other
 // codecs to a transport using a length prefix.
+//
+// If Compression is set to the name of a Compressor registered with
+// codec.RegisterCompressor, encoded values large enough to benefit are
+// compressed and marked as compressed using the highest bit of the length
+// prefix.
+//
+// If MaxSize is set, decoding a frame larger than MaxSize bytes, or that
+// decompresses to more than MaxSize bytes, returns ErrFrameTooLarge.
 type FrameCodec struct {
 	codec.Codec
+	Compression string
+	MaxSize     int
 }
 
 // Encoder returns a frame encoder that first encodes a value
@@ -20,26 +55,34 @@ type FrameCodec struct {
 // the given Writer.
 func (c *FrameCodec) Encoder(w io.Writer) codec.Encoder {
 	return &frameEncoder{
-		w: w,
-		c: c.Codec,
+		w:      w,
+		framer: c,
 	}
 }
 
 type frameEncoder struct {
-	w io.Writer
-	c codec.Codec
+	w      io.Writer
+	framer *FrameCodec
 }
 
 func (e *frameEncoder) Encode(v interface{}) error {
 	var buf bytes.Buffer
-	enc := e.c.Encoder(&buf)
+	enc := e.framer.Codec.Encoder(&buf)
 	err := enc.Encode(v)
 	if err != nil {
 		return err
 	}
 	b := buf.Bytes()
+	size := uint32(len(b))
+	if e.framer.Compression != "" && len(b) >= codec.CompressThreshold {
+		b, err = codec.Compress(e.framer.Compression, b)
+		if err != nil {
+			return err
+		}
+		size = uint32(len(b)) | frameCompressed
+	}
 	prefix := make([]byte, 4)
-	binary.BigEndian.PutUint32(prefix, uint32(len(b)))
+	binary.BigEndian.PutUint32(prefix, size)
 	_, err = e.w.Write(append(prefix, b...))
 	if err != nil {
 		return err
@@ -52,32 +95,112 @@ func (e *frameEncoder) Encode(v interface{}) error {
 // embedded codec to decode those bytes into a value.
 func (c *FrameCodec) Decoder(r io.Reader) codec.Decoder {
 	return &frameDecoder{
-		r: r,
-		c: c.Codec,
+		r:      r,
+		framer: c,
 	}
 }
 
 type frameDecoder struct {
-	r io.Reader
-	c codec.Codec
+	r      io.Reader
+	framer *FrameCodec
+
+	peeked []byte
+	// frame is the peeked frame as it was read, including the length prefix
+	frame []byte
 }
 
 func (d *frameDecoder) Decode(v interface{}) error {
+	buf, _, err := d.next()
+	if err != nil {
+		return err
+	}
+	d.peeked, d.frame = nil, nil
+	return d.decode(buf, v)
+}
+
+// Peek decodes the next frame into v without consuming it, so
+// the next call to Decode or Peek will decode the same frame.
+func (d *frameDecoder) Peek(v interface{}) error {
+	buf, frame, err := d.next()
+	if err != nil {
+		return err
+	}
+	d.peeked, d.frame = buf, frame
+	return d.decode(buf, v)
+}
+
+// replay puts the peeked frame back in front of the underlying reader and
+// returns it, so the frame can be read again directly from the reader as well
+// as decoded. The returned reader must be used in place of the underlying one.
+func (d *frameDecoder) replay() io.Reader {
+	if d.frame != nil {
+		d.r = io.MultiReader(bytes.NewReader(d.frame), d.r)
+		d.peeked, d.frame = nil, nil
+	}
+	return d.r
+}
+
+// next returns the bytes of the peeked frame or reads the next frame,
+// along with the frame as it was read.
+func (d *frameDecoder) next() ([]byte, []byte, error) {
+	if d.peeked != nil {
+		return d.peeked, d.frame, nil
+	}
 	prefix := make([]byte, 4)
 	_, err := io.ReadFull(d.r, prefix)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	size := binary.BigEndian.Uint32(prefix)
-	buf := make([]byte, size)
-	_, err = io.ReadFull(d.r, buf)
+	if size == 0 {
+		return nil, nil, errEndOfStream
+	}
+	n := int64(size &^ frameCompressed)
+	if max := int64(d.framer.MaxSize); max > 0 && n > max {
+		return nil, nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, n)
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(prefix) + int(min(n, preallocFrameSize)))
+	buf.Write(prefix)
+	read, err := io.CopyN(&buf, d.r, n)
+	if err == io.EOF && read > 0 {
+		err = io.ErrUnexpectedEOF
+	}
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	frame := buf.Bytes()
+	if size&frameCompressed != 0 {
+		b, err := d.decompress(frame[len(prefix):])
+		return b, frame, err
+	}
+	return frame[len(prefix):], frame, nil
+}
+
+// decompress decompresses a frame, limited to MaxSize bytes if set.
+func (d *frameDecoder) decompress(b []byte) ([]byte, error) {
+	if d.framer.MaxSize <= 0 {
+		return codec.Decompress(d.framer.Compression, b)
+	}
+	c, ok := codec.LookupCompressor(d.framer.Compression)
+	if !ok {
+		return nil, fmt.Errorf("codec: unknown compression: %s", d.framer.Compression)
 	}
-	dec := d.c.Decoder(bytes.NewBuffer(buf))
-	err = dec.Decode(v)
+	r, err := c.Decompress(bytes.NewReader(b))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	buf, err := io.ReadAll(io.LimitReader(r, int64(d.framer.MaxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > d.framer.MaxSize {
+		return nil, fmt.Errorf("%w: decompresses to more than %d bytes", ErrFrameTooLarge, d.framer.MaxSize)
+	}
+	return buf, nil
+}
+
+func (d *frameDecoder) decode(buf []byte, v interface{}) error {
+	dec := d.framer.Codec.Decoder(bytes.NewBuffer(buf))
+	return dec.Decode(v)
 }
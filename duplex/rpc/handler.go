@@ -1,10 +1,15 @@
 package rpc
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"sort"
 	"strings"
 	"sync"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/mux"
 )
 
 // A Handler responds to an RPC request.
@@ -47,10 +52,20 @@ func NotFoundHandler() Handler {
 //
 // Since RespondMux is also a Handler, you can use them for submuxing. If a pattern matches a handler that
 // is a RespondMux, it will trim the matching selector prefix before matching against the sub RespondMux.
+//
+// Default handler timeouts can be set for selectors or patterns with SetTimeout, and
+// params can be checked before calling handlers with SetValidator.
 type RespondMux struct {
-	m  map[string]muxEntry
-	es []muxEntry // slice of entries sorted from longest to shortest.
-	mu sync.RWMutex
+	m       map[string]muxEntry
+	es      []muxEntry // slice of entries sorted from longest to shortest.
+	configs map[string]selectorConfig
+	mu      sync.RWMutex
+}
+
+// selectorConfig holds settings for calls matching a selector or pattern.
+type selectorConfig struct {
+	timeout   time.Duration
+	validator Validator
 }
 
 type muxEntry struct {
@@ -62,8 +77,9 @@ type matcher interface {
 	Match(selector string) (h Handler, pattern string)
 }
 
-// cleanSelector returns the canonical selector for s, normalizing . separators to /.
-func cleanSelector(s string) string {
+// CleanSelector returns the canonical form of a selector as seen by handlers,
+// with a leading slash and dots replaced by slashes.
+func CleanSelector(s string) string {
 	if s == "" {
 		return "/"
 	}
@@ -78,11 +94,129 @@ func cleanSelector(s string) string {
 func NewRespondMux() *RespondMux { return new(RespondMux) }
 
 // RespondRPC dispatches the call to the handler whose pattern most closely matches the selector.
+// If a Validator is set for the selector or the matching pattern, the params are validated
+// before calling the handler. If a timeout is set for the selector or the matching pattern, the
+// handler is run with a Call Context using that timeout. If the handler has not responded when
+// the timeout expires, a context.DeadlineExceeded error is returned to the caller.
 func (m *RespondMux) RespondRPC(r Responder, c *Call) {
 	h, _ := m.Handler(c)
+	cfg := m.config(c.Selector())
+	if cfg.validator != nil {
+		var params any
+		if err := c.Peek(&params); err != nil {
+			r.Return(err)
+			return
+		}
+		if err := cfg.validator.Validate(params); err != nil {
+			r.Return(&ValidationError{Selector: c.Selector(), Err: err})
+			return
+		}
+		// handlers like ProxyHandler read the params from the channel directly
+		if d, ok := c.Decoder.(interface{ replay() io.Reader }); ok {
+			c.Channel = &replayChannel{Channel: c.Channel, r: d.replay()}
+		}
+	}
+	if cfg.timeout > 0 {
+		respondTimeout(h, r, c, cfg.timeout)
+		return
+	}
 	h.RespondRPC(r, c)
 }
 
+// SetTimeout sets the default timeout for handling calls matching the selector or pattern.
+// A zero duration removes the timeout.
+func (m *RespondMux) SetTimeout(pattern string, d time.Duration) {
+	m.configure(pattern, func(cfg *selectorConfig) {
+		cfg.timeout = d
+	})
+}
+
+// SetValidator sets a Validator used to check the params of calls matching the selector or
+// pattern before the handler is called. A nil Validator removes the validator.
+func (m *RespondMux) SetValidator(pattern string, v Validator) {
+	m.configure(pattern, func(cfg *selectorConfig) {
+		cfg.validator = v
+	})
+}
+
+func (m *RespondMux) configure(pattern string, fn func(cfg *selectorConfig)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pattern = CleanSelector(pattern)
+	if m.configs == nil {
+		m.configs = make(map[string]selectorConfig)
+	}
+	cfg := m.configs[pattern]
+	fn(&cfg)
+	m.configs[pattern] = cfg
+}
+
+// config returns the settings for the selector, using settings of a submux
+// handling it for any not set for the selector, then settings of the pattern
+// matching it.
+func (m *RespondMux) config(selector string) selectorConfig {
+	selector = CleanSelector(selector)
+
+	m.mu.RLock()
+	cfg := m.configs[selector]
+	pattern, sub, rest := m.entry(selector)
+	patternCfg := m.configs[pattern]
+	m.mu.RUnlock()
+
+	if sub != nil {
+		cfg = cfg.or(sub.config(rest))
+	}
+	return cfg.or(patternCfg)
+}
+
+// entry returns the pattern of this mux matching the selector and, if it is
+// handled by a submux, the submux and the selector with the pattern trimmed.
+func (m *RespondMux) entry(selector string) (pattern string, sub *RespondMux, rest string) {
+	if v, ok := m.m[selector]; ok {
+		return v.pattern, nil, ""
+	}
+	for _, e := range m.es {
+		if strings.HasPrefix(selector, e.pattern) {
+			sub, _ := e.h.(*RespondMux)
+			return e.pattern, sub, strings.TrimPrefix(selector, e.pattern)
+		}
+	}
+	return "", nil, ""
+}
+
+// or returns cfg with settings not set taken from other.
+func (cfg selectorConfig) or(other selectorConfig) selectorConfig {
+	if cfg.timeout <= 0 {
+		cfg.timeout = other.timeout
+	}
+	if cfg.validator == nil {
+		cfg.validator = other.validator
+	}
+	return cfg
+}
+
+// respondTimeout runs the handler with a timeout, returning a deadline error if
+// the handler has not responded in time. The handler is left to finish on its own.
+func respondTimeout(h Handler, r Responder, c *Call, d time.Duration) {
+	ctx, cancel := context.WithTimeout(c.Context, d)
+	defer cancel()
+	c.Context = ctx
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.RespondRPC(r, c)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// errors if the handler already responded, which is fine
+		r.Return(fmt.Errorf("%s: %w", c.Selector(), ctx.Err()))
+	}
+}
+
 // Handler returns the handler to use for the given call, consulting
 // c.Selector(). It always returns a non-nil handler.
 //
@@ -105,7 +239,7 @@ func (m *RespondMux) Remove(selector string) (h Handler) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	selector = cleanSelector(selector)
+	selector = CleanSelector(selector)
 	h = m.m[selector].h
 	delete(m.m, selector)
 
@@ -117,7 +251,7 @@ func (m *RespondMux) Remove(selector string) (h Handler) {
 // is a submux, it will call Match with the selector minus the
 // pattern.
 func (m *RespondMux) Match(selector string) (h Handler, pattern string) {
-	selector = cleanSelector(selector)
+	selector = CleanSelector(selector)
 
 	// Check for exact match first.
 	v, ok := m.m[selector]
@@ -145,7 +279,7 @@ func (m *RespondMux) Handle(pattern string, handler Handler) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	pattern = cleanSelector(pattern)
+	pattern = CleanSelector(pattern)
 	if _, ok := handler.(matcher); ok && pattern[len(pattern)-1] != '/' {
 		pattern = pattern + "/"
 	}
@@ -181,3 +315,14 @@ func appendSorted(es []muxEntry, e muxEntry) []muxEntry {
 	es[i] = e
 	return es
 }
+
+// replayChannel is a channel read from a reader with data already read from
+// the channel in front of it.
+type replayChannel struct {
+	mux.Channel
+	r io.Reader
+}
+
+func (c *replayChannel) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
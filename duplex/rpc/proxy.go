@@ -1,6 +1,12 @@
 package rpc
 
-import "io"
+import (
+	"fmt"
+	"io"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/mux"
+)
 
 // ProxyHandler returns a handler that tries its best to proxy the
 // call to the dst Client, regardless of call style and assuming the
@@ -12,28 +18,60 @@ func ProxyHandler(dst *Client) Handler {
 			r.Return(err)
 			return
 		}
+		ProxyCall(r, c, ch, dst.codec)
+	})
+}
 
-		framer := &FrameCodec{Codec: dst.codec}
-		enc := framer.Encoder(ch)
-		err = enc.Encode(CallHeader{
-			S: c.Selector(),
-		})
-		if err != nil {
-			ch.Close()
-			r.Return(err)
-			return
+// ProxyCall proxies the call to a channel opened on another session whose
+// remote side responds with codec, like ProxyHandler. The responder can wrap
+// the responder given to the handler if it has an Unwrap method returning it.
+// If the call can't be proxied, the channel is closed and the error is
+// returned, after returning it to the caller if the call has not been
+// responded to.
+func ProxyCall(r Responder, c *Call, ch mux.Channel, codec codec.Codec) error {
+	resp := r
+	for {
+		u, ok := resp.(interface{ Unwrap() Responder })
+		if !ok {
+			break
 		}
+		resp = u.Unwrap()
+	}
+	inner, ok := resp.(*responder)
+	if !ok {
+		ch.Close()
+		err := fmt.Errorf("rpc: can't proxy with responder %T", resp)
+		r.Return(err)
+		return err
+	}
 
-		go func() {
-			io.Copy(ch, c.Channel)
-			ch.CloseWrite()
-		}()
-		go func() {
-			io.Copy(c.Channel, ch)
-			c.Channel.Close()
-		}()
-
-		r.(*responder).responded = true
-		r.(*responder).header.C = true
+	framer := &FrameCodec{Codec: codec}
+	enc := framer.Encoder(ch)
+	err := enc.Encode(CallHeader{
+		S: c.Selector(),
+		I: c.ID(),
+		Z: c.Z,
 	})
+	if err != nil {
+		ch.Close()
+		r.Return(err)
+		return err
+	}
+
+	// the response is copied from the channel, so the responder must not
+	// respond itself, such as when the handler times out
+	if err := inner.proxied(); err != nil {
+		ch.Close()
+		return err
+	}
+
+	go func() {
+		io.Copy(ch, c.Channel)
+		ch.CloseWrite()
+	}()
+	go func() {
+		io.Copy(c.Channel, ch)
+		c.Channel.Close()
+	}()
+	return nil
 }
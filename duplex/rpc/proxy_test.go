@@ -32,6 +32,36 @@ func TestProxyHandlerUnaryRPC(t *testing.T) {
 	}
 }
 
+func TestProxyHandlerValidated(t *testing.T) {
+	ctx := context.Background()
+
+	backmux := NewRespondMux()
+	backmux.Handle("echo", HandlerFunc(func(r Responder, c *Call) {
+		var in string
+		fatal(t, c.Receive(&in))
+		r.Return(in)
+	}))
+
+	backend, _ := newTestPair(backmux)
+	defer backend.Close()
+
+	frontmux := NewRespondMux()
+	frontmux.Handle("", ProxyHandler(backend))
+	frontmux.SetValidator("", ValidatorFunc(func(params any) error {
+		return nil
+	}))
+
+	client, _ := newTestPair(frontmux)
+	defer client.Close()
+
+	var out string
+	_, err := client.Call(ctx, "echo", "hello", &out)
+	fatal(t, err)
+	if out != "hello" {
+		t.Fatal("unexpected return:", out)
+	}
+}
+
 func TestProxyHandlerBytestream(t *testing.T) {
 	ctx := context.Background()
 
@@ -64,3 +94,37 @@ func TestProxyHandlerBytestream(t *testing.T) {
 		t.Fatal("unexpected return data:", string(b))
 	}
 }
+
+// wrappedResponder wraps a Responder without an Unwrap method.
+type wrappedResponder struct {
+	Responder
+}
+
+func TestProxyCallUnknownResponder(t *testing.T) {
+	ctx := context.Background()
+
+	backend, _ := newTestPair(NewRespondMux())
+	defer backend.Close()
+
+	proxyErr := make(chan error, 1)
+	frontmux := NewRespondMux()
+	frontmux.Handle("", HandlerFunc(func(r Responder, c *Call) {
+		ch, err := backend.Session.Open(c.Context)
+		if err != nil {
+			r.Return(err)
+			return
+		}
+		proxyErr <- ProxyCall(&wrappedResponder{r}, c, ch, backend.codec)
+	}))
+
+	client, _ := newTestPair(frontmux)
+	defer client.Close()
+
+	_, err := client.Call(ctx, "simple", nil, nil)
+	if err == nil {
+		t.Fatal("expected error from call")
+	}
+	if err := <-proxyErr; err == nil {
+		t.Fatal("expected error from ProxyCall")
+	}
+}
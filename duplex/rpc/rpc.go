@@ -2,7 +2,12 @@ package rpc
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
+	"sync"
 
 	"github.com/mitchellh/mapstructure"
 	"tractor.dev/toolkit-go/duplex/codec"
@@ -17,7 +22,7 @@ import (
 // the call will still block until a response is sent. If there is an error making the call
 // an error is returned, and if an error is returned by the remote handler a RemoteError
 // is returned. Multiple reply parameters can be provided in order to receive multi-valued
-// returns from the remote call.
+// returns from the remote call. CallOption values can also be given with reply parameters.
 //
 // A Response is also returned for advanced operations. For example, you can check
 // if the call is continued, meaning the underlying channel will be kept open for either
@@ -26,9 +31,30 @@ type Caller interface {
 	Call(ctx context.Context, selector string, params any, reply ...any) (*Response, error)
 }
 
+// TraceEvent describes a call made by a Client or handled by a Server, given
+// to their Trace function once for the call and once for its response.
+type TraceEvent struct {
+	// ID and Selector identify the call.
+	ID       string
+	Selector string
+	// Response is the header of the response, or nil for the call.
+	Response *ResponseHeader
+}
+
 // CallHeader is the first value encoded over the channel to make a call.
 type CallHeader struct {
 	S string // Selector
+	I string // ID: identifies the call for correlation across peers
+	Z string // Compression: used for values after the call header in both directions
+}
+
+// newCallID returns a random identifier for a call.
+func newCallID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
 }
 
 // Call is used on the responding side of a call and is passed to the handler.
@@ -41,27 +67,71 @@ type Call struct {
 	Context context.Context
 
 	mux.Channel
+
+	session *Session
 }
 
 func (c *Call) Selector() string {
 	return c.S
 }
 
+// Session returns the session the call was received on, with values that
+// handlers can store for later calls on the same session. It returns nil
+// for calls not received by a Server.
+func (c *Call) Session() *Session {
+	return c.session
+}
+
+// ID returns the identifier given to the call by the caller.
+func (c *Call) ID() string {
+	return c.I
+}
+
 // Receive will decode an incoming value from the underlying channel. It can be
 // called more than once when multiple values are expected, but should always be
 // called once in a handler. It can be called with nil to discard the value.
+// It returns io.EOF when the caller ends the stream of values or closes the channel.
 func (c *Call) Receive(v interface{}) error {
 	if v == nil {
 		var discard []byte
 		v = &discard
 	}
-	return c.Decoder.Decode(v)
+	err := c.Decoder.Decode(v)
+	if err == errEndOfStream {
+		return io.EOF
+	}
+	return err
+}
+
+// Peek decodes the next incoming value like Receive, but without consuming it, so the
+// next call to Receive or Peek decodes the same value. It returns an error if the
+// Decoder does not support peeking.
+func (c *Call) Peek(v interface{}) error {
+	p, ok := c.Decoder.(interface{ Peek(v interface{}) error })
+	if !ok {
+		return errors.New("rpc: decoder does not support peek")
+	}
+	return p.Peek(v)
 }
 
 // ResponseHeader is the value encoded over the channel to indicate a response.
 type ResponseHeader struct {
 	E *string // Error
 	C bool    // Continue: after parsing response, keep stream open for whatever protocol
+	P bool    // Progress: a progress value follows, then another response header
+	K *string // Error code: identifies the error for matching on the calling side
+	D any     // Error details: structured data describing the error
+}
+
+func (h ResponseHeader) String() string {
+	var e, k string
+	if h.E != nil {
+		e = *h.E
+	}
+	if h.K != nil {
+		k = *h.K
+	}
+	return fmt.Sprintf("{E:%q C:%v P:%v K:%q D:%v}", e, h.C, h.P, k, h.D)
 }
 
 // Response is used on the calling side to represent a response and allow access
@@ -73,7 +143,14 @@ type Response struct {
 	Value   any
 	Channel mux.Channel
 
+	id    string
 	codec codec.Codec
+	ended bool
+}
+
+// ID returns the identifier of the call this is a response to.
+func (r *Response) ID() string {
+	return r.id
 }
 
 func (r *Response) Err() error {
@@ -93,15 +170,45 @@ func (r *Response) Send(v interface{}) error {
 }
 
 // Receive decodes a value from the underlying channel if it is still open.
+// It returns io.EOF once the handler ends the stream of values with CloseSend,
+// but io.ErrUnexpectedEOF if the channel closes without the stream ending.
 func (r *Response) Receive(v interface{}) error {
-	return r.codec.Decoder(r.Channel).Decode(v)
+	if r.ended {
+		return io.EOF
+	}
+	err := r.codec.Decoder(r.Channel).Decode(v)
+	switch err {
+	case errEndOfStream:
+		r.ended = true
+		return io.EOF
+	case io.EOF:
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// CloseSend ends the stream of values sent with Send and closes the sending
+// side of the underlying channel. The handler will receive io.EOF.
+func (r *Response) CloseSend() error {
+	if err := writeEndOfStream(r.Channel); err != nil {
+		return err
+	}
+	return r.Channel.CloseWrite()
 }
 
+// Close closes the underlying channel. Responses without a channel, such as
+// those served from a CachingCaller, have nothing to close.
 func (r *Response) Close() error {
+	if r.Channel == nil {
+		return nil
+	}
 	return r.Channel.Close()
 }
 
 func (r *Response) CloseWrite() error {
+	if r.Channel == nil {
+		return nil
+	}
 	return r.Channel.CloseWrite()
 }
 
@@ -118,19 +225,68 @@ type Responder interface {
 	// Send encodes a value over the underlying channel, but does not initiate a response,
 	// so it must be used after calling Continue.
 	Send(interface{}) error
+
+	// CloseSend ends the stream of values sent with Send and closes the sending side of
+	// the underlying channel, so the caller can tell the stream finished cleanly. It must
+	// be used after calling Continue.
+	CloseSend() error
+
+	// Progress sends an intermediate progress value to the caller. It can be called any
+	// number of times, but only before calling Return or Continue.
+	Progress(any) error
 }
 
 type responder struct {
+	mu        sync.Mutex
 	responded bool
 	header    *ResponseHeader
 	ch        mux.Channel
 	c         codec.Codec
 }
 
+// status returns whether a response was initiated and a copy of the header.
+func (r *responder) status() (responded bool, header ResponseHeader) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.responded, *r.header
+}
+
+// proxied marks the call as responded to by a proxied response, returning
+// an error if it was already responded to.
+func (r *responder) proxied() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.responded {
+		return errors.New("rpc: already responded")
+	}
+	r.responded = true
+	r.header.C = true
+	return nil
+}
+
 func (r *responder) Send(v interface{}) error {
 	return r.c.Encoder(r.ch).Encode(v)
 }
 
+func (r *responder) CloseSend() error {
+	if err := writeEndOfStream(r.ch); err != nil {
+		return err
+	}
+	return r.ch.CloseWrite()
+}
+
+func (r *responder) Progress(v any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.responded {
+		return errors.New("rpc: progress after response")
+	}
+	if err := r.Send(&ResponseHeader{P: true}); err != nil {
+		return err
+	}
+	return r.Send(v)
+}
+
 func (r *responder) Return(v ...any) error {
 	return r.respond(v, false)
 }
@@ -140,6 +296,11 @@ func (r *responder) Continue(v ...any) (mux.Channel, error) {
 }
 
 func (r *responder) respond(values []any, continue_ bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.responded {
+		return errors.New("rpc: already responded")
+	}
 	r.responded = true
 	r.header.C = continue_
 
@@ -154,6 +315,10 @@ func (r *responder) respond(values []any, continue_ bool) error {
 		if e != nil {
 			var errStr = e.Error()
 			r.header.E = &errStr
+			if code := ErrorCode(e); code != "" {
+				r.header.K = &code
+			}
+			r.header.D = ErrorDetails(e)
 		}
 	}
 
@@ -181,7 +346,8 @@ func (r *responder) respond(values []any, continue_ bool) error {
 
 // ReceiveNotify takes a continued response and sends received values to a channel,
 // until an error is returned or the context finishes. In either case, the response
-// and the channel will be closed.
+// and the channel will be closed. If the stream was ended cleanly, io.EOF is returned,
+// but if the underlying channel closed first, io.ErrUnexpectedEOF is returned.
 func ReceiveNotify[T any](ctx context.Context, resp *Response, ch chan T) error {
 	defer close(ch)
 	defer resp.Close()
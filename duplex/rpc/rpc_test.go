@@ -1,11 +1,23 @@
 package rpc
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -219,6 +231,133 @@ func TestRespondMux(t *testing.T) {
 		}
 	})
 
+	t.Run("selector timeout", func(t *testing.T) {
+		mux := NewRespondMux()
+		mux.Handle("slow", HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			select {
+			case <-time.After(time.Second):
+				r.Return("slow")
+			case <-c.Context.Done():
+			}
+		}))
+		mux.Handle("fast", HandlerFunc(func(r Responder, c *Call) {
+			r.Return("fast")
+		}))
+		mux.SetTimeout("slow", 50*time.Millisecond)
+		mux.SetTimeout("fast", 50*time.Millisecond)
+
+		client, _ := newTestPair(mux)
+		defer client.Close()
+
+		_, err := client.Call(ctx, "slow", nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expected deadline error:", err)
+		}
+
+		var out string
+		_, err = client.Call(ctx, "fast", nil, &out)
+		fatal(t, err)
+		if out != "fast" {
+			t.Fatal("unexpected return:", out)
+		}
+	})
+
+	t.Run("selector validator", func(t *testing.T) {
+		mux := NewRespondMux()
+		mux.Handle("upper", HandlerFunc(func(r Responder, c *Call) {
+			var in string
+			fatal(t, c.Receive(&in))
+			r.Return(strings.ToUpper(in))
+		}))
+		mux.SetValidator("upper", ValidatorFunc(func(params any) error {
+			if _, ok := params.(string); !ok {
+				return fmt.Errorf("expected string")
+			}
+			return nil
+		}))
+
+		client, _ := newTestPair(mux)
+		defer client.Close()
+
+		var out string
+		_, err := client.Call(ctx, "upper", "hello", &out)
+		fatal(t, err)
+		if out != "HELLO" {
+			t.Fatal("unexpected return:", out)
+		}
+
+		_, err = client.Call(ctx, "upper", 100, &out)
+		if !errors.Is(err, ErrInvalidParams) {
+			t.Fatal("expected validation error:", err)
+		}
+	})
+
+	t.Run("submux settings", func(t *testing.T) {
+		mux := NewRespondMux()
+		submux := NewRespondMux()
+		mux.Handle("sub", submux)
+		mux.SetTimeout("sub.", 20*time.Millisecond)
+		// unrelated to the submux despite having the name of its handler
+		mux.SetValidator("slow", ValidatorFunc(func(params any) error {
+			return fmt.Errorf("wrong validator")
+		}))
+		submux.Handle("slow", HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			<-c.Context.Done()
+		}))
+		submux.Handle("upper", HandlerFunc(func(r Responder, c *Call) {
+			var in string
+			fatal(t, c.Receive(&in))
+			r.Return(strings.ToUpper(in))
+		}))
+		submux.SetValidator("upper", ValidatorFunc(func(params any) error {
+			if _, ok := params.(string); !ok {
+				return fmt.Errorf("expected string")
+			}
+			return nil
+		}))
+
+		client, _ := newTestPair(mux)
+		defer client.Close()
+
+		_, err := client.Call(ctx, "sub.slow", nil)
+		if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+			t.Fatal("expected timeout error:", err)
+		}
+		var out string
+		_, err = client.Call(ctx, "sub.upper", "hello", &out)
+		fatal(t, err)
+		_, err = client.Call(ctx, "sub.upper", 100, &out)
+		if !errors.Is(err, ErrInvalidParams) {
+			t.Fatal("expected validation error:", err)
+		}
+	})
+
+	t.Run("schema validator", func(t *testing.T) {
+		schema, err := codec.ParseSchema([]byte(`{"type": "string", "maxLength": 5}`))
+		fatal(t, err)
+		mux := NewRespondMux()
+		mux.Handle("upper", HandlerFunc(func(r Responder, c *Call) {
+			var in string
+			fatal(t, c.Receive(&in))
+			r.Return(strings.ToUpper(in))
+		}))
+		mux.SetValidator("upper", schema)
+
+		client, _ := newTestPair(mux)
+		defer client.Close()
+
+		var out string
+		_, err = client.Call(ctx, "upper", "hello", &out)
+		fatal(t, err)
+
+		_, err = client.Call(ctx, "upper", "hello world", &out)
+		if !errors.Is(err, ErrInvalidParams) || !strings.Contains(err.Error(), "expected length at most 5") {
+			t.Fatal("expected validation error:", err)
+		}
+	})
+
 	t.Run("bad handler: nil", func(t *testing.T) {
 		defer func() {
 			if r := recover(); r == nil {
@@ -459,6 +598,189 @@ func TestRPC(t *testing.T) {
 		}
 	})
 
+	t.Run("discard reply values", func(t *testing.T) {
+		client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			r.Return("first", "second")
+		}))
+		defer client.Close()
+
+		_, err := client.Call(ctx, "", nil)
+		fatal(t, err)
+
+		var out string
+		_, err = client.Call(ctx, "", nil, Discard, &out)
+		fatal(t, err)
+		if out != "second" {
+			t.Fatalf("unexpected return: %#v", out)
+		}
+	})
+
+	t.Run("discard reply remote error", func(t *testing.T) {
+		client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			r.Return(fmt.Errorf("internal server error"))
+		}))
+		defer client.Close()
+
+		_, err := client.Call(ctx, "", nil)
+		if _, ok := err.(RemoteError); !ok {
+			t.Fatal("unexpected error:", err)
+		}
+	})
+
+	t.Run("progress values", func(t *testing.T) {
+		client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			for i := 1; i <= 3; i++ {
+				fatal(t, r.Progress(i))
+			}
+			r.Return("done")
+			if err := r.Progress(4); err == nil {
+				t.Error("expected error for progress after return")
+			}
+		}))
+		defer client.Close()
+
+		var progress []float64
+		var out string
+		_, err := client.Call(ctx, "", nil, &out, OnProgress(func(v any) {
+			progress = append(progress, v.(float64))
+		}))
+		fatal(t, err)
+		if out != "done" {
+			t.Fatalf("unexpected return: %#v", out)
+		}
+		if len(progress) != 3 || progress[2] != 3 {
+			t.Fatalf("unexpected progress: %#v", progress)
+		}
+
+		_, err = client.Call(ctx, "", nil, &out)
+		fatal(t, err)
+		if out != "done" {
+			t.Fatalf("unexpected return without progress option: %#v", out)
+		}
+	})
+
+	t.Run("call id", func(t *testing.T) {
+		client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			r.Return(c.ID())
+		}))
+		defer client.Close()
+
+		var out string
+		resp, err := client.Call(ctx, "", nil, &out)
+		fatal(t, err)
+		if resp.ID() == "" || out != resp.ID() {
+			t.Fatalf("unexpected call id: %#v != %#v", out, resp.ID())
+		}
+
+		resp, err = client.Call(ctx, "", nil, &out, WithCallID("upstream"))
+		fatal(t, err)
+		if out != "upstream" || resp.ID() != "upstream" {
+			t.Fatalf("unexpected call id: %#v", out)
+		}
+	})
+
+	t.Run("call id of failed call", func(t *testing.T) {
+		client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+			fatal(t, c.Receive(nil))
+			r.Return(fmt.Errorf("failed"))
+		}))
+		defer client.Close()
+
+		resp, err := client.Call(ctx, "", nil, WithCallID("failing"))
+		if _, ok := err.(RemoteError); !ok {
+			t.Fatal("unexpected error:", err)
+		}
+		if resp.ID() != "failing" {
+			t.Fatal("unexpected call id:", resp.ID())
+		}
+	})
+
+	t.Run("trace", func(t *testing.T) {
+		ar, bw := io.Pipe()
+		br, aw := io.Pipe()
+		sessA, _ := mux.DialIO(aw, ar)
+		sessB, _ := mux.DialIO(bw, br)
+
+		var mu sync.Mutex
+		var events []TraceEvent
+		trace := func(e TraceEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		}
+		srv := &Server{
+			Codec: codec.JSONCodec{},
+			Handler: HandlerFunc(func(r Responder, c *Call) {
+				fatal(t, c.Receive(nil))
+				r.Return("ok")
+			}),
+			Trace: trace,
+		}
+		go srv.Respond(sessA, nil)
+		client := NewClient(sessB, codec.JSONCodec{})
+		client.Trace = trace
+		defer client.Close()
+
+		_, err := client.Call(ctx, "traced", nil, WithCallID("traced-id"))
+		fatal(t, err)
+		// the server traces its response after sending it
+		mu.Lock()
+		defer mu.Unlock()
+		for deadline := time.Now().Add(time.Second); len(events) < 4 && time.Now().Before(deadline); {
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+		}
+		if len(events) != 4 {
+			t.Fatalf("unexpected events: %+v", events)
+		}
+		responses := 0
+		for _, e := range events {
+			if e.ID != "traced-id" || e.Selector == "" {
+				t.Fatalf("unexpected event: %+v", e)
+			}
+			if e.Response != nil {
+				responses++
+			}
+		}
+		if responses != 2 {
+			t.Fatalf("unexpected events: %+v", events)
+		}
+	})
+
+	t.Run("stream end", func(t *testing.T) {
+		client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+			var clean bool
+			fatal(t, c.Receive(&clean))
+			ch, err := r.Continue(nil)
+			fatal(t, err)
+			fatal(t, r.Send("Hello world"))
+			if clean {
+				fatal(t, r.CloseSend())
+			}
+			ch.Close()
+		}))
+		defer client.Close()
+
+		for _, clean := range []bool{true, false} {
+			resp, err := client.Call(ctx, "", clean, nil)
+			fatal(t, err)
+			var rcv string
+			fatal(t, resp.Receive(&rcv))
+			err = resp.Receive(&rcv)
+			if clean && err != io.EOF {
+				t.Fatal("expected EOF:", err)
+			}
+			if !clean && err != io.ErrUnexpectedEOF {
+				t.Fatal("expected unexpected EOF:", err)
+			}
+		}
+	})
+
 	t.Run("call timeout", func(t *testing.T) {
 		client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
 			time.Sleep(200 * time.Millisecond)
@@ -490,3 +812,323 @@ func TestRPC(t *testing.T) {
 	})
 
 }
+
+type codeError struct{}
+
+func (e codeError) Error() string     { return "custom" }
+func (e codeError) ErrorCode() string { return "custom_code" }
+func (e codeError) ErrorDetails() any { return []any{"detail"} }
+
+func TestErrorCodes(t *testing.T) {
+	ctx := context.Background()
+	errTest := errors.New("test error")
+	RegisterError("test_error", errTest)
+
+	client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+		var in string
+		fatal(t, c.Receive(&in))
+		switch in {
+		case "not_exist":
+			r.Return(fmt.Errorf("open foo: %w", os.ErrNotExist))
+		case "registered":
+			r.Return(errTest)
+		case "coder":
+			r.Return(codeError{})
+		default:
+			r.Return(errors.New(in))
+		}
+	}))
+	defer client.Close()
+
+	_, err := client.Call(ctx, "", "not_exist")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("expected not exist error:", err)
+	}
+	var rErr RemoteError
+	if !errors.As(err, &rErr) || rErr.Error() != "remote: open foo: file does not exist" {
+		t.Fatal("expected remote error:", err)
+	}
+
+	_, err = client.Call(ctx, "", "registered")
+	if !errors.Is(err, errTest) {
+		t.Fatal("expected registered error:", err)
+	}
+
+	_, err = client.Call(ctx, "", "coder")
+	if ErrorCode(err) != "custom_code" {
+		t.Fatal("unexpected error code:", ErrorCode(err))
+	}
+	if details, ok := ErrorDetails(err).([]any); !ok || len(details) != 1 || details[0] != "detail" {
+		t.Fatal("unexpected error details:", ErrorDetails(err))
+	}
+
+	_, err = client.Call(ctx, "", "other")
+	if _, ok := err.(RemoteError); !ok || ErrorCode(err) != "" {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestCachingCaller(t *testing.T) {
+	ctx := context.Background()
+	var calls int
+	client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+		var in string
+		fatal(t, c.Receive(&in))
+		calls++
+		r.Return(strings.ToUpper(in))
+	}))
+	defer client.Close()
+
+	cache := NewCachingCaller(client, codec.JSONCodec{}, time.Minute, 2)
+
+	resp, err := cache.Call(ctx, "upper", "hello")
+	fatal(t, err)
+	resp.Close()
+
+	var out string
+	for i := 0; i < 3; i++ {
+		resp, err := cache.Call(ctx, "upper", "hello", &out)
+		fatal(t, err)
+		resp.Close()
+		if out != "HELLO" {
+			t.Fatal("unexpected return:", out)
+		}
+	}
+	if calls != 2 {
+		t.Fatal("unexpected number of calls:", calls)
+	}
+
+	_, err = cache.Call(ctx, "upper", "world", &out)
+	fatal(t, err)
+	_, err = cache.Call(ctx, "upper", "again", &out)
+	fatal(t, err)
+	if cache.Len() != 2 {
+		t.Fatal("unexpected number of entries:", cache.Len())
+	}
+
+	cache.Invalidate("upper")
+	if cache.Len() != 0 {
+		t.Fatal("unexpected number of entries after invalidate:", cache.Len())
+	}
+	_, err = cache.Call(ctx, "upper", "hello", &out)
+	fatal(t, err)
+	if calls != 5 {
+		t.Fatal("unexpected number of calls:", calls)
+	}
+}
+
+func TestConn(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+		fatal(t, c.Receive(nil))
+		_, err := r.Continue(nil)
+		fatal(t, err)
+		conn := c.Conn()
+		io.Copy(conn, conn)
+		conn.Close()
+	}))
+	defer client.Close()
+
+	resp, err := client.Call(ctx, "", nil, nil)
+	fatal(t, err)
+	conn := resp.Conn()
+	defer conn.Close()
+
+	fatal(t, conn.SetReadDeadline(time.Now().Add(20*time.Millisecond)))
+	_, err = conn.Read(make([]byte, 1))
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Fatal("expected timeout error:", err)
+	}
+	fatal(t, conn.SetReadDeadline(time.Time{}))
+
+	_, err = io.WriteString(conn, "Hello world")
+	fatal(t, err)
+	fatal(t, conn.(interface{ CloseWrite() error }).CloseWrite())
+	b, err := io.ReadAll(conn)
+	fatal(t, err)
+	if string(b) != "Hello world" {
+		t.Fatalf("unexpected data: %#v", b)
+	}
+}
+
+func TestSessionValues(t *testing.T) {
+	type userKey struct{}
+	ctx := context.Background()
+	m := NewRespondMux()
+	m.Handle("login", HandlerFunc(func(r Responder, c *Call) {
+		var name string
+		fatal(t, c.Receive(&name))
+		c.Session().Values().Set(userKey{}, name)
+	}))
+	m.Handle("whoami", HandlerFunc(func(r Responder, c *Call) {
+		c.Receive(nil)
+		name, ok := c.Session().Values().Get(userKey{})
+		if !ok {
+			r.Return(fmt.Errorf("not logged in"))
+			return
+		}
+		r.Return(name)
+	}))
+
+	client, _ := newTestPair(m)
+	defer client.Close()
+	other, _ := newTestPair(m)
+	defer other.Close()
+
+	_, err := client.Call(ctx, "login", "alice", nil)
+	fatal(t, err)
+	var name string
+	_, err = client.Call(ctx, "whoami", nil, &name)
+	fatal(t, err)
+	if name != "alice" {
+		t.Fatal("unexpected name:", name)
+	}
+	// values are not shared with other sessions
+	if _, err := other.Call(ctx, "whoami", nil, &name); err == nil {
+		t.Fatal("expected error for other session")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newTestPair(HandlerFunc(func(r Responder, c *Call) {
+		var in string
+		fatal(t, c.Receive(&in))
+		_, err := r.Continue(strings.ToUpper(in))
+		fatal(t, err)
+		fatal(t, r.Send(in))
+		c.Channel.Close()
+	}))
+	defer client.Close()
+
+	in := strings.Repeat("hello world ", 1000)
+	var out string
+	resp, err := client.Call(ctx, "", in, &out, WithCompression("gzip"))
+	fatal(t, err)
+	if out != strings.ToUpper(in) {
+		t.Fatal("unexpected return")
+	}
+	fatal(t, resp.Receive(&out))
+	if out != in {
+		t.Fatal("unexpected streamed value")
+	}
+
+	_, err = client.Call(ctx, "", "small", &out, WithCompression("unknown"))
+	if err == nil || !strings.Contains(err.Error(), "unsupported compression") {
+		t.Fatal("expected unsupported compression error:", err)
+	}
+}
+
+func TestPeerCertificate(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	fatal(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	fatal(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	fatal(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	newCert := func(serial int64, tmpl *x509.Certificate) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		fatal(t, err)
+		tmpl.SerialNumber = big.NewInt(serial)
+		tmpl.NotAfter = time.Now().Add(time.Hour)
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		fatal(t, err)
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	serverCert := newCert(2, &x509.Certificate{
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	clientCert := newCert(3, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "alice"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	l, err := mux.ListenTLS("127.0.0.1:0", mux.ServerTLSConfig(serverCert, pool))
+	fatal(t, err)
+	defer l.Close()
+	srv := &Server{
+		Codec: codec.JSONCodec{},
+		Handler: HandlerFunc(func(r Responder, c *Call) {
+			cert, ok := PeerCertificate(c.Context)
+			if !ok {
+				r.Return(errors.New("unauthenticated"))
+				return
+			}
+			r.Return(cert.Subject.CommonName)
+		}),
+	}
+	go srv.ServeMux(l)
+
+	sess, err := mux.DialTLS(l.Addr().String(), mux.ClientTLSConfig(pool, &clientCert))
+	fatal(t, err)
+	client := NewClient(sess, codec.JSONCodec{})
+	defer client.Close()
+
+	var name string
+	_, err = client.Call(context.Background(), "whoami", nil, &name)
+	fatal(t, err)
+	if name != "alice" {
+		t.Fatalf("unexpected peer: %q", name)
+	}
+}
+
+func TestFrameMaxSize(t *testing.T) {
+	framer := &FrameCodec{Codec: codec.JSONCodec{}, MaxSize: 4096}
+
+	// a length prefix past the max is rejected without reading the frame
+	prefix := []byte{0x7f, 0xff, 0xff, 0xff}
+	var v any
+	err := framer.Decoder(bytes.NewReader(prefix)).Decode(&v)
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected frame too large error: %v", err)
+	}
+
+	// a small frame that decompresses past the max is rejected
+	var buf bytes.Buffer
+	enc := (&FrameCodec{Codec: codec.JSONCodec{}, Compression: "gzip"}).Encoder(&buf)
+	fatal(t, enc.Encode(strings.Repeat("x", 1<<20)))
+	if buf.Len() > framer.MaxSize {
+		t.Fatalf("expected compressed frame under the max: %d bytes", buf.Len())
+	}
+	framer.Compression = "gzip"
+	err = framer.Decoder(&buf).Decode(&v)
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected frame too large error: %v", err)
+	}
+}
+
+func FuzzDecodeFrame(f *testing.F) {
+	for _, c := range []codec.Codec{codec.JSONCodec{}, codec.CBORCodec{}} {
+		var buf bytes.Buffer
+		enc := (&FrameCodec{Codec: c}).Encoder(&buf)
+		enc.Encode(CallHeader{S: "foo.bar", I: "1"})
+		enc.Encode([]any{1, "two", map[string]any{"three": 3.0}})
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		for _, c := range []codec.Codec{codec.JSONCodec{}, codec.CBORCodec{}} {
+			dec := (&FrameCodec{Codec: c, MaxSize: 1 << 20}).Decoder(bytes.NewReader(b))
+			var header CallHeader
+			if err := dec.Decode(&header); err != nil {
+				continue
+			}
+			for {
+				var v any
+				if err := dec.Decode(&v); err != nil {
+					break
+				}
+			}
+		}
+	})
+}
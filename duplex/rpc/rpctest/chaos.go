@@ -0,0 +1,327 @@
+package rpctest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/mux"
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+// ChaosOp is an operation of the calling side in a run of Chaos.
+type ChaosOp int
+
+const (
+	// ChaosSend sends the next value to the handler.
+	ChaosSend ChaosOp = iota
+	// ChaosYield lets other goroutines run before the next operation.
+	ChaosYield
+	// ChaosCloseSend ends the stream of values sent with CloseSend.
+	ChaosCloseSend
+	// ChaosCloseWrite half-closes the channel without ending the stream.
+	ChaosCloseWrite
+	// ChaosClose closes the channel.
+	ChaosClose
+	// ChaosCancel cancels the context of the call, and closes the channel
+	// if the call has already returned.
+	ChaosCancel
+)
+
+func (op ChaosOp) String() string {
+	switch op {
+	case ChaosSend:
+		return "send"
+	case ChaosYield:
+		return "yield"
+	case ChaosCloseSend:
+		return "close-send"
+	case ChaosCloseWrite:
+		return "close-write"
+	case ChaosClose:
+		return "close"
+	case ChaosCancel:
+		return "cancel"
+	default:
+		return fmt.Sprintf("ChaosOp(%d)", int(op))
+	}
+}
+
+// Chaos runs a streaming handler against randomized interleavings of sends,
+// half-closes and cancellations by the calling side, to shake out deadlocks
+// in handlers and the goroutines they start. Each run makes the call on a new
+// pair of sessions, then performs a random script of operations ending the call
+// while values from the handler are received concurrently. A run fails if it
+// does not finish within Timeout: the call doesn't return, a send blocks, the
+// stream from the handler doesn't end after the call is ended, the handler
+// doesn't return, or channels are left open.
+//
+// The script of each run is chosen from Seed, so a failing run can be repeated,
+// although the interleaving with the handler also depends on scheduling.
+type Chaos struct {
+	// Handler responds to the call. It is required.
+	Handler rpc.Handler
+	// CallerHandler, if set, responds to calls the handler makes back to the
+	// calling side, such as callbacks.
+	CallerHandler rpc.Handler
+	// Codec is used by both sides. If nil, JSON is used.
+	Codec codec.Codec
+
+	// Selector and Args are the call made to Handler in each run.
+	Selector string
+	Args     any
+	// Value returns the i-th value sent by the calling side. If nil, i is sent.
+	Value func(i int) any
+
+	// Runs is the number of runs, 100 if zero.
+	Runs int
+	// MaxSends is the most values sent in a run, 10 if zero.
+	MaxSends int
+	// Seed seeds the scripts of the runs.
+	Seed int64
+	// Timeout is how long a run can take before it fails, 5 seconds if zero.
+	Timeout time.Duration
+}
+
+// Script returns the operations of the calling side in a run.
+func (c Chaos) Script(run int) []ChaosOp {
+	maxSends := c.MaxSends
+	if maxSends == 0 {
+		maxSends = 10
+	}
+	r := rand.New(rand.NewSource(c.Seed + int64(run)))
+	var ops []ChaosOp
+	sends := r.Intn(maxSends + 1)
+	for sends > 0 {
+		if r.Intn(3) == 0 {
+			ops = append(ops, ChaosYield)
+			continue
+		}
+		ops = append(ops, ChaosSend)
+		sends--
+	}
+	ends := []ChaosOp{ChaosCloseSend, ChaosCloseSend, ChaosCloseWrite, ChaosClose, ChaosCancel}
+	end := ends[r.Intn(len(ends))]
+	if end == ChaosCancel {
+		// cancel anywhere, including before the call returns
+		i := r.Intn(len(ops) + 1)
+		return append(ops[:i], end)
+	}
+	return append(ops, end)
+}
+
+// Run performs the runs, failing t with the run, its script and where it was
+// stuck for the first run that does not finish within Timeout, after logging
+// the stacks of all goroutines.
+func (c Chaos) Run(t testing.TB) {
+	t.Helper()
+	runs := c.Runs
+	if runs == 0 {
+		runs = 100
+	}
+	for run := 0; run < runs; run++ {
+		ops := c.Script(run)
+		if stage, err := c.run(ops); err != nil {
+			if errors.Is(err, errChaosTimeout) {
+				buf := make([]byte, 1<<20)
+				t.Logf("goroutines:\n%s", buf[:runtime.Stack(buf, true)])
+			}
+			t.Fatalf("chaos run %d (seed %d) %v: %s: %v", run, c.Seed, ops, stage, err)
+		}
+	}
+}
+
+var errChaosTimeout = errors.New("timed out")
+
+// chaosRun tracks the stage of a run to report where it was stuck.
+type chaosRun struct {
+	mu    sync.Mutex
+	stage string
+}
+
+func (r *chaosRun) set(stage string) {
+	r.mu.Lock()
+	r.stage = stage
+	r.mu.Unlock()
+}
+
+func (r *chaosRun) get() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stage
+}
+
+type chaosHandler struct {
+	started atomic.Bool
+	done    chan struct{}
+}
+
+func (c Chaos) run(ops []ChaosOp) (string, error) {
+	cd := c.Codec
+	if cd == nil {
+		cd = codec.JSONCodec{}
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
+	ar, bw := io.Pipe()
+	br, aw := io.Pipe()
+	sessA, _ := mux.DialIO(aw, ar)
+	sessB, _ := mux.DialIO(bw, br)
+	defer sessA.Close()
+	defer sessB.Close()
+
+	handler := &chaosHandler{done: make(chan struct{})}
+	srv := &rpc.Server{
+		Codec: cd,
+		Handler: rpc.HandlerFunc(func(r rpc.Responder, call *rpc.Call) {
+			handler.started.Store(true)
+			defer close(handler.done)
+			c.Handler.RespondRPC(r, call)
+		}),
+		ErrorHandler: func(error) {},
+	}
+	go srv.ServeSession(context.Background(), sessA)
+	if c.CallerHandler != nil {
+		caller := &rpc.Server{Codec: cd, Handler: c.CallerHandler, ErrorHandler: func(error) {}}
+		go caller.ServeSession(context.Background(), sessB)
+	}
+
+	state := &chaosRun{}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.script(state, rpc.NewClient(sessB, cd), ops, handler, sessA)
+	}()
+	select {
+	case err := <-done:
+		return state.get(), err
+	case <-time.After(timeout):
+		return state.get(), fmt.Errorf("%w after %s", errChaosTimeout, timeout)
+	}
+}
+
+func (c Chaos) script(state *chaosRun, client *rpc.Client, ops []ChaosOp, handler *chaosHandler, server mux.Session) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		resp    *rpc.Response
+		callErr error
+		called  = make(chan struct{})
+	)
+	go func() {
+		resp, callErr = client.Call(ctx, c.Selector, c.Args)
+		close(called)
+	}()
+
+	var received chan struct{}
+	ended := false
+	// wait for the call before operations that need the response, which
+	// also starts receiving values from the handler
+	wait := func() bool {
+		if received == nil {
+			state.set("waiting for call to return")
+			<-called
+			received = make(chan struct{})
+			go func() {
+				defer close(received)
+				if callErr != nil || !resp.Continue() {
+					return
+				}
+				var v any
+				for resp.Receive(&v) == nil {
+				}
+			}()
+		}
+		return callErr == nil && resp.Continue()
+	}
+
+	sent := 0
+	for _, op := range ops {
+		if ended {
+			break
+		}
+		switch op {
+		case ChaosYield:
+			runtime.Gosched()
+			continue
+		case ChaosCancel:
+			state.set("canceling")
+			cancel()
+			ended = true
+			select {
+			case <-called:
+				if wait() {
+					resp.Close()
+				}
+			default:
+			}
+			continue
+		}
+		if !wait() {
+			ended = true
+			break
+		}
+		switch op {
+		case ChaosSend:
+			state.set(fmt.Sprintf("sending value %d", sent))
+			v := any(sent)
+			if c.Value != nil {
+				v = c.Value(sent)
+			}
+			sent++
+			if resp.Send(v) != nil {
+				ended = true
+			}
+		case ChaosCloseSend:
+			state.set("closing send")
+			resp.CloseSend()
+			ended = true
+		case ChaosCloseWrite:
+			state.set("closing write")
+			resp.CloseWrite()
+			ended = true
+		case ChaosClose:
+			state.set("closing")
+			resp.Close()
+			ended = true
+		}
+	}
+
+	wait()
+	if callErr == nil && resp.Continue() {
+		state.set("waiting for stream from handler to end")
+		<-received
+	}
+	// a call canceled after the response is returned with its error,
+	// so the channel is closed whether or not the call failed
+	if resp != nil {
+		resp.Close()
+	}
+
+	// the handler is not called if the call was canceled before the
+	// channel was opened
+	if handler.started.Load() {
+		state.set("waiting for handler to return")
+		<-handler.done
+	}
+
+	state.set("waiting for channels to close")
+	for {
+		stats, _ := mux.StatsOf(server)
+		if len(stats.Channels) == 0 {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -2,6 +2,9 @@ package rpc
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -14,6 +17,19 @@ import (
 type Server struct {
 	Handler Handler
 	Codec   codec.Codec
+
+	// ErrorHandler is called with errors dispatching calls, such as a call
+	// that can't be decoded. If nil, errors are logged.
+	ErrorHandler func(error)
+
+	// MaxFrameSize, if set, is the largest encoded value in bytes that callers
+	// can send, so untrusted callers can't make the server buffer large values.
+	// Decoding a larger value returns an error wrapping ErrFrameTooLarge.
+	MaxFrameSize int
+
+	// Trace, if set, is called with each call received and its response, such
+	// as to log the IDs of calls to correlate them with the calling side.
+	Trace func(TraceEvent)
 }
 
 // ServeMux will Accept sessions until the Listener is closed, and will Respond to accepted sessions in their own goroutine.
@@ -37,9 +53,29 @@ func (s *Server) Serve(l net.Listener) error {
 // returned. If the handler does not call Continue, the channel will be closed. Respond will panic if Codec is nil.
 //
 // If the context is not nil, it will be added to Calls. Otherwise the Call Context will be set to a context.Background().
+// If the session runs over TLS, the connection state is added to the Call Context for PeerCertificate.
+// Respond also stops accepting channels and returns once the context is done.
 func (s *Server) Respond(sess mux.Session, ctx context.Context) {
 	defer sess.Close()
+	if err := s.serve(sess, ctx, ctx); err != nil && err != io.EOF && (ctx == nil || ctx.Err() == nil) {
+		panic(err)
+	}
+}
 
+// ServeSession is like Respond but returns instead of panicking if accepting a
+// channel fails, and leaves the session open when ctx is done so it can be drained.
+// It returns nil once the session is closed, or the error of ctx once it is done.
+// Unlike Respond, ctx is not added to Calls, so calls in progress are not canceled
+// when it is done.
+func (s *Server) ServeSession(ctx context.Context, sess mux.Session) error {
+	err := s.serve(sess, ctx, nil)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) serve(sess mux.Session, acceptCtx, callCtx context.Context) error {
 	if s.Codec == nil {
 		panic("rpc.Respond: nil codec")
 	}
@@ -49,33 +85,57 @@ func (s *Server) Respond(sess mux.Session, ctx context.Context) {
 		hn = NewRespondMux()
 	}
 
+	if acceptCtx == nil {
+		acceptCtx = context.Background()
+	}
+	session := &Session{Session: sess}
 	for {
-		ch, err := sess.Accept()
+		ch, err := sess.AcceptContext(acceptCtx)
 		if err != nil {
-			if err == io.EOF {
-				return
-			}
-			panic(err)
+			return err
 		}
-		go s.respond(hn, sess, ch, ctx)
+		go s.respond(hn, session, ch, callCtx)
+	}
+}
+
+func (s *Server) reportError(err error) {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(err)
+		return
 	}
+	log.Println("rpc.Respond:", err)
 }
 
-func (s *Server) respond(hn Handler, sess mux.Session, ch mux.Channel, ctx context.Context) {
-	framer := &FrameCodec{Codec: s.Codec}
+func (s *Server) respond(hn Handler, sess *Session, ch mux.Channel, ctx context.Context) {
+	framer := &FrameCodec{Codec: s.Codec, MaxSize: s.MaxFrameSize}
 	dec := framer.Decoder(ch)
 
 	var call Call
 	err := dec.Decode(&call)
 	if err != nil {
-		log.Println("rpc.Respond:", err)
+		s.reportError(err)
+		ch.Close()
 		return
 	}
 
-	call.S = cleanSelector(call.Selector())
+	if s.Trace != nil {
+		s.Trace(TraceEvent{ID: call.ID(), Selector: call.Selector()})
+	}
+
+	if call.Z != "" {
+		if _, ok := codec.LookupCompressor(call.Z); !ok {
+			resp := &responder{ch: ch, c: framer, header: &ResponseHeader{}}
+			resp.Return(fmt.Errorf("unsupported compression: %s", call.Z))
+			return
+		}
+		framer.Compression = call.Z
+	}
+
+	call.S = CleanSelector(call.Selector())
 	call.Decoder = dec
+	call.session = sess
 	call.Caller = &Client{
-		Session: sess,
+		Session: sess.Session,
 		codec:   s.Codec,
 	}
 	if ctx == nil {
@@ -83,20 +143,40 @@ func (s *Server) respond(hn Handler, sess mux.Session, ch mux.Channel, ctx conte
 	} else {
 		call.Context = ctx
 	}
+	if state, ok := mux.TLSConnectionState(sess.Session); ok {
+		call.Context = context.WithValue(call.Context, tlsStateKey{}, state)
+	}
 	call.Channel = ch
 
-	header := &ResponseHeader{}
 	resp := &responder{
 		ch:     ch,
 		c:      framer,
-		header: header,
+		header: &ResponseHeader{},
 	}
 
 	hn.RespondRPC(resp, &call)
-	if !resp.responded {
+	if responded, _ := resp.status(); !responded {
 		resp.Return()
 	}
-	if !resp.header.C {
+	_, header := resp.status()
+	if s.Trace != nil {
+		s.Trace(TraceEvent{ID: call.ID(), Selector: call.Selector(), Response: &header})
+	}
+	if !header.C {
 		ch.Close()
 	}
 }
+
+type tlsStateKey struct{}
+
+// PeerCertificate returns the verified certificate of the calling side from the
+// Context of a Call received over mutual TLS, such as with mux.ListenTLS, so
+// handlers can authorize calls by the identity of the caller. It returns false
+// if the call was not made over TLS or the certificate was not verified.
+func PeerCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	state, ok := ctx.Value(tlsStateKey{}).(tls.ConnectionState)
+	if !ok || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	return state.VerifiedChains[0][0], true
+}
@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"sync"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+// Session is a session served by a Server along with values stored for it,
+// so handlers of stateful protocols can keep state such as an authenticated
+// identity or open resources for the calls of a session.
+type Session struct {
+	mux.Session
+	values Values
+}
+
+// Values returns the values stored for the session, which are kept until
+// the Server stops serving it.
+func (s *Session) Values() *Values {
+	return &s.values
+}
+
+// Values is a set of values stored by key, safe for concurrent use. Like
+// context keys, keys should be of an unexported type to avoid collisions.
+type Values struct {
+	mu sync.RWMutex
+	m  map[any]any
+}
+
+// Get returns the value stored for key and whether there was one.
+func (v *Values) Get(key any) (any, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	val, ok := v.m[key]
+	return val, ok
+}
+
+// Set stores the value for key.
+func (v *Values) Set(key, val any) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.m == nil {
+		v.m = make(map[any]any)
+	}
+	v.m[key] = val
+}
+
+// Delete removes the value stored for key.
+func (v *Values) Delete(key any) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.m, key)
+}
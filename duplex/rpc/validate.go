@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidParams is matched by errors from params that failed validation,
+// including those received by the caller.
+var ErrInvalidParams = errors.New("invalid params")
+
+// A Validator checks the params of a call before it is handled.
+//
+// Validate is given the first value sent by the caller decoded as an empty
+// interface value. Returning an error rejects the call with a ValidationError.
+type Validator interface {
+	Validate(params any) error
+}
+
+// The ValidatorFunc type is an adapter to allow the use of ordinary functions as Validators.
+type ValidatorFunc func(params any) error
+
+// Validate calls f(params).
+func (f ValidatorFunc) Validate(params any) error {
+	return f(params)
+}
+
+// ValidationError is returned to the caller when a Validator rejects a call.
+type ValidationError struct {
+	Selector string
+	Err      error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s for %s: %s", ErrInvalidParams, e.Selector, e.Err)
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return []error{ErrInvalidParams, e.Err}
+}
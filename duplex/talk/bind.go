@@ -0,0 +1,110 @@
+package talk
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Bind returns a T whose func fields call selectors of the same name with
+// caller, such as a Peer. Since Go can't implement interface methods at
+// runtime, T is a struct, or pointer to a struct, with a func field for
+// each remote method:
+//
+//	type Calc struct {
+//		Add func(ctx context.Context, a, b int) (int, error)
+//	}
+//	calc := talk.Bind[Calc](peer)
+//	sum, err := calc.Add(ctx, 1, 2)
+//
+// The arguments of a func are sent as an array, as expected by handlers
+// made with fn.HandlerFrom, after an optional first context.Context used
+// for the call. Funcs must return an error last, and can return a value
+// before it, which the reply is decoded into. Bind panics if T or one of
+// its exported func fields does not fit this form. Other fields are left
+// as they are.
+func Bind[T any](caller rpc.Caller) T {
+	var v T
+	rv := reflect.ValueOf(&v).Elem()
+	if rv.Kind() == reflect.Pointer && rv.Type().Elem().Kind() == reflect.Struct {
+		rv.Set(reflect.New(rv.Type().Elem()))
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("talk: Bind needs a struct of func fields, not %s", rv.Type()))
+	}
+	for i := 0; i < rv.NumField(); i++ {
+		f := rv.Type().Field(i)
+		if !f.IsExported() || f.Type.Kind() != reflect.Func {
+			continue
+		}
+		if err := checkBinding(f.Type); err != nil {
+			panic(fmt.Sprintf("talk: Bind %s.%s: %s", rv.Type(), f.Name, err))
+		}
+		rv.Field(i).Set(reflect.MakeFunc(f.Type, bindingFunc(caller, f.Name, f.Type)))
+	}
+	return v
+}
+
+// checkBinding checks that a func type can be bound to a selector.
+func checkBinding(t reflect.Type) error {
+	switch {
+	case t.NumOut() == 0 || t.Out(t.NumOut()-1) != errorType:
+		return fmt.Errorf("must return an error last")
+	case t.NumOut() > 2:
+		return fmt.Errorf("must return at most a value and an error")
+	}
+	return nil
+}
+
+// bindingFunc returns the implementation of a func type that calls selector.
+func bindingFunc(caller rpc.Caller, selector string, t reflect.Type) func([]reflect.Value) []reflect.Value {
+	return func(in []reflect.Value) []reflect.Value {
+		ctx := context.Background()
+		if len(in) > 0 && t.In(0) == contextType {
+			if c, ok := in[0].Interface().(context.Context); ok && c != nil {
+				ctx = c
+			}
+			in = in[1:]
+		}
+		args := make([]any, 0, len(in))
+		for i, arg := range in {
+			if t.IsVariadic() && i == len(in)-1 {
+				for j := 0; j < arg.Len(); j++ {
+					args = append(args, arg.Index(j).Interface())
+				}
+				continue
+			}
+			args = append(args, arg.Interface())
+		}
+
+		var reply []any
+		var ret reflect.Value
+		if t.NumOut() == 2 {
+			ret = reflect.New(t.Out(0))
+			reply = append(reply, ret.Interface())
+		}
+		_, err := caller.Call(ctx, selector, args, reply...)
+
+		out := make([]reflect.Value, 0, 2)
+		if ret.IsValid() {
+			if err != nil {
+				out = append(out, reflect.Zero(t.Out(0)))
+			} else {
+				out = append(out, ret.Elem())
+			}
+		}
+		errv := reflect.Zero(errorType)
+		if err != nil {
+			errv = reflect.ValueOf(err)
+		}
+		return append(out, errv)
+	}
+}
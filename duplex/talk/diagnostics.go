@@ -0,0 +1,221 @@
+package talk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+// Reserved selectors registered by ServeDiagnostics.
+const (
+	// EchoSelector returns its argument.
+	EchoSelector = "talk.diag.echo"
+	// SinkSelector continues the call, discards the bytes sent on the channel
+	// until the caller closes it for writing, then sends the number of bytes
+	// it received.
+	SinkSelector = "talk.diag.sink"
+	// SourceSelector is called with the number of bytes to send and a rate in
+	// bytes per second, or zero for no limit, and continues the call to send
+	// that many bytes on the channel before closing it.
+	SourceSelector = "talk.diag.source"
+)
+
+// diagChunkSize is the size of the writes made to measure throughput.
+const diagChunkSize = 32 << 10
+
+// ServeDiagnostics registers the diagnostic selectors on the Peer, which are
+// used by MeasureRTT, MeasureUpload and MeasureDownload on the remote side to
+// diagnose a deployed service. Callers can make the Peer send and receive as
+// much data as they ask for, so only serve them to trusted callers.
+func (p *Peer) ServeDiagnostics() {
+	p.Handle(EchoSelector, rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		var v any
+		if err := c.Receive(&v); err != nil {
+			r.Return(err)
+			return
+		}
+		r.Return(v)
+	}))
+
+	p.Handle(SinkSelector, rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		// the call has no argument to receive
+		c.Receive(nil)
+		ch, err := r.Continue()
+		if err != nil {
+			return
+		}
+		defer ch.Close()
+		n, err := io.Copy(io.Discard, ch)
+		if err != nil {
+			return
+		}
+		r.Send(n)
+	}))
+
+	p.Handle(SourceSelector, rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		var args []int64
+		if err := c.Receive(&args); err != nil {
+			r.Return(err)
+			return
+		}
+		if len(args) != 2 || args[0] < 0 || args[1] < 0 {
+			r.Return(fmt.Errorf("expected [bytes, rate], got %v", args))
+			return
+		}
+		ch, err := r.Continue()
+		if err != nil {
+			return
+		}
+		defer ch.Close()
+		writePaced(ch, args[0], args[1])
+		ch.CloseWrite()
+	}))
+}
+
+// writePaced writes n bytes to w, at no more than rate bytes per second if
+// rate is not zero.
+func writePaced(w io.Writer, n, rate int64) error {
+	chunk := make([]byte, diagChunkSize)
+	start := time.Now()
+	var sent int64
+	for sent < n {
+		b := chunk[:min(int64(len(chunk)), n-sent)]
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		sent += int64(len(b))
+		if rate > 0 {
+			due := start.Add(time.Duration(float64(sent) / float64(rate) * float64(time.Second)))
+			time.Sleep(time.Until(due))
+		}
+	}
+	return nil
+}
+
+// RTT is the round trip time of calls measured by MeasureRTT.
+type RTT struct {
+	Count int
+	Min   time.Duration
+	Mean  time.Duration
+	Max   time.Duration
+}
+
+func (r RTT) String() string {
+	return fmt.Sprintf("%d calls: min %s, mean %s, max %s", r.Count, r.Min, r.Mean, r.Max)
+}
+
+// MeasureRTT makes count calls in a row to EchoSelector on the remote side
+// with an argument of size bytes and returns their round trip times. Unlike
+// Ping, this measures the whole call path, including the handlers of the
+// remote side. The remote side must serve the selector with ServeDiagnostics.
+func (p *Peer) MeasureRTT(ctx context.Context, count, size int) (RTT, error) {
+	arg := make([]byte, size)
+	var rtt RTT
+	var total time.Duration
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		var ret []byte
+		if _, err := p.Call(ctx, EchoSelector, arg, &ret); err != nil {
+			return rtt, err
+		}
+		d := time.Since(start)
+		if len(ret) != size {
+			return rtt, fmt.Errorf("talk: echo returned %d bytes, expected %d", len(ret), size)
+		}
+		if rtt.Count == 0 || d < rtt.Min {
+			rtt.Min = d
+		}
+		if d > rtt.Max {
+			rtt.Max = d
+		}
+		rtt.Count++
+		total += d
+		rtt.Mean = total / time.Duration(rtt.Count)
+	}
+	return rtt, nil
+}
+
+// Throughput is the data transferred and the time it took, measured by
+// MeasureUpload or MeasureDownload.
+type Throughput struct {
+	Bytes    int64
+	Duration time.Duration
+}
+
+// BytesPerSecond returns the rate of the transfer.
+func (t Throughput) BytesPerSecond() float64 {
+	if t.Duration <= 0 {
+		return 0
+	}
+	return float64(t.Bytes) / t.Duration.Seconds()
+}
+
+func (t Throughput) String() string {
+	return fmt.Sprintf("%d bytes in %s (%.2f MB/s)", t.Bytes, t.Duration, t.BytesPerSecond()/1e6)
+}
+
+// MeasureUpload sends n bytes to SinkSelector on the remote side and returns
+// the throughput, timed until the remote side confirms it received all of
+// them. The remote side must serve the selector with ServeDiagnostics.
+func (p *Peer) MeasureUpload(ctx context.Context, n int64) (Throughput, error) {
+	start := time.Now()
+	resp, err := p.Call(ctx, SinkSelector, nil)
+	if err != nil {
+		return Throughput{}, err
+	}
+	defer resp.Close()
+	stop := context.AfterFunc(ctx, func() { resp.Close() })
+	defer stop()
+
+	if err := writePaced(resp.Channel, n, 0); err != nil {
+		return Throughput{}, ctxErr(ctx, err)
+	}
+	if err := resp.CloseWrite(); err != nil {
+		return Throughput{}, ctxErr(ctx, err)
+	}
+	var received int64
+	if err := resp.Receive(&received); err != nil {
+		return Throughput{}, ctxErr(ctx, err)
+	}
+	t := Throughput{Bytes: received, Duration: time.Since(start)}
+	if received != n {
+		return t, fmt.Errorf("talk: sink received %d bytes, expected %d", received, n)
+	}
+	return t, nil
+}
+
+// MeasureDownload receives n bytes from SourceSelector on the remote side,
+// sent at no more than rate bytes per second if rate is not zero, and returns
+// the throughput. The remote side must serve the selector with ServeDiagnostics.
+func (p *Peer) MeasureDownload(ctx context.Context, n, rate int64) (Throughput, error) {
+	start := time.Now()
+	resp, err := p.Call(ctx, SourceSelector, []int64{n, rate})
+	if err != nil {
+		return Throughput{}, err
+	}
+	defer resp.Close()
+	stop := context.AfterFunc(ctx, func() { resp.Close() })
+	defer stop()
+
+	received, err := io.Copy(io.Discard, resp.Channel)
+	t := Throughput{Bytes: received, Duration: time.Since(start)}
+	if err != nil {
+		return t, ctxErr(ctx, err)
+	}
+	if received != n {
+		return t, ctxErr(ctx, fmt.Errorf("talk: source sent %d bytes, expected %d", received, n))
+	}
+	return t, nil
+}
+
+// ctxErr returns the error of ctx if it is done, which caused err by
+// closing the channel, or else err.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
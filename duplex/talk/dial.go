@@ -19,6 +19,9 @@ func init() {
 		"tcp":  mux.DialTCP,
 		"unix": mux.DialUnix,
 		"ws":   mux.DialWS,
+		"tls": func(addr string) (mux.Session, error) {
+			return mux.DialTLS(addr, nil)
+		},
 		"stdio": func(_ string) (mux.Session, error) {
 			return mux.DialStdio()
 		},
@@ -26,7 +29,7 @@ func init() {
 }
 
 // Dial connects to a remote address using a registered transport and returns a Peer.
-// Available transports are "tcp", "unix", "ws", and "stdio". In the case of "stdio",
+// Available transports are "tcp", "unix", "ws", "tls", and "stdio". In the case of "stdio",
 // the addr can be left an empty string.
 func Dial(transport, addr string, codec codec.Codec) (*Peer, error) {
 	d, ok := Dialers[transport]
@@ -0,0 +1,97 @@
+package talk
+
+import (
+	"context"
+	"sync"
+)
+
+// Group is a set of peers that calls can be broadcast to, such as the clients
+// connected to a hub. Peers are removed from the group when their session ends.
+// The zero value is an empty group without a concurrency limit.
+type Group struct {
+	// Limit is the most calls Broadcast makes at once, or unlimited if zero.
+	Limit int
+
+	mu    sync.Mutex
+	peers map[*Peer]struct{}
+}
+
+// BroadcastResult is the result of a broadcast call to one peer.
+type BroadcastResult struct {
+	Peer  *Peer
+	Reply any
+	Err   error
+}
+
+// Add adds a peer to the group until it is removed or its session ends.
+func (g *Group) Add(p *Peer) {
+	g.mu.Lock()
+	if g.peers == nil {
+		g.peers = make(map[*Peer]struct{})
+	}
+	_, ok := g.peers[p]
+	g.peers[p] = struct{}{}
+	g.mu.Unlock()
+	if !ok {
+		go func() {
+			p.Session.Wait()
+			g.Remove(p)
+		}()
+	}
+}
+
+// Remove removes a peer from the group.
+func (g *Group) Remove(p *Peer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.peers, p)
+}
+
+// Peers returns the peers in the group.
+func (g *Group) Peers() []*Peer {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	peers := make([]*Peer, 0, len(g.peers))
+	for p := range g.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Len returns the number of peers in the group.
+func (g *Group) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.peers)
+}
+
+// Broadcast calls selector with params on every peer in the group and returns
+// the result of each call once all calls are done, making at most Limit calls
+// at once. Calls not started before ctx is done fail with the error of ctx.
+func (g *Group) Broadcast(ctx context.Context, selector string, params any) []BroadcastResult {
+	peers := g.Peers()
+	results := make([]BroadcastResult, len(peers))
+	limit := g.Limit
+	if limit <= 0 || limit > len(peers) {
+		limit = len(peers)
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		results[i].Peer = p
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(r *BroadcastResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_, r.Err = r.Peer.Call(ctx, selector, params, &r.Reply)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,133 @@
+package talk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+)
+
+// ProtocolVersion is the version of the protocol between peers sent
+// in the handshake.
+const ProtocolVersion = 1
+
+// PeerInfo describes a peer to the remote side in the handshake.
+type PeerInfo struct {
+	// Identity names the peer, such as a service or user name.
+	Identity string `json:"identity,omitempty"`
+	// Version is the protocol version of the peer. It is set to
+	// ProtocolVersion if zero.
+	Version int `json:"version"`
+	// Codecs are the content types of the codecs the peer supports. They
+	// are set to the content types in codec.DefaultRegistry if empty.
+	Codecs []string `json:"codecs,omitempty"`
+	// Metadata is arbitrary data about the peer, such as capabilities.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// handshakeHello is sent over the handshake channel, always encoded as JSON.
+type handshakeHello struct {
+	Kind string `json:"kind"`
+	PeerInfo
+}
+
+// handshakeAnswer is sent back over the handshake channel, always encoded as JSON.
+type handshakeAnswer struct {
+	Info  PeerInfo `json:"info"`
+	Error string   `json:"error,omitempty"`
+}
+
+func (info PeerInfo) withDefaults() PeerInfo {
+	if info.Version == 0 {
+		info.Version = ProtocolVersion
+	}
+	if len(info.Codecs) == 0 {
+		info.Codecs = codec.DefaultRegistry.ContentTypes()
+	}
+	return info
+}
+
+// Handshake opens the first channel of the session to send info and receive
+// the info of the remote side, which is returned and kept for RemoteInfo. The
+// remote side must use AcceptHandshake before responding to calls.
+//
+// The Codecs of PeerInfo only describe the peers, since the codec of a Peer is
+// chosen before the handshake. When the Peer is made with NegotiatePeer, the
+// codec is negotiated on the first channel and the handshake is made on the
+// next one, so the remote side must use AcceptPeer before AcceptHandshake.
+// Using them in a different order fails on both sides.
+func (p *Peer) Handshake(ctx context.Context, info PeerInfo) (PeerInfo, error) {
+	ch, err := p.Session.Open(ctx)
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	defer ch.Close()
+	defer bindContext(ctx, ch)()
+
+	if err := json.NewEncoder(ch).Encode(handshakeHello{Kind: kindHandshake, PeerInfo: info.withDefaults()}); err != nil {
+		return PeerInfo{}, contextErr(ctx, err)
+	}
+	var answer handshakeAnswer
+	if err := json.NewDecoder(ch).Decode(&answer); err != nil {
+		return PeerInfo{}, contextErr(ctx, err)
+	}
+	if answer.Error != "" {
+		return PeerInfo{}, fmt.Errorf("talk: handshake: %s", answer.Error)
+	}
+	p.setRemoteInfo(answer.Info)
+	return answer.Info, nil
+}
+
+// AcceptHandshake accepts the first channel of the session to receive the info
+// of the remote side sent with Handshake and answer with info. If check is not
+// nil, it is called with the remote info and an error from it is sent back and
+// returned, such as to refuse an unsupported version. The remote info is
+// returned and kept for RemoteInfo.
+func (p *Peer) AcceptHandshake(ctx context.Context, info PeerInfo, check func(PeerInfo) error) (PeerInfo, error) {
+	ch, err := p.Session.AcceptContext(ctx)
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	defer ch.Close()
+	defer bindContext(ctx, ch)()
+
+	var hello handshakeHello
+	if err := json.NewDecoder(ch).Decode(&hello); err != nil {
+		return PeerInfo{}, contextErr(ctx, err)
+	}
+	remote := hello.PeerInfo
+	answer := handshakeAnswer{Info: info.withDefaults()}
+	if hello.Kind != kindHandshake {
+		answer = handshakeAnswer{Error: kindError(kindHandshake, hello.Kind).Error()}
+	} else if check != nil {
+		if err := check(remote); err != nil {
+			answer = handshakeAnswer{Error: err.Error()}
+		}
+	}
+	if err := json.NewEncoder(ch).Encode(answer); err != nil {
+		return PeerInfo{}, contextErr(ctx, err)
+	}
+	if answer.Error != "" {
+		return PeerInfo{}, fmt.Errorf("talk: handshake: %s", answer.Error)
+	}
+	p.setRemoteInfo(remote)
+	return remote, nil
+}
+
+// RemoteInfo returns the info of the remote side from the handshake,
+// or false if there was none.
+func (p *Peer) RemoteInfo() (PeerInfo, bool) {
+	p.infoMu.Lock()
+	defer p.infoMu.Unlock()
+	if p.remoteInfo == nil {
+		return PeerInfo{}, false
+	}
+	return *p.remoteInfo, true
+}
+
+func (p *Peer) setRemoteInfo(info PeerInfo) {
+	p.infoMu.Lock()
+	defer p.infoMu.Unlock()
+	p.remoteInfo = &info
+}
@@ -0,0 +1,156 @@
+package talk
+
+import (
+	"context"
+	"sync"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+// Direction is whether a call is made or handled by a Peer.
+type Direction int
+
+const (
+	// Outgoing calls are made by the Peer.
+	Outgoing Direction = iota
+	// Incoming calls are handled by the Peer.
+	Incoming
+)
+
+func (d Direction) String() string {
+	if d == Outgoing {
+		return "outgoing"
+	}
+	return "incoming"
+}
+
+// CallInfo describes a call to an Interceptor.
+type CallInfo struct {
+	Peer *Peer
+	// Selector is the selector of the call in the form given by
+	// rpc.CleanSelector for both directions.
+	Selector  string
+	Direction Direction
+}
+
+// Interceptor is called around each call made or handled by a Peer, so both
+// sides can be logged, timed or counted in one place. It continues the call by
+// calling next with ctx or a context derived from it, and returns the error from
+// next, or returns an error without calling next to fail the call. The error of
+// a handled call is the error it returned, if any.
+type Interceptor func(ctx context.Context, info CallInfo, next func(ctx context.Context) error) error
+
+// Use adds interceptors to the Peer, with the first being the outermost. Calls
+// handled by the Peer are intercepted by wrapping the Handler of its Server, so
+// Use must be called after setting the Handler.
+func (p *Peer) Use(interceptors ...Interceptor) {
+	p.hookMu.Lock()
+	defer p.hookMu.Unlock()
+	if len(p.interceptors) == 0 {
+		p.Server.Handler = &interceptedHandler{peer: p, next: p.Server.Handler}
+	}
+	p.interceptors = append(p.interceptors, interceptors...)
+}
+
+// intercept runs fn inside the interceptors of the Peer.
+func (p *Peer) intercept(ctx context.Context, info CallInfo, fn func(ctx context.Context) error) error {
+	p.hookMu.Lock()
+	interceptors := p.interceptors
+	p.hookMu.Unlock()
+	next := fn
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		ic, inner := interceptors[i], next
+		next = func(ctx context.Context) error {
+			return ic(ctx, info, inner)
+		}
+	}
+	return next(ctx)
+}
+
+// Call makes a call with the Client of the Peer inside its interceptors.
+func (p *Peer) Call(ctx context.Context, selector string, params any, reply ...any) (*rpc.Response, error) {
+	var resp *rpc.Response
+	err := p.intercept(ctx, CallInfo{Peer: p, Selector: rpc.CleanSelector(selector), Direction: Outgoing}, func(ctx context.Context) error {
+		var err error
+		resp, err = p.Client.Call(ctx, selector, params, reply...)
+		return err
+	})
+	return resp, err
+}
+
+type interceptedHandler struct {
+	peer *Peer
+	next rpc.Handler
+}
+
+func (h *interceptedHandler) RespondRPC(r rpc.Responder, c *rpc.Call) {
+	next := h.next
+	if next == nil {
+		next = rpc.NotFoundHandler()
+	}
+	ctx := c.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	er := &errorResponder{Responder: r}
+	err := h.peer.intercept(ctx, CallInfo{Peer: h.peer, Selector: c.Selector(), Direction: Incoming}, func(ctx context.Context) error {
+		c.Context = ctx
+		next.RespondRPC(er, c)
+		return er.result()
+	})
+	if err != nil && er.respond() {
+		r.Return(err)
+	}
+}
+
+// errorResponder keeps the error returned by a handler, which can return
+// after RespondRPC does, such as when it times out.
+type errorResponder struct {
+	rpc.Responder
+
+	mu       sync.Mutex
+	returned bool
+	err      error
+}
+
+// result returns the error returned by the handler so far.
+func (r *errorResponder) result() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// respond marks the call responded to, returning false if the handler
+// already responded.
+func (r *errorResponder) respond() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.returned {
+		return false
+	}
+	r.returned = true
+	return true
+}
+
+func (r *errorResponder) Return(v ...any) error {
+	r.mu.Lock()
+	r.returned = true
+	if len(v) > 0 {
+		r.err, _ = v[len(v)-1].(error)
+	}
+	r.mu.Unlock()
+	return r.Responder.Return(v...)
+}
+
+// Unwrap returns the wrapped responder for rpc.ProxyCall.
+func (r *errorResponder) Unwrap() rpc.Responder {
+	return r.Responder
+}
+
+func (r *errorResponder) Continue(v ...any) (mux.Channel, error) {
+	r.mu.Lock()
+	r.returned = true
+	r.mu.Unlock()
+	return r.Responder.Continue(v...)
+}
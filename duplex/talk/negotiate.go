@@ -0,0 +1,129 @@
+package talk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+// Kinds of the first value sent on the channels opened by NegotiatePeer and
+// Handshake, so a side accepting one of them can tell when the remote side
+// used the other, instead of misreading it.
+const (
+	kindCodec     = "codec"
+	kindHandshake = "handshake"
+)
+
+// kindError returns the error for a channel of the wrong kind.
+func kindError(want, got string) error {
+	return fmt.Errorf("expected %s channel but got %q: NegotiatePeer and Handshake must be used in the same order on both sides", want, got)
+}
+
+// codecOffer is sent over the negotiation channel, always encoded as JSON.
+type codecOffer struct {
+	Kind   string   `json:"kind"`
+	Codecs []string `json:"codecs"`
+}
+
+// codecAnswer is sent back over the negotiation channel, always encoded as JSON.
+type codecAnswer struct {
+	Codec string `json:"codec,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// NegotiatePeer opens the first channel of the session to propose codecs by
+// content type, in order of preference, and returns a Peer using the codec the
+// remote side agrees on. Content types are looked up in codec.DefaultRegistry.
+// The remote side must use AcceptPeer before responding to calls.
+//
+// Sessions made with the mux.Negotiate option already agree on codecs in the
+// handshake of the session, which can be read with mux.NegotiatedOf, so they
+// don't need NegotiatePeer. With Handshake, the codec is negotiated first.
+func NegotiatePeer(ctx context.Context, sess mux.Session, contentTypes ...string) (*Peer, error) {
+	ch, err := sess.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+	defer bindContext(ctx, ch)()
+
+	if err := json.NewEncoder(ch).Encode(codecOffer{Kind: kindCodec, Codecs: contentTypes}); err != nil {
+		return nil, contextErr(ctx, err)
+	}
+	var answer codecAnswer
+	if err := json.NewDecoder(ch).Decode(&answer); err != nil {
+		return nil, contextErr(ctx, err)
+	}
+	if answer.Error != "" {
+		return nil, fmt.Errorf("talk: codec negotiation: %s", answer.Error)
+	}
+	c, ok := codec.Lookup(answer.Codec)
+	if !ok {
+		return nil, fmt.Errorf("talk: codec negotiation: unknown codec '%s'", answer.Codec)
+	}
+	return NewPeer(sess, c), nil
+}
+
+// AcceptPeer accepts the first channel of the session to agree on a codec
+// proposed by the remote side using NegotiatePeer, and returns a Peer using it.
+// The first proposed content type that is in contentTypes is chosen, or that is
+// registered in codec.DefaultRegistry if contentTypes is empty.
+func AcceptPeer(ctx context.Context, sess mux.Session, contentTypes ...string) (*Peer, error) {
+	ch, err := sess.AcceptContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+	defer bindContext(ctx, ch)()
+
+	var offer codecOffer
+	if err := json.NewDecoder(ch).Decode(&offer); err != nil {
+		return nil, contextErr(ctx, err)
+	}
+	answer := codecAnswer{Error: fmt.Sprintf("no supported codec in %v", offer.Codecs)}
+	if offer.Kind != kindCodec {
+		answer = codecAnswer{Error: kindError(kindCodec, offer.Kind).Error()}
+		offer.Codecs = nil
+	}
+	for _, name := range offer.Codecs {
+		if _, ok := codec.Lookup(name); ok && (len(contentTypes) == 0 || slices.Contains(contentTypes, name)) {
+			answer = codecAnswer{Codec: name}
+			break
+		}
+	}
+	if err := json.NewEncoder(ch).Encode(answer); err != nil {
+		return nil, contextErr(ctx, err)
+	}
+	if answer.Error != "" {
+		return nil, fmt.Errorf("talk: codec negotiation: %s", answer.Error)
+	}
+	c, _ := codec.Lookup(answer.Codec)
+	return NewPeer(sess, c), nil
+}
+
+// bindContext applies the deadline of ctx to ch and closes ch when ctx is
+// done, so a remote side that stops answering can't block reads forever.
+// Calling the returned function stops closing ch.
+func bindContext(ctx context.Context, ch mux.Channel) (stop func() bool) {
+	if d, ok := ctx.Deadline(); ok {
+		ch.SetDeadline(d)
+	}
+	return context.AfterFunc(ctx, func() { ch.Close() })
+}
+
+// contextErr returns the error of ctx if it is done or past its deadline,
+// since it is the cause of err, otherwise err.
+func contextErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
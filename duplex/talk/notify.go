@@ -0,0 +1,97 @@
+package talk
+
+import (
+	"context"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+// HandlerChangeSelector is the reserved selector called on the remote side
+// to notify it of handlers added or removed on a Peer with NotifyHandlers set.
+const HandlerChangeSelector = "talk.handlers"
+
+// handlerNotifyTimeout bounds each notification call.
+const handlerNotifyTimeout = 10 * time.Second
+
+// HandlerChange is a handler added or removed on the remote side.
+type HandlerChange struct {
+	Pattern string
+	Removed bool
+}
+
+// Handle registers the handler for the given pattern like RespondMux.Handle,
+// and notifies the remote side if NotifyHandlers is set.
+func (p *Peer) Handle(pattern string, handler rpc.Handler) {
+	p.RespondMux.Handle(pattern, handler)
+	p.notifyHandler(HandlerChange{Pattern: pattern})
+}
+
+// Remove removes the handler for the given selector like RespondMux.Remove,
+// and notifies the remote side if NotifyHandlers is set.
+func (p *Peer) Remove(selector string) rpc.Handler {
+	h := p.RespondMux.Remove(selector)
+	if h != nil {
+		p.notifyHandler(HandlerChange{Pattern: selector, Removed: true})
+	}
+	return h
+}
+
+// OnHandlerChange calls fn when the remote side notifies the Peer of a handler
+// added or removed, which it does if it has NotifyHandlers set. It registers a
+// handler for HandlerChangeSelector on the RespondMux of the Peer.
+func (p *Peer) OnHandlerChange(fn func(HandlerChange)) {
+	p.hookMu.Lock()
+	defer p.hookMu.Unlock()
+	if p.changeFuncs == nil {
+		p.RespondMux.Handle(HandlerChangeSelector, rpc.HandlerFunc(p.handleChange))
+	}
+	p.changeFuncs = append(p.changeFuncs, fn)
+}
+
+func (p *Peer) handleChange(r rpc.Responder, c *rpc.Call) {
+	var change HandlerChange
+	if err := c.Receive(&change); err != nil {
+		r.Return(err)
+		return
+	}
+	p.hookMu.Lock()
+	funcs := p.changeFuncs
+	p.hookMu.Unlock()
+	for _, fn := range funcs {
+		fn(change)
+	}
+}
+
+// notifyHandler queues a notification, sent in order by a goroutine that
+// runs while there are notifications to send, so registering handlers
+// doesn't wait for the remote side.
+func (p *Peer) notifyHandler(change HandlerChange) {
+	if !p.NotifyHandlers {
+		return
+	}
+	p.hookMu.Lock()
+	defer p.hookMu.Unlock()
+	p.changes = append(p.changes, change)
+	if p.notifying {
+		return
+	}
+	p.notifying = true
+	go func() {
+		for {
+			p.hookMu.Lock()
+			if len(p.changes) == 0 {
+				p.notifying = false
+				p.hookMu.Unlock()
+				return
+			}
+			change := p.changes[0]
+			p.changes = p.changes[1:]
+			p.hookMu.Unlock()
+
+			ctx, cancel := context.WithTimeout(context.Background(), handlerNotifyTimeout)
+			p.Client.Call(ctx, HandlerChangeSelector, change)
+			cancel()
+		}
+	}()
+}
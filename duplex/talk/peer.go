@@ -1,6 +1,10 @@
 package talk
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"tractor.dev/toolkit-go/duplex/codec"
 	"tractor.dev/toolkit-go/duplex/mux"
 	"tractor.dev/toolkit-go/duplex/rpc"
@@ -13,6 +17,27 @@ type Peer struct {
 	*rpc.Server
 	*rpc.RespondMux
 	codec.Codec
+
+	// DrainTimeout is how long Serve waits for calls in progress once its
+	// context is done before closing the session. It defaults to 10 seconds.
+	DrainTimeout time.Duration
+
+	// NotifyHandlers makes Handle and Remove notify the remote side of the
+	// handler added or removed, which it can react to with OnHandlerChange.
+	NotifyHandlers bool
+
+	infoMu     sync.Mutex
+	remoteInfo *PeerInfo
+
+	hookMu       sync.Mutex
+	interceptors []Interceptor
+	changeFuncs  []func(HandlerChange)
+	changes      []HandlerChange
+	notifying    bool
+
+	disconnectFuncs []func(error)
+	disconnected    bool
+	disconnectErr   error
 }
 
 // NewPeer returns a Peer based on a session and codec.
@@ -37,3 +62,23 @@ func (p *Peer) Close() error {
 func (p *Peer) Respond() {
 	p.Server.Respond(p.Session, nil)
 }
+
+// Serve responds to calls like Respond until the session is closed, returning
+// nil, or accepting a channel fails, returning the error. Errors dispatching
+// calls are passed to the ErrorHandler of the Server. Once ctx is done, Serve
+// stops accepting calls and drains the session, waiting up to DrainTimeout for
+// calls in progress, and returns any error draining it.
+func (p *Peer) Serve(ctx context.Context) error {
+	err := p.Server.ServeSession(ctx, p.Session)
+	if ctx.Err() == nil {
+		p.Session.Close()
+		return err
+	}
+	timeout := p.DrainTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return p.Session.Drain(drainCtx)
+}
@@ -2,12 +2,19 @@ package talk
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/fn"
 	"tractor.dev/toolkit-go/duplex/mux"
 	"tractor.dev/toolkit-go/duplex/rpc"
+	"tractor.dev/toolkit-go/duplex/transport"
 )
 
 func TestPeerBidirectional(t *testing.T) {
@@ -49,3 +56,694 @@ func TestPeerBidirectional(t *testing.T) {
 		t.Fatal("unexpected return:", retA)
 	}
 }
+
+func TestNegotiatePeer(t *testing.T) {
+	ar, bw := io.Pipe()
+	br, aw := io.Pipe()
+	sessA, _ := mux.DialIO(aw, ar)
+	sessB, _ := mux.DialIO(bw, br)
+
+	ctx := context.Background()
+	accepted := make(chan *Peer)
+	go func() {
+		peer, err := AcceptPeer(ctx, sessB, "application/json")
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- peer
+	}()
+	peerA, err := NegotiatePeer(ctx, sessA, "application/cbor", "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peerA.Close()
+	peerB := <-accepted
+	if peerB == nil {
+		t.Fatal("expected accepted peer")
+	}
+	defer peerB.Close()
+
+	if _, ok := peerA.Codec.(codec.JSONCodec); !ok {
+		t.Fatalf("unexpected codec: %T", peerA.Codec)
+	}
+	if _, ok := peerB.Codec.(codec.JSONCodec); !ok {
+		t.Fatalf("unexpected codec: %T", peerB.Codec)
+	}
+
+	peerB.Handle("hello", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		r.Return("B")
+	}))
+	go peerB.Respond()
+
+	var ret string
+	if _, err := peerA.Call(ctx, "hello", nil, &ret); err != nil {
+		t.Fatal(err)
+	}
+	if ret != "B" {
+		t.Fatal("unexpected return:", ret)
+	}
+}
+
+func TestNegotiatePeerNoCodec(t *testing.T) {
+	ar, bw := io.Pipe()
+	br, aw := io.Pipe()
+	sessA, _ := mux.DialIO(aw, ar)
+	sessB, _ := mux.DialIO(bw, br)
+	defer sessA.Close()
+	defer sessB.Close()
+
+	ctx := context.Background()
+	go AcceptPeer(ctx, sessB, "application/cbor")
+	_, err := NegotiatePeer(ctx, sessA, "application/json")
+	if err == nil || !strings.Contains(err.Error(), "no supported codec") {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestNegotiatePeerTimeout(t *testing.T) {
+	ar, bw := io.Pipe()
+	br, aw := io.Pipe()
+	sessA, _ := mux.DialIO(aw, ar)
+	sessB, _ := mux.DialIO(bw, br)
+	defer sessA.Close()
+	defer sessB.Close()
+
+	// the remote side accepts the channel but never answers
+	go sessB.Accept()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := NegotiatePeer(ctx, sessA, "application/json")
+	if err != context.DeadlineExceeded {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestRedialPeer(t *testing.T) {
+	l, err := mux.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	servers := make(chan *Peer, 8)
+	go func() {
+		for {
+			sess, err := l.Accept()
+			if err != nil {
+				return
+			}
+			p := NewPeer(sess, codec.JSONCodec{})
+			p.Handle("hello", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+				r.Return("server")
+			}))
+			go p.Respond()
+			servers <- p
+		}
+	}()
+
+	r := transport.NewRedialer("tcp://" + l.Addr().String())
+	r.MinBackoff = 10 * time.Millisecond
+	peer := RedialPeer(r, codec.JSONCodec{}, QueueCalls)
+	defer peer.Close()
+	peer.Handle("hello", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		r.Return("client")
+	}))
+	go peer.Respond()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		var ret string
+		if _, err := peer.Call(ctx, "hello", nil, &ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != "server" {
+			t.Fatal("unexpected return:", ret)
+		}
+
+		// handlers are served over each session
+		server := <-servers
+		if _, err := server.Call(ctx, "hello", nil, &ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != "client" {
+			t.Fatal("unexpected return:", ret)
+		}
+
+		// the call after this waits for the session to be redialed
+		server.Close()
+	}
+}
+
+func TestRedialPeerFailFast(t *testing.T) {
+	r := &transport.Redialer{
+		Dial: func(ctx context.Context) (mux.Session, error) {
+			return nil, io.ErrUnexpectedEOF
+		},
+	}
+	peer := RedialPeer(r, codec.JSONCodec{}, FailFast)
+	if _, err := peer.Call(context.Background(), "hello", nil); err != ErrDisconnected {
+		t.Fatal("expected disconnected error:", err)
+	}
+	peer.Close()
+	if _, err := peer.Call(context.Background(), "hello", nil); err == nil {
+		t.Fatal("expected error after close")
+	}
+}
+
+func TestGroup(t *testing.T) {
+	g := &Group{Limit: 2}
+	var servers []*Peer
+	for i := 0; i < 5; i++ {
+		a, b := mux.Pair()
+		client := NewPeer(a, codec.JSONCodec{})
+		server := NewPeer(b, codec.JSONCodec{})
+		if i == 0 {
+			client.Handle("hello", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+				r.Return(fmt.Errorf("failed"))
+			}))
+		} else {
+			client.Handle("hello", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+				var name string
+				c.Receive(&name)
+				r.Return("hello " + name)
+			}))
+		}
+		go client.Respond()
+		g.Add(server)
+		servers = append(servers, server)
+		defer client.Close()
+	}
+
+	results := g.Broadcast(context.Background(), "hello", "group")
+	if len(results) != 5 {
+		t.Fatal("unexpected results:", len(results))
+	}
+	var failed int
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			continue
+		}
+		if r.Reply != "hello group" {
+			t.Fatal("unexpected reply:", r.Reply)
+		}
+	}
+	if failed != 1 {
+		t.Fatal("expected one failed call:", failed)
+	}
+
+	// peers are removed when their session ends
+	servers[0].Close()
+	for g.Len() != 4 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPeerHandshake(t *testing.T) {
+	a, b := mux.Pair()
+	peerA := NewPeer(a, codec.JSONCodec{})
+	peerB := NewPeer(b, codec.JSONCodec{})
+	defer peerA.Close()
+	defer peerB.Close()
+
+	ctx := context.Background()
+	done := make(chan PeerInfo)
+	go func() {
+		info, err := peerB.AcceptHandshake(ctx, PeerInfo{Identity: "server"}, func(info PeerInfo) error {
+			if info.Metadata["feature"] != "on" {
+				return fmt.Errorf("feature required")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- info
+	}()
+
+	if _, ok := peerA.RemoteInfo(); ok {
+		t.Fatal("unexpected remote info before handshake")
+	}
+	info, err := peerA.Handshake(ctx, PeerInfo{Identity: "client", Metadata: map[string]string{"feature": "on"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Identity != "server" || info.Version != ProtocolVersion || len(info.Codecs) == 0 {
+		t.Fatal("unexpected server info:", info)
+	}
+	remote := <-done
+	if remote.Identity != "client" {
+		t.Fatal("unexpected client info:", remote)
+	}
+	if got, _ := peerB.RemoteInfo(); got.Identity != "client" {
+		t.Fatal("unexpected remote info:", got)
+	}
+
+	// a failed check is returned on both sides
+	go peerB.AcceptHandshake(ctx, PeerInfo{}, func(PeerInfo) error {
+		return fmt.Errorf("refused")
+	})
+	if _, err := peerA.Handshake(ctx, PeerInfo{}); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Fatal("expected refused handshake:", err)
+	}
+
+	// a codec negotiation is not mistaken for a handshake
+	go NegotiatePeer(ctx, a, "application/json")
+	if _, err := peerB.AcceptHandshake(ctx, PeerInfo{}, nil); err == nil || !strings.Contains(err.Error(), "same order") {
+		t.Fatal("expected order error:", err)
+	}
+
+	// nor a handshake for a codec negotiation
+	go peerA.Handshake(ctx, PeerInfo{})
+	if _, err := AcceptPeer(ctx, b); err == nil || !strings.Contains(err.Error(), "same order") {
+		t.Fatal("expected order error:", err)
+	}
+
+	// a silent remote side doesn't block past the context
+	go b.Accept()
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := peerA.Handshake(tctx, PeerInfo{}); err != context.DeadlineExceeded {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestPeerServe(t *testing.T) {
+	a, b := mux.Pair()
+	client := NewPeer(a, codec.JSONCodec{})
+	server := NewPeer(b, codec.JSONCodec{})
+	defer client.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server.Handle("slow", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		close(started)
+		<-release
+		r.Return("done")
+	}))
+	errs := make(chan error, 1)
+	server.ErrorHandler = func(err error) {
+		errs <- err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- server.Serve(ctx)
+	}()
+
+	// a call that can't be decoded is reported
+	ch, err := client.Session.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.Write([]byte{0, 0, 0, 1, '!'})
+	ch.Close()
+	if err := <-errs; err == nil {
+		t.Fatal("expected dispatch error")
+	}
+
+	// the call in progress finishes while draining
+	replied := make(chan string)
+	go func() {
+		var ret string
+		client.Call(context.Background(), "slow", nil, &ret)
+		replied <- ret
+	}()
+	<-started
+	cancel()
+	close(release)
+	if ret := <-replied; ret != "done" {
+		t.Fatal("unexpected return:", ret)
+	}
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPeerInterceptors(t *testing.T) {
+	a, b := mux.Pair()
+	client := NewPeer(a, codec.JSONCodec{})
+	server := NewPeer(b, codec.JSONCodec{})
+	defer client.Close()
+	defer server.Close()
+	server.Handle("hello", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		r.Return("hello")
+	}))
+	server.Handle("fail", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		r.Return(fmt.Errorf("failed"))
+	}))
+
+	var mu sync.Mutex
+	var log []string
+	logger := func(ctx context.Context, info CallInfo, next func(context.Context) error) error {
+		err := next(ctx)
+		mu.Lock()
+		log = append(log, fmt.Sprintf("%s %s %v", info.Direction, info.Selector, err != nil))
+		mu.Unlock()
+		return err
+	}
+	client.Use(logger)
+	server.Use(logger, func(ctx context.Context, info CallInfo, next func(context.Context) error) error {
+		if info.Selector == "/secret" {
+			return fmt.Errorf("refused")
+		}
+		return next(ctx)
+	})
+	go server.Respond()
+
+	ctx := context.Background()
+	var ret string
+	if _, err := client.Call(ctx, "hello", nil, &ret); err != nil || ret != "hello" {
+		t.Fatal("unexpected result:", ret, err)
+	}
+	if _, err := client.Call(ctx, "fail", nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := client.Call(ctx, "secret", nil); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Fatal("expected refused call:", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"incoming /hello false", "outgoing /hello false",
+		"incoming /fail true", "outgoing /fail true",
+		"incoming /secret true", "outgoing /secret true",
+	}
+	if fmt.Sprint(log) != fmt.Sprint(want) {
+		t.Fatalf("unexpected log: %q", log)
+	}
+}
+
+func TestPool(t *testing.T) {
+	l, err := mux.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var mu sync.Mutex
+	calls := make(map[mux.Session]int)
+	go func() {
+		for {
+			sess, err := l.Accept()
+			if err != nil {
+				return
+			}
+			p := NewPeer(sess, codec.JSONCodec{})
+			p.Handle("hello", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+				mu.Lock()
+				calls[sess]++
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+			}))
+			go p.Respond()
+		}
+	}()
+
+	pool := NewPool(func(ctx context.Context) (mux.Session, error) {
+		return transport.Dial(ctx, "tcp://"+l.Addr().String())
+	}, 3, codec.JSONCodec{})
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.Call(context.Background(), "hello", nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// concurrent calls are spread across all the sessions
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 3 {
+		t.Fatal("unexpected sessions used:", len(calls))
+	}
+	if len(pool.Peers()) != 3 {
+		t.Fatal("unexpected peers")
+	}
+}
+
+func TestPeerHandlerNotifications(t *testing.T) {
+	a, b := mux.Pair()
+	server := NewPeer(a, codec.JSONCodec{})
+	client := NewPeer(b, codec.JSONCodec{})
+	defer server.Close()
+	defer client.Close()
+
+	changes := make(chan HandlerChange, 2)
+	client.OnHandlerChange(func(c HandlerChange) {
+		changes <- c
+	})
+	go client.Respond()
+	go server.Respond()
+
+	server.NotifyHandlers = true
+	server.Handle("feature", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {}))
+	server.Remove("feature")
+
+	for _, want := range []HandlerChange{{Pattern: "feature"}, {Pattern: "feature", Removed: true}} {
+		select {
+		case got := <-changes:
+			if got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for change")
+		}
+	}
+}
+
+func TestPeerRoute(t *testing.T) {
+	// client -> gateway -> backend
+	c1, g1 := mux.Pair()
+	g2, b2 := mux.Pair()
+	client := NewPeer(c1, codec.JSONCodec{})
+	gateway := NewPeer(g1, codec.JSONCodec{})
+	upstream := NewPeer(g2, codec.JSONCodec{})
+	backend := NewPeer(b2, codec.JSONCodec{})
+	defer client.Close()
+	defer gateway.Close()
+	defer backend.Close()
+
+	backend.Handle("remote", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		r.Return("backend")
+	}))
+	go backend.Respond()
+	gateway.Handle("local", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		r.Return("gateway")
+	}))
+	gateway.Use(func(ctx context.Context, info CallInfo, next func(context.Context) error) error {
+		return next(ctx)
+	})
+	gateway.Route(Upstream(upstream), Default(rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		r.Return("default")
+	})))
+	go gateway.Respond()
+
+	call := func(selector string) string {
+		t.Helper()
+		var ret string
+		if _, err := client.Call(context.Background(), selector, nil, &ret); err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+	if ret := call("local"); ret != "gateway" {
+		t.Fatal("unexpected return:", ret)
+	}
+	if ret := call("remote"); ret != "backend" {
+		t.Fatal("unexpected return:", ret)
+	}
+
+	// calls fall back to the default once the upstream is gone
+	upstream.Close()
+	if ret := call("remote"); ret != "default" {
+		t.Fatal("unexpected return:", ret)
+	}
+}
+
+func TestPeerPresence(t *testing.T) {
+	a, b := mux.Pair()
+	peerA := NewPeer(a, codec.JSONCodec{})
+	peerB := NewPeer(b, codec.JSONCodec{})
+	defer peerA.Close()
+	defer peerB.Close()
+
+	rtt, err := peerA.Ping(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtt <= 0 {
+		t.Fatalf("unexpected round trip time: %v", rtt)
+	}
+
+	// the remote side reads frames but never answers keepalives
+	conn, remote := net.Pipe()
+	go io.Copy(io.Discard, remote)
+	defer remote.Close()
+	peer := NewPeer(mux.New(conn, mux.KeepAlive(10*time.Millisecond, 50*time.Millisecond)), codec.JSONCodec{})
+
+	disconnected := make(chan error, 2)
+	peer.OnDisconnect(func(err error) {
+		disconnected <- err
+	})
+	select {
+	case err := <-disconnected:
+		if err != mux.ErrKeepAliveTimeout {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for disconnect")
+	}
+
+	// registering after the disconnect calls right away
+	peer.OnDisconnect(func(err error) {
+		disconnected <- err
+	})
+	if err := <-disconnected; err != mux.ErrKeepAliveTimeout {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+type bindCalc struct{}
+
+func (bindCalc) Add(a, b int) int { return a + b }
+
+func (bindCalc) Sum(nums ...int) (total int) {
+	for _, n := range nums {
+		total += n
+	}
+	return
+}
+
+func (bindCalc) Fail() error { return fmt.Errorf("failed") }
+
+func TestBind(t *testing.T) {
+	a, b := mux.Pair()
+	client := NewPeer(a, codec.JSONCodec{})
+	server := NewPeer(b, codec.JSONCodec{})
+	defer client.Close()
+	defer server.Close()
+
+	server.Handle("/", fn.HandlerFrom(bindCalc{}))
+	go server.Respond()
+
+	calc := Bind[*struct {
+		Add  func(ctx context.Context, a, b int) (int, error)
+		Sum  func(nums ...int) (int, error)
+		Fail func(ctx context.Context) error
+		Name string
+	}](client)
+
+	sum, err := calc.Add(context.Background(), 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 3 {
+		t.Fatalf("unexpected sum: %d", sum)
+	}
+	if sum, err = calc.Sum(1, 2, 3); err != nil || sum != 6 {
+		t.Fatalf("unexpected sum: %d, %v", sum, err)
+	}
+	if err := calc.Fail(context.Background()); err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic binding func without error return")
+		}
+	}()
+	Bind[struct{ Add func(a, b int) int }](client)
+}
+
+func TestPeerSelectors(t *testing.T) {
+	a, b := mux.Pair()
+	client := NewPeer(a, codec.JSONCodec{})
+	server := NewPeer(b, codec.JSONCodec{})
+	defer client.Close()
+	defer server.Close()
+	go client.Respond()
+	go server.Respond()
+
+	// the client does not serve its selectors
+	if _, err := server.Selectors(context.Background()); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server.Handle("calc/", fn.HandlerFrom(bindCalc{}))
+	server.ServeSelectors(fn.Describe(bindCalc{}))
+
+	schemas, err := client.Selectors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var selectors []string
+	for _, s := range schemas {
+		selectors = append(selectors, s.Selector)
+	}
+	if got := strings.Join(selectors, ","); got != "Add,Fail,Sum" {
+		t.Fatalf("unexpected selectors: %s", got)
+	}
+	if len(schemas[0].Params) != 2 || schemas[0].Params[0].Type != "int" {
+		t.Fatalf("unexpected params: %+v", schemas[0].Params)
+	}
+}
+
+func TestPeerDiagnostics(t *testing.T) {
+	a, b := mux.Pair()
+	client := NewPeer(a, codec.CBORCodec{})
+	server := NewPeer(b, codec.CBORCodec{})
+	defer client.Close()
+	defer server.Close()
+	go client.Respond()
+	go server.Respond()
+	server.ServeDiagnostics()
+
+	ctx := context.Background()
+	rtt, err := client.MeasureRTT(ctx, 5, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtt.Count != 5 || rtt.Min <= 0 || rtt.Min > rtt.Mean || rtt.Mean > rtt.Max {
+		t.Fatalf("unexpected rtt: %v", rtt)
+	}
+
+	up, err := client.MeasureUpload(ctx, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.Bytes != 1<<20 || up.BytesPerSecond() <= 0 {
+		t.Fatalf("unexpected upload: %v", up)
+	}
+
+	down, err := client.MeasureDownload(ctx, 1<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if down.Bytes != 1<<20 {
+		t.Fatalf("unexpected download: %v", down)
+	}
+
+	// 256KB at 1MB/s takes about 250ms
+	down, err = client.MeasureDownload(ctx, 256<<10, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if down.Duration < 200*time.Millisecond {
+		t.Fatalf("expected rate limited download, got %v", down)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.MeasureDownload(ctx, 1<<20, 1<<20); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
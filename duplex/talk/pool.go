@@ -0,0 +1,89 @@
+package talk
+
+import (
+	"context"
+	"sync/atomic"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/mux"
+	"tractor.dev/toolkit-go/duplex/rpc"
+	"tractor.dev/toolkit-go/duplex/transport"
+)
+
+// Pool is an rpc.Caller that keeps several sessions to the same endpoint and
+// spreads calls across them, so many concurrent or streaming calls are not
+// held up behind each other on a single connection. Each session is redialed
+// if it drops, as with RedialPeer.
+type Pool struct {
+	slots []*poolSlot
+	next  atomic.Uint32
+}
+
+type poolSlot struct {
+	peer     *Peer
+	redialer *transport.Redialer
+	inflight atomic.Int64
+}
+
+// NewPool returns a Pool of size sessions made with dial, such as with
+// transport.Dial, using codec for calls.
+func NewPool(dial func(ctx context.Context) (mux.Session, error), size int, codec codec.Codec) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	p := &Pool{}
+	for i := 0; i < size; i++ {
+		r := &transport.Redialer{Dial: dial}
+		p.slots = append(p.slots, &poolSlot{
+			peer:     RedialPeer(r, codec, QueueCalls),
+			redialer: r,
+		})
+	}
+	return p
+}
+
+// pick returns the connected slot with the fewest calls in progress,
+// or the next slot in turn if none are connected.
+func (p *Pool) pick() *poolSlot {
+	start := int(p.next.Add(1))
+	var best *poolSlot
+	for i := range p.slots {
+		s := p.slots[(start+i)%len(p.slots)]
+		if s.redialer.Current() == nil {
+			continue
+		}
+		if best == nil || s.inflight.Load() < best.inflight.Load() {
+			best = s
+		}
+	}
+	if best == nil {
+		best = p.slots[start%len(p.slots)]
+	}
+	return best
+}
+
+// Call makes a call on the least busy session of the pool. Calls made while
+// no session is connected wait for one to be dialed.
+func (p *Pool) Call(ctx context.Context, selector string, params any, reply ...any) (*rpc.Response, error) {
+	s := p.pick()
+	s.inflight.Add(1)
+	defer s.inflight.Add(-1)
+	return s.peer.Call(ctx, selector, params, reply...)
+}
+
+// Peers returns the peers of the pool, such as to add interceptors.
+func (p *Pool) Peers() []*Peer {
+	peers := make([]*Peer, len(p.slots))
+	for i, s := range p.slots {
+		peers[i] = s.peer
+	}
+	return peers
+}
+
+// Close closes the sessions of the pool.
+func (p *Pool) Close() error {
+	for _, s := range p.slots {
+		s.peer.Close()
+	}
+	return nil
+}
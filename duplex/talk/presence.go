@@ -0,0 +1,51 @@
+package talk
+
+import (
+	"context"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+// Ping measures the round trip time to the remote side without making a
+// call. The session must be created by mux.New, or be the session of a
+// Peer from RedialPeer, which pings its current session.
+func (p *Peer) Ping(ctx context.Context) (time.Duration, error) {
+	if s, ok := p.Session.(*redialSession); ok {
+		return s.Ping(ctx)
+	}
+	return mux.Ping(ctx, p.Session)
+}
+
+// OnDisconnect calls fn with the error returned by Wait once the session
+// ends. With the mux.KeepAlive option on the session, a remote side that
+// stops answering is detected and the error is mux.ErrKeepAliveTimeout.
+// If the session has already ended, fn is called right away. For a Peer
+// from RedialPeer, this is when the Peer is closed; reconnects are sent
+// as Events of its Redialer.
+func (p *Peer) OnDisconnect(fn func(err error)) {
+	p.hookMu.Lock()
+	if p.disconnected {
+		err := p.disconnectErr
+		p.hookMu.Unlock()
+		fn(err)
+		return
+	}
+	first := p.disconnectFuncs == nil
+	p.disconnectFuncs = append(p.disconnectFuncs, fn)
+	p.hookMu.Unlock()
+	if !first {
+		return
+	}
+	go func() {
+		err := p.Session.Wait()
+		p.hookMu.Lock()
+		p.disconnected = true
+		p.disconnectErr = err
+		funcs := p.disconnectFuncs
+		p.hookMu.Unlock()
+		for _, fn := range funcs {
+			fn(err)
+		}
+	}()
+}
@@ -0,0 +1,181 @@
+package talk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/mux"
+	"tractor.dev/toolkit-go/duplex/transport"
+)
+
+// ErrDisconnected is returned by calls made by a Peer from RedialPeer
+// with FailFast while it is not connected.
+var ErrDisconnected = errors.New("talk: peer disconnected")
+
+// redialPollInterval is how often a redialing session checks for a new
+// session after the current one ended.
+const redialPollInterval = 10 * time.Millisecond
+
+// OutagePolicy is what a Peer from RedialPeer does with calls made while
+// it is not connected.
+type OutagePolicy int
+
+const (
+	// QueueCalls makes calls wait for the session to be redialed,
+	// or for their context to be done.
+	QueueCalls OutagePolicy = iota
+	// FailFast makes calls return ErrDisconnected.
+	FailFast
+)
+
+// RedialPeer returns a Peer over the sessions of r, which it runs until the
+// Peer is closed. When the session drops and is redialed, the Peer keeps
+// responding with the same handlers over the new session, and calls made in
+// the meantime are handled according to policy.
+func RedialPeer(r *transport.Redialer, codec codec.Codec, policy OutagePolicy) *Peer {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &redialSession{
+		r:       r,
+		policy:  policy,
+		ctx:     ctx,
+		cancel:  cancel,
+		runDone: make(chan struct{}),
+	}
+	go func() {
+		r.Run(ctx)
+		close(s.runDone)
+	}()
+	return NewPeer(s, codec)
+}
+
+// redialSession is a mux.Session using the current session of a Redialer.
+type redialSession struct {
+	r      *transport.Redialer
+	policy OutagePolicy
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	runDone chan struct{}
+}
+
+// session returns the current session other than ended, waiting for one
+// unless wait is false.
+func (s *redialSession) session(ctx context.Context, ended mux.Session, wait bool) (mux.Session, error) {
+	for {
+		if s.ctx.Err() != nil {
+			return nil, net.ErrClosed
+		}
+		sess := s.r.Current()
+		if sess == nil && !wait {
+			return nil, ErrDisconnected
+		}
+		if sess == nil {
+			var err error
+			sess, err = s.waitSession(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if sess != ended {
+			return sess, nil
+		}
+		if !wait {
+			return nil, ErrDisconnected
+		}
+		// wait for the redialer to notice the session ended
+		select {
+		case <-time.After(redialPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *redialSession) waitSession(ctx context.Context) (mux.Session, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(s.ctx, cancel)
+	defer stop()
+	sess, err := s.r.Session(ctx)
+	if err != nil && s.ctx.Err() != nil {
+		return nil, net.ErrClosed
+	}
+	return sess, err
+}
+
+// Open opens a channel on the current session, retrying on the next
+// session if the current one has ended.
+func (s *redialSession) Open(ctx context.Context) (mux.Channel, error) {
+	var ended mux.Session
+	for {
+		sess, err := s.session(ctx, ended, s.policy == QueueCalls)
+		if err != nil {
+			return nil, err
+		}
+		ch, err := sess.Open(ctx)
+		if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+			ended = sess
+			continue
+		}
+		return ch, err
+	}
+}
+
+func (s *redialSession) Accept() (mux.Channel, error) {
+	return s.AcceptContext(context.Background())
+}
+
+// AcceptContext accepts channels from the current session, moving on to
+// the next session when it ends.
+func (s *redialSession) AcceptContext(ctx context.Context) (mux.Channel, error) {
+	var ended mux.Session
+	for {
+		sess, err := s.session(ctx, ended, true)
+		if err != nil {
+			if err == net.ErrClosed {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		ch, err := sess.AcceptContext(ctx)
+		if err == io.EOF {
+			ended = sess
+			continue
+		}
+		return ch, err
+	}
+}
+
+// Ping pings the current session, returning ErrDisconnected if there is none.
+func (s *redialSession) Ping(ctx context.Context) (time.Duration, error) {
+	sess, err := s.session(ctx, nil, false)
+	if err != nil {
+		return 0, err
+	}
+	return mux.Ping(ctx, sess)
+}
+
+// Drain drains the current session and stops redialing.
+func (s *redialSession) Drain(ctx context.Context) error {
+	if sess := s.r.Current(); sess != nil {
+		sess.Drain(ctx)
+	}
+	return s.Close()
+}
+
+// Close stops redialing and closes the current session.
+func (s *redialSession) Close() error {
+	s.cancel()
+	<-s.runDone
+	return nil
+}
+
+// Wait waits for the session to be closed.
+func (s *redialSession) Wait() error {
+	<-s.runDone
+	return io.EOF
+}
@@ -0,0 +1,72 @@
+package talk
+
+import (
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+// Route handles a call and returns true, or returns false without
+// responding so the next route of a Router is tried.
+type Route func(r rpc.Responder, c *rpc.Call) bool
+
+// Router is an rpc.Handler that tries its routes in order until one handles
+// the call, such as local handlers, then an upstream peer, then a default
+// handler. Calls that no route handles return a not found error.
+type Router []Route
+
+// RespondRPC handles the call with the first route that accepts it.
+func (rt Router) RespondRPC(r rpc.Responder, c *rpc.Call) {
+	for _, route := range rt {
+		if route(r, c) {
+			return
+		}
+	}
+	rpc.NotFoundHandler().RespondRPC(r, c)
+}
+
+// Local returns a Route to the handlers of m, which declines calls
+// with no matching handler.
+func Local(m *rpc.RespondMux) Route {
+	return func(r rpc.Responder, c *rpc.Call) bool {
+		if h, _ := m.Match(c.Selector()); h == nil {
+			return false
+		}
+		m.RespondRPC(r, c)
+		return true
+	}
+}
+
+// Upstream returns a Route forwarding calls to the remote side of p, which
+// declines calls if a channel can't be opened on its session, such as when it
+// is disconnected. The remote side must use the same codec as the caller.
+func Upstream(p *Peer) Route {
+	return func(r rpc.Responder, c *rpc.Call) bool {
+		ch, err := p.Session.Open(c.Context)
+		if err != nil {
+			return false
+		}
+		rpc.ProxyCall(r, c, ch, p.Codec)
+		return true
+	}
+}
+
+// Default returns a Route that handles every call with h.
+func Default(h rpc.Handler) Route {
+	return func(r rpc.Responder, c *rpc.Call) bool {
+		h.RespondRPC(r, c)
+		return true
+	}
+}
+
+// Route makes the Peer handle calls with its own handlers, then with the
+// given routes if it has no handler for a call. Interceptors added with Use
+// are kept.
+func (p *Peer) Route(routes ...Route) {
+	router := append(Router{Local(p.RespondMux)}, routes...)
+	p.hookMu.Lock()
+	defer p.hookMu.Unlock()
+	if h, ok := p.Server.Handler.(*interceptedHandler); ok {
+		h.next = router
+		return
+	}
+	p.Server.Handler = router
+}
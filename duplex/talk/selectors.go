@@ -0,0 +1,28 @@
+package talk
+
+import (
+	"context"
+
+	"tractor.dev/toolkit-go/duplex/fn"
+)
+
+// SelectorsSelector is the reserved selector a Peer serves the Schemas of its
+// selectors at with ServeSelectors, which the remote side calls with Selectors.
+const SelectorsSelector = "talk.selectors"
+
+// ServeSelectors registers fn.SchemaHandler for schemas at SelectorsSelector,
+// so the remote side can discover the selectors of the Peer with Selectors.
+// The schemas can be made for handlers with fn.Describe.
+func (p *Peer) ServeSelectors(schemas []fn.Schema) {
+	p.Handle(SelectorsSelector, fn.SchemaHandler(schemas))
+}
+
+// Selectors returns the Schemas of the selectors served by the remote side,
+// which it serves with ServeSelectors. This can be used by generic tools to
+// list the selectors of a peer, or to check if it provides a selector before
+// calling it. A remote side not serving its selectors returns a not found error.
+func (p *Peer) Selectors(ctx context.Context) ([]fn.Schema, error) {
+	var schemas []fn.Schema
+	_, err := p.Call(ctx, SelectorsSelector, "", &schemas)
+	return schemas, err
+}
@@ -0,0 +1,86 @@
+// Package transport makes mux sessions from URLs and keeps them connected.
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+// Dial connects to the URL with the transport for its scheme and returns the
+// session. Supported URLs are:
+//
+//	tcp://host:port
+//	tls://host:port
+//	unix:///path/to/socket
+//	ws://host:port/path and wss://host:port/path
+//	http://host:port/path and https://host:port/path, using HTTP/2 streams
+//	stdio:, using the stdin and stdout of this process
+//	stdio:command args..., using the stdin and stdout of a subprocess
+//
+// The context only bounds making the connection.
+func Dial(ctx context.Context, rawurl string) (mux.Session, error) {
+	var dial func() (mux.Session, error)
+	if cmd, ok := strings.CutPrefix(rawurl, "stdio:"); ok {
+		dial = func() (mux.Session, error) {
+			args := strings.Fields(cmd)
+			if len(args) == 0 {
+				return mux.DialStdio()
+			}
+			return dialCommand(args)
+		}
+		return dialContext(ctx, dial)
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp":
+		dial = func() (mux.Session, error) { return mux.DialTCP(u.Host) }
+	case "tls":
+		dial = func() (mux.Session, error) { return mux.DialTLS(u.Host, nil) }
+	case "unix":
+		path := u.Path
+		if u.Opaque != "" {
+			path = u.Opaque
+		}
+		dial = func() (mux.Session, error) { return mux.DialUnix(path) }
+	case "ws", "wss":
+		dial = func() (mux.Session, error) { return mux.DialWS(u.String()) }
+	case "http", "https":
+		dial = func() (mux.Session, error) { return mux.DialH2(u.String(), nil) }
+	default:
+		return nil, fmt.Errorf("transport: unsupported url scheme '%s'", u.Scheme)
+	}
+	return dialContext(ctx, dial)
+}
+
+// dialContext calls dial, returning early if ctx is done first, in which
+// case the session is closed once dial returns.
+func dialContext(ctx context.Context, dial func() (mux.Session, error)) (mux.Session, error) {
+	type result struct {
+		sess mux.Session
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sess, err := dial()
+		done <- result{sess, err}
+	}()
+	select {
+	case r := <-done:
+		return r.sess, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				r.sess.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !js && !tinygo
+
+package transport
+
+import (
+	"os/exec"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+func dialCommand(args []string) (mux.Session, error) {
+	return mux.DialCommand(exec.Command(args[0], args[1:]...))
+}
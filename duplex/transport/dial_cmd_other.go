@@ -0,0 +1,13 @@
+//go:build js || tinygo
+
+package transport
+
+import (
+	"errors"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+func dialCommand(args []string) (mux.Session, error) {
+	return nil, errors.New("transport: subprocesses are not supported on this platform")
+}
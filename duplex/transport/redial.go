@@ -0,0 +1,224 @@
+package transport
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+const (
+	defaultMinBackoff  = 100 * time.Millisecond
+	defaultMaxBackoff  = 30 * time.Second
+	defaultStableAfter = 10 * time.Second
+
+	// eventBuffer is the number of events kept for a slow reader
+	// of Events before further events are dropped.
+	eventBuffer = 16
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// SessionUp is sent when a session is connected.
+	SessionUp EventType = iota
+	// SessionDown is sent when a session has ended.
+	SessionDown
+)
+
+func (t EventType) String() string {
+	if t == SessionUp {
+		return "up"
+	}
+	return "down"
+}
+
+// Event is sent by a Redialer when its session changes.
+type Event struct {
+	Type    EventType
+	Session mux.Session
+	// Err is the reason a session went down, if known.
+	Err error
+}
+
+// Redialer maintains a live session, dialing again with exponential backoff
+// and jitter when a dial fails or the session ends or fails its health check. Set the fields
+// before calling Run.
+type Redialer struct {
+	// Dial makes a new session. NewRedialer sets it to dial a URL.
+	Dial func(ctx context.Context) (mux.Session, error)
+
+	// MinBackoff and MaxBackoff bound the time between failed dials, which
+	// doubles after each failure. They default to 100ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// StableAfter is how long a session must stay up for the backoff to be
+	// reset to MinBackoff when it ends. A session ending sooner counts as a
+	// failure, so a remote side that keeps closing sessions right after
+	// accepting them is not redialed in a tight loop. It defaults to 10s.
+	StableAfter time.Duration
+
+	// HealthCheck, if set, is called every HealthInterval with the session,
+	// which is closed and dialed again if it returns an error.
+	HealthCheck    func(ctx context.Context, sess mux.Session) error
+	HealthInterval time.Duration
+
+	once   sync.Once
+	events chan Event
+
+	mu    sync.Mutex
+	sess  mux.Session
+	ready chan struct{}
+}
+
+// NewRedialer returns a Redialer for sessions dialed to rawurl with Dial.
+func NewRedialer(rawurl string) *Redialer {
+	return &Redialer{
+		Dial: func(ctx context.Context) (mux.Session, error) {
+			return Dial(ctx, rawurl)
+		},
+	}
+}
+
+func (r *Redialer) init() {
+	r.once.Do(func() {
+		r.events = make(chan Event, eventBuffer)
+		r.ready = make(chan struct{})
+	})
+}
+
+// Events returns the channel of session up and down events. Events are
+// dropped instead of holding up redialing if the channel is not read.
+func (r *Redialer) Events() <-chan Event {
+	r.init()
+	return r.events
+}
+
+// Current returns the current session, or nil if none is connected.
+func (r *Redialer) Current() mux.Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sess
+}
+
+// Session returns the current session, waiting for one to be connected.
+func (r *Redialer) Session(ctx context.Context) (mux.Session, error) {
+	r.init()
+	for {
+		r.mu.Lock()
+		sess, ready := r.sess, r.ready
+		r.mu.Unlock()
+		if sess != nil {
+			return sess, nil
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Run keeps a session connected until ctx is done, then closes the session
+// and returns the error of ctx.
+func (r *Redialer) Run(ctx context.Context) error {
+	r.init()
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	backoff := r.minBackoff()
+	for {
+		sess, err := r.Dial(ctx)
+		if err == nil {
+			up := time.Now()
+			r.mu.Lock()
+			r.sess = sess
+			close(r.ready)
+			r.mu.Unlock()
+			r.emit(Event{Type: SessionUp, Session: sess})
+
+			err = r.watch(ctx, sess)
+			sess.Close()
+
+			r.mu.Lock()
+			r.sess = nil
+			r.ready = make(chan struct{})
+			r.mu.Unlock()
+			r.emit(Event{Type: SessionDown, Session: sess, Err: err})
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if time.Since(up) >= r.stableAfter() {
+				backoff = r.minBackoff()
+			}
+		}
+		// wait between half and all of the backoff
+		d := backoff/2 + time.Duration(rnd.Int63n(int64(backoff/2)+1))
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, r.maxBackoff())
+	}
+}
+
+// watch waits for the session to end or fail its health check.
+func (r *Redialer) watch(ctx context.Context, sess mux.Session) error {
+	ended := make(chan error, 1)
+	go func() {
+		ended <- sess.Wait()
+	}()
+	var tick <-chan time.Time
+	if r.HealthCheck != nil && r.HealthInterval > 0 {
+		ticker := time.NewTicker(r.HealthInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case err := <-ended:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick:
+			checkCtx, cancel := context.WithTimeout(ctx, r.HealthInterval)
+			err := r.HealthCheck(checkCtx, sess)
+			cancel()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (r *Redialer) emit(e Event) {
+	select {
+	case r.events <- e:
+	default:
+	}
+}
+
+func (r *Redialer) minBackoff() time.Duration {
+	if r.MinBackoff > 0 {
+		return r.MinBackoff
+	}
+	return defaultMinBackoff
+}
+
+func (r *Redialer) maxBackoff() time.Duration {
+	if r.MaxBackoff > 0 {
+		return r.MaxBackoff
+	}
+	return defaultMaxBackoff
+}
+
+func (r *Redialer) stableAfter() time.Duration {
+	if r.StableAfter > 0 {
+		return r.StableAfter
+	}
+	return defaultStableAfter
+}
@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+func fatal(err error, t *testing.T) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func acceptAll(l mux.Listener) <-chan mux.Session {
+	sessions := make(chan mux.Session, 8)
+	go func() {
+		for {
+			sess, err := l.Accept()
+			if err != nil {
+				return
+			}
+			sessions <- sess
+		}
+	}()
+	return sessions
+}
+
+func TestDial(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := mux.ListenTCP("127.0.0.1:0")
+	fatal(err, t)
+	defer l.Close()
+	acceptAll(l)
+	sess, err := Dial(ctx, "tcp://"+l.Addr().String())
+	fatal(err, t)
+	sess.Close()
+
+	path := filepath.Join(t.TempDir(), "qmux.sock")
+	ul, err := mux.ListenUnix(path)
+	fatal(err, t)
+	defer ul.Close()
+	acceptAll(ul)
+	sess, err = Dial(ctx, "unix://"+path)
+	fatal(err, t)
+	sess.Close()
+
+	if _, err := Dial(ctx, "gopher://localhost"); err == nil {
+		t.Fatal("expected unsupported scheme error")
+	}
+}
+
+func nextEvent(t *testing.T, r *Redialer) Event {
+	t.Helper()
+	select {
+	case e := <-r.Events():
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return Event{}
+	}
+}
+
+func TestRedialer(t *testing.T) {
+	l, err := mux.ListenTCP("127.0.0.1:0")
+	fatal(err, t)
+	defer l.Close()
+	accepted := acceptAll(l)
+
+	errUnhealthy := errors.New("unhealthy")
+	unhealthy := make(chan struct{})
+	r := NewRedialer("tcp://" + l.Addr().String())
+	r.MinBackoff = 10 * time.Millisecond
+	r.HealthInterval = 10 * time.Millisecond
+	r.HealthCheck = func(ctx context.Context, sess mux.Session) error {
+		select {
+		case <-unhealthy:
+			return errUnhealthy
+		default:
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.Run(ctx)
+	}()
+
+	sess, err := r.Session(ctx)
+	fatal(err, t)
+	if e := nextEvent(t, r); e.Type != SessionUp || e.Session != sess {
+		t.Fatalf("expected up event for session: %v", e)
+	}
+
+	// the remote side ends the session
+	(<-accepted).Close()
+	if e := nextEvent(t, r); e.Type != SessionDown {
+		t.Fatalf("expected down event: %v", e)
+	}
+	if e := nextEvent(t, r); e.Type != SessionUp {
+		t.Fatalf("expected up event: %v", e)
+	}
+
+	// the session fails its health check
+	close(unhealthy)
+	if e := nextEvent(t, r); e.Type != SessionDown || e.Err != errUnhealthy {
+		t.Fatalf("expected unhealthy down event: %v", e)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("unexpected run error: %v", err)
+	}
+}
+
+func TestRedialerBackoffAfterSessionEnds(t *testing.T) {
+	var dials atomic.Int32
+	r := &Redialer{
+		// the remote side closes each session right away
+		Dial: func(ctx context.Context) (mux.Session, error) {
+			dials.Add(1)
+			a, b := mux.Pair()
+			b.Close()
+			return a, nil
+		},
+		MinBackoff: 20 * time.Millisecond,
+		MaxBackoff: time.Second,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	r.Run(ctx)
+	// 0, 10-20, 20-40, 40-80 and 80-160ms after the previous dial
+	if n := dials.Load(); n > 6 {
+		t.Fatalf("expected backoff between dials, got %d dials", n)
+	}
+}
@@ -0,0 +1,17 @@
+module tractor.dev/toolkit-go/duplex/x/protobuf
+
+go 1.21
+
+require (
+	google.golang.org/protobuf v1.32.0
+	tractor.dev/toolkit-go v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/fxamacker/cbor/v2 v2.5.0 // indirect
+	github.com/vmihailenco/msgpack/v5 v5.4.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+)
+
+replace tractor.dev/toolkit-go => ../../..
@@ -0,0 +1,117 @@
+// Package protobuf provides a codec for Protocol Buffers.
+package protobuf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"tractor.dev/toolkit-go/duplex/codec"
+)
+
+// Codec encodes values as length delimited Protocol Buffers messages.
+//
+// Every value is encoded as an Any message, so decoding into an empty interface
+// value gives the original message type if it is linked into the program.
+// Values that are not proto.Message values, such as the rpc headers, are converted
+// to a structpb.Value using their JSON encoding. Decoding a structpb.Value into a
+// value that is not a proto.Message uses the same JSON conversion.
+type Codec struct{}
+
+// Encoder returns a Protocol Buffers encoder
+func (c Codec) Encoder(w io.Writer) codec.Encoder {
+	return &encoder{w: w}
+}
+
+// Decoder returns a Protocol Buffers decoder
+func (c Codec) Decoder(r io.Reader) codec.Decoder {
+	br, ok := r.(reader)
+	if !ok {
+		br = &byteReader{Reader: r}
+	}
+	return &decoder{r: br}
+}
+
+type encoder struct {
+	w io.Writer
+}
+
+func (e *encoder) Encode(v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		value := &structpb.Value{}
+		if err := protojson.Unmarshal(b, value); err != nil {
+			return err
+		}
+		msg = value
+	}
+	a, err := anypb.New(msg)
+	if err != nil {
+		return err
+	}
+	_, err = protodelim.MarshalTo(e.w, a)
+	return err
+}
+
+type reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// byteReader reads single bytes without buffering so no more than
+// the current message is read from the underlying reader.
+type byteReader struct {
+	io.Reader
+	buf [1]byte
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(r.Reader, r.buf[:])
+	return r.buf[0], err
+}
+
+type decoder struct {
+	r reader
+}
+
+func (d *decoder) Decode(v interface{}) error {
+	a := &anypb.Any{}
+	if err := protodelim.UnmarshalFrom(d.r, a); err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+	if msg, ok := v.(proto.Message); ok {
+		return a.UnmarshalTo(msg)
+	}
+	msg, err := a.UnmarshalNew()
+	if err != nil {
+		return err
+	}
+	value, ok := msg.(*structpb.Value)
+	if !ok {
+		// a message decoded into an empty interface value
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Interface {
+			return fmt.Errorf("protobuf: cannot decode %s into %T", a.MessageName(), v)
+		}
+		rv.Elem().Set(reflect.ValueOf(msg))
+		return nil
+	}
+	b, err := protojson.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,58 @@
+package protobuf
+
+import (
+	"bytes"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testData struct {
+	Map map[string]bool
+	Arr []int
+}
+
+func TestCodec(t *testing.T) {
+	c := Codec{}
+	var buf bytes.Buffer
+	enc := c.Encoder(&buf)
+
+	if err := enc.Encode(testData{
+		Map: map[string]bool{"true": true, "false": false},
+		Arr: []int{1, 2, 3},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(wrapperspb.String("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(wrapperspb.Int64(42)); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := c.Decoder(&buf)
+	var data testData
+	if err := dec.Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Map["true"] != true || data.Arr[2] != 3 {
+		t.Fatal("unexpected data:", data)
+	}
+
+	msg := &wrapperspb.StringValue{}
+	if err := dec.Decode(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Value != "hello" {
+		t.Fatal("unexpected message:", msg)
+	}
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(v.(proto.Message), wrapperspb.Int64(42)) {
+		t.Fatal("unexpected message:", v)
+	}
+}
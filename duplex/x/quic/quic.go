@@ -4,7 +4,11 @@ import (
 	"context"
 	"crypto/tls"
 	"io"
+	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/quic-go/quic-go"
 	"tractor.dev/toolkit-go/duplex/mux"
@@ -13,7 +17,7 @@ import (
 const Protocol = "qtalk-quic"
 
 func New(conn quic.Connection) mux.Session {
-	return &session{conn}
+	return &session{conn: conn}
 }
 
 var defaultTLSConfig = tls.Config{
@@ -38,13 +42,75 @@ func Dial(addr string, tlsVerify bool) (mux.Session, error) {
 	return New(conn), nil
 }
 
+// sessionCache keeps TLS sessions of servers dialed with DialEarly,
+// which is needed to resume connections with 0-RTT.
+var sessionCache = tls.NewLRUClientSessionCache(64)
+
+// ListenMux creates a QUIC listener at addr whose connections are accepted
+// as mux sessions, with each channel on its own QUIC stream. If config is nil,
+// clients can resume connections with 0-RTT.
+func ListenMux(addr string, tlsConf *tls.Config, config *Config) (mux.Listener, error) {
+	if config == nil {
+		config = &Config{Allow0RTT: true}
+	}
+	l, err := quic.ListenAddrEarly(addr, tlsConf, config)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{l}, nil
+}
+
+// DialEarly connects to a QUIC listener at addr and returns the connection as
+// a mux session. Channels can be opened before the handshake completes when
+// reconnecting to a server that allows 0-RTT. If tlsConf is nil, the default
+// configuration is used, and a client session cache is added if it has none.
+func DialEarly(ctx context.Context, addr string, tlsConf *tls.Config, config *Config) (mux.Session, error) {
+	if tlsConf == nil {
+		tlsConf = &defaultTLSConfig
+	}
+	tlsConf = tlsConf.Clone()
+	if len(tlsConf.NextProtos) == 0 {
+		tlsConf.NextProtos = []string{Protocol}
+	}
+	if tlsConf.ClientSessionCache == nil {
+		tlsConf.ClientSessionCache = sessionCache
+	}
+	conn, err := quic.DialAddrEarly(ctx, addr, tlsConf, config)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn), nil
+}
+
+type listener struct {
+	l *quic.EarlyListener
+}
+
+func (l *listener) Accept() (mux.Session, error) {
+	conn, err := l.l.Accept(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return New(conn), nil
+}
+
+func (l *listener) Close() error {
+	return l.l.Close()
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.l.Addr()
+}
+
 func init() {
 	// TODO: figure out better way to deal with Dialers with arguments
 	//talk.Dialers["quic"] = Dial
 }
 
 type session struct {
-	conn quic.Connection
+	conn     quic.Connection
+	open     atomic.Int64
+	draining atomic.Bool
 }
 
 func (s *session) Close() error {
@@ -52,8 +118,15 @@ func (s *session) Close() error {
 }
 
 func (s *session) Accept() (mux.Channel, error) {
-	stream, err := s.conn.AcceptStream(context.Background())
+	return s.AcceptContext(context.Background())
+}
+
+func (s *session) AcceptContext(ctx context.Context) (mux.Channel, error) {
+	stream, err := s.conn.AcceptStream(ctx)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if strings.Contains(err.Error(), "close connection") {
 			return nil, io.EOF
 		}
@@ -64,7 +137,7 @@ func (s *session) Accept() (mux.Channel, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &channel{stream}, nil
+	return s.newChannel(stream), nil
 }
 
 func (s *session) Open(ctx context.Context) (mux.Channel, error) {
@@ -72,6 +145,9 @@ func (s *session) Open(ctx context.Context) (mux.Channel, error) {
 	// accepted the connection. It writes some data in order to notify the remote
 	// of the new stream immediately, but my initial attempt to send an
 	// acknowledgement from the remote side lead to deadlocks in the tests.
+	if s.draining.Load() {
+		return nil, mux.ErrGoAway
+	}
 	stream, err := s.conn.OpenStreamSync(ctx)
 	if err != nil {
 		return nil, err
@@ -80,7 +156,30 @@ func (s *session) Open(ctx context.Context) (mux.Channel, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &channel{stream}, nil
+	return s.newChannel(stream), nil
+}
+
+func (s *session) newChannel(stream quic.Stream) *channel {
+	s.open.Add(1)
+	return &channel{stream: stream, session: s}
+}
+
+// Drain stops opening streams and waits for open streams to be closed
+// before closing the connection. Unlike mux sessions, the remote side
+// is not told to stop opening streams.
+func (s *session) Drain(ctx context.Context) error {
+	s.draining.Store(true)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for s.open.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		}
+	}
+	return s.Close()
 }
 
 func (s *session) Wait() error {
@@ -89,7 +188,9 @@ func (s *session) Wait() error {
 }
 
 type channel struct {
-	stream quic.Stream
+	stream  quic.Stream
+	session *session
+	closed  sync.Once
 }
 
 func (c *channel) ID() uint32 {
@@ -105,10 +206,33 @@ func (c *channel) Write(p []byte) (int, error) {
 }
 
 func (c *channel) Close() error {
+	c.closed.Do(func() {
+		c.session.open.Add(-1)
+	})
 	c.stream.CancelRead(42)
 	return c.CloseWrite()
 }
 
+func (c *channel) LocalAddr() net.Addr {
+	return c.session.conn.LocalAddr()
+}
+
+func (c *channel) RemoteAddr() net.Addr {
+	return c.session.conn.RemoteAddr()
+}
+
+func (c *channel) SetDeadline(t time.Time) error {
+	return c.stream.SetDeadline(t)
+}
+
+func (c *channel) SetReadDeadline(t time.Time) error {
+	return c.stream.SetReadDeadline(t)
+}
+
+func (c *channel) SetWriteDeadline(t time.Time) error {
+	return c.stream.SetWriteDeadline(t)
+}
+
 func (c *channel) CloseWrite() error {
 	// TODO this may need a lock to avoid concurrent call with Write
 	return c.stream.Close()
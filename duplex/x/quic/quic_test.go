@@ -8,6 +8,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"testing"
@@ -246,3 +247,45 @@ func TestOpenTimeout(t *testing.T) {
 	close(testComplete)
 	<-sessionClosed
 }
+
+func TestListenMuxDialEarly(t *testing.T) {
+	l, err := ListenMux("127.0.0.1:0", generateTLSConfig(), nil)
+	fatal(err, t)
+	defer l.Close()
+
+	go func() {
+		for {
+			sess, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				ch, err := sess.Accept()
+				if err != nil {
+					return
+				}
+				io.Copy(ch, ch)
+				ch.CloseWrite()
+			}()
+		}
+	}()
+
+	cfg := defaultTLSConfig.Clone()
+	cfg.InsecureSkipVerify = true
+	// the second session resumes the first with 0-RTT
+	for i := 0; i < 2; i++ {
+		sess, err := DialEarly(context.Background(), l.Addr().String(), cfg, nil)
+		fatal(err, t)
+		ch, err := sess.Open(context.Background())
+		fatal(err, t)
+		_, err = ch.Write([]byte("Hello world"))
+		fatal(err, t)
+		fatal(ch.CloseWrite(), t)
+		b, err := io.ReadAll(ch)
+		fatal(err, t)
+		if string(b) != "Hello world" {
+			t.Fatalf("unexpected data: %#v", b)
+		}
+		sess.Close()
+	}
+}
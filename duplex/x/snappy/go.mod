@@ -0,0 +1,17 @@
+module tractor.dev/toolkit-go/duplex/x/snappy
+
+go 1.21
+
+require (
+	github.com/golang/snappy v0.0.4
+	tractor.dev/toolkit-go v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/fxamacker/cbor/v2 v2.5.0 // indirect
+	github.com/vmihailenco/msgpack/v5 v5.4.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+)
+
+replace tractor.dev/toolkit-go => ../../..
@@ -0,0 +1,32 @@
+// Package snappy registers a "snappy" compression for duplex codecs.
+//
+// Importing it for side effects registers the compression with
+// codec.RegisterCompressor, so it can be used by codec.Compressed and the
+// rpc FrameCodec Compression setting:
+//
+//	import _ "tractor.dev/toolkit-go/duplex/x/snappy"
+package snappy
+
+import (
+	"io"
+
+	"github.com/golang/snappy"
+	"tractor.dev/toolkit-go/duplex/codec"
+)
+
+func init() {
+	codec.RegisterCompressor("snappy", Compressor{})
+}
+
+// Compressor compresses with the Snappy framing format.
+type Compressor struct{}
+
+// Compress returns a Snappy writer for w
+func (Compressor) Compress(w io.Writer) io.WriteCloser {
+	return snappy.NewBufferedWriter(w)
+}
+
+// Decompress returns a Snappy reader for r
+func (Compressor) Decompress(r io.Reader) (io.Reader, error) {
+	return snappy.NewReader(r), nil
+}
@@ -0,0 +1,16 @@
+module tractor.dev/toolkit-go/duplex/x/ssh
+
+go 1.21
+
+require (
+	golang.org/x/crypto v0.14.0
+	tractor.dev/toolkit-go v0.0.0-00010101000000-000000000000
+)
+
+require (
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+)
+
+replace tractor.dev/toolkit-go => ../../..
@@ -0,0 +1,156 @@
+// Package ssh runs mux sessions over SSH channels, so peers get
+// authentication and encryption from existing SSH infrastructure.
+package ssh
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+// ChannelType is the type of the SSH channel a session runs over.
+const ChannelType = "qmux"
+
+// Dial opens an SSH channel on a connected client and returns a session over it.
+func Dial(client *ssh.Client, opts ...mux.Option) (mux.Session, error) {
+	ch, reqs, err := client.OpenChannel(ChannelType, nil)
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+	return mux.New(ch, opts...), nil
+}
+
+// DialAddr connects to an SSH server at addr and returns a session over a
+// channel of the connection. The connection is closed with the session.
+func DialAddr(addr string, config *ssh.ClientConfig, opts ...mux.Option) (mux.Session, error) {
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	ch, reqs, err := client.OpenChannel(ChannelType, nil)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+	return mux.New(&clientChannel{Channel: ch, client: client}, opts...), nil
+}
+
+// clientChannel closes the client connection with the channel.
+type clientChannel struct {
+	ssh.Channel
+	client *ssh.Client
+}
+
+func (c *clientChannel) Close() error {
+	c.Channel.Close()
+	return c.client.Close()
+}
+
+// Accept accepts a new SSH channel of ChannelType and returns a session over it.
+func Accept(newCh ssh.NewChannel, opts ...mux.Option) (mux.Session, error) {
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+	return mux.New(ch, opts...), nil
+}
+
+// Listener accepts sessions from SSH channels of ChannelType opened on the
+// connections of a net.Listener. Other channel types are rejected.
+type Listener struct {
+	l      net.Listener
+	config *ssh.ServerConfig
+	opts   []mux.Option
+
+	accepted  chan mux.Session
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen listens for SSH connections at addr, authenticated using config,
+// and returns a Listener for their sessions.
+func Listen(addr string, config *ssh.ServerConfig, opts ...mux.Option) (*Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(l, config, opts...), nil
+}
+
+// NewListener returns a Listener serving SSH on the connections of l.
+func NewListener(l net.Listener, config *ssh.ServerConfig, opts ...mux.Option) *Listener {
+	sl := &Listener{
+		l:        l,
+		config:   config,
+		opts:     opts,
+		accepted: make(chan mux.Session),
+		closed:   make(chan struct{}),
+	}
+	go sl.serve()
+	return sl
+}
+
+func (l *Listener) serve() {
+	for {
+		conn, err := l.l.Accept()
+		if err != nil {
+			l.Close()
+			return
+		}
+		go l.serveConn(conn)
+	}
+}
+
+func (l *Listener) serveConn(conn net.Conn) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, l.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+	for newCh := range chans {
+		if newCh.ChannelType() != ChannelType {
+			newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		sess, err := Accept(newCh, l.opts...)
+		if err != nil {
+			continue
+		}
+		select {
+		case l.accepted <- sess:
+		case <-l.closed:
+			sess.Close()
+			return
+		}
+	}
+}
+
+// Accept waits for and returns the next session.
+func (l *Listener) Accept() (mux.Session, error) {
+	select {
+	case sess := <-l.accepted:
+		return sess, nil
+	case <-l.closed:
+		return nil, io.EOF
+	}
+}
+
+// Close closes the listener.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return l.l.Close()
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.l.Addr()
+}
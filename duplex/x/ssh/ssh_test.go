@@ -0,0 +1,81 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func fatal(err error, t *testing.T) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionEcho(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	fatal(err, t)
+	signer, err := ssh.NewSignerFromKey(key)
+	fatal(err, t)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "alice" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := Listen("127.0.0.1:0", config)
+	fatal(err, t)
+	defer l.Close()
+
+	go func() {
+		sess, err := l.Accept()
+		if err != nil {
+			return
+		}
+		ch, err := sess.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(ch, ch)
+		ch.CloseWrite()
+		sess.Wait()
+	}()
+
+	_, err = DialAddr(l.Addr().String(), &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.Password("wrong")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		t.Fatal("expected authentication to fail")
+	}
+
+	sess, err := DialAddr(l.Addr().String(), &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+		HostKeyCallback: ssh.FixedHostKey(signer.PublicKey()),
+	})
+	fatal(err, t)
+	defer sess.Close()
+
+	ch, err := sess.Open(context.Background())
+	fatal(err, t)
+	_, err = ch.Write([]byte("Hello world"))
+	fatal(err, t)
+	fatal(ch.CloseWrite(), t)
+	b, err := io.ReadAll(ch)
+	fatal(err, t)
+	if string(b) != "Hello world" {
+		t.Fatalf("unexpected data: %#v", b)
+	}
+}
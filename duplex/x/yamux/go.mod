@@ -0,0 +1,15 @@
+module tractor.dev/toolkit-go/duplex/x/yamux
+
+go 1.21
+
+require (
+	github.com/hashicorp/yamux v0.1.2
+	tractor.dev/toolkit-go v0.0.0-00010101000000-000000000000
+)
+
+require (
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+)
+
+replace tractor.dev/toolkit-go => ../../..
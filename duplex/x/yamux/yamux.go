@@ -0,0 +1,129 @@
+// Package yamux adapts hashicorp/yamux sessions to mux.Session, so rpc and
+// talk peers can use connections from existing yamux infrastructure, such as
+// plugin hosts, without a bridging proxy.
+package yamux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+type Config = yamux.Config
+
+// DefaultConfig returns the default yamux configuration.
+func DefaultConfig() *Config {
+	return yamux.DefaultConfig()
+}
+
+// New returns a mux.Session using the streams of a yamux session.
+func New(sess *yamux.Session) mux.Session {
+	return &session{sess}
+}
+
+// Client starts the client side of a yamux session over conn.
+// If config is nil, the default configuration is used.
+func Client(conn io.ReadWriteCloser, config *Config) (mux.Session, error) {
+	sess, err := yamux.Client(conn, config)
+	if err != nil {
+		return nil, err
+	}
+	return New(sess), nil
+}
+
+// Server starts the server side of a yamux session over conn.
+// If config is nil, the default configuration is used.
+func Server(conn io.ReadWriteCloser, config *Config) (mux.Session, error) {
+	sess, err := yamux.Server(conn, config)
+	if err != nil {
+		return nil, err
+	}
+	return New(sess), nil
+}
+
+type session struct {
+	sess *yamux.Session
+}
+
+func (s *session) Close() error {
+	return s.sess.Close()
+}
+
+func (s *session) Accept() (mux.Channel, error) {
+	return s.AcceptContext(context.Background())
+}
+
+func (s *session) AcceptContext(ctx context.Context) (mux.Channel, error) {
+	stream, err := s.sess.AcceptStreamWithContext(ctx)
+	if err != nil {
+		if errors.Is(err, yamux.ErrSessionShutdown) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	return &channel{stream}, nil
+}
+
+func (s *session) Open(ctx context.Context) (mux.Channel, error) {
+	// yamux does not wait for the remote side to acknowledge
+	// a stream, so the context is only checked up front.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stream, err := s.sess.OpenStream()
+	if err != nil {
+		if errors.Is(err, yamux.ErrRemoteGoAway) {
+			return nil, mux.ErrGoAway
+		}
+		return nil, err
+	}
+	return &channel{stream}, nil
+}
+
+// Drain sends a yamux go away so the remote side stops opening streams,
+// then waits for open streams to be closed before closing the session.
+func (s *session) Drain(ctx context.Context) error {
+	if err := s.sess.GoAway(); err != nil {
+		s.Close()
+		return err
+	}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for s.sess.NumStreams() > 0 {
+		select {
+		case <-ticker.C:
+		case <-s.sess.CloseChan():
+			return nil
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		}
+	}
+	return s.Close()
+}
+
+// Wait blocks until the session is closed. yamux does not expose the cause,
+// so io.EOF is returned.
+func (s *session) Wait() error {
+	<-s.sess.CloseChan()
+	return io.EOF
+}
+
+// channel is a yamux stream, which already has the methods of net.Conn.
+type channel struct {
+	*yamux.Stream
+}
+
+func (c *channel) ID() uint32 {
+	return c.StreamID()
+}
+
+// CloseWrite signals the end of sending data. A yamux stream only
+// supports half closing, so this is the same as Close.
+func (c *channel) CloseWrite() error {
+	return c.Stream.Close()
+}
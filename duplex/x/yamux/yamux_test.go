@@ -0,0 +1,77 @@
+package yamux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"tractor.dev/toolkit-go/duplex/mux"
+)
+
+func fatal(err error, t *testing.T) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestPair(t *testing.T) (client, server mux.Session) {
+	a, b := net.Pipe()
+	client, err := Client(a, nil)
+	fatal(err, t)
+	server, err = Server(b, nil)
+	fatal(err, t)
+	return
+}
+
+func TestChannelEcho(t *testing.T) {
+	client, server := newTestPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		ch, err := server.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(ch, ch)
+		ch.Close()
+	}()
+
+	ch, err := client.Open(context.Background())
+	fatal(err, t)
+	_, err = io.WriteString(ch, "Hello world")
+	fatal(err, t)
+	fatal(ch.CloseWrite(), t)
+	b, err := io.ReadAll(ch)
+	fatal(err, t)
+	if string(b) != "Hello world" {
+		t.Fatalf("unexpected data: %#v", b)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	client, server := newTestPair(t)
+	defer client.Close()
+
+	ch, err := client.Open(context.Background())
+	fatal(err, t)
+	_, err = ch.Write([]byte{1})
+	fatal(err, t)
+	chS, err := server.Accept()
+	fatal(err, t)
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- server.Drain(context.Background())
+	}()
+
+	fatal(chS.Close(), t)
+	fatal(ch.Close(), t)
+	fatal(<-drained, t)
+	if _, err := server.Accept(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF: %v", err)
+	}
+}
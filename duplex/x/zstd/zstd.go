@@ -0,0 +1,38 @@
+// Package zstd registers a "zstd" compression for duplex codecs.
+//
+// Importing it for side effects registers the compression with
+// codec.RegisterCompressor, so it can be used by codec.Compressed and the
+// rpc FrameCodec Compression setting:
+//
+//	import _ "tractor.dev/toolkit-go/duplex/x/zstd"
+package zstd
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"tractor.dev/toolkit-go/duplex/codec"
+)
+
+func init() {
+	codec.RegisterCompressor("zstd", Compressor{})
+}
+
+// Compressor compresses with Zstandard. Encoding and decoding are done
+// synchronously, so readers and writers do not leave goroutines behind.
+type Compressor struct{}
+
+// Compress returns a Zstandard writer for w
+func (Compressor) Compress(w io.Writer) io.WriteCloser {
+	zw, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	return zw
+}
+
+// Decompress returns a Zstandard reader for r
+func (Compressor) Decompress(r io.Reader) (io.Reader, error) {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
@@ -0,0 +1,28 @@
+package zstd
+
+import (
+	"bytes"
+	"testing"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+)
+
+func TestCompressed(t *testing.T) {
+	if _, ok := codec.LookupCompressor("zstd"); !ok {
+		t.Fatal("zstd not registered")
+	}
+
+	c := codec.Compressed(codec.JSONCodec{}, "zstd")
+	var buf bytes.Buffer
+	want := map[string]string{"hello": string(bytes.Repeat([]byte("world"), 100))}
+	if err := c.Encoder(&buf).Encode(want); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := c.Decoder(&buf).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got["hello"] != want["hello"] {
+		t.Fatalf("unexpected value: %v", got)
+	}
+}
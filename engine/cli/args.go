@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// posArg is a positional argument declared with ArgsFrom.
+type posArg struct {
+	name     string
+	usage    string
+	def      string
+	hasDef   bool
+	optional bool
+	variadic bool
+	choices  []string
+	field    reflect.Value
+}
+
+// ArgsFrom sets the Args of the command to parse its positional arguments
+// into the fields of the struct v points to, in order, so Run can use their
+// converted values. Fields are declared as arguments with tags:
+//
+//	type Args struct {
+//		Name   string   `arg:"name" usage:"name of the remote"`
+//		Format string   `arg:"format" choices:"json,cbor" default:"json"`
+//		Files  []string `arg:"file" optional:"true"`
+//	}
+//
+// An argument with a default or an optional tag can be left out, so it must
+// follow the required arguments. A slice field takes the rest of the
+// arguments, at least one unless it is optional, so it must be the last.
+// A choices tag limits the values to a comma separated list. Fields can have
+// the types supported by FlagsFrom, or be slices of them. If the struct has a
+// Validate() error method, it is called after the arguments are set. Fields
+// without an arg tag are skipped. ArgsFrom panics if v is not a pointer to a
+// struct, or a tagged field has an unsupported type or is out of order.
+func (c *Command) ArgsFrom(v any) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		panic("cli: ArgsFrom needs a pointer to a struct")
+	}
+	c.posArgs = nil
+	t := rv.Elem().Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := field.Tag.Lookup("arg")
+		if !ok {
+			continue
+		}
+		if !field.IsExported() {
+			panic(fmt.Sprintf("cli: arg field %s is not exported", field.Name))
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		a := posArg{
+			name:     name,
+			usage:    field.Tag.Get("usage"),
+			optional: field.Tag.Get("optional") == "true",
+			field:    rv.Elem().Field(i),
+		}
+		a.def, a.hasDef = field.Tag.Lookup("default")
+		a.optional = a.optional || a.hasDef
+		if choices := field.Tag.Get("choices"); choices != "" {
+			a.choices = strings.Split(choices, ",")
+		}
+		elem := a.field
+		if field.Type.Kind() == reflect.Slice {
+			a.variadic = true
+			elem = reflect.New(field.Type.Elem()).Elem()
+		}
+		if _, ok := flagValue(elem); !ok {
+			panic(fmt.Sprintf("cli: arg field %s has unsupported type %s", field.Name, field.Type))
+		}
+		if n := len(c.posArgs); n > 0 {
+			if last := c.posArgs[n-1]; last.variadic {
+				panic(fmt.Sprintf("cli: arg field %s follows a slice field", field.Name))
+			} else if last.optional && !a.optional {
+				panic(fmt.Sprintf("cli: required arg field %s follows an optional field", field.Name))
+			}
+		}
+		c.posArgs = append(c.posArgs, a)
+	}
+	c.Args = func(cmd *Command, args []string) error {
+		return parseArgs(c.posArgs, args, rv.Interface())
+	}
+}
+
+// parseArgs sets the fields of the positional arguments from args, then
+// validates v if it has a Validate method.
+func parseArgs(posArgs []posArg, args []string, v any) error {
+	required, limit := 0, len(posArgs)
+	for _, a := range posArgs {
+		if !a.optional {
+			required++
+		}
+		if a.variadic {
+			limit = -1
+		}
+	}
+	if len(args) < required {
+		var missing []string
+		for _, a := range posArgs[len(args):required] {
+			missing = append(missing, "<"+a.name+">")
+		}
+		return fmt.Errorf("missing argument(s): %s", strings.Join(missing, " "))
+	}
+	if limit >= 0 && len(args) > limit {
+		return fmt.Errorf("accepts at most %d arg(s), received %d", limit, len(args))
+	}
+	for i, a := range posArgs {
+		a.field.Set(reflect.Zero(a.field.Type()))
+		values := args[min(i, len(args)):]
+		if !a.variadic && len(values) > 1 {
+			values = values[:1]
+		}
+		if len(values) == 0 && a.hasDef {
+			values = []string{a.def}
+		}
+		for _, s := range values {
+			if err := a.set(s); err != nil {
+				return fmt.Errorf("invalid value %q for argument <%s>: %v", s, a.name, err)
+			}
+		}
+	}
+	if v, ok := v.(interface{ Validate() error }); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// set converts s and sets the field, appending it to slice fields.
+func (a posArg) set(s string) error {
+	if len(a.choices) > 0 {
+		found := false
+		for _, c := range a.choices {
+			found = found || c == s
+		}
+		if !found {
+			return fmt.Errorf("must be one of %s", strings.Join(a.choices, ", "))
+		}
+	}
+	if !a.variadic {
+		v, _ := flagValue(a.field)
+		return v.Set(s)
+	}
+	elem := reflect.New(a.field.Type().Elem()).Elem()
+	v, _ := flagValue(elem)
+	if err := v.Set(s); err != nil {
+		return err
+	}
+	a.field.Set(reflect.Append(a.field, elem))
+	return nil
+}
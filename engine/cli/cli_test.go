@@ -4,7 +4,14 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Tests TODO:
@@ -133,3 +140,719 @@ func TestVersion(t *testing.T) {
 		t.Fatal("unexpected output:", buf.String())
 	}
 }
+
+type logLevel int
+
+func (l *logLevel) String() string { return fmt.Sprint(int(*l)) }
+
+func (l *logLevel) Set(s string) error {
+	switch s {
+	case "debug":
+		*l = 0
+	case "info":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown level %q", s)
+	}
+	return nil
+}
+
+type commonOptions struct {
+	Verbose bool `flag:"v" usage:"verbose output" env:"CLI_TEST_VERBOSE"`
+}
+
+type serveOptions struct {
+	commonOptions
+	Addr    string        `flag:"addr" usage:"address to listen on" default:":8080" env:"CLI_TEST_ADDR"`
+	Timeout time.Duration `flag:"timeout" default:"10s"`
+	Workers int           `flag:"" usage:"number of workers" default:"4"`
+	Tags    []string      `flag:"tag" usage:"tags to add" default:"a,b"`
+	Level   logLevel      `flag:"level" default:"info"`
+	ignored string
+}
+
+func TestFlagsFrom(t *testing.T) {
+	newCmd := func() (*Command, *serveOptions) {
+		var opts serveOptions
+		cmd := &Command{
+			Usage: "serve",
+			Run:   func(ctx *Context, args []string) {},
+		}
+		cmd.FlagsFrom(&opts)
+		return cmd, &opts
+	}
+
+	cmd, opts := newCmd()
+	if err := Execute(context.Background(), cmd, nil); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Addr != ":8080" || opts.Timeout != 10*time.Second || opts.Workers != 4 ||
+		strings.Join(opts.Tags, ",") != "a,b" || opts.Level != 1 || opts.Verbose {
+		t.Fatalf("unexpected defaults: %+v", opts)
+	}
+
+	t.Setenv("CLI_TEST_VERBOSE", "true")
+	t.Setenv("CLI_TEST_ADDR", ":9090")
+	cmd, opts = newCmd()
+	args := []string{"-addr", ":7070", "-workers=8", "-tag", "x", "-tag", "y,z", "-level", "debug", "-timeout", "1m"}
+	if err := Execute(context.Background(), cmd, args); err != nil {
+		t.Fatal(err)
+	}
+	// arguments take precedence over the environment
+	if opts.Addr != ":7070" || !opts.Verbose || opts.Workers != 8 || opts.Timeout != time.Minute ||
+		strings.Join(opts.Tags, ",") != "x,y,z" || opts.Level != 0 {
+		t.Fatalf("unexpected values: %+v", opts)
+	}
+
+	cmd, _ = newCmd()
+	if err := Execute(context.Background(), cmd, []string{"-level", "loud"}); err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+
+	var help bytes.Buffer
+	(&CommandHelp{cmd}).WriteHelp(&help)
+	for _, want := range []string{"-addr string", "address to listen on", `(default ":8080")`, "-workers int", "-timeout duration"} {
+		if !strings.Contains(help.String(), want) {
+			t.Fatalf("missing %q in help:\n%s", want, help.String())
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.json")
+	if err := os.WriteFile(path, []byte(`{
+		"verbose": true,
+		"serve": {"addr": ":7070", "workers": 1000000, "tag": ["x", "y"]}
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		verbose bool
+		opts    serveOptions
+	)
+	newRoot := func() *Command {
+		verbose = false
+		opts = serveOptions{}
+		root := &Command{
+			Usage:  "app",
+			Config: &Config{EnvPrefix: "APP", File: path},
+		}
+		root.Flags().BoolVar(&verbose, "verbose", false, "verbose output")
+		serve := &Command{
+			Usage: "serve",
+			Run:   func(ctx *Context, args []string) {},
+		}
+		serve.FlagsFrom(&opts)
+		root.AddCommand(serve)
+		return root
+	}
+
+	if err := Execute(context.Background(), newRoot(), []string{"serve"}); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Addr != ":7070" || opts.Workers != 1000000 || strings.Join(opts.Tags, ",") != "x,y" || opts.Timeout != 10*time.Second {
+		t.Fatalf("unexpected values from file: %+v", opts)
+	}
+
+	// the environment takes precedence over the file, and arguments over both
+	t.Setenv("APP_SERVE_ADDR", ":9090")
+	t.Setenv("APP_SERVE_TIMEOUT", "1m")
+	if err := Execute(context.Background(), newRoot(), []string{"serve", "-timeout", "5s"}); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Addr != ":9090" || opts.Timeout != 5*time.Second {
+		t.Fatalf("unexpected values: %+v", opts)
+	}
+
+	t.Setenv("APP_SERVE_WORKERS", "many")
+	if err := Execute(context.Background(), newRoot(), []string{"serve"}); err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+	os.Unsetenv("APP_SERVE_WORKERS")
+
+	var buf bytes.Buffer
+	ctx := ContextWithIO(context.Background(), nil, &buf, &buf)
+	if err := Execute(ctx, newRoot(), []string{"config", "serve"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"addr     :9090    env APP_SERVE_ADDR",
+		"tag      x,y      file " + path,
+		"timeout  1m0s     env APP_SERVE_TIMEOUT",
+		"v        false    default",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("missing %q in config output:\n%s", want, buf.String())
+		}
+	}
+	buf.Reset()
+	if err := Execute(ctx, newRoot(), []string{"config"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "verbose  true   file "+path) {
+		t.Fatalf("unexpected config output:\n%s", buf.String())
+	}
+}
+
+func TestConfigFormats(t *testing.T) {
+	for name, data := range map[string]string{
+		"app.toml": "[serve]\naddr = \":7070\"\nworkers = 1000000\ntag = [\"x\", \"y\"]\n",
+		"app.yaml": "serve:\n  addr: \":7070\"\n  workers: 1000000\n  tag: [x, y]\n",
+	} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var opts serveOptions
+		root := &Command{
+			Usage:  "app",
+			Config: &Config{File: path},
+		}
+		serve := &Command{
+			Usage: "serve",
+			Run:   func(ctx *Context, args []string) {},
+		}
+		serve.FlagsFrom(&opts)
+		root.AddCommand(serve)
+
+		if err := Execute(context.Background(), root, []string{"serve"}); err != nil {
+			t.Fatal(name, err)
+		}
+		if opts.Addr != ":7070" || opts.Workers != 1000000 || strings.Join(opts.Tags, ",") != "x,y" {
+			t.Fatalf("%s: unexpected values from file: %+v", name, opts)
+		}
+	}
+}
+
+func TestPersistentFlags(t *testing.T) {
+	var (
+		verbose bool
+		addr    string
+		name    string
+		ran     string
+	)
+	newRoot := func() (*Command, *Command) {
+		root := &Command{
+			Usage:  "app",
+			Config: &Config{EnvPrefix: "APP"},
+		}
+		root.PersistentFlags().BoolVar(&verbose, "verbose", false, "verbose output")
+		root.PersistentFlags().StringVar(&addr, "addr", "localhost:8080", "server address")
+		remote := &Command{Usage: "remote"}
+		root.AddCommand(remote)
+		add := &Command{
+			Usage: "add <name>",
+			Args:  ExactArgs(1),
+			Run: func(ctx *Context, args []string) {
+				ran = args[0]
+			},
+		}
+		add.Flags().StringVar(&name, "name", "", "remote name")
+		remote.AddCommand(add)
+		return root, add
+	}
+
+	for _, args := range [][]string{
+		{"remote", "add", "-verbose", "-addr", "example.com:80", "-name", "a", "origin"},
+		{"-verbose", "remote", "-addr", "example.com:80", "add", "-name", "a", "origin"},
+	} {
+		root, _ := newRoot()
+		if err := Execute(context.Background(), root, args); err != nil {
+			t.Fatal(err)
+		}
+		if !verbose || addr != "example.com:80" || name != "a" || ran != "origin" {
+			t.Fatalf("%v: unexpected values: %v %q %q %q", args, verbose, addr, name, ran)
+		}
+	}
+
+	// inherited flags are read from the environment variables of the
+	// command defining them
+	t.Setenv("APP_ADDR", "env.example.com:80")
+	root, add := newRoot()
+	if err := Execute(context.Background(), root, []string{"remote", "add", "origin"}); err != nil {
+		t.Fatal(err)
+	}
+	if addr != "env.example.com:80" {
+		t.Fatalf("unexpected addr from env: %q", addr)
+	}
+
+	var help bytes.Buffer
+	if err := (&CommandHelp{add}).WriteHelp(&help); err != nil {
+		t.Fatal(err)
+	}
+	flags, global, ok := strings.Cut(help.String(), "Global Flags:")
+	if !ok || !strings.Contains(flags, "-name") || strings.Contains(flags, "-verbose") ||
+		!strings.Contains(global, "-verbose") || !strings.Contains(global, `server address (default "localhost:8080")`) {
+		t.Fatalf("unexpected help:\n%s", help.String())
+	}
+}
+
+func TestHelp(t *testing.T) {
+	defer func(w int) { HelpWidth = w }(HelpWidth)
+	HelpWidth = 60
+
+	var opts serveOptions
+	root := &Command{
+		Usage: "app",
+		Short: "App is an application.",
+	}
+	root.PersistentFlags().Bool("verbose", false, "verbose output")
+	serve := &Command{
+		Usage:   "serve [-addr addr]",
+		Short:   "Serve the application over HTTP, with a description long enough to be wrapped",
+		Example: "app serve -addr :80\napp serve -tag a -tag b",
+		Run:     func(ctx *Context, args []string) {},
+	}
+	serve.FlagsFrom(&opts)
+	serve.GroupFlags("Network", "addr", "timeout")
+	root.AddCommand(serve)
+	root.AddCommand(&Command{
+		Usage:  "secret",
+		Hidden: true,
+		Run:    func(ctx *Context, args []string) {},
+	})
+	var oldRan bool
+	root.AddCommand(&Command{
+		Usage:      "old",
+		Deprecated: "use serve instead",
+		Run:        func(ctx *Context, args []string) { oldRan = true },
+	})
+
+	var stdout, stderr bytes.Buffer
+	ctx := ContextWithIO(context.Background(), nil, &stdout, &stderr)
+	if err := Execute(ctx, root, []string{"help"}); err != nil {
+		t.Fatal(err)
+	}
+	want := `App is an application.
+
+Usage:
+  app [command]
+
+Available Commands:
+  serve       Serve the application over HTTP, with a
+              description long enough to be wrapped
+  help        Help about any command
+
+Flags:
+  -verbose
+    	verbose output
+
+Use "app [command] -help" for more information about a command.
+`
+	if stdout.String() != want {
+		t.Fatalf("unexpected help:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := Execute(ctx, root, []string{"help", "serve"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Usage:\n  app serve [-addr addr]\n",
+		"Examples:\n  app serve -addr :80\n  app serve -tag a -tag b\n",
+		"\nFlags:\n  -level value\n",
+		"\nNetwork Flags:\n  -addr string\n    \taddress to listen on (default \":8080\")\n  -timeout duration\n",
+		"\nGlobal Flags:\n  -verbose\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Fatalf("missing %q in help:\n%s", want, stdout.String())
+		}
+	}
+
+	stdout.Reset()
+	if err := Execute(ctx, root, []string{"help", "nope"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "Unknown help topic: nope") {
+		t.Fatalf("unexpected output: %q", stderr.String())
+	}
+
+	stderr.Reset()
+	if err := Execute(ctx, root, []string{"old"}); err != nil {
+		t.Fatal(err)
+	}
+	if !oldRan || stderr.String() != "Command \"old\" is deprecated, use serve instead\n" {
+		t.Fatalf("unexpected output: %q", stderr.String())
+	}
+}
+
+type remoteArgs struct {
+	Name   string   `arg:"name" usage:"name of the remote"`
+	Port   int      `arg:"port"`
+	Format string   `arg:"format" choices:"json,cbor" default:"json"`
+	Tags   []string `arg:"tag" optional:"true"`
+}
+
+func (a *remoteArgs) Validate() error {
+	if a.Port <= 0 {
+		return fmt.Errorf("port must be positive")
+	}
+	return nil
+}
+
+func TestArgsFrom(t *testing.T) {
+	var args remoteArgs
+	cmd := &Command{
+		Usage: "add <name> <port> [format] [tag...]",
+		Run:   func(ctx *Context, _ []string) {},
+	}
+	cmd.ArgsFrom(&args)
+
+	for _, tt := range []struct {
+		args []string
+		want remoteArgs
+		err  string
+	}{
+		{args: []string{"origin", "80"}, want: remoteArgs{Name: "origin", Port: 80, Format: "json"}},
+		{args: []string{"origin", "80", "cbor", "a", "b"}, want: remoteArgs{Name: "origin", Port: 80, Format: "cbor", Tags: []string{"a", "b"}}},
+		{args: []string{"origin"}, err: "missing argument(s): <port>"},
+		{args: []string{"origin", "http"}, err: `invalid value "http" for argument <port>`},
+		{args: []string{"origin", "80", "xml"}, err: "must be one of json, cbor"},
+		{args: []string{"origin", "-1"}, err: "port must be positive"},
+	} {
+		err := Execute(context.Background(), cmd, tt.args)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("%v: expected error %q, got %v", tt.args, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		if fmt.Sprint(args) != fmt.Sprint(tt.want) {
+			t.Fatalf("%v: got %+v, want %+v", tt.args, args, tt.want)
+		}
+	}
+
+	var help bytes.Buffer
+	(&CommandHelp{cmd}).WriteHelp(&help)
+	if !strings.Contains(help.String(), "Arguments:\n  <name>    name of the remote\n  <port>\n  <format>  (one of json, cbor) (default \"json\")\n  <tag>     (optional)\n") {
+		t.Fatalf("unexpected help:\n%s", help.String())
+	}
+}
+
+func TestPrompts(t *testing.T) {
+	var prompts bytes.Buffer
+	ctx := ContextWithIO(context.Background(), strings.NewReader(strings.Join([]string{
+		"",         // input default
+		"  value ", // input
+		"yes",      // confirm
+		"",         // confirm default
+		"2",        // select
+		"3, 1,3",   // multi-select
+		" p4ss  ",  // password
+		"maybe",    // invalid confirm
+		"",         // select default
+		"none",     // multi-select none
+	}, "\n")+"\n"), nil, &prompts)
+
+	if v, err := Input(ctx, "Name", "app"); err != nil || v != "app" {
+		t.Fatal(v, err)
+	}
+	if v, err := Input(ctx, "Name", "app"); err != nil || v != "value" {
+		t.Fatal(v, err)
+	}
+	if v, err := Confirm(ctx, "Continue?", false); err != nil || !v {
+		t.Fatal(v, err)
+	}
+	if v, err := Confirm(ctx, "Continue?", true); err != nil || !v {
+		t.Fatal(v, err)
+	}
+	options := []string{"json", "cbor", "msgpack"}
+	if v, err := Select(ctx, "Codec", options, -1); err != nil || v != 1 {
+		t.Fatal(v, err)
+	}
+	if v, err := MultiSelect(ctx, "Codecs", options, nil); err != nil || fmt.Sprint(v) != "[0 2]" {
+		t.Fatal(v, err)
+	}
+	if v, err := Password(ctx, "Password"); err != nil || v != " p4ss  " {
+		t.Fatalf("%q %v", v, err)
+	}
+	// answers that are not typed can't be asked again
+	if _, err := Confirm(ctx, "Continue?", false); err == nil {
+		t.Fatal("expected error for invalid answer")
+	}
+	if v, err := Select(ctx, "Codec", options, 2); err != nil || v != 2 {
+		t.Fatal(v, err)
+	}
+	if v, err := MultiSelect(ctx, "Codecs", options, []int{0}); err != nil || len(v) != 0 {
+		t.Fatal(v, err)
+	}
+
+	// the input has ended
+	if v, err := Select(ctx, "Codec", options, 0); err != nil || v != 0 {
+		t.Fatal(v, err)
+	}
+	if _, err := Input(ctx, "Name", ""); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	if !strings.Contains(prompts.String(), "Codec\n  1) json\n  2) cbor\n  3) msgpack\nEnter a number [3]: ") {
+		t.Fatalf("unexpected prompts:\n%s", prompts.String())
+	}
+}
+
+func TestOutput(t *testing.T) {
+	type remote struct {
+		Name string `json:"name"`
+		Port int    `json:"port"`
+	}
+	root := &Command{Usage: "app"}
+	root.OutputFlags()
+	root.AddCommand(&Command{
+		Usage: "list",
+		Run: func(ctx *Context, args []string) {
+			out := ctx.Output()
+			if len(args) > 0 {
+				out.Emit([]remote{{"origin", 80}, {"backup", 8080}})
+				return
+			}
+			table := NewTable("name", "port")
+			table.Append("origin", 80)
+			table.Append("backup", 8080)
+			out.Emit(table)
+		},
+	})
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"list"}, "NAME    PORT\norigin  80\nbackup  8080\n"},
+		{[]string{"list", "-json"}, "[\n  {\n    \"name\": \"origin\",\n    \"port\": 80\n  },\n  {\n    \"name\": \"backup\",\n    \"port\": 8080\n  }\n]\n"},
+		{[]string{"-output", "ndjson", "list"}, "{\"name\":\"origin\",\"port\":80}\n{\"name\":\"backup\",\"port\":8080}\n"},
+		{[]string{"list", "-output=ndjson", "structs"}, "{\"name\":\"origin\",\"port\":80}\n{\"name\":\"backup\",\"port\":8080}\n"},
+		{[]string{"list", "structs"}, "{origin 80}\n{backup 8080}\n"},
+	} {
+		var buf bytes.Buffer
+		ctx := ContextWithIO(context.Background(), nil, &buf, nil)
+		if err := Execute(ctx, root, tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Fatalf("%v: unexpected output:\n%s", tt.args, buf.String())
+		}
+		// reset the format for the next run
+		root.PersistentFlags().Set("output", "text")
+	}
+
+	if err := Execute(context.Background(), root, []string{"list", "-output", "xml"}); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
+
+func TestHooks(t *testing.T) {
+	type key struct{}
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next RunFunc) RunFunc {
+			return func(ctx *Context, args []string) {
+				calls = append(calls, name+" in")
+				next(ctx.WithValue(key{}, name), args)
+				calls = append(calls, name+" out")
+			}
+		}
+	}
+	hook := func(name string, err error) func(ctx *Context, args []string) error {
+		return func(ctx *Context, args []string) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+
+	root := &Command{
+		Usage:      "app",
+		Before:     hook("root before", nil),
+		After:      hook("root after", nil),
+		Middleware: []Middleware{trace("root")},
+	}
+	remote := &Command{
+		Usage:      "remote",
+		Before:     hook("remote before", nil),
+		After:      hook("remote after", nil),
+		Middleware: []Middleware{trace("remote 1"), trace("remote 2")},
+	}
+	root.AddCommand(remote)
+	remote.AddCommand(&Command{
+		Usage: "list",
+		Run: func(ctx *Context, args []string) {
+			calls = append(calls, "run "+ctx.Value(key{}).(string))
+		},
+	})
+
+	if err := Execute(context.Background(), root, []string{"remote", "list"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "root before, remote before, root in, remote 1 in, remote 2 in, run remote 2, remote 2 out, remote 1 out, root out, remote after, root after"
+	if got := strings.Join(calls, ", "); got != want {
+		t.Fatalf("unexpected calls:\n%s", got)
+	}
+
+	// a failing Before stops the command, and only the hooks that ran are undone
+	calls = nil
+	errBefore := fmt.Errorf("no daemon")
+	remote.Before = hook("remote before", errBefore)
+	if err := Execute(context.Background(), root, []string{"remote", "list"}); err != errBefore {
+		t.Fatalf("expected error from Before, got %v", err)
+	}
+	if got := strings.Join(calls, ", "); got != "root before, remote before, root after" {
+		t.Fatalf("unexpected calls:\n%s", got)
+	}
+}
+
+func docsTree() *Command {
+	root := &Command{Usage: "tool", Short: "Tool manages remotes."}
+	root.PersistentFlags().Bool("verbose", false, "verbose output")
+	remote := &Command{Usage: "remote", Short: "Manage remotes"}
+	root.AddCommand(remote)
+	var args remoteArgs
+	add := &Command{
+		Usage:   "add <name> <port>",
+		Short:   "Add a remote",
+		Long:    "Add a remote to the list of remotes.\n.dot lines are escaped in man pages.",
+		Example: "tool remote add origin 80",
+		Run:     func(ctx *Context, args []string) {},
+	}
+	add.ArgsFrom(&args)
+	add.Flags().String("name-prefix", "up-", "prefix of `names`")
+	remote.AddCommand(add)
+	remote.AddCommand(&Command{Usage: "secret", Hidden: true, Run: func(ctx *Context, args []string) {}})
+	return root
+}
+
+func TestGenDocs(t *testing.T) {
+	root := docsTree()
+	add, _ := root.Find([]string{"remote", "add"})
+
+	var md bytes.Buffer
+	if err := GenMarkdown(add, &md); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"## tool remote add\n\nAdd a remote\n\n### Synopsis\n\nAdd a remote to the list of remotes.\n",
+		"```\ntool remote add <name> <port>\n```\n",
+		"### Arguments\n\n```\n  <name>    name of the remote\n",
+		"### Examples\n\n```\ntool remote add origin 80\n```\n",
+		"### Options\n\n```\n  -name-prefix names\n    \tprefix of names (default \"up-\")\n```\n",
+		"### Options inherited from parent commands\n\n```\n  -verbose\n",
+		"### See also\n\n* [tool remote](tool_remote.md)\t - Manage remotes\n",
+	} {
+		if !strings.Contains(md.String(), want) {
+			t.Fatalf("missing %q in markdown:\n%s", want, md.String())
+		}
+	}
+
+	var man bytes.Buffer
+	if err := GenMan(add, ManHeader{Source: "Tool 1.0"}, &man); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		".TH \"TOOL-REMOTE-ADD\" \"1\" \"\" \"Tool 1.0\" \"\"\n",
+		".SH NAME\ntool\\-remote\\-add \\- Add a remote\n",
+		".SH SYNOPSIS\n.B tool remote add <name> <port>\n",
+		"\n\\&.dot lines are escaped in man pages.\n",
+		".TP\n\\fB\\-name\\-prefix\\fP \\fInames\\fP\nprefix of names (default up\\-)\n",
+		".SH OPTIONS INHERITED FROM PARENT COMMANDS\n.TP\n\\fB\\-verbose\\fP\n",
+		".SH SEE ALSO\n\\fBtool\\-remote\\fP(1)\n",
+	} {
+		if !strings.Contains(man.String(), want) {
+			t.Fatalf("missing %q in man page:\n%s", want, man.String())
+		}
+	}
+
+	dir := t.TempDir()
+	if err := GenMarkdownTree(root, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenManTree(root, ManHeader{}, dir); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if got := strings.Join(names, " "); got != "tool-remote-add.1 tool-remote.1 tool.1 tool.md tool_remote.md tool_remote_add.md" {
+		t.Fatalf("unexpected files: %s", got)
+	}
+}
+
+func TestPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugins are shell scripts")
+	}
+	dir := t.TempDir()
+	writePlugin := func(name, script string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePlugin("tool-hello", `if [ "$CLI_PLUGIN_METADATA" = 1 ]; then
+	echo '{"usage": "tool-hello [name]", "short": "Say hello"}'
+	exit
+fi
+read line
+echo "hello $@ $line"
+echo oops >&2
+exit 3
+`)
+	writePlugin("tool-quiet", "exit 0\n")
+	writePlugin("tool-list", "echo shadowed\n")
+	if err := os.WriteFile(filepath.Join(dir, "tool-data"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var ran bool
+	root := &Command{
+		Usage:   "tool",
+		Plugins: &Plugins{Dirs: []string{dir}, IgnorePath: true},
+	}
+	root.AddCommand(&Command{
+		Usage: "list",
+		Short: "List things",
+		Run:   func(ctx *Context, args []string) { ran = true },
+	})
+
+	var stdout, stderr bytes.Buffer
+	ctx := ContextWithIO(context.Background(), strings.NewReader("world\n"), &stdout, &stderr)
+	err := Execute(ctx, root, []string{"hello", "-x", "there"})
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Fatalf("expected exit status 3, got %v", err)
+	}
+	if stdout.String() != "hello -x there world\n" || stderr.String() != "oops\n" {
+		t.Fatalf("unexpected output: %q %q", stdout.String(), stderr.String())
+	}
+
+	// subcommands take precedence over plugins
+	if err := Execute(ctx, root, []string{"list"}); err != nil || !ran {
+		t.Fatalf("expected list to run, got %v", err)
+	}
+	if err := Execute(ctx, root, []string{"data"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout.Reset()
+	if err := Execute(ctx, root, []string{"help"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "Available Commands:\n  list        List things\n  help        Help about any command\n  hello       Say hello\n  quiet       \n"
+	if !strings.Contains(stdout.String(), want) {
+		t.Fatalf("unexpected help:\n%s", stdout.String())
+	}
+
+	// plugins built with this package report their metadata
+	t.Setenv(PluginMetadataEnv, "1")
+	stdout.Reset()
+	if err := Execute(ctx, &Command{Usage: "tool-hello [name]", Short: "Say hello", Version: "1.0"}, []string{"-cli-plugin-metadata"}); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != `{"usage":"tool-hello [name]","short":"Say hello","version":"1.0"}`+"\n" {
+		t.Fatalf("unexpected metadata: %s", stdout.String())
+	}
+}
@@ -29,6 +29,10 @@ type Command struct {
 	// Hidden defines, if this command is hidden and should NOT show up in the list of available commands.
 	Hidden bool
 
+	// Deprecated defines, if this command is deprecated and should print this string when used.
+	// Deprecated commands don't show up in the list of available commands.
+	Deprecated string
+
 	// Aliases is an array of aliases that can be used instead of the first word in Use.
 	Aliases []string
 
@@ -45,15 +49,55 @@ type Command struct {
 	// command does not define one.
 	Version string
 
+	// Plugins, if set on the root command, runs external executables as
+	// subcommands not defined by the root.
+	Plugins *Plugins
+
+	// Config, if set on the root command, declares where flag values not
+	// given as arguments are read from, and adds a "config" subcommand to
+	// show the effective values if the root does not define one.
+	Config *Config
+
 	// Expected arguments
 	Args PositionalArgs
 
 	// Run is the function that performs the command
 	Run func(ctx *Context, args []string)
 
-	commands []*Command
-	parent   *Command
-	flags    *flag.FlagSet
+	// Before is called before running this command or any of its subcommands,
+	// after the arguments are parsed. If it returns an error, the command is
+	// not run and Execute returns the error.
+	Before func(ctx *Context, args []string) error
+
+	// After is called after running this command or any of its subcommands,
+	// if Before was called without an error. An error it returns is returned
+	// by Execute.
+	After func(ctx *Context, args []string) error
+
+	// Middleware wraps the Run of this command and its subcommands, applied
+	// in order with the middleware of parents outermost.
+	Middleware []Middleware
+
+	commands        []*Command
+	parent          *Command
+	flags           *flag.FlagSet
+	persistentFlags *flag.FlagSet
+	// inherited maps the names of flags merged from the persistent
+	// flags of parents to the command defining them
+	inherited map[string]*Command
+	// flagGroups maps flag names to the groups they are shown
+	// under in help, declared in flagGroupOrder
+	flagGroups     map[string]string
+	flagGroupOrder []string
+	// posArgs are the positional arguments declared with ArgsFrom
+	posArgs []posArg
+	// outputFormat is set by the flags added with OutputFlags
+	outputFormat *Format
+	// plugins are the commands found for Plugins to show in help
+	plugins *[]*Command
+	// flagEnvs maps flag names to the environment variables they
+	// are set from, as declared with FlagsFrom
+	flagEnvs map[string]string
 }
 
 // Flags returns the complete FlagSet that applies to this command.
@@ -66,6 +110,60 @@ func (c *Command) Flags() *flag.FlagSet {
 	return c.flags
 }
 
+// PersistentFlags returns the FlagSet of flags defined on this command that
+// are also available on all of its subcommands, such as global options. They
+// can be given before or after the names of subcommands.
+func (c *Command) PersistentFlags() *flag.FlagSet {
+	if c.persistentFlags == nil {
+		c.persistentFlags = flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+		var null bytes.Buffer
+		c.persistentFlags.SetOutput(&null)
+	}
+	return c.persistentFlags
+}
+
+// mergePersistentFlags adds the persistent flags of this command and its
+// parents to its FlagSet, unless it defines a flag of the same name.
+func (c *Command) mergePersistentFlags() {
+	for cmd := c; cmd != nil; cmd = cmd.parent {
+		if cmd.persistentFlags == nil {
+			continue
+		}
+		cmd.persistentFlags.VisitAll(func(f *flag.Flag) {
+			if c.Flags().Lookup(f.Name) != nil {
+				return
+			}
+			c.Flags().Var(f.Value, f.Name, f.Usage)
+			c.Flags().Lookup(f.Name).DefValue = f.DefValue
+			if cmd != c {
+				if c.inherited == nil {
+					c.inherited = make(map[string]*Command)
+				}
+				c.inherited[f.Name] = cmd
+			}
+		})
+	}
+}
+
+// GroupFlags shows the named flags of this command under a group in help,
+// titled "<group> Flags", after the flags not in a group. Groups are shown
+// in the order they are first declared.
+func (c *Command) GroupFlags(group string, names ...string) {
+	if c.flagGroups == nil {
+		c.flagGroups = make(map[string]string)
+	}
+	found := false
+	for _, g := range c.flagGroupOrder {
+		found = found || g == group
+	}
+	if !found {
+		c.flagGroupOrder = append(c.flagGroupOrder, group)
+	}
+	for _, name := range names {
+		c.flagGroups[name] = group
+	}
+}
+
 // AddCommand adds one or more commands to this parent command.
 func (c *Command) AddCommand(sub *Command) {
 	if sub == c {
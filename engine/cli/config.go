@@ -0,0 +1,248 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config declares where flag values not given as arguments are read from,
+// set on the root Command. In order of precedence, a flag is set from its
+// argument, its environment variable, the config file, and otherwise keeps
+// its default.
+type Config struct {
+	// EnvPrefix, if set, gives every flag an environment variable named
+	// by the prefix, the path of the command below the root and the flag
+	// name in upper case, joined by underscores. For example, the flag
+	// "max-conns" of the subcommand "serve" with the prefix "APP" is set
+	// by APP_SERVE_MAX_CONNS. Variables declared with FlagsFrom take
+	// precedence.
+	EnvPrefix string
+
+	// File is the path of the config file, which is not required to exist.
+	// It is decoded with the ConfigDecoders entry of its extension. Flags of
+	// the root are the top level keys of the file, and flags of subcommands
+	// are keys of objects nested under their names:
+	//
+	//	{"verbose": true, "serve": {"addr": ":8080", "tag": ["a", "b"]}}
+	File string
+}
+
+// ConfigDecoders decode config files by extension into maps of keys to
+// values. JSON, TOML and YAML are built in, and decoders for other formats
+// can be added with functions of the same signature:
+//
+//	cli.ConfigDecoders[".hcl"] = hcl.Unmarshal
+var ConfigDecoders = map[string]func(data []byte, v any) error{
+	".json": json.Unmarshal,
+	".toml": toml.Unmarshal,
+	".yaml": yaml.Unmarshal,
+	".yml":  yaml.Unmarshal,
+}
+
+// configFile is a decoded config file.
+type configFile struct {
+	path   string
+	values map[string]any
+}
+
+// loadConfigFile reads and decodes the config file at path, returning nil
+// if there is no path or file.
+func loadConfigFile(path string) (*configFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	decode, ok := ConfigDecoders[filepath.Ext(path)]
+	if !ok {
+		return nil, fmt.Errorf("config file %s: unsupported format %q", path, filepath.Ext(path))
+	}
+	var values map[string]any
+	if err := decode(b, &values); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return &configFile{path: path, values: values}, nil
+}
+
+// lookup returns the value of the flag name of the command at path below
+// the root.
+func (f *configFile) lookup(path []string, name string) (any, bool) {
+	if f == nil {
+		return nil, false
+	}
+	values := f.values
+	for _, p := range path {
+		sub, ok := configTable(values[p])
+		if !ok {
+			return nil, false
+		}
+		values = sub
+	}
+	v, ok := values[name]
+	if _, isTable := configTable(v); !ok || isTable {
+		return nil, false
+	}
+	return v, true
+}
+
+// configTable returns v as a map with string keys, which some YAML packages
+// decode as a map with interface keys.
+func configTable(v any) (map[string]any, bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		return v, true
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, vv := range v {
+			m[fmt.Sprint(k)] = vv
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+// setFlag sets the flag from a value decoded from a config file, where
+// lists set the flag once for each element.
+func setFlag(fs *flag.FlagSet, name string, v any) error {
+	if list, ok := v.([]any); ok {
+		for _, e := range list {
+			if err := setFlag(fs, name, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var s string
+	switch v := v.(type) {
+	case float64:
+		// avoid the exponent fmt uses for large numbers
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		s = fmt.Sprint(v)
+	}
+	return fs.Set(name, s)
+}
+
+// envName returns the environment variable for the flag name of the command
+// at path below the root, using the prefix given by Config.
+func envName(prefix string, path []string, name string) string {
+	parts := append([]string{prefix}, path...)
+	parts = append(parts, name)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, strings.ToUpper(strings.Join(parts, "_")))
+}
+
+// subPath returns the names of the commands from below the root to c.
+func (c *Command) subPath() []string {
+	var path []string
+	for cmd := c; cmd.parent != nil; cmd = cmd.parent {
+		path = append([]string{cmd.Name()}, path...)
+	}
+	return path
+}
+
+// resolveFlags sets the flags of the command not given in the arguments from
+// the environment and the config file, returning where each flag of the
+// command was set from. Inherited flags are read from the environment
+// variables and keys of the command defining them.
+func (c *Command) resolveFlags(cfg *Config, file *configFile, given map[string]bool) (map[string]string, error) {
+	sources := make(map[string]string)
+	var err error
+	c.Flags().VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		if given[f.Name] {
+			sources[f.Name] = "argument"
+			return
+		}
+		owner := c
+		if cmd, ok := c.inherited[f.Name]; ok {
+			owner = cmd
+		}
+		path := owner.subPath()
+		var envs []string
+		if env := owner.flagEnvs[f.Name]; env != "" {
+			envs = append(envs, env)
+		}
+		if cfg != nil && cfg.EnvPrefix != "" {
+			envs = append(envs, envName(cfg.EnvPrefix, path, f.Name))
+		}
+		for _, env := range envs {
+			if v, ok := os.LookupEnv(env); ok {
+				if e := c.Flags().Set(f.Name, v); e != nil {
+					err = fmt.Errorf("invalid value %q for flag -%s from %s: %v", v, f.Name, env, e)
+				}
+				sources[f.Name] = "env " + env
+				return
+			}
+		}
+		if v, ok := file.lookup(path, f.Name); ok {
+			if e := setFlag(c.Flags(), f.Name, v); e != nil {
+				err = fmt.Errorf("invalid value %v for flag -%s from %s: %v", v, f.Name, file.path, e)
+			}
+			sources[f.Name] = "file " + file.path
+			return
+		}
+		sources[f.Name] = "default"
+	})
+	return sources, err
+}
+
+// configCommand returns the built-in config command, which shows the
+// effective flag values of a command of root and where they were set from.
+func configCommand(root *Command) *Command {
+	return &Command{
+		Usage: "config [command...]",
+		Short: "Show the effective configuration",
+		Long:  "Show the effective values of the flags of a command, or of the root command if none is given, and where they were set from.",
+		Run: func(ctx *Context, args []string) {
+			cmd, n := root.Find(args)
+			if n < len(args) {
+				fmt.Fprintf(ctx.Errout(), "unknown command: %s\n", strings.Join(args, " "))
+				return
+			}
+			file, err := loadConfigFile(root.Config.File)
+			if err != nil {
+				fmt.Fprintln(ctx.Errout(), err)
+				return
+			}
+			cmd.mergePersistentFlags()
+			sources, err := cmd.resolveFlags(root.Config, file, nil)
+			if err != nil {
+				fmt.Fprintln(ctx.Errout(), err)
+				return
+			}
+			var names []string
+			for name := range sources {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			table := NewTable("flag", "value", "source")
+			for _, name := range names {
+				table.Append(name, cmd.Flags().Lookup(name).Value.String(), sources[name])
+			}
+			if err := ctx.Output().Emit(table); err != nil {
+				fmt.Fprintln(ctx.Errout(), err)
+			}
+		},
+	}
+}
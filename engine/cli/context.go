@@ -26,6 +26,8 @@ func ContextWithIO(parent context.Context, in io.Reader, out io.Writer, err io.W
 type iocontext struct {
 	out, err io.Writer
 	in       io.Reader
+	// format is the Format of Output selected for the command
+	format Format
 }
 
 func (c *iocontext) Write(p []byte) (n int, err error) {
@@ -0,0 +1,242 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// docName returns the name of the command for doc files, its path joined
+// by sep.
+func docName(c *Command, sep string) string {
+	return strings.ReplaceAll(c.CommandPath(), " ", sep)
+}
+
+// docCommands returns the command and its subcommands shown in help,
+// depth first.
+func docCommands(c *Command) []*Command {
+	cmds := []*Command{c}
+	for _, sub := range (&CommandHelp{c}).Commands() {
+		if sub.Available() {
+			cmds = append(cmds, docCommands(sub.Command)...)
+		}
+	}
+	return cmds
+}
+
+// GenMarkdown writes a markdown reference page for the command, linking
+// to the pages of its parent and subcommands written by GenMarkdownTree.
+func GenMarkdown(c *Command, w io.Writer) error {
+	h := &CommandHelp{c}
+	h.mergePersistentFlags()
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "## %s\n\n", c.CommandPath())
+	if c.Short != "" {
+		fmt.Fprintf(&b, "%s\n\n", c.Short)
+	}
+	if c.Long != "" {
+		fmt.Fprintf(&b, "### Synopsis\n\n%s\n\n", strings.TrimSpace(c.Long))
+	}
+	if h.Runnable() {
+		fmt.Fprintf(&b, "```\n%s\n```\n\n", c.UseLine())
+	}
+	if len(c.Aliases) > 0 {
+		fmt.Fprintf(&b, "### Aliases\n\n%s\n\n", h.NameAndAliases())
+	}
+	if h.HasArgs() {
+		fmt.Fprintf(&b, "### Arguments\n\n```\n%s```\n\n", h.ArgUsages())
+	}
+	if h.HasExample() {
+		fmt.Fprintf(&b, "### Examples\n\n```\n%s\n```\n\n", strings.TrimRight(c.Example, "\n"))
+	}
+	for _, g := range h.FlagGroups() {
+		title := "Options"
+		switch g.Name {
+		case "Flags":
+		case "Global Flags":
+			title = "Options inherited from parent commands"
+		default:
+			title = strings.TrimSuffix(g.Name, " Flags") + " options"
+		}
+		fmt.Fprintf(&b, "### %s\n\n```\n%s```\n\n", title, g.Usages)
+	}
+
+	var seeAlso []string
+	if c.parent != nil {
+		seeAlso = append(seeAlso, fmt.Sprintf("* [%s](%s.md)\t - %s", c.parent.CommandPath(), docName(c.parent, "_"), c.parent.Short))
+	}
+	for _, sub := range h.Commands() {
+		if sub.Available() {
+			seeAlso = append(seeAlso, fmt.Sprintf("* [%s](%s.md)\t - %s", sub.CommandPath(), docName(sub.Command, "_"), sub.Short))
+		}
+	}
+	if len(seeAlso) > 0 {
+		fmt.Fprintf(&b, "### See also\n\n%s\n\n", strings.Join(seeAlso, "\n"))
+	}
+
+	_, err := w.Write(bytes.TrimRight(b.Bytes(), "\n"))
+	if err == nil {
+		_, err = io.WriteString(w, "\n")
+	}
+	return err
+}
+
+// GenMarkdownTree writes a markdown page with GenMarkdown to dir for the
+// command and each of its subcommands shown in help, named by their paths
+// joined by underscores, such as "tool_remote_add.md".
+func GenMarkdownTree(c *Command, dir string) error {
+	for _, cmd := range docCommands(c) {
+		var b bytes.Buffer
+		if err := GenMarkdown(cmd, &b); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, docName(cmd, "_")+".md"), b.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ManHeader is the header of man pages written by GenMan.
+type ManHeader struct {
+	// Section is the section of the manual, "1" if empty.
+	Section string
+	// Date is shown in the footer, such as "Jan 2006". It is left out if
+	// empty, so pages can be reproduced.
+	Date string
+	// Source is the project the command is from, such as "Tool 1.0".
+	Source string
+	// Manual is the title of the manual, such as "Tool Manual".
+	Manual string
+}
+
+// GenMan writes a man page in roff for the command, named by its path
+// joined by dashes, such as "tool-remote-add".
+func GenMan(c *Command, header ManHeader, w io.Writer) error {
+	h := &CommandHelp{c}
+	h.mergePersistentFlags()
+	if header.Section == "" {
+		header.Section = "1"
+	}
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, ".TH \"%s\" \"%s\" \"%s\" \"%s\" \"%s\"\n", strings.ToUpper(docName(c, "-")), header.Section, header.Date, header.Source, header.Manual)
+	fmt.Fprintf(&b, ".SH NAME\n%s", roff(docName(c, "-")))
+	if c.Short != "" {
+		fmt.Fprintf(&b, " \\- %s", roff(c.Short))
+	}
+	b.WriteString("\n")
+
+	b.WriteString(".SH SYNOPSIS\n")
+	if h.Runnable() {
+		fmt.Fprintf(&b, ".B %s\n", roff(c.UseLine()))
+	}
+	if h.HasSubCommands() {
+		fmt.Fprintf(&b, ".B %s\n[command]\n", roff(c.CommandPath()))
+	}
+
+	if desc := strings.TrimSpace(c.Long); desc != "" || c.Short != "" {
+		if desc == "" {
+			desc = c.Short
+		}
+		fmt.Fprintf(&b, ".SH DESCRIPTION\n%s\n", roffText(desc))
+	}
+
+	if h.HasArgs() {
+		b.WriteString(".SH ARGUMENTS\n")
+		for _, a := range c.posArgs {
+			fmt.Fprintf(&b, ".TP\n.I %s\n%s\n", roff(a.name), roffText(a.usage))
+		}
+	}
+
+	manFlags := func(title string, include func(f *flag.Flag) bool) {
+		var fb bytes.Buffer
+		c.Flags().VisitAll(func(f *flag.Flag) {
+			if !include(f) {
+				return
+			}
+			name, usage := flag.UnquoteUsage(f)
+			fmt.Fprintf(&fb, ".TP\n\\fB\\-%s\\fP", roff(f.Name))
+			if name != "" {
+				fmt.Fprintf(&fb, " \\fI%s\\fP", roff(name))
+			}
+			fb.WriteString("\n" + roff(usage))
+			if !isZeroValue(f, f.DefValue) {
+				fmt.Fprintf(&fb, " (default %s)", roff(f.DefValue))
+			}
+			fb.WriteString("\n")
+		})
+		if fb.Len() > 0 {
+			fmt.Fprintf(&b, ".SH %s\n", title)
+			b.Write(fb.Bytes())
+		}
+	}
+	manFlags("OPTIONS", func(f *flag.Flag) bool {
+		return c.inherited[f.Name] == nil
+	})
+	manFlags("OPTIONS INHERITED FROM PARENT COMMANDS", func(f *flag.Flag) bool {
+		return c.inherited[f.Name] != nil
+	})
+
+	if h.HasExample() {
+		fmt.Fprintf(&b, ".SH EXAMPLES\n.PP\n.nf\n%s\n.fi\n", roffText(strings.TrimRight(c.Example, "\n")))
+	}
+
+	var seeAlso []string
+	if c.parent != nil {
+		seeAlso = append(seeAlso, fmt.Sprintf("\\fB%s\\fP(%s)", roff(docName(c.parent, "-")), header.Section))
+	}
+	for _, sub := range h.Commands() {
+		if sub.Available() {
+			seeAlso = append(seeAlso, fmt.Sprintf("\\fB%s\\fP(%s)", roff(docName(sub.Command, "-")), header.Section))
+		}
+	}
+	if len(seeAlso) > 0 {
+		fmt.Fprintf(&b, ".SH SEE ALSO\n%s\n", strings.Join(seeAlso, ", "))
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// GenManTree writes a man page with GenMan to dir for the command and each
+// of its subcommands shown in help, in files named by their names and the
+// section, such as "tool-remote-add.1".
+func GenManTree(c *Command, header ManHeader, dir string) error {
+	if header.Section == "" {
+		header.Section = "1"
+	}
+	for _, cmd := range docCommands(c) {
+		var b bytes.Buffer
+		if err := GenMan(cmd, header, &b); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, docName(cmd, "-")+"."+header.Section), b.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roff escapes s for use within a line of roff.
+func roff(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	return strings.ReplaceAll(s, "-", `\-`)
+}
+
+// roffText escapes the lines of s for roff, so lines starting with a
+// control character are not read as requests.
+func roffText(s string) string {
+	lines := strings.Split(roff(s), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// FlagsFrom adds flags to the command for the fields of the struct v points to,
+// so they are set when the command is executed. Fields are declared as flags
+// with tags:
+//
+//	type Options struct {
+//		Addr    string        `flag:"addr" usage:"address to listen on" default:":8080" group:"Network"`
+//		Verbose bool          `flag:"v" usage:"verbose output" env:"APP_VERBOSE"`
+//		Timeout time.Duration `flag:"timeout" default:"10s"`
+//	}
+//
+// A flag not given in the arguments is set from the environment variable named
+// by env if it is set, and otherwise keeps its default. A group tag shows the
+// flag under that group in help, as with GroupFlags. Fields can be strings,
+// bools, ints, uints, floats, time.Durations, string slices, which are set by
+// repeating the flag or separating values with commas, or types implementing
+// flag.Value through a pointer. A flag tag without a name uses the lowercase
+// field name. Fields without a flag tag are skipped, except embedded structs
+// whose fields are added. FlagsFrom panics if v is not a pointer to a struct,
+// or a tagged field has an unsupported type or invalid default.
+func (c *Command) FlagsFrom(v any) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		panic("cli: FlagsFrom needs a pointer to a struct")
+	}
+	c.flagsFrom(rv.Elem())
+}
+
+func (c *Command) flagsFrom(rv reflect.Value) {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := field.Tag.Lookup("flag")
+		if !ok {
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				c.flagsFrom(rv.Field(i))
+			}
+			continue
+		}
+		if !field.IsExported() {
+			panic(fmt.Sprintf("cli: flag field %s is not exported", field.Name))
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		usage := field.Tag.Get("usage")
+		value, ok := flagValue(rv.Field(i))
+		if !ok {
+			panic(fmt.Sprintf("cli: flag field %s has unsupported type %s", field.Name, field.Type))
+		}
+		c.Flags().Var(value, name, usage)
+		if def, ok := field.Tag.Lookup("default"); ok {
+			if err := value.Set(def); err != nil {
+				panic(fmt.Sprintf("cli: flag field %s has invalid default: %v", field.Name, err))
+			}
+			c.Flags().Lookup(name).DefValue = value.String()
+			if sv, ok := value.(*stringsValue); ok {
+				// values given later replace the default
+				sv.set = false
+			}
+		}
+		if group := field.Tag.Get("group"); group != "" {
+			c.GroupFlags(group, name)
+		}
+		if env := field.Tag.Get("env"); env != "" {
+			if c.flagEnvs == nil {
+				c.flagEnvs = make(map[string]string)
+			}
+			c.flagEnvs[name] = env
+		}
+	}
+}
+
+// flagValue returns a flag.Value setting the field, or false if the
+// type of the field is not supported.
+func flagValue(field reflect.Value) (flag.Value, bool) {
+	if v, ok := field.Addr().Interface().(flag.Value); ok {
+		return v, true
+	}
+	// a throwaway FlagSet makes the flag.Values of the standard types
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	switch field.Kind() {
+	case reflect.Int64:
+		if field.Type() == durationType {
+			fs.DurationVar(fieldPtr[time.Duration](field), "v", time.Duration(field.Int()), "")
+		} else {
+			fs.Int64Var(fieldPtr[int64](field), "v", field.Int(), "")
+		}
+	case reflect.String:
+		fs.StringVar(fieldPtr[string](field), "v", field.String(), "")
+	case reflect.Bool:
+		fs.BoolVar(fieldPtr[bool](field), "v", field.Bool(), "")
+	case reflect.Int:
+		fs.IntVar(fieldPtr[int](field), "v", int(field.Int()), "")
+	case reflect.Uint:
+		fs.UintVar(fieldPtr[uint](field), "v", uint(field.Uint()), "")
+	case reflect.Uint64:
+		fs.Uint64Var(fieldPtr[uint64](field), "v", field.Uint(), "")
+	case reflect.Float64:
+		fs.Float64Var(fieldPtr[float64](field), "v", field.Float(), "")
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return nil, false
+		}
+		return &stringsValue{v: field}, true
+	default:
+		return nil, false
+	}
+	return fs.Lookup("v").Value, true
+}
+
+// fieldPtr returns a pointer to the field, which has a type with T as its
+// underlying type.
+func fieldPtr[T any](field reflect.Value) *T {
+	return field.Addr().Convert(reflect.TypeOf((*T)(nil))).Interface().(*T)
+}
+
+// stringsValue is a flag.Value for a string slice, appending the comma
+// separated values each time the flag is set.
+type stringsValue struct {
+	v   reflect.Value
+	set bool
+}
+
+func (s *stringsValue) String() string {
+	if s == nil || !s.v.IsValid() {
+		return ""
+	}
+	values := make([]string, s.v.Len())
+	for i := range values {
+		values[i] = s.v.Index(i).String()
+	}
+	return strings.Join(values, ",")
+}
+
+func (s *stringsValue) Set(v string) error {
+	// the first value given replaces the default
+	if !s.set {
+		s.v.SetLen(0)
+		s.set = true
+	}
+	for _, v := range strings.Split(v, ",") {
+		s.v.Set(reflect.Append(s.v, reflect.ValueOf(v).Convert(s.v.Type().Elem())))
+	}
+	return nil
+}
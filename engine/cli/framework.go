@@ -62,8 +62,10 @@ func (f *Framework) Run(ctx context.Context) error {
 }
 
 // Execute takes a root Command plus arguments, finds the Command to run,
-// parses flags, checks for expected arguments, and runs the Command.
-// It also adds a version flag if the root Command has Version set.
+// parses flags, checks for expected arguments, and runs the Command with
+// the hooks and middleware of it and its parents.
+// It also adds a version flag if the root Command has Version set, and
+// sets flags not given as arguments as declared by its Config.
 func Execute(ctx context.Context, root *Command, args []string) error {
 	var (
 		stdout io.Writer = os.Stdout
@@ -73,25 +75,73 @@ func Execute(ctx context.Context, root *Command, args []string) error {
 	if c, ok := ctx.(*Context); ok {
 		stdout = c
 		stderr = c
+		if c.Errout() != nil {
+			stderr = c.Errout()
+		}
 		ioctx = c
 	} else {
 		ioctx = ContextWithIO(ctx, os.Stdin, stdout, stderr)
 	}
 
+	if os.Getenv(PluginMetadataEnv) == "1" {
+		return writePluginMetadata(ioctx, root)
+	}
+
 	var showVersion bool
 	if root.Version != "" {
 		root.Flags().BoolVar(&showVersion, "v", false, "show version")
 	}
+	if root.Config != nil && root.findSub("config") == nil {
+		root.AddCommand(configCommand(root))
+	}
+	if len(root.commands) > 0 && root.findSub("help") == nil {
+		root.AddCommand(helpCommand(root))
+	}
 
-	cmd, n := root.Find(args)
-	f := cmd.Flags()
-	if f != nil {
-		if err := f.Parse(args[n:]); err != nil {
+	// flags of parents can be given before the names of subcommands, so
+	// the arguments are parsed by each command down to the one to run
+	var (
+		cmd   = root
+		rest  = args
+		given = make(map[string]bool)
+	)
+	for {
+		cmd.mergePersistentFlags()
+		f := cmd.Flags()
+		if err := f.Parse(rest); err != nil {
 			if err == flag.ErrHelp {
 				return (&CommandHelp{cmd}).WriteHelp(stderr)
 			}
 			return err
 		}
+		f.Visit(func(f *flag.Flag) {
+			given[f.Name] = true
+		})
+		rest = f.Args()
+		if len(rest) == 0 {
+			break
+		}
+		sub := cmd.findSub(rest[0])
+		if sub == nil && cmd == root && root.Plugins != nil {
+			if path, ok := root.Plugins.lookup(root, rest[0]); ok {
+				return runPlugin(ioctx, path, rest[1:])
+			}
+		}
+		if sub == nil {
+			break
+		}
+		cmd, rest = sub, rest[1:]
+	}
+
+	var file *configFile
+	if root.Config != nil {
+		var err error
+		if file, err = loadConfigFile(root.Config.File); err != nil {
+			return err
+		}
+	}
+	if _, err := cmd.resolveFlags(root.Config, file, given); err != nil {
+		return err
 	}
 
 	if showVersion {
@@ -100,7 +150,7 @@ func Execute(ctx context.Context, root *Command, args []string) error {
 	}
 
 	if cmd.Args != nil {
-		if err := cmd.Args(cmd, f.Args()); err != nil {
+		if err := cmd.Args(cmd, rest); err != nil {
 			return err
 		}
 	}
@@ -110,8 +160,20 @@ func Execute(ctx context.Context, root *Command, args []string) error {
 		return nil
 	}
 
-	cmd.Run(ioctx, f.Args())
-	return nil
+	if format := cmd.outputFormatOf(); format != ioctx.format {
+		ioctx = &Context{Context: ioctx.Context, iocontext: &iocontext{
+			in:     ioctx.in,
+			out:    ioctx.out,
+			err:    ioctx.err,
+			format: format,
+		}}
+	}
+
+	if cmd.Deprecated != "" {
+		fmt.Fprintf(stderr, "Command %q is deprecated, %s\n", cmd.Name(), cmd.Deprecated)
+	}
+
+	return cmd.run(ioctx, rest)
 }
 
 // Export wraps a function as a command.
@@ -4,12 +4,18 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"text/template"
 	"unicode"
 )
 
+// HelpWidth is the width help is wrapped to. If zero, the COLUMNS
+// environment variable is used if set, or else 80.
+var HelpWidth = 0
+
 // HelpFuncs are used by the help templating system.
 var HelpFuncs = template.FuncMap{
 	"trim": strings.TrimSpace,
@@ -20,28 +26,101 @@ var HelpFuncs = template.FuncMap{
 		template := fmt.Sprintf("%%-%ds", padding)
 		return fmt.Sprintf(template, s)
 	},
+	"wrap": func(indent int, s string) string {
+		return wrap(s, indent, helpWidth())
+	},
+	"indent": func(indent int, s string) string {
+		pad := strings.Repeat(" ", indent)
+		return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+	},
+	"add": func(a, b int) int {
+		return a + b
+	},
 }
 
 // HelpTemplate is a template used to generate help.
-var HelpTemplate = `Usage:{{if .Runnable}}
-{{.UseLine}}{{end}}{{if .HasSubCommands}}
-{{.CommandPath}} [command]{{end}}{{if gt (len .Aliases) 0}}
+var HelpTemplate = `{{with (or .Long .Short)}}{{wrap 0 . | trimRight}}
+
+{{end}}Usage:{{if .Runnable}}
+  {{.UseLine}}{{end}}{{if .HasSubCommands}}
+  {{.CommandPath}} [command]{{end}}{{if gt (len .Aliases) 0}}
 
 Aliases:
-{{.NameAndAliases}}{{end}}{{if .HasExample}}
+  {{.NameAndAliases}}{{end}}{{if .HasExample}}
 
 Examples:
-{{.Example}}{{end}}{{if .HasSubCommands}}
+{{.Example | trimRight | indent 2}}{{end}}{{if .HasSubCommands}}
+
+Available Commands:{{range .Commands}}{{if .Available}}
+  {{padRight .Name .NamePadding }} {{wrap (add .NamePadding 3) .Short | trim}}{{end}}{{end}}{{end}}{{if .HasArgs}}
 
-Available Commands:{{range .Commands}}{{if (or .Available (eq .Name "help"))}}
-{{padRight .Name .NamePadding }} {{.Short}}{{end}}{{end}}{{end}}{{if .HasFlags}}
+Arguments:
+{{.ArgUsages | trimRight}}{{end}}{{range .FlagGroups}}
 
-Flags:
-{{.FlagUsages | trimRight }}{{end}}{{if .HasSubCommands}}
+{{.Name}}:
+{{.Usages | trimRight}}{{end}}{{if .HasSubCommands}}
 
 Use "{{.CommandPath}} [command] -help" for more information about a command.{{end}}
 `
 
+// helpWidth returns the width help is wrapped to.
+func helpWidth() int {
+	if HelpWidth > 0 {
+		return HelpWidth
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// wrap word wraps each line of s to width, indenting the lines it adds by
+// indent. Lines starting with a space or tab are preformatted and left alone.
+func wrap(s string, indent, width int) string {
+	pad := strings.Repeat(" ", indent)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		var sb strings.Builder
+		col := indent
+		for j, word := range strings.Fields(line) {
+			if j > 0 {
+				if col+1+len(word) > width {
+					sb.WriteString("\n" + pad)
+					col = indent
+				} else {
+					sb.WriteString(" ")
+					col++
+				}
+			}
+			sb.WriteString(word)
+			col += len(word)
+		}
+		lines[i] = sb.String()
+	}
+	return strings.Join(lines, "\n"+pad)
+}
+
+// helpCommand returns the built-in help command, which shows help for a
+// command of root.
+func helpCommand(root *Command) *Command {
+	return &Command{
+		Usage: "help [command...]",
+		Short: "Help about any command",
+		Long:  "Help provides help for any command in the application.",
+		Run: func(ctx *Context, args []string) {
+			cmd, n := root.Find(args)
+			if n < len(args) {
+				fmt.Fprintf(ctx.Errout(), "Unknown help topic: %s\n", strings.Join(args, " "))
+				return
+			}
+			(&CommandHelp{cmd}).WriteHelp(ctx)
+		},
+	}
+}
+
 // CommandHelp wraps a Command to generate help.
 type CommandHelp struct {
 	*Command
@@ -49,6 +128,7 @@ type CommandHelp struct {
 
 // WriteHelp generates help for the command written to an io.Writer.
 func (c *CommandHelp) WriteHelp(w io.Writer) error {
+	c.mergePersistentFlags()
 	t := template.Must(template.New("help").Funcs(HelpFuncs).Parse(HelpTemplate))
 	return t.Execute(w, c)
 }
@@ -58,9 +138,10 @@ func (c *CommandHelp) Runnable() bool {
 	return c.Run != nil
 }
 
-// Available determines if a command is available as a non-help command (this includes all non hidden commands).
+// Available determines if a command is available as a non-help command (this includes all non hidden
+// and non deprecated commands).
 func (c *CommandHelp) Available() bool {
-	if c.Hidden {
+	if c.Hidden || c.Deprecated != "" {
 		return false
 	}
 	if c.Runnable() || c.HasSubCommands() {
@@ -72,8 +153,8 @@ func (c *CommandHelp) Available() bool {
 // HasSubCommands determines if a command has available sub commands that need to be
 // shown in the usage/help default template under 'available commands'.
 func (c *CommandHelp) HasSubCommands() bool {
-	for _, sub := range c.commands {
-		if (&CommandHelp{sub}).Available() {
+	for _, sub := range c.Commands() {
+		if sub.Available() {
 			return true
 		}
 	}
@@ -90,59 +171,157 @@ func (c *CommandHelp) HasExample() bool {
 	return len(c.Example) > 0
 }
 
-// Commands returns any subcommands as CommandHelp values.
+// Commands returns any subcommands as CommandHelp values, followed by any
+// plugins found for the root.
 func (c *CommandHelp) Commands() (cmds []*CommandHelp) {
 	for _, cmd := range c.commands {
 		cmds = append(cmds, &CommandHelp{cmd})
 	}
+	for _, cmd := range c.pluginCommands() {
+		cmds = append(cmds, &CommandHelp{cmd})
+	}
 	return
 }
 
-// NamePadding returns padding for the name.
+// NamePadding returns padding for the name, fitting the longest name of the
+// available sibling commands.
 func (c *CommandHelp) NamePadding() int {
-	// TODO: consider making this dynamic, based on length of all sibling commands
-	return 16
+	padding := 11
+	if c.parent == nil {
+		return padding
+	}
+	for _, sib := range (&CommandHelp{c.parent}).Commands() {
+		if sib.Available() && len(sib.Name()) > padding {
+			padding = len(sib.Name())
+		}
+	}
+	return padding
+}
+
+// HasArgs checks if the command declares positional arguments with ArgsFrom.
+func (c *CommandHelp) HasArgs() bool {
+	return len(c.posArgs) > 0
 }
 
-// HasFlags checks if the command contains flags.
+// ArgUsages creates a string for usage help of the positional arguments.
+func (c *CommandHelp) ArgUsages() string {
+	var sb strings.Builder
+	padding := 0
+	for _, a := range c.posArgs {
+		padding = max(padding, len(a.name)+2)
+	}
+	for _, a := range c.posArgs {
+		usage := a.usage
+		if len(a.choices) > 0 {
+			usage += fmt.Sprintf(" (one of %s)", strings.Join(a.choices, ", "))
+		}
+		if a.hasDef {
+			usage += fmt.Sprintf(" (default %q)", a.def)
+		} else if a.optional {
+			usage += " (optional)"
+		}
+		line := fmt.Sprintf("  %-*s  %s", padding, "<"+a.name+">", wrap(strings.TrimSpace(usage), padding+4, helpWidth()))
+		sb.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return sb.String()
+}
+
+// HasFlags checks if the command contains flags, not counting inherited flags.
 func (c *CommandHelp) HasFlags() bool {
 	n := 0
 	c.Flags().VisitAll(func(f *flag.Flag) {
-		n++
+		if c.inherited[f.Name] == nil {
+			n++
+		}
 	})
 	return n > 0
 }
 
-// FlagUsages creates a string for flag usage help.
+// HasInheritedFlags checks if the command inherits persistent flags from its parents.
+func (c *CommandHelp) HasInheritedFlags() bool {
+	return len(c.inherited) > 0
+}
+
+// FlagUsages creates a string for flag usage help, not including inherited flags.
 func (c *CommandHelp) FlagUsages() string {
+	return c.flagUsages(func(f *flag.Flag) bool {
+		return c.inherited[f.Name] == nil
+	})
+}
+
+// InheritedFlagUsages creates a string for usage help of the flags inherited
+// from parents.
+func (c *CommandHelp) InheritedFlagUsages() string {
+	return c.flagUsages(func(f *flag.Flag) bool {
+		return c.inherited[f.Name] != nil
+	})
+}
+
+// FlagGroup is a titled group of flags shown in help.
+type FlagGroup struct {
+	Name   string
+	Usages string
+}
+
+// FlagGroups returns the flags of the command grouped for help: flags not in
+// a group declared with GroupFlags, then each group in the order declared,
+// then the flags inherited from parents. Empty groups are left out.
+func (c *CommandHelp) FlagGroups() []FlagGroup {
+	var groups []FlagGroup
+	add := func(name string, include func(f *flag.Flag) bool) {
+		if usages := c.flagUsages(include); usages != "" {
+			groups = append(groups, FlagGroup{Name: name, Usages: usages})
+		}
+	}
+	add("Flags", func(f *flag.Flag) bool {
+		return c.inherited[f.Name] == nil && c.flagGroups[f.Name] == ""
+	})
+	for _, group := range c.flagGroupOrder {
+		group := group
+		add(group+" Flags", func(f *flag.Flag) bool {
+			return c.inherited[f.Name] == nil && c.flagGroups[f.Name] == group
+		})
+	}
+	add("Global Flags", func(f *flag.Flag) bool {
+		return c.inherited[f.Name] != nil
+	})
+	return groups
+}
+
+// flagUsages creates a string for usage help of the flags include returns true for.
+func (c *CommandHelp) flagUsages(include func(f *flag.Flag) bool) string {
 	var sb strings.Builder
 	c.Flags().VisitAll(func(f *flag.Flag) {
-		fmt.Fprintf(&sb, "  -%s", f.Name) // Two spaces before -; see next two comments.
+		if !include(f) {
+			return
+		}
+		line := fmt.Sprintf("  -%s", f.Name) // Two spaces before -; see next two comments.
 		name, usage := flag.UnquoteUsage(f)
 		if len(name) > 0 {
-			sb.WriteString(" ")
-			sb.WriteString(name)
+			line += " " + name
 		}
 		// Boolean flags of one ASCII letter are so common we
 		// treat them specially, putting their usage on the same line.
-		if sb.Len() <= 4 { // space, space, '-', 'x'.
-			sb.WriteString("\t")
+		if len(line) <= 4 { // space, space, '-', 'x'.
+			line += "\t"
 		} else {
 			// Four spaces before the tab triggers good alignment
 			// for both 4- and 8-space tab stops.
-			sb.WriteString("\n    \t")
+			line += "\n    \t"
 		}
-		sb.WriteString(strings.ReplaceAll(usage, "\n", "\n    \t"))
-		f.Usage = ""
+		sb.WriteString(line)
 		if !isZeroValue(f, f.DefValue) {
-			typ, _ := flag.UnquoteUsage(f)
+			typ, _ := flag.UnquoteUsage(&flag.Flag{Value: f.Value})
 			if typ == "string" {
 				// put quotes on the value
-				fmt.Fprintf(&sb, " (default %q)", f.DefValue)
+				usage += fmt.Sprintf(" (default %q)", f.DefValue)
 			} else {
-				fmt.Fprintf(&sb, " (default %v)", f.DefValue)
+				usage += fmt.Sprintf(" (default %v)", f.DefValue)
 			}
 		}
+		// the usage starts at the tab stop at column 8
+		usage = wrap(strings.TrimSpace(usage), 8, helpWidth())
+		sb.WriteString(strings.ReplaceAll(usage, "\n        ", "\n    \t"))
 		sb.WriteString("\n")
 	})
 	return sb.String()
@@ -0,0 +1,56 @@
+package cli
+
+import "context"
+
+// RunFunc is the function that performs a command, as in Command.Run.
+type RunFunc func(ctx *Context, args []string)
+
+// Middleware wraps the Run of a command, such as to add values to its
+// Context or observe it. It should call next to run the command.
+type Middleware func(next RunFunc) RunFunc
+
+// run runs the command with the hooks and middleware of it and its parents.
+// The Before hooks run from the root down, stopping at the first error, then
+// Run wrapped by the middleware with the root outermost, then the After hooks
+// of the commands whose Before hooks ran, from the command up. It returns the
+// first error of a hook.
+func (c *Command) run(ctx *Context, args []string) (err error) {
+	var path []*Command
+	for cmd := c; cmd != nil; cmd = cmd.parent {
+		path = append([]*Command{cmd}, path...)
+	}
+
+	ran := 0
+	defer func() {
+		for i := ran - 1; i >= 0; i-- {
+			if after := path[i].After; after != nil {
+				if e := after(ctx, args); err == nil {
+					err = e
+				}
+			}
+		}
+	}()
+	for _, cmd := range path {
+		if cmd.Before != nil {
+			if err := cmd.Before(ctx, args); err != nil {
+				return err
+			}
+		}
+		ran++
+	}
+
+	run := RunFunc(c.Run)
+	for i := len(path) - 1; i >= 0; i-- {
+		for j := len(path[i].Middleware) - 1; j >= 0; j-- {
+			run = path[i].Middleware[j](run)
+		}
+	}
+	run(ctx, args)
+	return nil
+}
+
+// WithValue returns a copy of the Context with the same IO in which the value
+// associated with key is val, as with context.WithValue.
+func (c *Context) WithValue(key, val any) *Context {
+	return &Context{Context: context.WithValue(c.Context, key, val), iocontext: c.iocontext}
+}
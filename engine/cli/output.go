@@ -0,0 +1,225 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+)
+
+// Format is a format of command output.
+type Format string
+
+const (
+	// Text is output for people, such as tables and lines of text.
+	Text Format = "text"
+	// JSON is output as indented JSON documents.
+	JSON Format = "json"
+	// NDJSON is output as newline delimited JSON, one line for each value
+	// or each element of a slice or table.
+	NDJSON Format = "ndjson"
+)
+
+func (f *Format) String() string {
+	if f == nil || *f == "" {
+		return string(Text)
+	}
+	return string(*f)
+}
+
+func (f *Format) Set(s string) error {
+	switch Format(s) {
+	case Text, JSON, NDJSON:
+		*f = Format(s)
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, expected text, json or ndjson", s)
+}
+
+// jsonFlag is a boolean flag selecting JSON output.
+type jsonFlag struct {
+	f *Format
+}
+
+func (j jsonFlag) IsBoolFlag() bool { return true }
+
+func (j jsonFlag) String() string {
+	return fmt.Sprint(j.f != nil && *j.f == JSON)
+}
+
+func (j jsonFlag) Set(s string) error {
+	switch s {
+	case "true":
+		*j.f = JSON
+	case "false":
+		*j.f = Text
+	default:
+		return fmt.Errorf("invalid boolean %q", s)
+	}
+	return nil
+}
+
+// OutputFlags adds the persistent flags -output and its shorthand -json to
+// the command, selecting the Format of the Output of it and its subcommands.
+func (c *Command) OutputFlags() {
+	c.outputFormat = new(Format)
+	*c.outputFormat = Text
+	c.PersistentFlags().Var(c.outputFormat, "output", "output `format`: text, json or ndjson")
+	c.PersistentFlags().Var(jsonFlag{c.outputFormat}, "json", "output JSON, same as -output json")
+}
+
+// outputFormatOf returns the Format selected for the command by the output
+// flags of it or its parents, or "" if there are none.
+func (c *Command) outputFormatOf() Format {
+	for cmd := c; cmd != nil; cmd = cmd.parent {
+		if cmd.outputFormat != nil {
+			return *cmd.outputFormat
+		}
+	}
+	return ""
+}
+
+// Output writes values in a Format, so commands can emit output for people
+// or programs with the same code.
+type Output struct {
+	w      io.Writer
+	format Format
+}
+
+// NewOutput returns an Output writing to w in format.
+func NewOutput(w io.Writer, format Format) *Output {
+	if format == "" {
+		format = Text
+	}
+	return &Output{w: w, format: format}
+}
+
+// Output returns an Output writing to the standard output of the Context in
+// the Format selected by the output flags of the command being run.
+func (c *Context) Output() *Output {
+	return NewOutput(c, c.format)
+}
+
+// Format returns the Format of the output.
+func (o *Output) Format() Format {
+	return o.format
+}
+
+// Emit writes v in the format of the output. As text, a *Table is written
+// with aligned columns, slices are written an element per line, and other
+// values are written with fmt.Println. As NDJSON, tables and slices are
+// written a row or element per line.
+func (o *Output) Emit(v any) error {
+	switch o.format {
+	case JSON:
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case NDJSON:
+		enc := json.NewEncoder(o.w)
+		if t, ok := v.(*Table); ok {
+			for _, row := range t.records() {
+				if err := enc.Encode(row); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+			for i := 0; i < rv.Len(); i++ {
+				if err := enc.Encode(rv.Index(i).Interface()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return enc.Encode(v)
+	default:
+		if t, ok := v.(*Table); ok {
+			_, err := t.WriteTo(o.w)
+			return err
+		}
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < rv.Len(); i++ {
+				if _, err := fmt.Fprintln(o.w, rv.Index(i).Interface()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		_, err := fmt.Fprintln(o.w, v)
+		return err
+	}
+}
+
+// Table is tabular output, written as aligned columns with a header as text
+// and as a list of objects keyed by the header as JSON.
+type Table struct {
+	Header []string
+	Rows   [][]any
+}
+
+// NewTable returns a Table with the header.
+func NewTable(header ...string) *Table {
+	return &Table{Header: header}
+}
+
+// Append adds a row of values for the columns of the header.
+func (t *Table) Append(values ...any) {
+	t.Rows = append(t.Rows, values)
+}
+
+// WriteTo writes the table as text with aligned columns, the header in
+// upper case.
+func (t *Table) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 4, 2, ' ', 0)
+	if len(t.Header) > 0 {
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(t.Header, "\t")))
+	}
+	for _, row := range t.Rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = fmt.Sprint(v)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	err := tw.Flush()
+	return cw.n, err
+}
+
+// MarshalJSON encodes the table as a list of objects keyed by the header.
+func (t *Table) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.records())
+}
+
+// records returns the rows as maps keyed by the header, where values
+// without a column are keyed by their index.
+func (t *Table) records() []map[string]any {
+	records := make([]map[string]any, 0, len(t.Rows))
+	for _, row := range t.Rows {
+		r := make(map[string]any, len(row))
+		for i, v := range row {
+			key := fmt.Sprint(i)
+			if i < len(t.Header) {
+				key = t.Header[i]
+			}
+			r[key] = v
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// PluginMetadataEnv is set to "1" in the environment of a plugin run to ask
+// for its PluginMetadata. Execute writes the metadata of its root command as
+// JSON to the standard output instead of running a command, so plugins built
+// with this package report it without extra code. Plugins are also given the
+// argument "-cli-plugin-metadata", so other programs are unlikely to take any
+// action when asked.
+const PluginMetadataEnv = "CLI_PLUGIN_METADATA"
+
+// pluginMetadataTimeout limits how long a plugin can take to report its metadata.
+const pluginMetadataTimeout = 2 * time.Second
+
+// PluginMetadata describes a plugin, shown in the help of the parent.
+type PluginMetadata struct {
+	Usage   string `json:"usage,omitempty"`
+	Short   string `json:"short,omitempty"`
+	Long    string `json:"long,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// Plugins configures external subcommands of the root command, such as git
+// or kubectl have: if no subcommand matches, "tool foo" runs an executable
+// named "tool-foo" with the remaining arguments and the IO of the Context.
+type Plugins struct {
+	// Prefix is the prefix of plugin executables, the name of the root
+	// followed by a dash if empty.
+	Prefix string
+
+	// Dirs are searched for plugins before the directories of PATH.
+	Dirs []string
+
+	// IgnorePath, if set, only searches Dirs for plugins.
+	IgnorePath bool
+}
+
+func (p *Plugins) prefix(root *Command) string {
+	if p.Prefix != "" {
+		return p.Prefix
+	}
+	return root.Name() + "-"
+}
+
+func (p *Plugins) dirs() []string {
+	dirs := append([]string{}, p.Dirs...)
+	if !p.IgnorePath {
+		dirs = append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+	}
+	return dirs
+}
+
+// lookup returns the path of the executable for the plugin name.
+func (p *Plugins) lookup(root *Command, name string) (string, bool) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, "-") {
+		return "", false
+	}
+	file := p.prefix(root) + name
+	for _, dir := range p.dirs() {
+		if path, ok := executable(filepath.Join(dir, file)); ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// list returns the names and paths of the plugins found, where plugins found
+// first shadow those of the same name found later.
+func (p *Plugins) list(root *Command) (names []string, paths map[string]string) {
+	paths = make(map[string]string)
+	prefix := p.prefix(root)
+	for _, dir := range p.dirs() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutPrefix(e.Name(), prefix)
+			if runtime.GOOS == "windows" {
+				name = strings.TrimSuffix(name, ".exe")
+			}
+			if !ok || name == "" || paths[name] != "" {
+				continue
+			}
+			if path, ok := executable(filepath.Join(dir, e.Name())); ok {
+				names = append(names, name)
+				paths[name] = path
+			}
+		}
+	}
+	return names, paths
+}
+
+// executable returns the path if it is an executable file, adding the .exe
+// extension on Windows.
+func executable(path string) (string, bool) {
+	if runtime.GOOS == "windows" && !strings.HasSuffix(path, ".exe") {
+		path += ".exe"
+	}
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", false
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0111 == 0 {
+		return "", false
+	}
+	return path, true
+}
+
+// runPlugin runs the plugin executable with the args and IO of ctx.
+func runPlugin(ctx *Context, path string, args []string) error {
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin = ctx.in
+	cmd.Stdout = ctx.out
+	cmd.Stderr = ctx.err
+	if cmd.Stderr == nil {
+		cmd.Stderr = ctx.out
+	}
+	return cmd.Run()
+}
+
+// queryPlugin asks the plugin executable for its metadata, returning empty
+// metadata if it doesn't report any.
+func queryPlugin(path string) PluginMetadata {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginMetadataTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, "-cli-plugin-metadata")
+	cmd.Env = append(os.Environ(), PluginMetadataEnv+"=1")
+	var md PluginMetadata
+	if out, err := cmd.Output(); err == nil {
+		json.Unmarshal(out, &md)
+	}
+	return md
+}
+
+// pluginCommands returns commands for the plugins of the root not shadowed by its
+// subcommands, described by their metadata, to be shown in help. They are
+// found once and kept, since each plugin is run to get its metadata.
+func (c *Command) pluginCommands() []*Command {
+	if c.Plugins == nil || c.parent != nil {
+		return nil
+	}
+	if c.plugins != nil {
+		return *c.plugins
+	}
+	names, paths := c.Plugins.list(c)
+	var cmds []*Command
+	for _, name := range names {
+		if c.findSub(name) != nil {
+			continue
+		}
+		path := paths[name]
+		md := queryPlugin(path)
+		usage := name
+		if _, args, ok := strings.Cut(md.Usage, " "); ok {
+			usage += " " + args
+		}
+		cmds = append(cmds, &Command{
+			Usage:   usage,
+			Short:   md.Short,
+			Long:    md.Long,
+			Version: md.Version,
+			Run: func(ctx *Context, args []string) {
+				runPlugin(ctx, path, args)
+			},
+			parent: c,
+		})
+	}
+	c.plugins = &cmds
+	return cmds
+}
+
+// writePluginMetadata writes the metadata of root as JSON.
+func writePluginMetadata(ctx *Context, root *Command) error {
+	return json.NewEncoder(ctx).Encode(PluginMetadata{
+		Usage:   root.Usage,
+		Short:   root.Short,
+		Long:    root.Long,
+		Version: root.Version,
+	})
+}
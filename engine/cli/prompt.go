@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The prompt helpers ask questions on the IO of a Context, writing to its
+// error output so the standard output of a command can still be piped. They
+// read answers a line at a time, so they work the same on a terminal and
+// with answers piped to the standard input. On a terminal, invalid answers
+// are asked again; otherwise they return an error. If the input ends before
+// an answer, the default is returned if there is one, or else io.EOF.
+
+// Input asks for a line of text, returning def if the answer is empty.
+func Input(ctx *Context, question, def string) (string, error) {
+	prompt := question + ": "
+	if def != "" {
+		prompt = fmt.Sprintf("%s [%s]: ", question, def)
+	}
+	for {
+		answer, err := ask(ctx, prompt)
+		if err == io.EOF && def != "" {
+			return def, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if answer != "" {
+			return answer, nil
+		}
+		if def != "" {
+			return def, nil
+		}
+		if err := reask(ctx, "an answer is required"); err != nil {
+			return "", err
+		}
+	}
+}
+
+// Confirm asks a yes or no question, returning def if the answer is empty.
+func Confirm(ctx *Context, question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer, err := ask(ctx, fmt.Sprintf("%s (%s): ", question, hint))
+		if err == io.EOF || (err == nil && answer == "") {
+			return def, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		if err := reask(ctx, "answer yes or no"); err != nil {
+			return false, err
+		}
+	}
+}
+
+// Select asks to choose one of the options by number, returning the index
+// of the chosen option, or def if the answer is empty. If def is negative,
+// there is no default.
+func Select(ctx *Context, question string, options []string, def int) (int, error) {
+	var defs []int
+	if def >= 0 {
+		defs = []int{def}
+	}
+	for {
+		choices, err := choose(ctx, question, options, defs, "Enter a number")
+		if err != nil {
+			return -1, err
+		}
+		if len(choices) == 1 {
+			return choices[0], nil
+		}
+		if err := reask(ctx, "choose one option"); err != nil {
+			return -1, err
+		}
+	}
+}
+
+// MultiSelect asks to choose any of the options by number, returning the
+// indexes of the chosen options in order, or defs if the answer is empty.
+// An answer of "none" chooses no options.
+func MultiSelect(ctx *Context, question string, options []string, defs []int) ([]int, error) {
+	return choose(ctx, question, options, defs, "Enter numbers separated by commas")
+}
+
+// choose lists the options and reads the numbers of the chosen options.
+func choose(ctx *Context, question string, options []string, defs []int, hint string) ([]int, error) {
+	promptf(ctx, "%s\n", question)
+	for i, opt := range options {
+		promptf(ctx, "  %d) %s\n", i+1, opt)
+	}
+	var def []string
+	for _, i := range defs {
+		def = append(def, strconv.Itoa(i+1))
+	}
+	for {
+		answer, err := Input(ctx, hint, strings.Join(def, ","))
+		if err != nil {
+			return nil, err
+		}
+		if answer == "none" {
+			return []int{}, nil
+		}
+		var choices []int
+		seen := make(map[int]bool)
+		for _, s := range strings.Split(answer, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || n < 1 || n > len(options) {
+				choices = nil
+				break
+			}
+			if !seen[n-1] {
+				seen[n-1] = true
+				choices = append(choices, n-1)
+			}
+		}
+		if choices != nil {
+			sort.Ints(choices)
+			return choices, nil
+		}
+		if err := reask(ctx, fmt.Sprintf("enter numbers from 1 to %d", len(options))); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Password asks for a secret without showing it as it is typed on a
+// terminal. The answer is returned as is, without trimming spaces.
+func Password(ctx *Context, question string) (string, error) {
+	promptf(ctx, "%s: ", question)
+	if f, ok := ctx.in.(*os.File); ok && isTerminal(f) {
+		restore, err := disableEcho(f)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			restore()
+			// the newline typed was not echoed
+			promptf(ctx, "\n")
+		}()
+	}
+	line, err := readLine(ctx)
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return line, err
+}
+
+// ask writes the prompt and reads the answer, returning io.EOF only if the
+// input ended before any answer.
+func ask(ctx *Context, prompt string) (string, error) {
+	promptf(ctx, "%s", prompt)
+	line, err := readLine(ctx)
+	line = strings.TrimSpace(line)
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return line, err
+}
+
+// promptf writes a prompt to the error output of the Context.
+func promptf(ctx *Context, format string, args ...any) {
+	w := ctx.Errout()
+	if w == nil {
+		w = ctx
+	}
+	fmt.Fprintf(w, format, args...)
+}
+
+// reask reports an invalid answer, returning an error instead if the answers
+// are not typed on a terminal, since asking again would not change them.
+func reask(ctx *Context, problem string) error {
+	if f, ok := ctx.in.(*os.File); !ok || !isTerminal(f) {
+		return fmt.Errorf("invalid answer: %s", problem)
+	}
+	promptf(ctx, "Invalid answer: %s.\n", problem)
+	return nil
+}
+
+// readLine reads a line from the input of the Context without the line
+// ending. It reads a byte at a time so no input after the line is consumed.
+func readLine(ctx *Context) (string, error) {
+	var sb strings.Builder
+	b := make([]byte, 1)
+	for {
+		n, err := ctx.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return strings.TrimSuffix(sb.String(), "\r"), nil
+			}
+			sb.WriteByte(b[0])
+		}
+		if err != nil {
+			return strings.TrimSuffix(sb.String(), "\r"), err
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func getTermios(f *os.File) (*syscall.Termios, error) {
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&t)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return &t, nil
+}
+
+func setTermios(f *os.File, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminal returns whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	_, err := getTermios(f)
+	return err == nil
+}
+
+// disableEcho stops the terminal f from echoing input until restore is called.
+func disableEcho(f *os.File) (restore func(), err error) {
+	t, err := getTermios(f)
+	if err != nil {
+		return nil, err
+	}
+	old := *t
+	t.Lflag &^= syscall.ECHO
+	t.Lflag |= syscall.ICANON | syscall.ISIG
+	if err := setTermios(f, t); err != nil {
+		return nil, err
+	}
+	return func() { setTermios(f, &old) }, nil
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+package cli
+
+import (
+	"os"
+	"os/exec"
+)
+
+// isTerminal returns whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// disableEcho stops the terminal f from echoing input until restore is called,
+// using stty since there is no portable way without it.
+func disableEcho(f *os.File) (restore func(), err error) {
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = f
+		return cmd.Run()
+	}
+	if err := stty("-echo"); err != nil {
+		return nil, err
+	}
+	return func() { stty("echo") }, nil
+}
@@ -0,0 +1,151 @@
+// Package remote executes commands of a cli command tree over duplex rpc, so
+// a client can forward the commands typed locally to a running daemon that
+// executes them with the same parsing as if they were run in its process.
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"tractor.dev/toolkit-go/duplex/rpc"
+	"tractor.dev/toolkit-go/engine/cli"
+)
+
+// Handler exposes the commands of a command tree as rpc selectors. A call to
+// the selector of a command, which is the Prefix followed by the names on its
+// path from the root, such as "cli.remote.add", executes it with the string
+// slice argument of the call as its arguments. The Prefix itself executes the
+// root with the arguments as given on a command line, which is what Forward
+// calls.
+//
+// The handler continues the call to stream the output of the command to the
+// caller, reading its standard input from the channel until the caller closes
+// it for writing. Commands run in the process of the handler, so they must not
+// exit it, such as with log.Fatal.
+type Handler struct {
+	// Prefix is the selector of the root command.
+	Prefix string
+
+	// Root returns a new command tree for each call, so calls can run
+	// concurrently without sharing the state of parsed flags.
+	Root func() *cli.Command
+}
+
+// Register handles the selectors of the commands on m.
+func (h *Handler) Register(m *rpc.RespondMux) {
+	m.Handle(h.Prefix, h)
+	m.Handle(h.Prefix+"/", h)
+}
+
+// frame is a value streamed to the caller: the output of the command on Fd 1
+// or 2, or finally, when Done, the error of executing it if there was one.
+type frame struct {
+	Fd   int
+	Data []byte
+	Done bool
+	Err  string
+}
+
+func (h *Handler) RespondRPC(r rpc.Responder, c *rpc.Call) {
+	var args []string
+	if err := c.Receive(&args); err != nil {
+		r.Return(err)
+		return
+	}
+	selector := strings.Trim(rpc.CleanSelector(c.Selector()), "/")
+	prefix := strings.Trim(rpc.CleanSelector(h.Prefix), "/")
+	rest, ok := strings.CutPrefix(selector, prefix)
+	if !ok || (rest != "" && rest[0] != '/') {
+		r.Return(fmt.Errorf("remote: selector %q is not below %q", c.Selector(), h.Prefix))
+		return
+	}
+	path := strings.FieldsFunc(rest, func(r rune) bool { return r == '/' })
+
+	ch, err := r.Continue()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	var mu sync.Mutex
+	send := func(f frame) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Send(f)
+	}
+	ctx := cli.ContextWithIO(c.Context, ch, &frameWriter{fd: 1, send: send}, &frameWriter{fd: 2, send: send})
+	done := frame{Done: true}
+	if err := cli.Execute(ctx, h.Root(), append(path, args...)); err != nil {
+		done.Err = err.Error()
+	}
+	if send(done) == nil {
+		r.CloseSend()
+	}
+}
+
+// frameWriter sends what is written to it as output frames for fd.
+type frameWriter struct {
+	fd   int
+	send func(frame) error
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	if err := w.send(frame{Fd: w.fd, Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Forward executes the command given by args on the remote side of caller,
+// which handles selector with a Handler for its Prefix. The standard input is
+// copied from stdin, if not nil, and the output of the command to stdout and
+// stderr. It returns the error of executing the command, or of the call.
+func Forward(ctx context.Context, caller rpc.Caller, selector string, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if args == nil {
+		args = []string{}
+	}
+	resp, err := caller.Call(ctx, selector, args)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	if !resp.Continue() {
+		return fmt.Errorf("remote: %s did not continue the call", selector)
+	}
+	stop := context.AfterFunc(ctx, func() { resp.Close() })
+	defer stop()
+
+	go func() {
+		if stdin != nil {
+			io.Copy(resp.Channel, stdin)
+		}
+		resp.CloseWrite()
+	}()
+
+	for {
+		var f frame
+		if err := resp.Receive(&f); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		switch {
+		case f.Done && f.Err != "":
+			return errors.New(f.Err)
+		case f.Done:
+			return nil
+		case f.Fd == 1:
+			stdout.Write(f.Data)
+		case f.Fd == 2:
+			stderr.Write(f.Data)
+		}
+	}
+}
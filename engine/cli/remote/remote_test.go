@@ -0,0 +1,86 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/mux"
+	"tractor.dev/toolkit-go/duplex/rpc"
+	"tractor.dev/toolkit-go/engine/cli"
+)
+
+func newRoot() *cli.Command {
+	root := &cli.Command{Usage: "tool"}
+	var verbose bool
+	root.PersistentFlags().BoolVar(&verbose, "verbose", false, "verbose output")
+	remote := &cli.Command{Usage: "remote"}
+	root.AddCommand(remote)
+	var name string
+	add := &cli.Command{
+		Usage: "add <url>",
+		Args:  cli.ExactArgs(1),
+		Run: func(ctx *cli.Context, args []string) {
+			fmt.Fprintf(ctx, "added %s as %s (verbose %v)\n", args[0], name, verbose)
+			fmt.Fprintln(ctx.Errout(), "warning: unverified")
+		},
+	}
+	add.Flags().StringVar(&name, "name", "origin", "remote name")
+	remote.AddCommand(add)
+	root.AddCommand(&cli.Command{
+		Usage: "upper",
+		Run: func(ctx *cli.Context, args []string) {
+			b, _ := io.ReadAll(ctx)
+			fmt.Fprint(ctx, strings.ToUpper(string(b)))
+		},
+	})
+	return root
+}
+
+func TestForward(t *testing.T) {
+	m := rpc.NewRespondMux()
+	(&Handler{Prefix: "cli", Root: newRoot}).Register(m)
+
+	sa, sb := mux.Pair()
+	defer sa.Close()
+	defer sb.Close()
+	srv := &rpc.Server{Handler: m, Codec: codec.JSONCodec{}}
+	go srv.Respond(sb, nil)
+	client := rpc.NewClient(sa, codec.JSONCodec{})
+	ctx := context.Background()
+
+	var stdout, stderr bytes.Buffer
+	err := Forward(ctx, client, "cli", []string{"-verbose", "remote", "add", "-name", "up", "https://example.com"}, nil, &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "added https://example.com as up (verbose true)\n" || stderr.String() != "warning: unverified\n" {
+		t.Fatalf("unexpected output: %q %q", stdout.String(), stderr.String())
+	}
+
+	// each call parses from a new tree, and commands have their own selectors
+	stdout.Reset()
+	if err := Forward(ctx, client, "cli.remote.add", []string{"https://example.com"}, nil, &stdout, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "added https://example.com as origin (verbose false)\n" {
+		t.Fatalf("unexpected output: %q", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := Forward(ctx, client, "cli", []string{"upper"}, strings.NewReader("hello"), &stdout, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "HELLO" {
+		t.Fatalf("unexpected output: %q", stdout.String())
+	}
+
+	err = Forward(ctx, client, "cli", []string{"remote", "add"}, nil, io.Discard, io.Discard)
+	if err == nil || err.Error() != "accepts 1 arg(s), received 0" {
+		t.Fatalf("expected error from executing the command, got %v", err)
+	}
+}
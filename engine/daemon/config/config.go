@@ -0,0 +1,327 @@
+// Package config is a daemon service for configuration that can change while
+// the daemon runs. It loads configuration from sources such as files and the
+// environment, watches them for changes, and notifies the services that
+// subscribed to a key with its new value, so they can change log levels,
+// limits and addresses without a restart.
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+// DefaultInterval is how often sources are checked for changes if the
+// Service doesn't set an interval.
+const DefaultInterval = time.Second
+
+// Source is a source of configuration, such as a File or the Env.
+type Source interface {
+	// LoadConfig returns the configuration of the source, where nested maps
+	// are the values of dotted keys, so {"log": {"level": "debug"}} is the
+	// value "debug" for the key "log.level".
+	LoadConfig() (map[string]any, error)
+}
+
+// Service merges the configuration of its sources, where later sources
+// override earlier ones, and checks them for changes as it serves. Values set
+// with Set override all sources.
+type Service struct {
+	Sources []Source
+
+	// Interval is how often sources are checked for changes,
+	// DefaultInterval if zero.
+	Interval time.Duration
+
+	Log *slog.Logger
+
+	reloadMu  sync.Mutex
+	mu        sync.Mutex
+	values    map[string]any
+	overrides map[string]any
+	subs      map[int]*subscription
+	nextSub   int
+}
+
+// subscription is a callback for changes of a key.
+type subscription struct {
+	key    string
+	notify func(v any) error
+}
+
+func (s *Service) log() *slog.Logger {
+	if s.Log == nil {
+		return slog.Default()
+	}
+	return s.Log
+}
+
+// InitializeDaemon loads the configuration, so it is available to services
+// as they start.
+func (s *Service) InitializeDaemon() error {
+	return s.Reload()
+}
+
+// Serve checks the sources for changes every interval until ctx is done.
+func (s *Service) Serve(ctx context.Context) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(); err != nil {
+				s.log().Warn("config reload failed", "err", err)
+			}
+		}
+	}
+}
+
+// Reload loads the configuration from the sources and notifies subscribers
+// of the keys that changed. If a source fails to load, the configuration is
+// left unchanged and the error is returned.
+func (s *Service) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	values := make(map[string]any)
+	for _, src := range s.Sources {
+		m, err := src.LoadConfig()
+		if err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+		merge(values, m)
+	}
+
+	s.mu.Lock()
+	for key, v := range s.overrides {
+		set(values, key, v)
+	}
+	old := s.values
+	s.values = values
+	var changed []*subscription
+	for _, id := range s.subIDs() {
+		sub := s.subs[id]
+		if !reflect.DeepEqual(lookup(old, sub.key), lookup(values, sub.key)) {
+			changed = append(changed, sub)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sub := range changed {
+		if v := s.Get(sub.key); v != nil {
+			if err := sub.notify(v); err != nil {
+				s.log().Warn("config value invalid", "key", sub.key, "err", err)
+			}
+		}
+	}
+	return nil
+}
+
+// subIDs returns the ids of subscriptions in the order they were made.
+func (s *Service) subIDs() []int {
+	ids := make([]int, 0, len(s.subs))
+	for id := range s.subs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Get returns the value of the dotted key, or nil if it is not set. The empty
+// key returns all of the configuration.
+func (s *Service) Get(key string) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copyValue(lookup(s.values, key))
+}
+
+// Set overrides the value of the dotted key, taking precedence over the
+// sources until the daemon restarts, and notifies subscribers of the change.
+func (s *Service) Set(key string, value any) error {
+	if key == "" {
+		return fmt.Errorf("config: empty key")
+	}
+	s.mu.Lock()
+	if s.overrides == nil {
+		s.overrides = make(map[string]any)
+	}
+	s.overrides[key] = normalize(value)
+	s.mu.Unlock()
+	return s.Reload()
+}
+
+// Subscribe calls fn with the value of the dotted key decoded into a T, once
+// when subscribing if the key is set and again each time it changes. String
+// values are converted to the type of T as needed, such as for values from
+// the environment, and a map value can be decoded into a struct. Values that
+// can't be decoded are logged and skipped. Calling the returned function
+// cancels the subscription.
+func Subscribe[T any](s *Service, key string, fn func(T)) (cancel func()) {
+	sub := &subscription{key: key, notify: func(v any) error {
+		var t T
+		if err := decode(v, &t); err != nil {
+			return err
+		}
+		fn(t)
+		return nil
+	}}
+
+	s.mu.Lock()
+	if s.subs == nil {
+		s.subs = make(map[int]*subscription)
+	}
+	id := s.nextSub
+	s.nextSub++
+	s.subs[id] = sub
+	s.mu.Unlock()
+
+	if v := s.Get(key); v != nil {
+		if err := sub.notify(v); err != nil {
+			s.log().Warn("config value invalid", "key", key, "err", err)
+		}
+	}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subs, id)
+	}
+}
+
+// Decode decodes the value of the dotted key into v, which should be a
+// pointer, converting values as Subscribe does.
+func (s *Service) Decode(key string, v any) error {
+	return decode(s.Get(key), v)
+}
+
+func decode(in, out any) error {
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		WeaklyTypedInput: true,
+		Result:           out,
+	})
+	if err != nil {
+		return err
+	}
+	return dec.Decode(in)
+}
+
+// setArgs are the arguments of the rpc set call.
+type setArgs struct {
+	Key   string
+	Value any
+}
+
+// RespondRPC handles calls that change the configuration remotely. A call
+// with the selector ending in "set" and the arguments {Key, Value} calls Set,
+// and otherwise a call with a key argument returns the value of Get.
+func (s *Service) RespondRPC(r rpc.Responder, c *rpc.Call) {
+	if strings.HasSuffix(c.Selector(), "set") {
+		var args setArgs
+		if err := c.Receive(&args); err != nil {
+			r.Return(err)
+			return
+		}
+		r.Return(s.Set(args.Key, args.Value))
+		return
+	}
+	var key string
+	if err := c.Receive(&key); err != nil {
+		r.Return(err)
+		return
+	}
+	r.Return(s.Get(key))
+}
+
+// lookup returns the value at the dotted key in m, or nil.
+func lookup(m map[string]any, key string) any {
+	if key == "" {
+		if m == nil {
+			return nil
+		}
+		return m
+	}
+	var v any = m
+	for _, k := range strings.Split(key, ".") {
+		mm, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = mm[k]
+	}
+	return v
+}
+
+// set sets the value at the dotted key in m, replacing values in the way
+// that are not maps.
+func set(m map[string]any, key string, v any) {
+	keys := strings.Split(key, ".")
+	for _, k := range keys[:len(keys)-1] {
+		mm, ok := m[k].(map[string]any)
+		if !ok {
+			mm = make(map[string]any)
+			m[k] = mm
+		}
+		m = mm
+	}
+	m[keys[len(keys)-1]] = v
+}
+
+// merge sets the values of src in dst, merging nested maps.
+func merge(dst, src map[string]any) {
+	for k, v := range src {
+		v = normalize(v)
+		if sm, ok := v.(map[string]any); ok {
+			dm, ok := dst[k].(map[string]any)
+			if !ok {
+				dm = make(map[string]any)
+				dst[k] = dm
+			}
+			merge(dm, sm)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// normalize converts maps with keys of other types, such as decoded from
+// cbor, to maps with string keys.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, vv := range v {
+			m[fmt.Sprint(k)] = normalize(vv)
+		}
+		return m
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, vv := range v {
+			m[k] = normalize(vv)
+		}
+		return m
+	}
+	return v
+}
+
+// copyValue copies nested maps, so values returned can't change the
+// configuration.
+func copyValue(v any) any {
+	if m, ok := v.(map[string]any); ok {
+		return normalize(m)
+	}
+	return v
+}
@@ -0,0 +1,97 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"tractor.dev/toolkit-go/engine/daemon/config"
+)
+
+func fatal(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	fatal(t, os.WriteFile(path, []byte(`{"log": {"level": "info"}, "limits": {"conns": 10, "timeout": "1s"}}`), 0644))
+	t.Setenv("TESTAPP_LIMITS_CONNS", "20")
+
+	s := &config.Service{Sources: []config.Source{&config.File{Path: path}, config.Env{Prefix: "testapp"}}}
+	fatal(t, s.InitializeDaemon())
+
+	var (
+		mu     sync.Mutex
+		levels []string
+		conns  []int
+		limits struct {
+			Conns   int
+			Timeout time.Duration
+		}
+	)
+	config.Subscribe(s, "log.level", func(level string) {
+		mu.Lock()
+		defer mu.Unlock()
+		levels = append(levels, level)
+	})
+	cancel := config.Subscribe(s, "limits.conns", func(n int) {
+		conns = append(conns, n)
+	})
+	fatal(t, s.Decode("limits", &limits))
+	if limits.Conns != 20 || limits.Timeout != time.Second {
+		t.Fatalf("unexpected limits: %+v", limits)
+	}
+
+	// unchanged values don't notify
+	fatal(t, s.Reload())
+	if len(levels) != 1 || levels[0] != "info" {
+		t.Fatalf("unexpected levels: %v", levels)
+	}
+
+	fatal(t, os.WriteFile(path, []byte(`{"log": {"level": "debug"}, "limits": {"conns": 10}}`), 0644))
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	fatal(t, s.Reload())
+	if len(levels) != 2 || levels[1] != "debug" {
+		t.Fatalf("file change not notified: %v", levels)
+	}
+
+	cancel()
+	fatal(t, s.Set("limits.conns", 30))
+	fatal(t, s.Set("log.level", "warn"))
+	if len(conns) != 1 || conns[0] != 20 {
+		t.Fatalf("canceled subscription notified: %v", conns)
+	}
+	if len(levels) != 3 || levels[2] != "warn" {
+		t.Fatalf("set not notified: %v", levels)
+	}
+	if got := s.Get("limits.conns"); got != 30 {
+		t.Fatalf("unexpected value after set: %v", got)
+	}
+
+	// a broken file keeps the last configuration
+	fatal(t, os.WriteFile(path, []byte(`{`), 0644))
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+	if err := s.Reload(); err == nil {
+		t.Fatal("expected reload error")
+	}
+	if got := s.Get("log.level"); got != "warn" {
+		t.Fatalf("configuration changed after failed reload: %v", got)
+	}
+}
+
+func TestOptionalFile(t *testing.T) {
+	s := &config.Service{Sources: []config.Source{&config.File{Path: filepath.Join(t.TempDir(), "missing.json"), Optional: true}}}
+	fatal(t, s.InitializeDaemon())
+	if got := s.Get("anything"); got != nil {
+		t.Fatalf("unexpected value: %v", got)
+	}
+
+	s = &config.Service{Sources: []config.Source{&config.File{Path: filepath.Join(t.TempDir(), "missing.json")}}}
+	if err := s.InitializeDaemon(); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File is a Source reading a file, only decoding it again when it changes.
+type File struct {
+	Path string
+
+	// Decode decodes the contents of the file, json.Unmarshal if nil.
+	Decode func(data []byte, v any) error
+
+	// Optional, if set, loads no configuration when the file doesn't exist
+	// instead of returning an error.
+	Optional bool
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	values  map[string]any
+}
+
+func (f *File) LoadConfig() (map[string]any, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fi, err := os.Stat(f.Path)
+	if errors.Is(err, fs.ErrNotExist) && f.Optional {
+		f.values = nil
+		f.modTime = time.Time{}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.values != nil && fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return f.values, nil
+	}
+
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	decode := f.Decode
+	if decode == nil {
+		decode = json.Unmarshal
+	}
+	values := make(map[string]any)
+	if err := decode(data, &values); err != nil {
+		return nil, err
+	}
+	f.values, f.modTime, f.size = values, fi.ModTime(), fi.Size()
+	return values, nil
+}
+
+// Env is a Source of the environment variables starting with the prefix and
+// an underscore. The rest of the name is the key in lower case with dots for
+// underscores, so with the prefix "APP" the variable APP_LOG_LEVEL sets the
+// key "log.level". Values are strings, converted by Subscribe as needed.
+type Env struct {
+	Prefix string
+}
+
+func (e Env) LoadConfig() (map[string]any, error) {
+	values := make(map[string]any)
+	prefix := strings.ToUpper(e.Prefix) + "_"
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		name, ok := strings.CutPrefix(name, prefix)
+		if !ok || name == "" {
+			continue
+		}
+		set(values, strings.ToLower(strings.ReplaceAll(name, "_", ".")), value)
+	}
+	return values, nil
+}
@@ -0,0 +1,149 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/mux"
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+// Controller is a service with its own selectors on the control socket.
+type Controller interface {
+	RegisterControl(m *rpc.RespondMux)
+}
+
+// Status is returned by the "daemon.status" selector of the control socket.
+type Status struct {
+	PID      int
+	Uptime   time.Duration
+	Running  bool
+	Services int
+}
+
+// ServiceStatus is an element of the list returned by the "daemon.services"
+// selector of the control socket.
+type ServiceStatus struct {
+	// Name is the type name of the service.
+	Name string
+	// Status is one of "starting", "serving", "restarting", "exited",
+	// "failed" or "stopped".
+	Status   string
+	Restarts int
+}
+
+// ServiceStatuses returns the status of each service started, in the order
+// they were started.
+func (d *Framework) ServiceStatuses() []ServiceStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	statuses := make([]ServiceStatus, 0, len(d.started))
+	for _, state := range d.started {
+		statuses = append(statuses, ServiceStatus{
+			Name:     ptrName(state.service),
+			Status:   state.status,
+			Restarts: state.restarts,
+		})
+	}
+	return statuses
+}
+
+// controlMux returns the mux of the control socket with the built-in
+// selectors and those registered by Controllers.
+func (d *Framework) controlMux(started time.Time) *rpc.RespondMux {
+	m := rpc.NewRespondMux()
+	m.Handle("daemon.status", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		c.Receive(nil)
+		d.mu.Lock()
+		n := len(d.started)
+		d.mu.Unlock()
+		r.Return(Status{
+			PID:      os.Getpid(),
+			Uptime:   time.Since(started),
+			Running:  d.Running(),
+			Services: n,
+		})
+	}))
+	m.Handle("daemon.services", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		c.Receive(nil)
+		r.Return(d.ServiceStatuses())
+	}))
+	m.Handle("daemon.shutdown", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		c.Receive(nil)
+		d.log().Info("shutdown requested over control socket")
+		r.Return(nil)
+		go d.Terminate()
+	}))
+	m.Handle("daemon.loglevel", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		var level string
+		if err := c.Receive(&level); err != nil {
+			r.Return(err)
+			return
+		}
+		if d.LogLevel == nil {
+			r.Return(fmt.Errorf("log level is not configurable"))
+			return
+		}
+		if level != "" {
+			if err := d.LogLevel.UnmarshalText([]byte(level)); err != nil {
+				r.Return(err)
+				return
+			}
+			d.log().Info("log level changed over control socket", "level", d.LogLevel.Level())
+		}
+		r.Return(strings.ToLower(d.LogLevel.Level().String()))
+	}))
+	if c, ok := d.Loggers.(Controller); ok {
+		c.RegisterControl(m)
+	}
+	for _, s := range d.Services {
+		if c, ok := s.(Controller); ok && any(s) != any(d.Loggers) {
+			c.RegisterControl(m)
+		}
+	}
+	return m
+}
+
+// listenControl listens on the ControlSocket and serves the control mux on
+// it until the listener is closed.
+func (d *Framework) listenControl() (mux.Listener, error) {
+	l, err := mux.ListenUnixMode(d.ControlSocket, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("control socket: %w", err)
+	}
+	srv := &rpc.Server{
+		Handler: d.controlMux(time.Now()),
+		Codec:   codec.CBORCodec{},
+		ErrorHandler: func(err error) {
+			d.log().Debug("control socket", "err", err)
+		},
+	}
+	go func() {
+		for {
+			sess, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer sess.Close()
+				srv.ServeSession(context.Background(), sess)
+			}()
+		}
+	}()
+	d.log().Debug("control socket listening", "path", d.ControlSocket)
+	return l, nil
+}
+
+// DialControl connects to the control socket of a daemon at path, returning
+// a client to call its selectors, such as "daemon.status".
+func DialControl(path string) (*rpc.Client, error) {
+	sess, err := mux.DialUnix(path)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(sess, codec.CBORCodec{}), nil
+}
@@ -3,8 +3,11 @@ package daemon
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -26,6 +29,86 @@ type Service interface {
 	Serve(ctx context.Context)
 }
 
+// Logged is a service given its own logger by the daemon, named for the
+// service, before the daemon is initialized.
+type Logged interface {
+	SetDaemonLogger(l *slog.Logger)
+}
+
+// Loggers makes loggers named for services, such as the logging service of
+// the daemon/logging package, so their levels can be set by name.
+type Loggers interface {
+	Logger(name string) *slog.Logger
+}
+
+// Dependent is a service that depends on other services of the daemon, so it
+// is started after them and stopped before them.
+type Dependent interface {
+	DaemonDependencies() []Service
+}
+
+// Starter is a service started before it is served, such as to open the
+// resources it serves. Services depending on it are not started until
+// StartDaemon returns. Returning an error will cancel the start of the
+// daemon, stopping the services already started.
+type Starter interface {
+	StartDaemon(ctx context.Context) error
+}
+
+// StartTimeouter is a Starter with its own start timeout, instead of the
+// StartTimeout of the daemon.
+type StartTimeouter interface {
+	DaemonStartTimeout() time.Duration
+}
+
+// DefaultStartTimeout is the time a Starter has to start if neither it nor
+// the daemon sets a timeout.
+const DefaultStartTimeout = 30 * time.Second
+
+// StopTimeouter is a service or Terminator with its own grace period to
+// stop, instead of the StopTimeout of the daemon.
+type StopTimeouter interface {
+	DaemonStopTimeout() time.Duration
+}
+
+// DefaultStopTimeout is the time a service or Terminator has to stop if
+// neither it nor the daemon sets a timeout.
+const DefaultStopTimeout = 2 * time.Second
+
+// ServiceError is an error of a service, attributing the error to the
+// service and the step of the lifecycle it failed in.
+type ServiceError struct {
+	// Service is the type name of the service.
+	Service string
+	// Op is the step that failed, such as "initialize" or "start".
+	Op  string
+	Err error
+}
+
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Service, e.Err)
+}
+
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// StopError is returned by Run when services or Terminators did not stop
+// within their stop timeout, so they may still be running.
+type StopError struct {
+	// Services are the type names of those that did not stop, in the order
+	// they were stopped.
+	Services []string
+}
+
+func (e *StopError) Error() string {
+	return "refused to stop: " + strings.Join(e.Services, ", ")
+}
+
+// ErrNotService is returned by RunWindowsService if the process was not
+// started as a Windows service.
+var ErrNotService = errors.New("not running as a service")
+
 // Framework is a top-level daemon lifecycle manager runs services given to it.
 type Framework struct {
 	Initializers []Initializer
@@ -35,9 +118,82 @@ type Framework struct {
 	OnFinished   func()
 	Log          *slog.Logger
 
+	// Loggers, if set, makes the loggers of Logged services and the daemon
+	// if Log is not set. Otherwise Logged services get Log with the
+	// attribute "service" set to their name.
+	Loggers Loggers
+
+	// StartTimeout is the time each Starter has to start, DefaultStartTimeout
+	// if zero.
+	StartTimeout time.Duration
+
+	// StopTimeout is the grace period each service and Terminator has to
+	// stop, DefaultStopTimeout if zero.
+	StopTimeout time.Duration
+
+	// Restart is the RestartPolicy of services that are not Supervised.
+	Restart RestartPolicy
+
+	// OnEvent, if set, is called with supervision events of services, such
+	// as failures and restarts. It is called from the goroutine serving the
+	// service, so it should not block.
+	OnEvent func(Event)
+
+	// ControlSocket, if set, is the path of a unix socket for controlling
+	// the daemon while it runs, serving selectors such as "daemon.status"
+	// and those of services that are Controllers. See DialControl.
+	ControlSocket string
+
+	// LogLevel, if set, is the level of Log that can be changed with the
+	// "daemon.loglevel" selector of the control socket.
+	LogLevel *slog.LevelVar
+
+	// PIDFile, if set, is the path of a file the PID of the daemon is
+	// written to while it runs. The file is locked, so Run returns an
+	// *InstanceError if another instance is running with it.
+	PIDFile string
+
 	running    int32
 	cancel     context.CancelFunc
 	terminated chan bool
+	stopErr    error
+	control    io.Closer
+	// serving is the services of the run serving, new for each run since
+	// it may still be waited on after a run finishes
+	serving *sync.WaitGroup
+
+	// initialized is set by Initialize until the daemon finishes, with the
+	// order services are started in and the unlock of the PIDFile
+	initialized bool
+	order       []Service
+	unlock      func()
+
+	// onReady are called once the services have started
+	onReady []func()
+	// notifyMu orders watchdog pings before STOPPING=1
+	notifyMu sync.Mutex
+
+	mu sync.Mutex
+	// started are the services started, in order
+	started []*serviceState
+}
+
+// serviceState is a service started by the daemon.
+type serviceState struct {
+	service Service
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	// status and restarts are guarded by the mutex of the daemon
+	status   string
+	restarts int
+}
+
+// setStatus sets the status of the service shown by the control socket.
+func (d *Framework) setStatus(state *serviceState, status string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state.status = status
 }
 
 // New builds a daemon configured to run a set of services. The services
@@ -62,22 +218,122 @@ func (d *Framework) Add(services ...Service) {
 	}
 }
 
-// Run executes the daemon lifecycle
+func (d *Framework) log() *slog.Logger {
+	if d.Log == nil {
+		if d.Loggers != nil {
+			return d.Loggers.Logger("daemon")
+		}
+		return slog.Default()
+	}
+	return d.Log
+}
+
+// serviceLogger returns the logger for the service named name.
+func (d *Framework) serviceLogger(name string) *slog.Logger {
+	if d.Loggers != nil {
+		return d.Loggers.Logger(name)
+	}
+	return d.log().With("service", name)
+}
+
+// Run executes the daemon lifecycle. Services are started in the order of
+// their dependencies, and an error is returned if a service fails to
+// initialize or start, or a *StopError if services did not stop in time.
 func (d *Framework) Run(ctx context.Context) error {
-	if !atomic.CompareAndSwapInt32(&d.running, 0, 1) {
+	if err := d.Start(ctx); err != nil {
+		return err
+	}
+	return d.Wait()
+}
+
+// Initialize checks the dependencies of services, locks the PIDFile, gives
+// Logged services their loggers and calls the Initializers. Start calls it
+// if it has not been called, so it is only needed to initialize the daemon
+// apart from starting it, such as in tests.
+func (d *Framework) Initialize() error {
+	if atomic.LoadInt32(&d.running) == 1 {
 		return errors.New("already running")
 	}
+	if d.initialized {
+		return errors.New("already initialized")
+	}
+	return d.initialize()
+}
+
+func (d *Framework) initialize() error {
+	order, err := startOrder(d.Services)
+	if err != nil {
+		return err
+	}
+
+	unlock := func() {}
+	if d.PIDFile != "" {
+		unlock, err = lockInstance(d.PIDFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range d.Services {
+		if l, ok := s.(Logged); ok {
+			l.SetDaemonLogger(d.serviceLogger(ptrName(s)))
+		}
+	}
 
 	// call initializers
 	for _, i := range d.Initializers {
-		d.Log.Debug("initializing", "service", ptrName(i))
+		d.log().Debug("initializing", "service", ptrName(i))
 		if err := i.InitializeDaemon(); err != nil {
+			unlock()
+			return &ServiceError{Service: ptrName(i), Op: "initialize", Err: err}
+		}
+	}
+
+	d.initialized = true
+	d.order = order
+	d.unlock = unlock
+	return nil
+}
+
+// release unlocks the PIDFile and resets the state of the run, so the
+// daemon can be initialized and started again.
+func (d *Framework) release() {
+	if d.unlock != nil {
+		d.unlock()
+	}
+	d.initialized = false
+	d.order = nil
+	d.unlock = nil
+	d.mu.Lock()
+	d.started = nil
+	d.mu.Unlock()
+	d.cancel = nil
+	d.terminated = nil
+	d.serving = nil
+	d.stopErr = nil
+	d.control = nil
+}
+
+// Start initializes the daemon if Initialize has not been called, then
+// starts its services in the order of their dependencies and returns once
+// they have started. If a service fails to start, the services already
+// started are stopped and the error is returned. Otherwise Wait must be
+// called to wait for the daemon to terminate.
+func (d *Framework) Start(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&d.running, 0, 1) {
+		return errors.New("already running")
+	}
+	if !d.initialized {
+		if err := d.initialize(); err != nil {
+			atomic.StoreInt32(&d.running, 0)
 			return err
 		}
 	}
 
 	// finish if no services
 	if len(d.Services) == 0 {
+		d.release()
+		atomic.StoreInt32(&d.running, 0)
 		return errors.New("no services to run")
 	}
 
@@ -88,104 +344,322 @@ func (d *Framework) Run(ctx context.Context) error {
 	d.Context = ctx
 	d.cancel = cancelFunc
 	d.terminated = make(chan bool, 1)
+	d.serving = new(sync.WaitGroup)
+
+	if d.ControlSocket != "" {
+		l, err := d.listenControl()
+		if err != nil {
+			d.cancel()
+			d.release()
+			atomic.StoreInt32(&d.running, 0)
+			return err
+		}
+		d.control = l
+	}
 
 	// setup terminators on stop signals
 	go TerminateOnSignal(d)
 	go TerminateOnContextDone(d)
 
-	var wg sync.WaitGroup
-	var running sync.Map
-	for _, service := range d.Services {
-		running.Store(service, nil)
-		wg.Add(1)
-		go func(s Service) {
-			defer func() {
-				if r := recover(); r != nil {
-					d.Log.Info("serve panic from ", ptrName(s))
-					d.Log.Debug("serve panic:", r)
-				}
-			}()
-			defer wg.Done()
-			s.Serve(d.Context)
-			running.Delete(s)
-		}(service)
+	for _, service := range d.order {
+		if err := d.start(service, d.serving); err != nil {
+			d.log().Error("start failed", "service", ptrName(service), "err", err)
+			d.Terminate()
+			d.wait()
+			return err
+		}
+	}
+	d.ready()
+	return nil
+}
+
+// Wait waits for the daemon started by Start to terminate, such as by
+// Terminate or the end of the context given to Start, returning a
+// *StopError if services did not stop in time. It is called once for each
+// Start.
+func (d *Framework) Wait() error {
+	if d.terminated == nil {
+		return errors.New("not started")
 	}
+	return d.wait()
+}
 
+// wait waits for the daemon to terminate and releases it, returning the
+// error of stopping its services.
+func (d *Framework) wait() error {
 	finished := make(chan bool)
+	serving := d.serving
 	go func() {
-		wg.Wait()
+		serving.Wait()
 		close(finished)
 	}()
 
+	// Terminate has waited for services to stop, so only those that refused
+	// to stop are left running
 	select {
 	case <-finished:
 		<-d.terminated
 	case <-d.terminated:
-		<-time.After(10 * time.Millisecond)
-		var waiting []string
-		running.Range(func(k, v interface{}) bool {
-			waiting = append(waiting, ptrName(k))
-			return true
-		})
-		if len(waiting) > 0 {
-			d.Log.Info("waiting on serve for:", waiting)
-		}
-		select {
-		case <-finished:
-		case <-time.After(2 * time.Second):
-			var waiting []string
-			running.Range(func(k, v interface{}) bool {
-				waiting = append(waiting, ptrName(k))
-				return true
-			})
-			d.Log.Info("warning: unfinished services:", waiting)
-		}
 	}
 
 	if d.OnFinished != nil {
 		d.OnFinished()
 	}
+	err := d.stopErr
+	d.release()
+	return err
+}
+
+// start starts the service with StartDaemon if it is a Starter, then serves
+// it with its own context, so it can be stopped apart from other services,
+// supervised by its RestartPolicy.
+func (d *Framework) start(s Service, wg *sync.WaitGroup) error {
+	d.mu.Lock()
+	if atomic.LoadInt32(&d.running) == 0 {
+		d.mu.Unlock()
+		return errors.New("daemon terminated")
+	}
+	// services are stopped by Terminate in order, not all at once when the
+	// daemon context is canceled
+	ctx, cancel := context.WithCancel(context.WithoutCancel(d.Context))
+	state := &serviceState{service: s, cancel: cancel, done: make(chan struct{}), status: "starting"}
+	d.started = append(d.started, state)
+	d.mu.Unlock()
+
+	if starter, ok := s.(Starter); ok {
+		d.log().Debug("starting", "service", ptrName(s))
+		if err := d.startService(ctx, starter); err != nil {
+			cancel()
+			d.setStatus(state, "failed")
+			close(state.done)
+			return &ServiceError{Service: ptrName(s), Op: "start", Err: err}
+		}
+	}
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(state.done)
+		d.supervise(ctx, state)
+	}()
 	return nil
 }
 
-// Terminate cancels the daemon context and calls Terminators in reverse order
+// startService calls StartDaemon with the start timeout of the service,
+// returning an error when the timeout expires even if StartDaemon has not
+// returned.
+func (d *Framework) startService(ctx context.Context, s Starter) error {
+	timeout := d.StartTimeout
+	if t, ok := s.(StartTimeouter); ok {
+		timeout = t.DaemonStartTimeout()
+	}
+	if timeout <= 0 {
+		timeout = DefaultStartTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.StartDaemon(ctx)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s", timeout)
+		}
+		return ctx.Err()
+	}
+}
+
+// startOrder returns the services ordered so each comes after its
+// dependencies, keeping the order they were given in otherwise. It returns
+// an error for dependencies that are not services of the daemon or that
+// form a cycle.
+func startOrder(services []Service) ([]Service, error) {
+	known := make(map[Service]bool)
+	for _, s := range services {
+		known[s] = true
+	}
+	var (
+		order    []Service
+		visited  = make(map[Service]bool)
+		visiting []Service
+	)
+	var visit func(s Service) error
+	visit = func(s Service) error {
+		if visited[s] {
+			return nil
+		}
+		for i, v := range visiting {
+			if v == s {
+				var cycle []string
+				for _, c := range append(visiting[i:], s) {
+					cycle = append(cycle, ptrName(c))
+				}
+				return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		visiting = append(visiting, s)
+		if dep, ok := s.(Dependent); ok {
+			for _, dd := range dep.DaemonDependencies() {
+				if !known[dd] {
+					return &ServiceError{Service: ptrName(s), Op: "start", Err: fmt.Errorf("depends on %s, which is not a service of the daemon", ptrName(dd))}
+				}
+				if err := visit(dd); err != nil {
+					return err
+				}
+			}
+		}
+		visiting = visiting[:len(visiting)-1]
+		visited[s] = true
+		order = append(order, s)
+		return nil
+	}
+	for _, s := range services {
+		if err := visit(s); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// Terminate stops the services in the reverse order they were started,
+// then calls the other Terminators in parallel and cancels the daemon
+// context. Each service has its stop timeout to stop: its Terminator is
+// called with a context ending at the deadline, then its serve context is
+// canceled, and if it has not returned from Serve by the deadline it is left
+// running and reported in the StopError returned by Run.
 func (d *Framework) Terminate() {
 	if d == nil {
 		// find these cases and prevent them!
 		panic("daemon reference used to Terminate but daemon pointer is nil")
 	}
 
+	d.mu.Lock()
 	if !atomic.CompareAndSwapInt32(&d.running, 1, 0) {
+		d.mu.Unlock()
 		return
 	}
+	started := d.started
+	d.mu.Unlock()
 
-	d.Log.Info("shutting down")
-
-	if d.cancel != nil {
-		d.cancel()
+	d.log().Info("shutting down")
+	d.notifyMu.Lock()
+	if _, err := SdNotify("STOPPING=1"); err != nil {
+		d.log().Debug("systemd notify failed", "err", err)
 	}
+	d.notifyMu.Unlock()
 
-	// hmmm..
-	ctx := context.Background()
+	// services not started are not terminated
+	terminated := make(map[Terminator]bool)
+	for _, s := range d.Services {
+		if t, ok := s.(Terminator); ok {
+			terminated[t] = true
+		}
+	}
+	var unstopped []string
+	for i := len(started) - 1; i >= 0; i-- {
+		state := started[i]
+		d.log().Debug("stopping", "service", ptrName(state.service))
+		if !d.stop(state) {
+			unstopped = append(unstopped, ptrName(state.service))
+		}
+	}
 
-	var wg sync.WaitGroup
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
 	for i := len(d.Terminators) - 1; i >= 0; i-- {
+		if terminated[d.Terminators[i]] {
+			continue
+		}
 		wg.Add(1)
 		go func(t Terminator) {
-			d.Log.Debug("terminating", ptrName(t))
-			// TODO: timeout
-			if err := t.TerminateDaemon(ctx); err != nil {
-				d.Log.Info("terminate error:", err)
+			defer wg.Done()
+			d.log().Debug("terminating", "service", ptrName(t))
+			ctx, cancel := context.WithTimeout(context.Background(), d.stopTimeout(t))
+			defer cancel()
+			if !d.terminate(ctx, t) {
+				mu.Lock()
+				unstopped = append(unstopped, ptrName(t))
+				mu.Unlock()
 			}
-			wg.Done()
 		}(d.Terminators[i])
 	}
 	wg.Wait()
-	d.Log.Debug("finished termination")
+
+	if len(unstopped) > 0 {
+		d.stopErr = &StopError{Services: unstopped}
+		d.log().Warn("services refused to stop", "services", unstopped)
+	}
+	if d.control != nil {
+		d.control.Close()
+	}
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.log().Debug("finished termination")
 	d.terminated <- true
 }
 
+// stop stops a started service within its stop timeout, returning false if
+// it is still serving at the deadline.
+func (d *Framework) stop(state *serviceState) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), d.stopTimeout(state.service))
+	defer cancel()
+	ok := true
+	if t, isTerminator := state.service.(Terminator); isTerminator {
+		ok = d.terminate(ctx, t)
+	}
+	// force the service to stop serving if terminating it did not
+	state.cancel()
+	select {
+	case <-state.done:
+		return ok
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// terminate calls TerminateDaemon, returning false if it has not returned
+// by the end of ctx.
+func (d *Framework) terminate(ctx context.Context, t Terminator) bool {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := t.TerminateDaemon(ctx); err != nil {
+			d.log().Info("terminate error", "service", ptrName(t), "err", err)
+		}
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// stopTimeout returns the time the service or Terminator has to stop.
+func (d *Framework) stopTimeout(s any) time.Duration {
+	timeout := d.StopTimeout
+	if t, ok := s.(StopTimeouter); ok {
+		timeout = t.DaemonStopTimeout()
+	}
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	return timeout
+}
+
+// Running returns whether the daemon is running and not terminated.
+func (d *Framework) Running() bool {
+	return atomic.LoadInt32(&d.running) == 1
+}
+
 // TerminateOnContextDone waits for the deamon's context to be canceled.
 func TerminateOnContextDone(d *Framework) {
 	<-d.Context.Done()
@@ -193,5 +667,9 @@ func TerminateOnContextDone(d *Framework) {
 }
 
 func ptrName(v any) string {
-	return reflect.ValueOf(v).Elem().Type().String()
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		return rv.Elem().Type().String()
+	}
+	return rv.Type().String()
 }
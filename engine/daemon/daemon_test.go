@@ -2,12 +2,26 @@ package daemon_test
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"tractor.dev/toolkit-go/duplex/rpc"
 	"tractor.dev/toolkit-go/engine"
 	"tractor.dev/toolkit-go/engine/daemon"
+	"tractor.dev/toolkit-go/engine/daemon/logging"
 )
 
 type initService struct {
@@ -78,3 +92,514 @@ func TestDaemon(t *testing.T) {
 		t.Fatal("terminator not used")
 	}
 }
+
+type countingService struct {
+	terms atomic.Int32
+}
+
+func (s *countingService) Serve(ctx context.Context) {
+	<-ctx.Done()
+}
+
+func (s *countingService) TerminateDaemon(ctx context.Context) error {
+	s.terms.Add(1)
+	return nil
+}
+
+func TestDaemonRunTwice(t *testing.T) {
+	s := new(countingService)
+	d := daemon.New(s)
+	for i := 1; i <= 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		fatal(t, d.Run(ctx))
+		cancel()
+		if n := s.terms.Load(); n != int32(i) {
+			t.Fatalf("expected %d terminations after %d runs, got %d", i, i, n)
+		}
+	}
+}
+
+func TestDaemonStartTwice(t *testing.T) {
+	s := new(countingService)
+	d := daemon.New(s)
+	for i := 1; i <= 2; i++ {
+		fatal(t, d.Start(context.Background()))
+		if n := len(d.ServiceStatuses()); n != 1 {
+			t.Fatalf("expected 1 service in run %d, got %d", i, n)
+		}
+		d.Terminate()
+		fatal(t, d.Wait())
+		if n := len(d.ServiceStatuses()); n != 0 {
+			t.Fatalf("expected no services after run %d, got %d", i, n)
+		}
+		if err := d.Wait(); err == nil {
+			t.Fatal("expected error waiting for a finished run")
+		}
+	}
+	if n := s.terms.Load(); n != 2 {
+		t.Fatalf("expected 2 terminations, got %d", n)
+	}
+}
+
+type orderLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *orderLog) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *orderLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.events, " ")
+}
+
+type depService struct {
+	name    string
+	log     *orderLog
+	deps    []daemon.Service
+	startFn func(ctx context.Context) error
+}
+
+func (s *depService) DaemonDependencies() []daemon.Service {
+	return s.deps
+}
+
+func (s *depService) StartDaemon(ctx context.Context) error {
+	if s.startFn != nil {
+		return s.startFn(ctx)
+	}
+	s.log.add("start:" + s.name)
+	return nil
+}
+
+func (s *depService) Serve(ctx context.Context) {
+	<-ctx.Done()
+	s.log.add("stop:" + s.name)
+}
+
+func TestDaemonDependencies(t *testing.T) {
+	log := new(orderLog)
+	db := &depService{name: "db", log: log}
+	cache := &depService{name: "cache", log: log, deps: []daemon.Service{db}}
+	api := &depService{name: "api", log: log, deps: []daemon.Service{cache, db}}
+
+	d := daemon.New(api, cache, db)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	fatal(t, d.Run(ctx))
+
+	want := "start:db start:cache start:api stop:api stop:cache stop:db"
+	if got := log.String(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestDaemonDependencyErrors(t *testing.T) {
+	a := &depService{name: "a"}
+	b := &depService{name: "b", deps: []daemon.Service{a}}
+	a.deps = []daemon.Service{b}
+	err := daemon.New(a, b).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle") {
+		t.Fatalf("expected dependency cycle error, got %v", err)
+	}
+
+	c := &depService{name: "c", deps: []daemon.Service{new(simpleService)}}
+	err = daemon.New(c).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "not a service of the daemon") {
+		t.Fatalf("expected unknown dependency error, got %v", err)
+	}
+}
+
+func TestDaemonStartTimeout(t *testing.T) {
+	log := new(orderLog)
+	db := &depService{name: "db", log: log}
+	slow := &depService{name: "slow", log: log, deps: []daemon.Service{db}, startFn: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	api := &depService{name: "api", log: log, deps: []daemon.Service{slow}}
+
+	d := daemon.New(api, slow, db)
+	d.StartTimeout = 10 * time.Millisecond
+	err := d.Run(context.Background())
+
+	var serr *daemon.ServiceError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected ServiceError, got %v", err)
+	}
+	if serr.Op != "start" || !strings.Contains(serr.Service, "depService") {
+		t.Fatalf("unexpected attribution: %v", serr)
+	}
+	if want := "start:db stop:db"; log.String() != want {
+		t.Fatalf("got %q, want %q", log.String(), want)
+	}
+}
+
+type flakyService struct {
+	mu     sync.Mutex
+	serves int
+	fails  int
+}
+
+func (s *flakyService) Serve(ctx context.Context) {
+	s.mu.Lock()
+	s.serves++
+	fail := s.serves <= s.fails
+	s.mu.Unlock()
+	if fail {
+		panic("flaky")
+	}
+	<-ctx.Done()
+}
+
+func (s *flakyService) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.serves
+}
+
+func TestDaemonRestart(t *testing.T) {
+	s := &flakyService{fails: 2}
+	var mu sync.Mutex
+	var events []string
+	d := daemon.New(s)
+	d.Restart = daemon.RestartPolicy{When: daemon.RestartOnFailure, Backoff: time.Millisecond}
+	d.OnEvent = func(e daemon.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, string(e.Type))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	fatal(t, d.Run(ctx))
+
+	if s.count() != 3 {
+		t.Fatalf("expected 3 serves, got %d", s.count())
+	}
+	want := "failed restarting failed restarting"
+	if got := strings.Join(events, " "); got != want {
+		t.Fatalf("got events %q, want %q", got, want)
+	}
+}
+
+func TestDaemonRestartGaveUp(t *testing.T) {
+	s := &flakyService{fails: 10}
+	var gaveUp daemon.Event
+	d := daemon.New(s)
+	d.Restart = daemon.RestartPolicy{When: daemon.RestartAlways, MaxRetries: 2, Backoff: time.Millisecond}
+	d.OnEvent = func(e daemon.Event) {
+		if e.Type == daemon.EventGaveUp {
+			gaveUp = e
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	fatal(t, d.Run(ctx))
+
+	if s.count() != 3 {
+		t.Fatalf("expected 3 serves, got %d", s.count())
+	}
+	if gaveUp.Restarts != 2 || gaveUp.Err == nil {
+		t.Fatalf("unexpected gave up event: %+v", gaveUp)
+	}
+}
+
+func TestDaemonRestartNever(t *testing.T) {
+	s := &flakyService{fails: 1}
+	var failed int
+	d := daemon.New(s)
+	d.OnEvent = func(e daemon.Event) {
+		if e.Type == daemon.EventFailed {
+			failed++
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	fatal(t, d.Run(ctx))
+
+	if s.count() != 1 || failed != 1 {
+		t.Fatalf("expected 1 serve and failure, got %d and %d", s.count(), failed)
+	}
+}
+
+type stubbornService struct {
+	release  chan struct{}
+	deadline bool
+}
+
+func (s *stubbornService) Serve(ctx context.Context) {
+	<-s.release
+}
+
+func (s *stubbornService) TerminateDaemon(ctx context.Context) error {
+	_, s.deadline = ctx.Deadline()
+	return nil
+}
+
+func (s *stubbornService) DaemonStopTimeout() time.Duration {
+	return 10 * time.Millisecond
+}
+
+func TestDaemonStopTimeout(t *testing.T) {
+	log := new(orderLog)
+	stubborn := &stubbornService{release: make(chan struct{})}
+	defer close(stubborn.release)
+	db := &depService{name: "db", log: log}
+
+	d := daemon.New(stubborn, db)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := d.Run(ctx)
+
+	var serr *daemon.StopError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected StopError, got %v", err)
+	}
+	if len(serr.Services) != 1 || serr.Services[0] != "daemon_test.stubbornService" {
+		t.Fatalf("unexpected services refusing to stop: %v", serr.Services)
+	}
+	if !stubborn.deadline {
+		t.Fatal("terminator context has no deadline")
+	}
+	if log.String() != "start:db stop:db" {
+		t.Fatalf("other services not stopped: %q", log.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("shutdown took %s", elapsed)
+	}
+}
+
+type controlService struct{}
+
+func (s *controlService) Serve(ctx context.Context) {
+	<-ctx.Done()
+}
+
+func (s *controlService) RegisterControl(m *rpc.RespondMux) {
+	m.Handle("echo", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		var v string
+		c.Receive(&v)
+		r.Return(v)
+	}))
+}
+
+func TestDaemonControlSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "daemon")
+	fatal(t, err)
+	defer os.RemoveAll(dir)
+
+	d := daemon.New(new(controlService))
+	d.ControlSocket = filepath.Join(dir, "control.sock")
+	d.LogLevel = new(slog.LevelVar)
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run(context.Background())
+	}()
+
+	var client *rpc.Client
+	for i := 0; i < 100; i++ {
+		if client, err = daemon.DialControl(d.ControlSocket); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fatal(t, err)
+	defer client.Close()
+	ctx := context.Background()
+
+	var status daemon.Status
+	_, err = client.Call(ctx, "daemon.status", nil, &status)
+	fatal(t, err)
+	if status.PID != os.Getpid() || !status.Running || status.Services != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	var services []daemon.ServiceStatus
+	_, err = client.Call(ctx, "daemon.services", nil, &services)
+	fatal(t, err)
+	if len(services) != 1 || services[0].Name != "daemon_test.controlService" || services[0].Status != "serving" {
+		t.Fatalf("unexpected services: %+v", services)
+	}
+
+	var level string
+	_, err = client.Call(ctx, "daemon.loglevel", "debug", &level)
+	fatal(t, err)
+	if level != "debug" || d.LogLevel.Level() != slog.LevelDebug {
+		t.Fatalf("log level not changed: %s", level)
+	}
+
+	var echo string
+	_, err = client.Call(ctx, "echo", "hello", &echo)
+	fatal(t, err)
+	if echo != "hello" {
+		t.Fatalf("unexpected echo: %q", echo)
+	}
+
+	_, err = client.Call(ctx, "daemon.shutdown", nil)
+	fatal(t, err)
+	select {
+	case err := <-done:
+		fatal(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon not shut down")
+	}
+	if _, err := os.Stat(d.ControlSocket); !os.IsNotExist(err) {
+		t.Fatal("control socket not removed")
+	}
+}
+
+func TestDaemonSdNotify(t *testing.T) {
+	dir, err := os.MkdirTemp("", "daemon")
+	fatal(t, err)
+	defer os.RemoveAll(dir)
+	addr := &net.UnixAddr{Name: filepath.Join(dir, "notify.sock"), Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", addr)
+	fatal(t, err)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", addr.Name)
+	t.Setenv("WATCHDOG_USEC", "20000")
+
+	d := daemon.New(new(controlService))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	fatal(t, d.Run(ctx))
+
+	var states []string
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		states = append(states, strings.SplitN(string(buf[:n]), "\n", 2)[0])
+		conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	}
+	got := strings.Join(states, " ")
+	if !strings.HasPrefix(got, "READY=1 WATCHDOG=1") || !strings.HasSuffix(got, "STOPPING=1") {
+		t.Fatalf("unexpected notify states: %s", got)
+	}
+}
+
+func TestActivationListeners(t *testing.T) {
+	if os.Getenv("DAEMON_TEST_ACTIVATION") == "1" {
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		ls, err := daemon.ActivationListenersByName()
+		fatal(t, err)
+		if len(ls["web"]) != 1 {
+			t.Fatalf("unexpected listeners: %v", ls)
+		}
+		conn, err := ls["web"][0].Accept()
+		fatal(t, err)
+		conn.Write([]byte("activated"))
+		conn.Close()
+		if os.Getenv("LISTEN_FDS") != "" {
+			t.Fatal("activation environment not unset")
+		}
+		return
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("socket activation is only supported on linux")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	fatal(t, err)
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	fatal(t, err)
+	defer f.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestActivationListeners$")
+	cmd.Env = append(os.Environ(), "DAEMON_TEST_ACTIVATION=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=web")
+	cmd.ExtraFiles = []*os.File{f}
+	var out strings.Builder
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	fatal(t, cmd.Start())
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	fatal(t, err)
+	b, _ := io.ReadAll(conn)
+	conn.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("%v: %s", err, out.String())
+	}
+	if string(b) != "activated" {
+		t.Fatalf("unexpected response: %q", b)
+	}
+}
+
+func TestDaemonPIDFile(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "daemon.pid")
+	d := daemon.New(new(controlService))
+	d.PIDFile = pidFile
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run(ctx)
+	}()
+	for i := 0; i < 100 && len(d.ServiceStatuses()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b, err := os.ReadFile(pidFile)
+	fatal(t, err)
+	if strings.TrimSpace(string(b)) != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("unexpected pid file contents: %q", b)
+	}
+
+	other := daemon.New(new(controlService))
+	other.PIDFile = pidFile
+	var ierr *daemon.InstanceError
+	if err := other.Run(context.Background()); !errors.As(err, &ierr) || ierr.PID != os.Getpid() {
+		t.Fatalf("expected InstanceError, got %v", err)
+	}
+
+	fatal(t, daemon.SignalInstance(pidFile, syscall.Signal(0)))
+
+	cancel()
+	fatal(t, <-done)
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Fatal("pid file not removed")
+	}
+	if err := daemon.SignalInstance(pidFile, syscall.Signal(0)); err == nil {
+		t.Fatal("expected error signaling stopped instance")
+	}
+}
+
+type loggedService struct {
+	log *slog.Logger
+}
+
+func (s *loggedService) SetDaemonLogger(l *slog.Logger) {
+	s.log = l
+}
+
+func (s *loggedService) Serve(ctx context.Context) {
+	s.log.Debug("serving")
+}
+
+func TestDaemonLoggers(t *testing.T) {
+	var buf strings.Builder
+	loggers := &logging.Service{Sinks: []slog.Handler{slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})}}
+	loggers.SetLevel("daemon_test.loggedService", slog.LevelDebug)
+
+	d := daemon.New(new(loggedService))
+	d.Loggers = loggers
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	fatal(t, d.Run(ctx))
+
+	if !strings.Contains(buf.String(), "msg=serving service=daemon_test.loggedService") {
+		t.Fatalf("service logger not set: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "msg=\"shutting down\" service=daemon") {
+		t.Fatalf("daemon logger not used: %s", buf.String())
+	}
+}
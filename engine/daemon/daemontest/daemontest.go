@@ -0,0 +1,206 @@
+// Package daemontest assembles daemons for tests with some of their units
+// replaced by fakes, and drives the daemon lifecycle a step at a time, so
+// services can be tested together without running a real process.
+package daemontest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"tractor.dev/toolkit-go/engine"
+	"tractor.dev/toolkit-go/engine/daemon"
+)
+
+// Harness is a daemon assembled from units as engine.Run does, with units
+// replaced by fakes. Each step of the lifecycle returns once it is done:
+// Initialize assembles the units and initializes the daemon, Start starts
+// its services, and Stop terminates them. Steps not taken are taken by the
+// steps after them, so a test can call Start or Stop alone.
+type Harness struct {
+	// Daemon is the daemon, which can be configured before Initialize,
+	// such as with a StopTimeout or Restart policy.
+	Daemon *daemon.Framework
+
+	// Assembly is the assembly of the units after Initialize.
+	Assembly *engine.Assembly
+
+	t       testing.TB
+	units   []engine.Unit
+	fakes   map[reflect.Type]engine.Unit
+	state   int
+	stopErr error
+}
+
+// states of a Harness
+const (
+	created = iota
+	initialized
+	started
+	stopped
+)
+
+// New returns a harness for a daemon of the units and their dependencies.
+// The daemon is stopped when the test finishes if it was started.
+func New(t testing.TB, units ...engine.Unit) *Harness {
+	h := &Harness{
+		Daemon: &daemon.Framework{},
+		t:      t,
+		units:  units,
+		fakes:  make(map[reflect.Type]engine.Unit),
+	}
+	t.Cleanup(func() {
+		if h.state == started {
+			if err := h.Stop(); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	return h
+}
+
+// Replace has fake assembled in place of the unit of the same type as unit,
+// which can be a nil pointer of the type. Units depending on an interface
+// the fake implements are given the fake, including those of dependencies
+// of the units, so the unit replaced is never assembled or run.
+func (h *Harness) Replace(unit, fake engine.Unit) *Harness {
+	if h.state != created {
+		h.t.Fatal("daemontest: Replace after Initialize")
+	}
+	h.fakes[unitType(unit)] = fake
+	return h
+}
+
+// unitType is the type of the unit as it is assembled, which is always a
+// pointer.
+func unitType(unit engine.Unit) reflect.Type {
+	t := reflect.TypeOf(unit)
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PtrTo(t)
+	}
+	return t
+}
+
+// Initialize assembles the units with the fakes and the daemon, calling the
+// Initialize and PostInitialize hooks of units as engine.Assemble does,
+// then initializes the daemon, calling the InitializeDaemon of services.
+func (h *Harness) Initialize() error {
+	if h.state != created {
+		return errors.New("daemontest: already initialized")
+	}
+	units := engine.Dependencies(append([]engine.Unit(nil), h.units...)...)
+	used := make(map[reflect.Type]bool)
+	for i, u := range units {
+		if fake, ok := h.fakes[unitType(u)]; ok {
+			units[i] = fake
+			used[unitType(u)] = true
+		}
+	}
+	for typ := range h.fakes {
+		if !used[typ] {
+			return fmt.Errorf("daemontest: no unit of type %s to replace", typ)
+		}
+	}
+
+	asm, err := engine.New(units...)
+	if err != nil {
+		return err
+	}
+	if err := asm.Add(h.Daemon); err != nil {
+		return err
+	}
+	if err := asm.SelfAssemble(); err != nil {
+		return err
+	}
+	for i := len(asm.Units()) - 1; i >= 0; i-- {
+		if u, ok := asm.Units()[i].(engine.Initializer); ok {
+			u.Initialize()
+		}
+	}
+	for _, u := range asm.Units() {
+		if u, ok := u.(engine.PostInitializer); ok {
+			u.PostInitialize()
+		}
+	}
+	h.Assembly = asm
+	if err := h.Daemon.Initialize(); err != nil {
+		h.state = stopped
+		return err
+	}
+	h.state = initialized
+	return nil
+}
+
+// Start starts the services of the daemon, returning once they have
+// started, or the error of the service that failed to start after stopping
+// those already started.
+func (h *Harness) Start() error {
+	switch h.state {
+	case created:
+		if err := h.Initialize(); err != nil {
+			return err
+		}
+	case started:
+		return errors.New("daemontest: already started")
+	case stopped:
+		return errors.New("daemontest: already stopped")
+	}
+	h.state = started
+	if err := h.Daemon.Start(context.Background()); err != nil {
+		h.state = stopped
+		return err
+	}
+	return nil
+}
+
+// Stop terminates the daemon, returning once its services have stopped, or
+// a *daemon.StopError if services did not stop within their stop timeout.
+// Stopping a harness again returns the error of the first Stop.
+func (h *Harness) Stop() error {
+	switch h.state {
+	case created, initialized:
+		if err := h.Start(); err != nil {
+			return err
+		}
+	case stopped:
+		return h.stopErr
+	}
+	h.state = stopped
+	h.Daemon.Terminate()
+	h.stopErr = h.Daemon.Wait()
+	return h.stopErr
+}
+
+// Run starts the daemon, calls fn, and stops the daemon, failing the test if
+// a step returns an error.
+func (h *Harness) Run(fn func()) {
+	h.t.Helper()
+	if err := h.Start(); err != nil {
+		h.t.Fatal(err)
+	}
+	fn()
+	if err := h.Stop(); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// Unit sets ptr, a pointer to a pointer or interface, to the first assembled
+// unit assignable to it, such as a fake or a service the fakes were given
+// to, failing the test if there is none.
+func (h *Harness) Unit(ptr any) {
+	h.t.Helper()
+	if h.Assembly == nil {
+		h.t.Fatal("daemontest: Unit before Initialize")
+	}
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr {
+		h.t.Fatalf("daemontest: Unit of non-pointer %T", ptr)
+	}
+	units := h.Assembly.AssignableTo(v.Type().Elem())
+	if len(units) == 0 {
+		h.t.Fatalf("daemontest: no unit assignable to %s", v.Type().Elem())
+	}
+	v.Elem().Set(reflect.ValueOf(units[0]))
+}
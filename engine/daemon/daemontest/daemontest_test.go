@@ -0,0 +1,183 @@
+package daemontest_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"tractor.dev/toolkit-go/engine"
+	"tractor.dev/toolkit-go/engine/daemon"
+	"tractor.dev/toolkit-go/engine/daemon/daemontest"
+)
+
+func fatal(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// events records lifecycle events of services in order.
+type events struct {
+	mu  sync.Mutex
+	log []string
+}
+
+func (e *events) add(event string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.log = append(e.log, event)
+}
+
+func (e *events) String() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return strings.Join(e.log, " ")
+}
+
+type Store interface {
+	Get(key string) string
+}
+
+// diskStore is the real store, which the tests replace.
+type diskStore struct{}
+
+func (s *diskStore) Get(key string) string {
+	panic("diskStore used in test")
+}
+
+func (s *diskStore) Serve(ctx context.Context) {
+	panic("diskStore served in test")
+}
+
+type fakeStore struct {
+	Events *events
+}
+
+func (s *fakeStore) Get(key string) string {
+	return "fake:" + key
+}
+
+func (s *fakeStore) StartDaemon(ctx context.Context) error {
+	s.Events.add("start:store")
+	return nil
+}
+
+func (s *fakeStore) Serve(ctx context.Context) {
+	<-ctx.Done()
+}
+
+func (s *fakeStore) TerminateDaemon(ctx context.Context) error {
+	s.Events.add("stop:store")
+	return nil
+}
+
+// api depends on the store it is assembled with.
+type api struct {
+	Store  Store
+	Events *events
+
+	value string
+	err   error
+}
+
+func (a *api) Assembly() []engine.Unit {
+	return []engine.Unit{&diskStore{}}
+}
+
+func (a *api) DaemonDependencies() []daemon.Service {
+	return []daemon.Service{a.Store.(daemon.Service)}
+}
+
+func (a *api) InitializeDaemon() error {
+	a.Events.add("init:api")
+	a.value = a.Store.Get("config")
+	return nil
+}
+
+func (a *api) StartDaemon(ctx context.Context) error {
+	a.Events.add("start:api")
+	return a.err
+}
+
+func (a *api) Serve(ctx context.Context) {
+	<-ctx.Done()
+}
+
+func (a *api) TerminateDaemon(ctx context.Context) error {
+	a.Events.add("stop:api")
+	return nil
+}
+
+func TestHarness(t *testing.T) {
+	log := new(events)
+	h := daemontest.New(t, &api{}, log).Replace((*diskStore)(nil), &fakeStore{})
+
+	fatal(t, h.Initialize())
+	var a *api
+	h.Unit(&a)
+	if a.value != "fake:config" {
+		t.Fatalf("api not assembled with fake store: %q", a.value)
+	}
+	if got := log.String(); got != "init:api" {
+		t.Fatalf("unexpected events after initialize: %s", got)
+	}
+
+	fatal(t, h.Start())
+	if got := log.String(); got != "init:api start:store start:api" {
+		t.Fatalf("unexpected events after start: %s", got)
+	}
+	if !h.Daemon.Running() {
+		t.Fatal("daemon not running")
+	}
+
+	fatal(t, h.Stop())
+	if got := log.String(); got != "init:api start:store start:api stop:api stop:store" {
+		t.Fatalf("unexpected events after stop: %s", got)
+	}
+	if h.Daemon.Running() {
+		t.Fatal("daemon still running")
+	}
+	fatal(t, h.Stop())
+}
+
+func TestHarnessStartError(t *testing.T) {
+	log := new(events)
+	h := daemontest.New(t, &api{err: errors.New("no port")}, log).Replace(&diskStore{}, &fakeStore{})
+
+	err := h.Start()
+	var serr *daemon.ServiceError
+	if !errors.As(err, &serr) || serr.Op != "start" {
+		t.Fatalf("expected start error, got %v", err)
+	}
+	if got := log.String(); got != "init:api start:store start:api stop:api stop:store" {
+		t.Fatalf("unexpected events: %s", got)
+	}
+	if err := h.Start(); err == nil {
+		t.Fatal("expected error starting a stopped harness")
+	}
+}
+
+func TestHarnessRun(t *testing.T) {
+	log := new(events)
+	h := daemontest.New(t, &api{}, log).Replace(&diskStore{}, &fakeStore{})
+	h.Run(func() {
+		var store Store
+		h.Unit(&store)
+		if _, ok := store.(*fakeStore); !ok {
+			t.Fatalf("unexpected store: %T", store)
+		}
+	})
+	if got := log.String(); got != "init:api start:store start:api stop:api stop:store" {
+		t.Fatalf("unexpected events: %s", got)
+	}
+}
+
+func TestHarnessReplaceMissing(t *testing.T) {
+	h := daemontest.New(t, &fakeStore{}, new(events)).Replace(&api{}, &fakeStore{})
+	if err := h.Initialize(); err == nil {
+		t.Fatal("expected error replacing a unit not in the daemon")
+	}
+}
@@ -0,0 +1,173 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the next time after t a scheduled job runs.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// Every is a Schedule running a job at a fixed interval.
+type Every time.Duration
+
+func (e Every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// cron is a Schedule of a cron expression, where each field is a bit set of
+// the values it matches.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are whether the day fields are "*", since a day
+	// matches either day field if both are restricted
+	domAny, dowAny bool
+}
+
+// ParseCron parses a cron expression of five fields: minute, hour, day of
+// month, month and day of week (0 is Sunday). Fields are a "*", values,
+// ranges such as "1-5" and steps such as "*/15" or "0-30/10", separated by
+// commas. As in cron, a day matches if either of the day fields match when
+// both are restricted. The macros @yearly, @monthly, @weekly, @daily and
+// @hourly are also supported, as is "@every <duration>" for Every.
+func ParseCron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := strings.CutPrefix(expr, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %w", expr, err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("cron %q: interval must be positive", expr)
+		}
+		return Every(interval), nil
+	}
+	switch expr {
+	case "@yearly", "@annually":
+		expr = "0 0 1 1 *"
+	case "@monthly":
+		expr = "0 0 1 * *"
+	case "@weekly":
+		expr = "0 0 * * 0"
+	case "@daily", "@midnight":
+		expr = "0 0 * * *"
+	case "@hourly":
+		expr = "0 * * * *"
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: expected 5 fields, got %d", expr, len(fields))
+	}
+	var c cron
+	var err error
+	parse := func(i int, first, last int) uint64 {
+		if err != nil {
+			return 0
+		}
+		var bits uint64
+		bits, err = parseField(fields[i], first, last)
+		if err != nil {
+			err = fmt.Errorf("cron %q: field %d: %w", expr, i+1, err)
+		}
+		return bits
+	}
+	c.minute = parse(0, 0, 59)
+	c.hour = parse(1, 0, 23)
+	c.dom = parse(2, 1, 31)
+	c.month = parse(3, 1, 12)
+	c.dow = parse(4, 0, 7)
+	if err != nil {
+		return nil, err
+	}
+	// 7 is also Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return &c, nil
+}
+
+// parseField returns the bit set of the values of a field.
+func parseField(field string, first, last int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+		lo, hi := first, last
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return 0, fmt.Errorf("invalid value %q", loStr)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, fmt.Errorf("invalid value %q", hiStr)
+				}
+			} else if hasStep {
+				hi = last
+			}
+		}
+		if lo < first || hi > last || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, first, last)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next returns the next minute after t matching the expression, or the zero
+// time if none does within five years, such as for February 30th.
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<t.Month()) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,325 @@
+// Package jobs is a background job service for daemons. Services enqueue jobs
+// on a Pool, which runs them with a limited number of workers, retries those
+// that fail, runs scheduled jobs, and drains the queue when the daemon stops.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultQueueSize is the number of jobs that can wait to run if the
+	// Pool doesn't set a size.
+	DefaultQueueSize = 1024
+	// DefaultRetryBackoff is the time waited before retrying a job if the
+	// job doesn't set one.
+	DefaultRetryBackoff = time.Second
+)
+
+var (
+	// ErrQueueFull is returned by Enqueue when QueueSize jobs are waiting.
+	ErrQueueFull = errors.New("jobs: queue full")
+	// ErrClosed is returned by Enqueue once the pool is draining.
+	ErrClosed = errors.New("jobs: pool closed")
+)
+
+// Job is a unit of background work.
+type Job struct {
+	// Name identifies the job in logs.
+	Name string
+
+	Run func(ctx context.Context) error
+
+	// Timeout, if set, limits each run of the job.
+	Timeout time.Duration
+
+	// MaxRetries is the number of times the job is run again after it
+	// fails, waiting Backoff before the first retry, doubled for each retry
+	// after it.
+	MaxRetries int
+	Backoff    time.Duration
+}
+
+// Stats are counts of the jobs of a Pool.
+type Stats struct {
+	Queued    int
+	Running   int
+	Succeeded int
+	Failed    int
+	Retried   int
+}
+
+// Pool runs jobs with a limited number of workers as a daemon service.
+// Jobs can be enqueued before the daemon starts, and run once it serves.
+type Pool struct {
+	// Workers is the number of jobs run at once, the number of CPUs if zero.
+	Workers int
+
+	// QueueSize is the number of jobs that can wait to run,
+	// DefaultQueueSize if zero.
+	QueueSize int
+
+	Log *slog.Logger
+
+	once      sync.Once
+	queue     chan *task
+	pending   sync.WaitGroup
+	mu        sync.Mutex
+	closed    bool
+	ctx       context.Context
+	schedules []*schedule
+	stats     Stats
+}
+
+// task is a job with the number of times it has run.
+type task struct {
+	job      Job
+	attempts int
+}
+
+// schedule is a job run on a Schedule.
+type schedule struct {
+	job      Job
+	schedule Schedule
+	stop     chan struct{}
+}
+
+func (p *Pool) init() {
+	p.once.Do(func() {
+		size := p.QueueSize
+		if size <= 0 {
+			size = DefaultQueueSize
+		}
+		p.queue = make(chan *task, size)
+	})
+}
+
+func (p *Pool) log() *slog.Logger {
+	if p.Log == nil {
+		return slog.Default()
+	}
+	return p.Log
+}
+
+// Enqueue adds the job to the queue, returning ErrQueueFull if the queue is
+// full or ErrClosed if the pool is draining.
+func (p *Pool) Enqueue(job Job) error {
+	if job.Run == nil {
+		return fmt.Errorf("jobs: job %q has no Run func", job.Name)
+	}
+	p.init()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	p.pending.Add(1)
+	select {
+	case p.queue <- &task{job: job}:
+		p.stats.Queued++
+		return nil
+	default:
+		p.pending.Done()
+		return ErrQueueFull
+	}
+}
+
+// Schedule runs the job on the schedule, such as one from ParseCron or an
+// Every interval, once the pool serves and until it drains. Runs are skipped
+// while the queue is full. Calling the returned function stops the schedule.
+func (p *Pool) Schedule(s Schedule, job Job) (stop func()) {
+	p.init()
+	sched := &schedule{job: job, schedule: s, stop: make(chan struct{})}
+	p.mu.Lock()
+	p.schedules = append(p.schedules, sched)
+	ctx := p.ctx
+	p.mu.Unlock()
+	if ctx != nil {
+		go p.runSchedule(ctx, sched)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(sched.stop)
+		})
+	}
+}
+
+// Stats returns the counts of jobs.
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// CollectMetrics reports the Stats as metrics, such as to the metrics
+// service of a daemon.
+func (p *Pool) CollectMetrics(metric func(name string, value float64)) {
+	stats := p.Stats()
+	metric("jobs.queued", float64(stats.Queued))
+	metric("jobs.running", float64(stats.Running))
+	metric("jobs.succeeded", float64(stats.Succeeded))
+	metric("jobs.failed", float64(stats.Failed))
+	metric("jobs.retried", float64(stats.Retried))
+}
+
+// Serve runs jobs and schedules until ctx is done, canceling the contexts of
+// jobs still running.
+func (p *Pool) Serve(ctx context.Context) {
+	p.init()
+	p.mu.Lock()
+	p.ctx = ctx
+	schedules := p.schedules
+	p.mu.Unlock()
+	for _, s := range schedules {
+		go p.runSchedule(ctx, s)
+	}
+
+	workers := p.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case t := <-p.queue:
+					p.run(ctx, t)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TerminateDaemon stops accepting jobs and waits for queued and running
+// jobs, including those waiting to be retried, until ctx is done.
+func (p *Pool) TerminateDaemon(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		p.pending.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		stats := p.Stats()
+		return fmt.Errorf("jobs: %d queued and %d running jobs not drained", stats.Queued, stats.Running)
+	}
+}
+
+// run runs the task, retrying it if it fails.
+func (p *Pool) run(ctx context.Context, t *task) {
+	p.mu.Lock()
+	p.stats.Queued--
+	p.stats.Running++
+	p.mu.Unlock()
+
+	t.attempts++
+	err := runJob(ctx, t.job)
+
+	p.mu.Lock()
+	p.stats.Running--
+	retry := err != nil && t.attempts <= t.job.MaxRetries && ctx.Err() == nil
+	switch {
+	case err == nil:
+		p.stats.Succeeded++
+	case retry:
+		p.stats.Retried++
+		p.stats.Queued++
+	default:
+		p.stats.Failed++
+	}
+	p.mu.Unlock()
+
+	if err == nil {
+		p.pending.Done()
+		return
+	}
+	if !retry {
+		p.log().Error("job failed", "job", t.job.Name, "attempts", t.attempts, "err", err)
+		p.pending.Done()
+		return
+	}
+
+	backoff := t.job.Backoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	backoff <<= t.attempts - 1
+	p.log().Warn("job failed, retrying", "job", t.job.Name, "attempts", t.attempts, "backoff", backoff, "err", err)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+			select {
+			case p.queue <- t:
+				return
+			case <-ctx.Done():
+			}
+		}
+		p.mu.Lock()
+		p.stats.Queued--
+		p.stats.Failed++
+		p.mu.Unlock()
+		p.pending.Done()
+	}()
+}
+
+// runJob runs the job with its timeout, returning a panic as an error.
+func runJob(ctx context.Context, job Job) (err error) {
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// runSchedule enqueues the job of the schedule at each time of its Schedule.
+func (p *Pool) runSchedule(ctx context.Context, s *schedule) {
+	for {
+		now := time.Now()
+		next := s.schedule.Next(now)
+		if next.IsZero() {
+			return
+		}
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := p.Enqueue(s.job); err != nil {
+			if errors.Is(err, ErrClosed) {
+				return
+			}
+			p.log().Warn("scheduled job skipped", "job", s.job.Name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tractor.dev/toolkit-go/engine/daemon"
+	"tractor.dev/toolkit-go/engine/daemon/jobs"
+)
+
+func fatal(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		fatal(t, err)
+		return v
+	}
+	for _, tt := range []struct {
+		expr string
+		from string
+		next string
+	}{
+		// 2024-01-05 is a Friday
+		{"*/15 9-17 * * 1-5", "2024-01-05 09:07", "2024-01-05 09:15"},
+		{"*/15 9-17 * * 1-5", "2024-01-05 17:45", "2024-01-08 09:00"},
+		{"0 0 * * 7", "2024-01-05 12:00", "2024-01-07 00:00"},
+		{"30 2 1,15 * *", "2024-01-05 12:00", "2024-01-15 02:30"},
+		{"0 0 13 * 5", "2024-01-06 00:00", "2024-01-12 00:00"},
+		{"@daily", "2024-01-05 12:00", "2024-01-06 00:00"},
+		{"@yearly", "2024-01-05 12:00", "2025-01-01 00:00"},
+		{"@every 90s", "2024-01-05 12:00", "2024-01-05 12:01"},
+	} {
+		s, err := jobs.ParseCron(tt.expr)
+		fatal(t, err)
+		next := s.Next(at(tt.from)).Truncate(time.Minute)
+		if !next.Equal(at(tt.next)) {
+			t.Errorf("%q from %s: got %s, want %s", tt.expr, tt.from, next, tt.next)
+		}
+	}
+
+	s, err := jobs.ParseCron("0 0 30 2 *")
+	fatal(t, err)
+	if next := s.Next(at("2024-01-05 12:00")); !next.IsZero() {
+		t.Fatalf("expected no next time, got %s", next)
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *", "@every -1s"} {
+		if _, err := jobs.ParseCron(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
+
+// serve runs a daemon of the pool until the returned func is called, which
+// returns the error of Run.
+func serve(p *jobs.Pool) (stop func() error) {
+	d := daemon.New(p)
+	d.StopTimeout = time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run(ctx)
+	}()
+	return func() error {
+		cancel()
+		return <-done
+	}
+}
+
+func TestPoolWorkers(t *testing.T) {
+	p := &jobs.Pool{Workers: 2}
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		fatal(t, p.Enqueue(jobs.Job{Run: func(ctx context.Context) error {
+			defer wg.Done()
+			n := running.Add(1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+			return nil
+		}}))
+	}
+	stop := serve(p)
+	wg.Wait()
+	fatal(t, stop())
+
+	if peak.Load() != 2 {
+		t.Fatalf("expected 2 jobs at once, got %d", peak.Load())
+	}
+	if stats := p.Stats(); stats.Succeeded != 6 || stats.Queued != 0 || stats.Running != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestPoolQueueFull(t *testing.T) {
+	p := &jobs.Pool{QueueSize: 1}
+	job := jobs.Job{Run: func(ctx context.Context) error { return nil }}
+	fatal(t, p.Enqueue(job))
+	if err := p.Enqueue(job); !errors.Is(err, jobs.ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+}
+
+func TestPoolRetries(t *testing.T) {
+	p := &jobs.Pool{Workers: 1}
+	var attempts atomic.Int32
+	fatal(t, p.Enqueue(jobs.Job{
+		Name:       "flaky",
+		MaxRetries: 3,
+		Backoff:    time.Millisecond,
+		Run: func(ctx context.Context) error {
+			if attempts.Add(1) < 3 {
+				return errors.New("not yet")
+			}
+			return nil
+		},
+	}))
+	fatal(t, p.Enqueue(jobs.Job{
+		Name:       "broken",
+		MaxRetries: 1,
+		Backoff:    time.Millisecond,
+		Run: func(ctx context.Context) error {
+			panic("broken")
+		},
+	}))
+	stop := serve(p)
+	for i := 0; i < 100; i++ {
+		if stats := p.Stats(); stats.Succeeded+stats.Failed == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fatal(t, stop())
+
+	if attempts.Load() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts.Load())
+	}
+	if stats := p.Stats(); stats.Succeeded != 1 || stats.Failed != 1 || stats.Retried != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestPoolTimeout(t *testing.T) {
+	p := &jobs.Pool{}
+	errs := make(chan error, 1)
+	fatal(t, p.Enqueue(jobs.Job{
+		Timeout: 10 * time.Millisecond,
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			errs <- ctx.Err()
+			return ctx.Err()
+		},
+	}))
+	stop := serve(p)
+	defer stop()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job not timed out")
+	}
+}
+
+func TestPoolSchedule(t *testing.T) {
+	p := &jobs.Pool{}
+	var runs atomic.Int32
+	stopSchedule := p.Schedule(jobs.Every(10*time.Millisecond), jobs.Job{
+		Name: "tick",
+		Run: func(ctx context.Context) error {
+			runs.Add(1)
+			return nil
+		},
+	})
+	stop := serve(p)
+	defer stop()
+	for i := 0; i < 100 && runs.Load() < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runs.Load() < 3 {
+		t.Fatalf("expected at least 3 runs, got %d", runs.Load())
+	}
+
+	stopSchedule()
+	time.Sleep(20 * time.Millisecond)
+	n := runs.Load()
+	time.Sleep(50 * time.Millisecond)
+	if runs.Load() != n {
+		t.Fatal("schedule ran after it was stopped")
+	}
+}
+
+func TestPoolDrain(t *testing.T) {
+	p := &jobs.Pool{Workers: 1}
+	var finished atomic.Int32
+	for i := 0; i < 3; i++ {
+		fatal(t, p.Enqueue(jobs.Job{Run: func(ctx context.Context) error {
+			time.Sleep(20 * time.Millisecond)
+			if ctx.Err() == nil {
+				finished.Add(1)
+			}
+			return nil
+		}}))
+	}
+	stop := serve(p)
+	time.Sleep(5 * time.Millisecond)
+	fatal(t, stop())
+
+	if finished.Load() != 3 {
+		t.Fatalf("expected queued jobs to finish, got %d", finished.Load())
+	}
+	err := p.Enqueue(jobs.Job{Run: func(ctx context.Context) error { return nil }})
+	if !errors.Is(err, jobs.ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+// Package logging is a slog based logging service for daemons. It gives each
+// service a logger named after it, with a level that can be changed while the
+// daemon runs, such as over the daemon control socket, and writes records to
+// pluggable sinks: stderr, rotated files and remote peers.
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+// NameKey is the attribute key of the name of a logger.
+const NameKey = "service"
+
+// Service makes named loggers writing to its sinks.
+type Service struct {
+	// Sinks are the handlers records are written to, a text handler on
+	// stderr if empty. The Service filters records by level, so sinks should
+	// be enabled for all levels.
+	Sinks []slog.Handler
+
+	// Level is the level of loggers without their own level, slog.LevelInfo
+	// by default.
+	Level slog.LevelVar
+
+	once    sync.Once
+	handler slog.Handler
+
+	mu     sync.RWMutex
+	levels map[string]slog.Level
+}
+
+func (s *Service) sink() slog.Handler {
+	s.once.Do(func() {
+		switch len(s.Sinks) {
+		case 0:
+			s.handler = Stderr()
+		case 1:
+			s.handler = s.Sinks[0]
+		default:
+			s.handler = fanout(s.Sinks)
+		}
+	})
+	return s.handler
+}
+
+// Logger returns a logger with the name as its NameKey attribute, enabled
+// for the level set for the name or otherwise the Level of the service. An
+// empty name returns a logger without a name, using the Level of the service.
+func (s *Service) Logger(name string) *slog.Logger {
+	h := s.sink()
+	if name != "" {
+		h = h.WithAttrs([]slog.Attr{slog.String(NameKey, name)})
+	}
+	return slog.New(&handler{svc: s, name: name, next: h})
+}
+
+// SetLevel sets the level of the loggers with the name.
+func (s *Service) SetLevel(name string, level slog.Level) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.levels == nil {
+		s.levels = make(map[string]slog.Level)
+	}
+	s.levels[name] = level
+}
+
+// ResetLevel has the loggers with the name use the Level of the service.
+func (s *Service) ResetLevel(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.levels, name)
+}
+
+// Levels returns the levels set for names with SetLevel.
+func (s *Service) Levels() map[string]slog.Level {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	levels := make(map[string]slog.Level, len(s.levels))
+	for name, level := range s.levels {
+		levels[name] = level
+	}
+	return levels
+}
+
+func (s *Service) level(name string) slog.Level {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if level, ok := s.levels[name]; ok {
+		return level
+	}
+	return s.Level.Level()
+}
+
+// Close closes the sinks that are io.Closers, such as a handler writing to a
+// RotatingFile made with FileSink.
+func (s *Service) Close() error {
+	var errs []error
+	for _, sink := range s.Sinks {
+		if c, ok := sink.(interface{ Close() error }); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LevelsReply is returned by the "log.levels" selector.
+type LevelsReply struct {
+	// Default is the Level of the service.
+	Default string
+	// Names are the levels set for names.
+	Names map[string]string
+}
+
+// SetLevelArgs are the arguments of the "log.setlevel" selector. An empty
+// Name sets the Level of the service, and an empty Level resets the level of
+// the Name.
+type SetLevelArgs struct {
+	Name  string
+	Level string
+}
+
+// RegisterControl handles the selectors "log.levels" and "log.setlevel" on
+// the daemon control socket, so levels can be changed while the daemon runs.
+func (s *Service) RegisterControl(m *rpc.RespondMux) {
+	m.Handle("log.levels", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		c.Receive(nil)
+		reply := LevelsReply{Default: levelName(s.Level.Level()), Names: make(map[string]string)}
+		for name, level := range s.Levels() {
+			reply.Names[name] = levelName(level)
+		}
+		r.Return(reply)
+	}))
+	m.Handle("log.setlevel", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		var args SetLevelArgs
+		if err := c.Receive(&args); err != nil {
+			r.Return(err)
+			return
+		}
+		if args.Level == "" {
+			s.ResetLevel(args.Name)
+			r.Return(nil)
+			return
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(args.Level)); err != nil {
+			r.Return(err)
+			return
+		}
+		if args.Name == "" {
+			s.Level.Set(level)
+		} else {
+			s.SetLevel(args.Name, level)
+		}
+		r.Return(nil)
+	}))
+}
+
+func levelName(l slog.Level) string {
+	return strings.ToLower(l.String())
+}
+
+// handler filters records by the level of its name.
+type handler struct {
+	svc  *Service
+	name string
+	next slog.Handler
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.svc.level(h.name) && h.next.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{svc: h.svc, name: h.name, next: h.next.WithAttrs(attrs)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{svc: h.svc, name: h.name, next: h.next.WithGroup(name)}
+}
+
+// fanout writes records to all of its handlers.
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hs := make(fanout, len(f))
+	for i, h := range f {
+		hs[i] = h.WithAttrs(attrs)
+	}
+	return hs
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	hs := make(fanout, len(f))
+	for i, h := range f {
+		hs[i] = h.WithGroup(name)
+	}
+	return hs
+}
+
+// allLevels enables sinks for all levels, leaving filtering to the Service.
+var allLevels = &slog.HandlerOptions{Level: slog.Level(-1 << 10)}
+
+// Stderr returns a sink writing records as text to stderr.
+func Stderr() slog.Handler {
+	return slog.NewTextHandler(os.Stderr, allLevels)
+}
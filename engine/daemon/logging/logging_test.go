@@ -0,0 +1,119 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/rpc"
+	"tractor.dev/toolkit-go/duplex/rpc/rpctest"
+	"tractor.dev/toolkit-go/engine/daemon/logging"
+)
+
+func fatal(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// buffer is a concurrency safe bytes.Buffer.
+type buffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *buffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestLevels(t *testing.T) {
+	var buf buffer
+	s := &logging.Service{Sinks: []slog.Handler{slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})}}
+	db := s.Logger("db")
+	api := s.Logger("api")
+
+	db.Debug("hidden")
+	s.SetLevel("db", slog.LevelDebug)
+	db.Debug("shown", "table", "users")
+	api.Debug("hidden")
+	api.Info("started")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Fatalf("records below level written:\n%s", out)
+	}
+	if !strings.Contains(out, "msg=shown service=db table=users") || !strings.Contains(out, "msg=started service=api") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+
+	m := rpc.NewRespondMux()
+	s.RegisterControl(m)
+	client, _ := rpctest.NewPair(m, codec.CBORCodec{})
+	defer client.Close()
+	ctx := context.Background()
+	_, err := client.Call(ctx, "log.setlevel", logging.SetLevelArgs{Name: "api", Level: "error"})
+	fatal(t, err)
+	_, err = client.Call(ctx, "log.setlevel", logging.SetLevelArgs{Name: "db"})
+	fatal(t, err)
+	var levels logging.LevelsReply
+	_, err = client.Call(ctx, "log.levels", nil, &levels)
+	fatal(t, err)
+	if levels.Default != "info" || len(levels.Names) != 1 || levels.Names["api"] != "error" {
+		t.Fatalf("unexpected levels: %+v", levels)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.log")
+	f := &logging.RotatingFile{Path: path, MaxSize: 100, MaxBackups: 2}
+	s := &logging.Service{Sinks: []slog.Handler{logging.FileSink(f)}}
+	l := s.Logger("db")
+	for i := 0; i < 10; i++ {
+		l.Info("a message long enough to fill the file", "i", i)
+	}
+	fatal(t, s.Close())
+
+	for _, name := range []string{"daemon.log", "daemon.log.1", "daemon.log.2"} {
+		b, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		fatal(t, err)
+		var rec map[string]any
+		fatal(t, json.Unmarshal(bytes.SplitN(b, []byte("\n"), 2)[0], &rec))
+		if rec["service"] != "db" {
+			t.Fatalf("unexpected record in %s: %v", name, rec)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("more backups kept than MaxBackups")
+	}
+}
+
+func TestRemoteSink(t *testing.T) {
+	var buf buffer
+	m := rpc.NewRespondMux()
+	m.Handle("log", logging.Receive(slog.NewTextHandler(&buf, nil)))
+	client, _ := rpctest.NewPair(m, codec.CBORCodec{})
+	defer client.Close()
+
+	s := &logging.Service{Sinks: []slog.Handler{logging.RemoteSink(client, "log", 16)}}
+	s.Logger("worker").WithGroup("job").Info("done", "id", 7)
+	fatal(t, s.Close())
+
+	if out := buf.String(); !strings.Contains(out, "msg=done job.id=7 service=worker") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
@@ -0,0 +1,278 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+const (
+	// DefaultMaxSize is the size in bytes a RotatingFile is rotated at if it
+	// doesn't set a size.
+	DefaultMaxSize = 10 << 20
+	// DefaultMaxBackups is the number of rotated files kept if a
+	// RotatingFile doesn't set a number.
+	DefaultMaxBackups = 3
+)
+
+// RotatingFile writes to the file at Path, renaming it to Path.1 once it gets
+// larger than MaxSize and starting a new file. Files rotated before are
+// renamed to Path.2 and so on, removing those after MaxBackups.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	limit := r.MaxSize
+	if limit <= 0 {
+		limit = DefaultMaxSize
+	}
+	if r.size > 0 && r.size+int64(len(p)) > limit {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	backups := r.MaxBackups
+	if backups <= 0 {
+		backups = DefaultMaxBackups
+	}
+	os.Remove(fmt.Sprintf("%s.%d", r.Path, backups))
+	for i := backups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.Path, i), fmt.Sprintf("%s.%d", r.Path, i+1))
+	}
+	if err := os.Rename(r.Path, r.Path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// Close closes the file, which is opened again by the next Write.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// FileSink returns a sink writing records as JSON lines to the file, which is
+// closed by Close of the Service.
+func FileSink(f *RotatingFile) slog.Handler {
+	return &closer{Handler: slog.NewJSONHandler(f, allLevels), close: f.Close}
+}
+
+// closer is a sink closed by Close of the Service.
+type closer struct {
+	slog.Handler
+	close func() error
+}
+
+func (c *closer) Close() error {
+	return c.close()
+}
+
+// RemoteRecord is a record sent to a peer by a RemoteSink. Attributes in
+// groups are keyed by the group names and key joined by dots.
+type RemoteRecord struct {
+	Time    time.Time
+	Level   int
+	Message string
+	Attrs   map[string]any
+}
+
+// remote sends records to a peer in the background.
+type remote struct {
+	caller   rpc.Caller
+	selector string
+	records  chan RemoteRecord
+	done     chan struct{}
+	once     sync.Once
+}
+
+// RemoteSink returns a sink sending records to a peer with calls to the
+// selector, handled by Receive on the peer. Records are sent in the
+// background, up to buffer records at a time, and records are dropped while
+// the buffer is full, so a slow peer doesn't block logging.
+func RemoteSink(caller rpc.Caller, selector string, buffer int) slog.Handler {
+	r := &remote{
+		caller:   caller,
+		selector: selector,
+		records:  make(chan RemoteRecord, buffer),
+		done:     make(chan struct{}),
+	}
+	go r.send()
+	return &closer{Handler: &remoteHandler{remote: r}, close: r.close}
+}
+
+func (r *remote) send() {
+	defer close(r.done)
+	for rec := range r.records {
+		r.caller.Call(context.Background(), r.selector, rec)
+	}
+}
+
+// close sends the records left in the buffer.
+func (r *remote) close() error {
+	r.once.Do(func() {
+		close(r.records)
+	})
+	<-r.done
+	return nil
+}
+
+type remoteHandler struct {
+	remote *remote
+	prefix string
+	attrs  map[string]any
+}
+
+func (h *remoteHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return true
+}
+
+func (h *remoteHandler) Handle(ctx context.Context, r slog.Record) (err error) {
+	rec := RemoteRecord{
+		Time:    r.Time,
+		Level:   int(r.Level),
+		Message: r.Message,
+		Attrs:   make(map[string]any, len(h.attrs)+r.NumAttrs()),
+	}
+	for k, v := range h.attrs {
+		rec.Attrs[k] = v
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(rec.Attrs, h.prefix, a)
+		return true
+	})
+	defer func() {
+		// the sink was closed
+		if recover() != nil {
+			err = fmt.Errorf("logging: remote sink closed")
+		}
+	}()
+	select {
+	case h.remote.records <- rec:
+	default:
+	}
+	return nil
+}
+
+func (h *remoteHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := &remoteHandler{remote: h.remote, prefix: h.prefix, attrs: make(map[string]any, len(h.attrs)+len(attrs))}
+	for k, v := range h.attrs {
+		h2.attrs[k] = v
+	}
+	for _, a := range attrs {
+		addAttr(h2.attrs, h.prefix, a)
+	}
+	return h2
+}
+
+func (h *remoteHandler) WithGroup(name string) slog.Handler {
+	return &remoteHandler{remote: h.remote, prefix: h.prefix + name + ".", attrs: h.attrs}
+}
+
+// addAttr adds the attribute to m keyed by its key after the prefix,
+// flattening groups.
+func addAttr(m map[string]any, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		p := prefix
+		if a.Key != "" {
+			p += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			addAttr(m, p, ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	switch v.Kind() {
+	case slog.KindTime:
+		m[prefix+a.Key] = v.Time().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		m[prefix+a.Key] = v.Duration().String()
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			m[prefix+a.Key] = err.Error()
+			return
+		}
+		m[prefix+a.Key] = fmt.Sprint(v.Any())
+	default:
+		m[prefix+a.Key] = v.Any()
+	}
+}
+
+// Receive returns an rpc handler for the calls of a RemoteSink, writing the
+// records received to h, such as a sink of a Service.
+func Receive(h slog.Handler) rpc.Handler {
+	return rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		var rec RemoteRecord
+		if err := c.Receive(&rec); err != nil {
+			r.Return(err)
+			return
+		}
+		record := slog.NewRecord(rec.Time, slog.Level(rec.Level), rec.Message, 0)
+		keys := make([]string, 0, len(rec.Attrs))
+		for k := range rec.Attrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			record.AddAttrs(slog.Any(k, rec.Attrs[k]))
+		}
+		if !h.Enabled(c.Context, record.Level) {
+			r.Return(nil)
+			return
+		}
+		r.Return(h.Handle(c.Context, record))
+	})
+}
@@ -0,0 +1,318 @@
+// Package metrics is an opt-in observability service for daemons. It exposes
+// runtime metrics, counters and gauges of other services, and pprof profiles
+// over HTTP or rpc selectors of the daemon control socket, so a daemon can be
+// profiled in production.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	rmetrics "runtime/metrics"
+	rpprof "runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/rpc"
+)
+
+// MaxProfileDuration limits the duration of CPU profiles and traces taken
+// over rpc.
+const MaxProfileDuration = time.Minute
+
+// Counter is a metric that only increases, such as requests served.
+type Counter struct {
+	v atomic.Int64
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n int64) {
+	c.v.Add(n)
+}
+
+// Value returns the value of the counter.
+func (c *Counter) Value() int64 {
+	return c.v.Load()
+}
+
+// Gauge is a metric that can go up and down, such as open connections.
+type Gauge struct {
+	v atomic.Int64
+}
+
+// Set sets the value of the gauge.
+func (g *Gauge) Set(n int64) {
+	g.v.Store(n)
+}
+
+// Add adds n to the gauge, which can be negative.
+func (g *Gauge) Add(n int64) {
+	g.v.Add(n)
+}
+
+// Value returns the value of the gauge.
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
+
+// Collector is a service with its own metrics, collected for each snapshot.
+type Collector interface {
+	CollectMetrics(metric func(name string, value float64))
+}
+
+// Service collects metrics and serves them with pprof profiles.
+type Service struct {
+	// Addr, if set, is the address to serve metrics on over HTTP at
+	// /metrics, and pprof profiles at /debug/pprof/.
+	Addr string
+
+	// Collectors are collected in each snapshot, along with the counters
+	// and gauges of the service.
+	Collectors []Collector
+
+	Log *slog.Logger
+
+	mu       sync.Mutex
+	counters map[string]*Counter
+	gauges   map[string]*Gauge
+	listener net.Listener
+	server   *http.Server
+}
+
+func (s *Service) log() *slog.Logger {
+	if s.Log == nil {
+		return slog.Default()
+	}
+	return s.Log
+}
+
+// Counter returns the counter with the name, adding it if there is none.
+func (s *Service) Counter(name string) *Counter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counters == nil {
+		s.counters = make(map[string]*Counter)
+	}
+	c, ok := s.counters[name]
+	if !ok {
+		c = new(Counter)
+		s.counters[name] = c
+	}
+	return c
+}
+
+// Gauge returns the gauge with the name, adding it if there is none.
+func (s *Service) Gauge(name string) *Gauge {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gauges == nil {
+		s.gauges = make(map[string]*Gauge)
+	}
+	g, ok := s.gauges[name]
+	if !ok {
+		g = new(Gauge)
+		s.gauges[name] = g
+	}
+	return g
+}
+
+// Snapshot returns the current value of each metric. Runtime metrics are
+// named as in the runtime/metrics package, such as
+// "/sched/goroutines:goroutines", and histograms are left out.
+func (s *Service) Snapshot() map[string]float64 {
+	snapshot := make(map[string]float64)
+	descs := rmetrics.All()
+	samples := make([]rmetrics.Sample, len(descs))
+	for i, d := range descs {
+		samples[i].Name = d.Name
+	}
+	rmetrics.Read(samples)
+	for _, sample := range samples {
+		switch sample.Value.Kind() {
+		case rmetrics.KindUint64:
+			snapshot[sample.Name] = float64(sample.Value.Uint64())
+		case rmetrics.KindFloat64:
+			snapshot[sample.Name] = sample.Value.Float64()
+		}
+	}
+
+	s.mu.Lock()
+	for name, c := range s.counters {
+		snapshot[name] = float64(c.Value())
+	}
+	for name, g := range s.gauges {
+		snapshot[name] = float64(g.Value())
+	}
+	s.mu.Unlock()
+
+	for _, c := range s.Collectors {
+		c.CollectMetrics(func(name string, value float64) {
+			snapshot[name] = value
+		})
+	}
+	return snapshot
+}
+
+// Handler returns an HTTP handler serving the snapshot as JSON at /metrics
+// and pprof profiles at /debug/pprof/.
+func (s *Service) Handler() http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(s.Snapshot())
+	})
+	m.HandleFunc("/debug/pprof/", pprof.Index)
+	m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return m
+}
+
+// StartDaemon listens on Addr if it is set.
+func (s *Service) StartDaemon(ctx context.Context) error {
+	if s.Addr == "" {
+		return nil
+	}
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listener = l
+	s.server = &http.Server{Handler: s.Handler()}
+	return nil
+}
+
+// ListenAddr returns the address the HTTP server listens on, or nil if it is
+// not listening.
+func (s *Service) ListenAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Serve serves HTTP on the listener of StartDaemon until ctx is done.
+func (s *Service) Serve(ctx context.Context) {
+	if s.server == nil {
+		<-ctx.Done()
+		return
+	}
+	s.log().Info("serving metrics", "addr", s.listener.Addr().String())
+	go func() {
+		<-ctx.Done()
+		s.server.Close()
+	}()
+	if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.log().Error("metrics server", "err", err)
+	}
+}
+
+// TerminateDaemon shuts down the HTTP server, waiting for profiles being
+// taken until ctx is done.
+func (s *Service) TerminateDaemon(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
+
+// ProfileArgs are the arguments of the "pprof.profile" selector.
+type ProfileArgs struct {
+	// Name is a profile of runtime/pprof, such as "heap" or "goroutine", or
+	// "cpu" or "trace".
+	Name string
+	// Seconds is the duration of "cpu" and "trace" profiles, 30 if zero.
+	Seconds int
+	// Debug is the debug parameter of WriteTo for other profiles, where 0
+	// is the binary format read by go tool pprof.
+	Debug int
+}
+
+// Profile writes the profile described by args.
+func (s *Service) Profile(ctx context.Context, args ProfileArgs) ([]byte, error) {
+	var buf bytes.Buffer
+	d := time.Duration(args.Seconds) * time.Second
+	if d <= 0 {
+		d = 30 * time.Second
+	}
+	if d > MaxProfileDuration {
+		d = MaxProfileDuration
+	}
+	wait := func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(d):
+		}
+	}
+	switch args.Name {
+	case "cpu":
+		if err := rpprof.StartCPUProfile(&buf); err != nil {
+			return nil, err
+		}
+		wait()
+		rpprof.StopCPUProfile()
+	case "trace":
+		if err := trace.Start(&buf); err != nil {
+			return nil, err
+		}
+		wait()
+		trace.Stop()
+	default:
+		p := rpprof.Lookup(args.Name)
+		if p == nil {
+			return nil, fmt.Errorf("unknown profile: %s", args.Name)
+		}
+		if err := p.WriteTo(&buf, args.Debug); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// RegisterControl handles selectors on the daemon control socket:
+// "metrics.snapshot" returns the Snapshot, "pprof.list" returns the names of
+// profiles, and "pprof.profile" returns the profile for ProfileArgs.
+func (s *Service) RegisterControl(m *rpc.RespondMux) {
+	m.Handle("metrics.snapshot", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		c.Receive(nil)
+		r.Return(s.Snapshot())
+	}))
+	m.Handle("pprof.list", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		c.Receive(nil)
+		names := []string{"cpu", "trace"}
+		for _, p := range rpprof.Profiles() {
+			names = append(names, p.Name())
+		}
+		sort.Strings(names)
+		r.Return(names)
+	}))
+	m.Handle("pprof.profile", rpc.HandlerFunc(func(r rpc.Responder, c *rpc.Call) {
+		var args ProfileArgs
+		if err := c.Receive(&args); err != nil {
+			r.Return(err)
+			return
+		}
+		b, err := s.Profile(c.Context, args)
+		if err != nil {
+			r.Return(err)
+			return
+		}
+		r.Return(b)
+	}))
+}
@@ -0,0 +1,107 @@
+package metrics_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"tractor.dev/toolkit-go/duplex/codec"
+	"tractor.dev/toolkit-go/duplex/rpc"
+	"tractor.dev/toolkit-go/duplex/rpc/rpctest"
+	"tractor.dev/toolkit-go/engine/daemon"
+	"tractor.dev/toolkit-go/engine/daemon/metrics"
+)
+
+func fatal(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type queue struct{}
+
+func (q *queue) CollectMetrics(metric func(name string, value float64)) {
+	metric("queue.length", 3)
+}
+
+func TestSnapshot(t *testing.T) {
+	s := &metrics.Service{Collectors: []metrics.Collector{new(queue)}}
+	s.Counter("requests").Add(2)
+	s.Counter("requests").Add(1)
+	s.Gauge("conns").Set(5)
+	s.Gauge("conns").Add(-1)
+
+	snapshot := s.Snapshot()
+	if snapshot["requests"] != 3 || snapshot["conns"] != 4 || snapshot["queue.length"] != 3 {
+		t.Fatalf("unexpected custom metrics: %v", snapshot)
+	}
+	if snapshot["/sched/goroutines:goroutines"] < 1 {
+		t.Fatal("runtime metrics missing")
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	s := &metrics.Service{Addr: "127.0.0.1:0"}
+	s.Counter("requests").Add(1)
+	d := daemon.New(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		fatal(t, <-done)
+	}()
+	for i := 0; i < 100 && s.ListenAddr() == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	base := "http://" + s.ListenAddr().String()
+	resp, err := http.Get(base + "/metrics")
+	fatal(t, err)
+	var snapshot map[string]float64
+	fatal(t, json.NewDecoder(resp.Body).Decode(&snapshot))
+	resp.Body.Close()
+	if snapshot["requests"] != 1 {
+		t.Fatalf("unexpected snapshot: %v", snapshot)
+	}
+
+	resp, err = http.Get(base + "/debug/pprof/goroutine?debug=1")
+	fatal(t, err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected pprof status: %s", resp.Status)
+	}
+}
+
+func TestControl(t *testing.T) {
+	s := &metrics.Service{}
+	m := rpc.NewRespondMux()
+	s.RegisterControl(m)
+	client, _ := rpctest.NewPair(m, codec.CBORCodec{})
+	defer client.Close()
+	ctx := context.Background()
+
+	var profile []byte
+	_, err := client.Call(ctx, "pprof.profile", metrics.ProfileArgs{Name: "goroutine", Debug: 1}, &profile)
+	fatal(t, err)
+	if !strings.Contains(string(profile), "goroutine profile") {
+		t.Fatalf("unexpected profile: %.100s", profile)
+	}
+
+	var names []string
+	_, err = client.Call(ctx, "pprof.list", nil, &names)
+	fatal(t, err)
+	if !strings.Contains(strings.Join(names, " "), "heap") {
+		t.Fatalf("unexpected profiles: %v", names)
+	}
+
+	_, err = client.Call(ctx, "pprof.profile", metrics.ProfileArgs{Name: "nope"})
+	if err == nil {
+		t.Fatal("expected error for unknown profile")
+	}
+}
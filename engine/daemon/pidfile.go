@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// InstanceError is returned by Run if another instance of the daemon holds
+// the lock of the PID file.
+type InstanceError struct {
+	PIDFile string
+	// PID is the process of the running instance, or zero if it has not
+	// written its PID yet.
+	PID int
+}
+
+func (e *InstanceError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("already running: %s is locked", e.PIDFile)
+	}
+	return fmt.Sprintf("already running as pid %d: %s is locked", e.PID, e.PIDFile)
+}
+
+// readPID returns the PID in the PID file, or zero if it has none.
+func readPID(path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(string(bytes.TrimSpace(b)))
+	return pid
+}
+
+// SignalInstance sends the signal to the running instance of the daemon with
+// the PID file, such as to have it terminate. It returns an error if no
+// instance holds the lock of the PID file, so a stale PID file doesn't get
+// an unrelated process signaled.
+func SignalInstance(pidFile string, sig os.Signal) error {
+	locked, err := instanceLocked(pidFile)
+	if err != nil {
+		return err
+	}
+	pid := readPID(pidFile)
+	if !locked || pid == 0 {
+		return fmt.Errorf("no instance running with %s", pidFile)
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
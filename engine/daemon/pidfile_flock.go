@@ -0,0 +1,75 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package daemon
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// lockInstance locks the PID file with flock, so only one instance can run
+// with it, and writes the PID of the process to it. Calling unlock removes
+// the PID file and releases the lock.
+func lockInstance(path string) (unlock func(), err error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, &InstanceError{PIDFile: path, PID: readPID(path)}
+			}
+			return nil, err
+		}
+		// the file may have been removed by the instance holding the lock
+		// before we locked it, and another instance may lock a new file at
+		// the path, so the lock only counts if the path is still this file
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		current, err := os.Stat(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			f.Close()
+			return nil, err
+		}
+		if err != nil || !os.SameFile(locked, current) {
+			f.Close()
+			continue
+		}
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := f.WriteString(strconv.Itoa(os.Getpid()) + "\n"); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return func() {
+			os.Remove(path)
+			f.Close()
+		}, nil
+	}
+}
+
+// instanceLocked returns whether an instance holds the lock of the PID file.
+func instanceLocked(path string) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return true, nil
+	}
+	return false, err
+}
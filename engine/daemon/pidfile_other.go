@@ -0,0 +1,20 @@
+//go:build !windows && !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package daemon
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// lockInstance returns an error, since locking a PID file is not supported
+// on this platform.
+func lockInstance(path string) (unlock func(), err error) {
+	return nil, fmt.Errorf("daemon: locking pid file %s is not supported on %s", path, runtime.GOOS)
+}
+
+// instanceLocked returns an error, since locking a PID file is not supported
+// on this platform.
+func instanceLocked(path string) (bool, error) {
+	return false, fmt.Errorf("daemon: locking pid file %s is not supported on %s", path, runtime.GOOS)
+}
@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procCreateMutexW = kernel32.NewProc("CreateMutexW")
+)
+
+const (
+	errorAccessDenied  = 5
+	errorAlreadyExists = 183
+)
+
+// mutexName returns the name of the mutex locking the PID file in the
+// namespace, either Global or Local.
+func mutexName(namespace, path string) (*uint16, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	// backslashes are not allowed after the namespace
+	name := strings.ReplaceAll(strings.ToLower(path), `\`, "/")
+	return syscall.UTF16PtrFromString(namespace + `\daemon:` + name)
+}
+
+// createMutex creates the named mutex of the PID file, returning whether it
+// already existed. The mutex is global, so instances in other sessions such
+// as services are seen, unless creating global objects is not allowed, which
+// requires SeCreateGlobalPrivilege, and then it is local to the session.
+func createMutex(path string) (syscall.Handle, bool, error) {
+	h, exists, err := createNamedMutex("Global", path)
+	if err == syscall.Errno(errorAccessDenied) {
+		return createNamedMutex("Local", path)
+	}
+	return h, exists, err
+}
+
+func createNamedMutex(namespace, path string) (syscall.Handle, bool, error) {
+	name, err := mutexName(namespace, path)
+	if err != nil {
+		return 0, false, err
+	}
+	h, _, err := procCreateMutexW.Call(0, 0, uintptr(unsafe.Pointer(name)))
+	if h == 0 {
+		return 0, false, err
+	}
+	return syscall.Handle(h), err == syscall.Errno(errorAlreadyExists), nil
+}
+
+// lockInstance creates a named mutex for the PID file, so only one instance
+// can run with it, and writes the PID of the process to it. Calling unlock
+// removes the PID file and releases the mutex.
+func lockInstance(path string) (unlock func(), err error) {
+	h, exists, err := createMutex(path)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		syscall.CloseHandle(h)
+		return nil, &InstanceError{PIDFile: path, PID: readPID(path)}
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		syscall.CloseHandle(h)
+		return nil, err
+	}
+	return func() {
+		os.Remove(path)
+		syscall.CloseHandle(h)
+	}, nil
+}
+
+// instanceLocked returns whether an instance holds the mutex of the PID file.
+func instanceLocked(path string) (bool, error) {
+	h, exists, err := createMutex(path)
+	if err != nil {
+		return false, err
+	}
+	syscall.CloseHandle(h)
+	return exists, nil
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package daemon
+
+// RunWindowsService runs the daemon as the Windows service with the name,
+// which is only supported on Windows, so it returns ErrNotService.
+func (d *Framework) RunWindowsService(name string) error {
+	return ErrNotService
+}
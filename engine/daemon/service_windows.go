@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"context"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+)
+
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceAcceptStop     = 0x1
+	serviceAcceptShutdown = 0x4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	errorCallNotImplemented             = 120
+	errorFailedServiceControllerConnect = 1063
+)
+
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+type serviceStatus struct {
+	serviceType             uint32
+	currentState            uint32
+	controlsAccepted        uint32
+	win32ExitCode           uint32
+	serviceSpecificExitCode uint32
+	checkPoint              uint32
+	waitHint                uint32
+}
+
+// RunWindowsService runs the daemon as the Windows service with the name,
+// reporting to the service control manager that it is running once its
+// services have started, and terminating it when the service is stopped or
+// the system shuts down. It returns ErrNotService if the process was not
+// started by the service control manager, so the daemon can be run with Run
+// instead.
+func (d *Framework) RunWindowsService(name string) error {
+	namep, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	var handle uintptr
+	var status serviceStatus
+	setStatus := func(state uint32, exitCode uint32) {
+		status.serviceType = serviceWin32OwnProcess
+		status.currentState = state
+		status.win32ExitCode = exitCode
+		status.controlsAccepted = 0
+		if state == serviceRunning {
+			status.controlsAccepted = serviceAcceptStop | serviceAcceptShutdown
+		}
+		procSetServiceStatus.Call(handle, uintptr(unsafe.Pointer(&status)))
+	}
+
+	handler := syscall.NewCallback(func(ctrl, eventType uint32, eventData, _ uintptr) uintptr {
+		switch ctrl {
+		case serviceControlStop, serviceControlShutdown:
+			d.log().Info("service stop requested")
+			setStatus(serviceStopPending, 0)
+			go d.Terminate()
+			return 0
+		case serviceControlInterrogate:
+			return 0
+		}
+		return errorCallNotImplemented
+	})
+
+	serviceMain := syscall.NewCallback(func(argc uint32, argv **uint16) uintptr {
+		h, _, err := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(namep)), handler, 0)
+		if h == 0 {
+			done <- err
+			return 0
+		}
+		handle = h
+		setStatus(serviceStartPending, 0)
+		d.onReady = append(d.onReady, func() {
+			setStatus(serviceRunning, 0)
+		})
+		err = d.Run(context.Background())
+		var exitCode uint32
+		if err != nil {
+			exitCode = 1
+		}
+		setStatus(serviceStopped, exitCode)
+		done <- err
+		return 0
+	})
+
+	table := []serviceTableEntry{{name: namep, proc: serviceMain}, {}}
+	ok, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0])))
+	if ok == 0 {
+		if errno, isErrno := err.(syscall.Errno); isErrno && errno == errorFailedServiceControllerConnect {
+			return ErrNotService
+		}
+		return err
+	}
+	return <-done
+}
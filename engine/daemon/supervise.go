@@ -0,0 +1,184 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Restart is when a supervised service is restarted.
+type Restart string
+
+const (
+	// RestartNever leaves a service stopped once Serve returns. It is the
+	// default.
+	RestartNever Restart = "never"
+	// RestartOnFailure restarts a service if it fails, by panicking or
+	// failing to start again.
+	RestartOnFailure Restart = "on-failure"
+	// RestartAlways restarts a service whenever Serve returns before the
+	// daemon stops it.
+	RestartAlways Restart = "always"
+)
+
+const (
+	// DefaultRestartBackoff is the time waited before the first restart if
+	// the policy doesn't set one.
+	DefaultRestartBackoff = 100 * time.Millisecond
+	// DefaultMaxRestartBackoff limits the backoff between restarts if the
+	// policy doesn't set a limit.
+	DefaultMaxRestartBackoff = 30 * time.Second
+)
+
+// RestartPolicy is how a service is supervised once it is serving.
+type RestartPolicy struct {
+	// When is when the service is restarted, RestartNever if empty.
+	When Restart
+	// MaxRetries is the number of restarts after which the service is
+	// given up on, unlimited if zero.
+	MaxRetries int
+	// Backoff is the time waited before the first restart, doubled for
+	// each restart after it up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// restarts returns whether the policy restarts a service that stopped with
+// err, nil if Serve returned without failing.
+func (p RestartPolicy) restarts(err error) bool {
+	switch p.When {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	}
+	return false
+}
+
+// backoff returns the time to wait before restart number n, counting from 0.
+func (p RestartPolicy) backoff(n int) time.Duration {
+	backoff, limit := p.Backoff, p.MaxBackoff
+	if backoff <= 0 {
+		backoff = DefaultRestartBackoff
+	}
+	if limit <= 0 {
+		limit = DefaultMaxRestartBackoff
+	}
+	for ; n > 0 && backoff < limit; n-- {
+		backoff *= 2
+	}
+	return min(backoff, limit)
+}
+
+// Supervised is a service with its own RestartPolicy, instead of the Restart
+// policy of the daemon.
+type Supervised interface {
+	DaemonRestartPolicy() RestartPolicy
+}
+
+// EventType is a type of supervision Event.
+type EventType string
+
+const (
+	// EventExited is a service returning from Serve before it was stopped.
+	EventExited EventType = "exited"
+	// EventFailed is a service panicking in Serve or failing to restart.
+	EventFailed EventType = "failed"
+	// EventRestarting is a service about to be restarted after a backoff.
+	EventRestarting EventType = "restarting"
+	// EventGaveUp is a service not restarted again after MaxRetries.
+	EventGaveUp EventType = "gave-up"
+)
+
+// Event is something that happened to a supervised service.
+type Event struct {
+	Type EventType
+	// Service is the type name of the service.
+	Service string
+	// Restarts is the number of times the service was restarted.
+	Restarts int
+	// Err is why the service failed, for EventFailed.
+	Err error
+	// Backoff is the time until the restart, for EventRestarting.
+	Backoff time.Duration
+}
+
+func (d *Framework) restartPolicy(s Service) RestartPolicy {
+	if s, ok := s.(Supervised); ok {
+		return s.DaemonRestartPolicy()
+	}
+	return d.Restart
+}
+
+func (d *Framework) emit(e Event) {
+	if d.OnEvent != nil {
+		d.OnEvent(e)
+	}
+}
+
+// supervise serves the service until ctx is canceled, restarting it by its
+// RestartPolicy when Serve returns early.
+func (d *Framework) supervise(ctx context.Context, state *serviceState) {
+	s := state.service
+	policy := d.restartPolicy(s)
+	name := ptrName(s)
+	for restarts := 0; ; restarts++ {
+		var err error
+		if starter, ok := s.(Starter); ok && restarts > 0 {
+			if serr := d.startService(ctx, starter); serr != nil {
+				err = &ServiceError{Service: name, Op: "start", Err: serr}
+			}
+		}
+		if err == nil {
+			d.setStatus(state, "serving")
+			err = serve(ctx, s)
+		}
+		if ctx.Err() != nil {
+			d.setStatus(state, "stopped")
+			return
+		}
+
+		if err != nil {
+			d.setStatus(state, "failed")
+			d.log().Error("service failed", "service", name, "err", err)
+			d.emit(Event{Type: EventFailed, Service: name, Restarts: restarts, Err: err})
+		} else {
+			d.setStatus(state, "exited")
+			d.log().Debug("service exited", "service", name)
+			d.emit(Event{Type: EventExited, Service: name, Restarts: restarts})
+		}
+		if !policy.restarts(err) {
+			return
+		}
+		if policy.MaxRetries > 0 && restarts >= policy.MaxRetries {
+			d.log().Error("service gave up", "service", name, "restarts", restarts)
+			d.emit(Event{Type: EventGaveUp, Service: name, Restarts: restarts, Err: err})
+			return
+		}
+
+		backoff := policy.backoff(restarts)
+		d.log().Info("restarting", "service", name, "backoff", backoff)
+		d.emit(Event{Type: EventRestarting, Service: name, Restarts: restarts, Backoff: backoff})
+		d.mu.Lock()
+		state.status = "restarting"
+		state.restarts++
+		d.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			d.setStatus(state, "stopped")
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// serve calls Serve, returning a panic in it as an error.
+func serve(ctx context.Context, s Service) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &ServiceError{Service: ptrName(s), Op: "serve", Err: fmt.Errorf("panic: %v", r)}
+		}
+	}()
+	s.Serve(ctx)
+	return nil
+}
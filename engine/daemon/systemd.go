@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SdNotify sends the state to systemd, such as "READY=1" or "STATUS=...",
+// if the daemon runs as a systemd service with Type=notify. It returns false
+// if there is no notify socket to send to. Run sends READY=1 once services
+// have started and Terminate sends STOPPING=1, so most daemons don't need to
+// call it.
+func SdNotify(state string) (bool, error) {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return false, nil
+	}
+	if name[0] == '@' {
+		// abstract socket
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// watchdogInterval returns the interval systemd expects WATCHDOG=1 within,
+// or zero if the watchdog is not enabled for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// ready tells the service manager the daemon is ready once its services have
+// started, and keeps the systemd watchdog from restarting the daemon until
+// it terminates.
+func (d *Framework) ready() {
+	if _, err := SdNotify("READY=1\nMAINPID=" + strconv.Itoa(os.Getpid())); err != nil {
+		d.log().Debug("systemd notify failed", "err", err)
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval / 2)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-d.Context.Done():
+					return
+				case <-ticker.C:
+					if !d.pingWatchdog() {
+						return
+					}
+				}
+			}
+		}()
+	}
+	for _, fn := range d.onReady {
+		fn()
+	}
+}
+
+// pingWatchdog sends WATCHDOG=1 unless the daemon has terminated, returning
+// false if it has. The notify mutex keeps the ping from being sent after
+// Terminate sends STOPPING=1.
+func (d *Framework) pingWatchdog() bool {
+	d.notifyMu.Lock()
+	defer d.notifyMu.Unlock()
+	if !d.Running() {
+		return false
+	}
+	if _, err := SdNotify("WATCHDOG=1"); err != nil {
+		d.log().Debug("systemd watchdog failed", "err", err)
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// ActivationListeners returns the listening sockets passed to the daemon by
+// systemd socket activation, in the order of the socket unit, or none if the
+// daemon was not socket activated. The environment variables describing them
+// are unset, so only the first call returns them and child processes don't
+// see them.
+func ActivationListeners() ([]net.Listener, error) {
+	files, _ := activationFiles()
+	return fileListeners(files)
+}
+
+// ActivationListenersByName is like ActivationListeners but returns the
+// listeners keyed by their FileDescriptorName in the socket unit.
+func ActivationListenersByName() (map[string][]net.Listener, error) {
+	files, names := activationFiles()
+	listeners, err := fileListeners(files)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string][]net.Listener)
+	for i, l := range listeners {
+		byName[names[i]] = append(byName[names[i]], l)
+	}
+	return byName, nil
+}
+
+// activationFiles returns the files passed by systemd and their names.
+func activationFiles() ([]*os.File, []string) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	var files []*os.File
+	var fileNames []string
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		name := "unknown"
+		if i := fd - listenFdsStart; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+		fileNames = append(fileNames, name)
+	}
+	return files, fileNames
+}
+
+// fileListeners returns listeners for the files, closing the files.
+func fileListeners(files []*os.File) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for i, f := range files {
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, f := range files[i+1:] {
+				f.Close()
+			}
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("activation socket %s: %w", f.Name(), err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
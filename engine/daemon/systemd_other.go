@@ -0,0 +1,17 @@
+//go:build !linux
+
+package daemon
+
+import "net"
+
+// ActivationListeners returns the listening sockets passed to the daemon by
+// systemd socket activation, which is only supported on Linux.
+func ActivationListeners() ([]net.Listener, error) {
+	return nil, nil
+}
+
+// ActivationListenersByName is like ActivationListeners but returns the
+// listeners keyed by their FileDescriptorName in the socket unit.
+func ActivationListenersByName() (map[string][]net.Listener, error) {
+	return nil, nil
+}
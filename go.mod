@@ -3,10 +3,16 @@ module tractor.dev/toolkit-go
 go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/fxamacker/cbor/v2 v2.5.0
 	github.com/mitchellh/mapstructure v1.5.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	golang.org/x/net v0.17.0
 	golang.org/x/text v0.13.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require github.com/x448/float16 v0.8.4 // indirect
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+)